@@ -1,86 +1,172 @@
 package main
 
 import (
-	"flag"
-	"fmt"
-	"log"
+	"context"
+	"errors"
+	"log/slog"
 	"os"
-	"path/filepath"
-	"strings"
 
+	"github.com/danielbehrens/cloudsync/internal/api"
+	"github.com/danielbehrens/cloudsync/internal/config"
+	"github.com/danielbehrens/cloudsync/internal/logging"
+	"github.com/danielbehrens/cloudsync/internal/metrics"
+	"github.com/danielbehrens/cloudsync/internal/notify"
+	"github.com/danielbehrens/cloudsync/internal/power"
+	"github.com/danielbehrens/cloudsync/internal/storage"
+	"github.com/danielbehrens/cloudsync/internal/sync"
+	"github.com/danielbehrens/cloudsync/internal/watcher"
 	"github.com/fsnotify/fsnotify"
-	"github.com/minio/minio-go/v7"
-	"github.com/minio/minio-go/v7/pkg/credentials"
 )
 
 var (
-	watchPath   string
-	processName string
-	backupDir   string
-	endpoint    string
-	accessKey   string
-	secretKey   string
-	bucketName  string
+	triggerOps  fsnotify.Op
+	powerSource power.Provider    // nil unless -pause-on-battery
+	notifier    *notify.Webhook   // nil unless -notify-url
+	progress    *progressPrinter  // nil unless -progress on a terminal
+	registry    *metrics.Registry // nil unless -metrics-addr
+	controlAPI  *api.Server       // the running watches, for -api-addr and the pause signals
+
+	// logLevel is the least severe level logged, set from -log-level and
+	// again on reload
+	logLevel slog.LevelVar
 )
 
-func init() {
-	flag.StringVar(&watchPath, "watch-path", "", "Path to watch for file changes, if empty will autogenerate game data location")
-	flag.StringVar(&processName, "process-name", "RSDragonwilds-Win64-Shipping.exe", "Process name to pause sync when running")
-	flag.StringVar(&backupDir, "backup-dir", "", "Directory to store backups, if empty will autogenerate game data location backup folder")
-	flag.StringVar(&endpoint, "cloud-endpoint", "localhost:9000", "MinIO/S3 cloud endpoint")
-	flag.StringVar(&accessKey, "access-key", "", "Cloud storage access key")
-	flag.StringVar(&secretKey, "secret-key", "", "Cloud storage secret key")
-	flag.StringVar(&bucketName, "bucket-name", "gamesync-dragonwilds", "Bucket name in cloud storage")
+// logLevels maps the -log-level names to slog levels
+var logLevels = map[string]slog.Level{
+	config.LogLevelError: slog.LevelError,
+	config.LogLevelWarn:  slog.LevelWarn,
+	config.LogLevelInfo:  slog.LevelInfo,
+	config.LogLevelDebug: slog.LevelDebug,
 }
 
-func configure() (*minio.Client, *fsnotify.Watcher) {
-	flag.Parse()
+// loadConfig parses the command line and sets the package globals shared by
+// every watch
+func loadConfig() (*config.Config, error) {
+	cfg, err := config.LoadFromFlags()
+	if err != nil {
+		return nil, err
+	}
+	if cfg.ShowVersion {
+		return cfg, nil
+	}
+
+	// Either handler also takes the log package's output, at info level
+	logLevel.Set(logLevels[cfg.LogLevel])
+	if cfg.LogFormat == config.LogFormatJSON {
+		slog.SetDefault(slog.New(slog.NewJSONHandler(os.Stderr, &slog.HandlerOptions{Level: &logLevel})))
+	} else {
+		slog.SetDefault(slog.New(logging.NewTextHandler(os.Stderr, &logLevel)))
+	}
+
+	if cfg.PauseOnBattery {
+		powerSource = power.NewProvider()
+	}
+
+	triggerOps, err = watcher.ParseOps(cfg.TriggerOps)
+	if err != nil {
+		return nil, err
+	}
 
-	if endpoint == "" || accessKey == "" || secretKey == "" || bucketName == "" {
-		log.Fatal("missing required arguments")
+	if cfg.Progress && isTerminal(os.Stdout) {
+		progress = newProgressPrinter(os.Stdout)
 	}
 
-	// Auto-generate watchPath if not provided
-	if watchPath == "" {
-		var err error
-		watchPath, err = getDefaultWatchPath()
+	if cfg.NotifyURL != "" {
+		notifier, err = notify.NewWebhook(cfg.NotifyURL, cfg.NotifyEvents, cfg.NotifyTemplate)
 		if err != nil {
-			log.Fatal(err)
+			return nil, err
 		}
-		log.Printf("watch-path autogenerated to: %s", watchPath)
 	}
 
-	// Auto-generate backupDir if not provided
-	if backupDir == "" {
-		backupDir = filepath.Join(watchPath, "Backup")
-		log.Printf("backup-dir autogenerated to: %s", backupDir)
-	}
+	return cfg, nil
+}
+
+// newSyncer builds the storage-backed Syncer used by the one-shot commands,
+// which act on the first watch
+func newSyncer(cfg *config.Config) (*sync.Syncer, error) {
+	return newWatchSyncer(cfg, cfg.Watches[0])
+}
 
-	// Create MinIO client
-	client, err := minio.New(endpoint, &minio.Options{
-		Creds:  credentials.NewStaticV4(accessKey, secretKey, ""),
-		Secure: strings.HasPrefix(endpoint, "https"),
-	})
+// newWatchSyncer builds the Syncer for one watch: its folders, patterns and
+// bucket come from w, everything else from cfg
+func newWatchSyncer(cfg *config.Config, w config.WatchConfig) (*sync.Syncer, error) {
+	store, err := newWatchStorage(cfg, w)
 	if err != nil {
-		log.Fatal("could not create MinIO client:", err)
+		return nil, err
 	}
 
-	// Create file system watcher
-	watcher, err := fsnotify.NewWatcher()
-	if err != nil {
-		log.Fatal("cannot create file system watcher:", err)
+	if ps, ok := store.(interface{ SetProgress(storage.ProgressFunc) }); ok && progress != nil {
+		ps.SetProgress(progress.report)
+	}
+
+	syncer := sync.NewSyncer(store, w.WatchPath, w.BackupDir, w.ProcessNames, cfg.TimeTolerance)
+	syncer.CoarseTimeTolerance = cfg.CoarseTimeTolerance
+	syncer.IncludePatterns = w.IncludePatterns
+	syncer.ExcludePatterns = w.ExcludePatterns
+	syncer.LoadIgnoreFile()
+	syncer.PriorityPatterns = cfg.PriorityPatterns
+	syncer.ChecksumMode = cfg.ChecksumMode
+	syncer.VerifyAfterUpload = cfg.VerifyAfterUpload
+	syncer.MinFileSize = cfg.MinFileSize
+	syncer.MinSizeRatio = cfg.MinSizeRatio
+	syncer.HardlinkBackups = cfg.HardlinkBackups
+	syncer.MaxBackups = cfg.MaxBackups
+	syncer.MaxBackupAge = cfg.MaxBackupAge
+	syncer.BackupWindow = cfg.BackupWindow
+	syncer.DryRun = cfg.DryRun
+	syncer.StateFile = w.StateFile
+	syncer.JournalFile = w.JournalFile
+	syncer.TempDir = cfg.TempDir
+	syncer.ConflictStrategy = sync.ConflictStrategy(cfg.ConflictStrategy)
+	syncer.Direction = sync.SyncDirection(cfg.Direction)
+	syncer.ForceFullSync = cfg.ForceFullSync
+	syncer.Concurrency = cfg.Concurrency
+	syncer.PropagateDeletes = cfg.PropagateDeletes
+	syncer.Recursive = cfg.Recursive
+	syncer.SyncEmptyDirs = cfg.SyncEmptyDirs
+	syncer.ProcessMatch = sync.ProcessMatchMode(cfg.ProcessMatch)
+	syncer.ProcessCacheTTL = cfg.ProcessCacheTTL
+	syncer.FullSyncInterval = cfg.FullSyncInterval
+	if len(w.ProcessNames) > 0 {
+		// Catches up on changes made while the game ran
+		syncer.SyncInterval = cfg.SyncInterval
 	}
-	if err := watcher.Add(watchPath); err != nil {
-		log.Fatalf("failed to watch path %v: %v", watchPath, err)
+	syncer.Power = powerSource
+	if notifier != nil {
+		syncer.Notifier = notifier
 	}
+	if registry != nil {
+		syncer.Metrics = registry
+	}
+	if cfg.KeepGoodCopy {
+		syncer.GoodCopyDir = w.GoodCopyDir
+	}
+
+	return syncer, nil
+}
+
+// newWatchStorage creates the storage backend for one watch: its bucket,
+// local dir and object prefix come from w, everything else from cfg
+func newWatchStorage(cfg *config.Config, w config.WatchConfig) (sync.Storage, error) {
+	storeCfg := cfg.S3Config
+	storeCfg.BucketName = w.BucketName
+	storeCfg.LocalDir = w.LocalDir
+	storeCfg.ObjectPrefix = w.ObjectPrefix
 
-	return client, watcher
+	return storage.New(context.Background(), storeCfg)
 }
 
-func getDefaultWatchPath() (string, error) {
-	localAppData := os.Getenv("LOCALAPPDATA")
-	if localAppData == "" {
-		return "", fmt.Errorf("LOCALAPPDATA environment variable is not set")
+// storageHint suggests a fix for a storage check error, as a sentence to
+// append to it, or returns "" if there is no specific advice
+func storageHint(err error) string {
+	switch {
+	case errors.Is(err, storage.ErrAccessDenied):
+		return ". Check -access-key and -secret-key, and that they may access the bucket."
+	case errors.Is(err, storage.ErrUnreachable):
+		return ". Check -cloud-endpoint (host[:port], with https:// for TLS) and the network."
+	case errors.Is(err, storage.ErrWrongRegion):
+		return ". Set -region to the bucket's region."
+	default:
+		return ""
 	}
-	return filepath.Join(localAppData, "RSDragonwilds", "Saved", "SaveGames"), nil
 }
@@ -0,0 +1,60 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"io"
+	"log/slog"
+	"strings"
+
+	"github.com/danielbehrens/cloudsync/internal/config"
+)
+
+// runDedupeCloud lists on out the cloud objects that hold identical content
+// under different keys and, after confirmation on in, removes the redundant
+// copies. Each removed object is backed up first. It returns the process
+// exit code.
+func runDedupeCloud(ctx context.Context, cfg *config.Config, in io.Reader, out io.Writer) int {
+	syncer, err := newSyncer(cfg)
+	if err != nil {
+		slog.Error("could not create storage client", "err", err)
+		return exitConfig
+	}
+
+	groups, err := syncer.FindDuplicates(ctx)
+	if err != nil {
+		slog.Error("failed to find duplicates", "err", err)
+		return exitSync
+	}
+
+	if len(groups) == 0 {
+		slog.Info("No duplicate cloud objects found")
+		return exitOK
+	}
+
+	redundant := 0
+	for _, g := range groups {
+		fmt.Fprintf(out, "%s (%d bytes, sha256 %s)\n", g.Keep, g.Size, g.Checksum)
+		for _, key := range g.Redundant {
+			fmt.Fprintf(out, "  duplicate: %s\n", key)
+			redundant++
+		}
+	}
+
+	fmt.Fprintf(out, "Remove %d redundant objects? Each is backed up to %s first. [y/N] ", redundant, cfg.BackupDir)
+	answer, _ := bufio.NewReader(in).ReadString('\n')
+	if a := strings.ToLower(strings.TrimSpace(answer)); a != "y" && a != "yes" {
+		slog.Info("Nothing removed")
+		return exitOK
+	}
+
+	removed, err := syncer.RemoveDuplicates(ctx, groups)
+	slog.Info("Removed redundant objects", "count", removed, "redundant", redundant)
+	if err != nil {
+		slog.Error("dedupe failed", "err", err)
+		return exitSync
+	}
+
+	return exitOK
+}
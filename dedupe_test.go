@@ -0,0 +1,61 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/danielbehrens/cloudsync/internal/config"
+	"github.com/danielbehrens/cloudsync/internal/storage"
+)
+
+func TestRunDedupeCloudListsDuplicates(t *testing.T) {
+	ctx := context.Background()
+	cloudDir := t.TempDir()
+
+	// The same save uploaded under two keys
+	src := filepath.Join(t.TempDir(), "slot1.sav")
+	if err := os.WriteFile(src, []byte("slot one"), 0o644); err != nil {
+		t.Fatalf("WriteFile() error = %v", err)
+	}
+	store := storage.NewLocalBackend(cloudDir)
+	for _, name := range []string{"slot1.sav", "slot1 (copy).sav"} {
+		if err := store.Upload(ctx, src, name); err != nil {
+			t.Fatalf("Upload(%s) error = %v", name, err)
+		}
+	}
+
+	cfg := &config.Config{
+		S3Config: config.S3Config{Backend: config.BackendLocal},
+		Watches: []config.WatchConfig{{
+			WatchPath:       t.TempDir(),
+			BackupDir:       t.TempDir(),
+			LocalDir:        cloudDir,
+			IncludePatterns: []string{"*.sav"},
+		}},
+	}
+	cfg.BackupDir = cfg.Watches[0].BackupDir
+
+	var out bytes.Buffer
+	if code := runDedupeCloud(ctx, cfg, strings.NewReader("n\n"), &out); code != exitOK {
+		t.Fatalf("runDedupeCloud() = %v, want %v", code, exitOK)
+	}
+
+	sum := sha256.Sum256([]byte("slot one"))
+	want := "slot1.sav (8 bytes, sha256 " + hex.EncodeToString(sum[:]) + ")\n" +
+		"  duplicate: slot1 (copy).sav\n" +
+		"Remove 1 redundant objects? Each is backed up to " + cfg.BackupDir + " first. [y/N] "
+	if got := out.String(); got != want {
+		t.Errorf("output = %q, want %q", got, want)
+	}
+
+	// Declining the prompt removes nothing
+	if _, err := store.Stat(ctx, "slot1 (copy).sav"); err != nil {
+		t.Errorf("Stat() after declining error = %v, want the duplicate kept", err)
+	}
+}
@@ -0,0 +1,241 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/danielbehrens/cloudsync/internal/config"
+	"github.com/danielbehrens/cloudsync/internal/storage"
+	"github.com/danielbehrens/cloudsync/internal/sync"
+)
+
+// doctorObjectPrefix starts the name of the test object doctor uploads. It
+// doesn't match the default include patterns, so a daemon syncing the same
+// bucket meanwhile ignores it.
+const doctorObjectPrefix = "cloudsync-doctor-"
+
+// doctorReport prints the doctor checklist and keeps the exit code of the
+// first failed check
+type doctorReport struct {
+	out    io.Writer
+	checks int
+	failed int
+	code   int
+}
+
+func (r *doctorReport) pass(check string) {
+	fmt.Fprintf(r.out, "  [ OK ] %s\n", check)
+	r.checks++
+}
+
+// fail records a failed check with the exit code it maps to and a hint on
+// how to fix it, which may be empty
+func (r *doctorReport) fail(check string, err error, code int, hint string) {
+	fmt.Fprintf(r.out, "  [FAIL] %s: %v\n", check, err)
+	if hint != "" {
+		fmt.Fprintf(r.out, "         %s\n", hint)
+	}
+	r.checks++
+	r.failed++
+	if r.code == exitOK {
+		r.code = code
+	}
+}
+
+func (r *doctorReport) skip(check string) {
+	fmt.Fprintf(r.out, "  [SKIP] %s\n", check)
+	r.checks++
+}
+
+// runDoctor checks every watch end to end: that the watch path is readable,
+// the backup dir writable, and the storage reachable with working
+// credentials and a bucket, and that a tiny test object survives a round
+// trip. It prints a checklist with a hint for each failure and returns
+// exitConfig or exitConnectivity for the first failed check, or exitOK.
+func runDoctor(ctx context.Context, cfg *config.Config, out io.Writer) int {
+	r := &doctorReport{out: out}
+	for _, w := range cfg.Watches {
+		fmt.Fprintf(out, "Watch %s\n", w.WatchPath)
+		doctorWatch(ctx, cfg, w, r)
+	}
+
+	if r.failed > 0 {
+		fmt.Fprintf(out, "\n%d of %d checks failed\n", r.failed, r.checks)
+		return r.code
+	}
+	fmt.Fprintf(out, "\nAll %d checks passed\n", r.checks)
+	return exitOK
+}
+
+// doctorWatch runs the checks for one watch. The storage checks after a
+// failed one are skipped, since they would fail the same way.
+func doctorWatch(ctx context.Context, cfg *config.Config, w config.WatchConfig, r *doctorReport) {
+	if err := checkReadableDir(w.WatchPath); err != nil {
+		hint := "Check the folder's permissions."
+		if errors.Is(err, os.ErrNotExist) {
+			hint = "Check -watch-path, or pass -create-watch-path if the game hasn't created the folder yet."
+		}
+		r.fail("watch path is readable", err, exitConfig, hint)
+	} else {
+		r.pass("watch path is readable")
+	}
+
+	if err := checkWritableDir(w.BackupDir); err != nil {
+		r.fail(fmt.Sprintf("backup dir %s is writable", w.BackupDir), err, exitConfig, "Check -backup-dir and the folder's permissions.")
+	} else {
+		r.pass(fmt.Sprintf("backup dir %s is writable", w.BackupDir))
+	}
+
+	reachable := "storage endpoint is reachable"
+	if cfg.S3Config.Backend == config.BackendS3 {
+		reachable = fmt.Sprintf("endpoint %s is reachable", cfg.S3Config.Endpoint)
+	}
+	credentials := "credentials are accepted"
+	bucket := fmt.Sprintf("bucket %s exists or can be created", w.BucketName)
+	if cfg.S3Config.Backend == config.BackendLocal {
+		bucket = fmt.Sprintf("local dir %s exists or can be created", w.LocalDir)
+	}
+	roundTrip := "test object round trip"
+
+	store, err := newWatchStorage(cfg, w)
+	if err != nil {
+		r.fail(reachable, err, exitConfig, "")
+		r.skip(credentials)
+		r.skip(bucket)
+		r.skip(roundTrip)
+		return
+	}
+
+	err = store.EnsureBucket(ctx)
+	switch {
+	case errors.Is(err, storage.ErrUnreachable):
+		r.fail(reachable, err, exitConnectivity, doctorHint(err))
+		r.skip(credentials)
+		r.skip(bucket)
+		r.skip(roundTrip)
+		return
+	case errors.Is(err, storage.ErrAccessDenied):
+		r.pass(reachable)
+		r.fail(credentials, err, exitConnectivity, doctorHint(err))
+		r.skip(bucket)
+		r.skip(roundTrip)
+		return
+	case err != nil:
+		r.pass(reachable)
+		r.pass(credentials)
+		hint := doctorHint(err)
+		if hint == "" {
+			hint = "Create the bucket by hand, or let the credentials create buckets."
+		}
+		r.fail(bucket, err, exitConnectivity, hint)
+		r.skip(roundTrip)
+		return
+	}
+	r.pass(reachable)
+	r.pass(credentials)
+	r.pass(bucket)
+
+	if err := checkRoundTrip(ctx, store); err != nil {
+		r.fail(roundTrip, err, exitConnectivity, "Check that the credentials may write, read and delete objects in the bucket.")
+	} else {
+		r.pass(roundTrip)
+	}
+}
+
+// doctorHint is storageHint as a sentence of its own
+func doctorHint(err error) string {
+	return strings.TrimPrefix(storageHint(err), ". ")
+}
+
+// checkReadableDir checks that dir is a directory whose entries can be listed
+func checkReadableDir(dir string) error {
+	info, err := os.Stat(dir)
+	if err != nil {
+		return err
+	}
+	if !info.IsDir() {
+		return fmt.Errorf("%s is not a directory", dir)
+	}
+	_, err = os.ReadDir(dir)
+	return err
+}
+
+// checkWritableDir checks that a file can be created in dir. A dir that
+// doesn't exist yet, which the daemon would create, is checked by its
+// nearest existing parent; doctor doesn't create it, since that could
+// create folders on a drive that isn't mounted.
+func checkWritableDir(dir string) error {
+	for {
+		info, err := os.Stat(dir)
+		if err == nil {
+			if !info.IsDir() {
+				return fmt.Errorf("%s is not a directory", dir)
+			}
+			break
+		}
+		parent := filepath.Dir(dir)
+		if !errors.Is(err, os.ErrNotExist) || parent == dir {
+			return err
+		}
+		dir = parent
+	}
+
+	f, err := os.CreateTemp(dir, doctorObjectPrefix+"*")
+	if err != nil {
+		return err
+	}
+	f.Close()
+	return os.Remove(f.Name())
+}
+
+// checkRoundTrip uploads a tiny test object, downloads it again and compares
+// the content, then deletes it
+func checkRoundTrip(ctx context.Context, store sync.Storage) (err error) {
+	id := make([]byte, 8)
+	if _, err := rand.Read(id); err != nil {
+		return err
+	}
+	objectName := doctorObjectPrefix + hex.EncodeToString(id)
+	content := []byte("cloudsync doctor " + time.Now().Format(time.RFC3339))
+
+	dir, err := os.MkdirTemp("", "cloudsync-doctor-*")
+	if err != nil {
+		return err
+	}
+	defer os.RemoveAll(dir)
+
+	src := filepath.Join(dir, "upload")
+	if err := os.WriteFile(src, content, 0644); err != nil {
+		return err
+	}
+	if err := store.Upload(ctx, src, objectName); err != nil {
+		return fmt.Errorf("upload: %w", err)
+	}
+	defer func() {
+		if derr := store.Delete(ctx, objectName); derr != nil && err == nil {
+			err = fmt.Errorf("delete %s: %w", objectName, derr)
+		}
+	}()
+
+	dst := filepath.Join(dir, "download")
+	if err := store.Download(ctx, objectName, dst); err != nil {
+		return fmt.Errorf("download: %w", err)
+	}
+	got, err := os.ReadFile(dst)
+	if err != nil {
+		return err
+	}
+	if !bytes.Equal(got, content) {
+		return fmt.Errorf("downloaded content differs from the upload")
+	}
+	return nil
+}
@@ -0,0 +1,41 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"log/slog"
+	"os"
+
+	"github.com/danielbehrens/cloudsync/internal/config"
+)
+
+// runExportManifest writes a JSON snapshot of every tracked file's local and
+// cloud state to cfg.ExportManifest for attaching to bug reports. Nothing is
+// transferred or modified. It returns the process exit code.
+func runExportManifest(ctx context.Context, cfg *config.Config) int {
+	syncer, err := newSyncer(cfg)
+	if err != nil {
+		slog.Error("could not create storage client", "err", err)
+		return exitConfig
+	}
+
+	manifest, err := syncer.BuildManifest(ctx)
+	if err != nil {
+		slog.Error("failed to build manifest", "err", err)
+		return exitSync
+	}
+
+	data, err := json.MarshalIndent(manifest, "", "  ")
+	if err != nil {
+		slog.Error("failed to encode manifest", "err", err)
+		return exitSync
+	}
+
+	if err := os.WriteFile(cfg.ExportManifest, append(data, '\n'), 0644); err != nil {
+		slog.Error("failed to write manifest", "err", err)
+		return exitSync
+	}
+
+	slog.Info("Wrote manifest", "files", len(manifest.Files), "path", cfg.ExportManifest)
+	return exitOK
+}
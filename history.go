@@ -0,0 +1,88 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"text/tabwriter"
+	"time"
+
+	"github.com/danielbehrens/cloudsync/internal/config"
+	"github.com/danielbehrens/cloudsync/internal/storage"
+	"github.com/danielbehrens/cloudsync/internal/sync"
+)
+
+// runHistory lists the versions of cfg.HistoryFile kept by a versioned
+// bucket, newest first, or restores one with cfg.RestoreVersion. It returns
+// the process exit code.
+func runHistory(ctx context.Context, cfg *config.Config, out io.Writer) int {
+	syncer, err := newSyncer(cfg)
+	if err != nil {
+		slog.Error("could not create storage client", "err", err)
+		return exitConfig
+	}
+
+	name, err := syncer.ObjectName(cfg.HistoryFile)
+	if err != nil {
+		slog.Error("history failed", "path", cfg.HistoryFile, "err", err)
+		return exitConfig
+	}
+
+	if cfg.RestoreVersion != "" {
+		if err := syncer.RestoreVersion(ctx, name, cfg.RestoreVersion); err != nil {
+			return historyError(cfg, name, err)
+		}
+		return exitOK
+	}
+
+	versions, err := syncer.Versions(ctx, name)
+	if err != nil {
+		return historyError(cfg, name, err)
+	}
+	if len(versions) == 0 {
+		fmt.Fprintf(out, "No versions of %s in bucket %s\n", name, cfg.S3Config.BucketName)
+		return exitOK
+	}
+
+	tw := tabwriter.NewWriter(out, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, "VERSION\tMODIFIED\tSIZE\t")
+	for _, v := range versions {
+		note := ""
+		switch {
+		case v.DeleteMarker:
+			note = "deleted"
+		case v.IsLatest:
+			note = "latest"
+		}
+		size := fmt.Sprint(v.Size)
+		if v.DeleteMarker {
+			size = "-"
+		}
+		fmt.Fprintf(tw, "%s\t%s\t%s\t%s\n", v.VersionID, v.ModTime.Local().Format(time.DateTime), size, note)
+	}
+	if err := tw.Flush(); err != nil {
+		slog.Error("failed to write history", "err", err)
+		return exitSync
+	}
+
+	return exitOK
+}
+
+// historyError logs why the history of name can't be read or restored and
+// returns the exit code for it
+func historyError(cfg *config.Config, name string, err error) int {
+	switch {
+	case errors.Is(err, sync.ErrNoVersions):
+		slog.Error("This backend keeps no earlier versions; history needs a versioned S3 bucket", "backend", cfg.S3Config.Backend)
+		return exitConfig
+	case errors.Is(err, storage.ErrVersioningDisabled):
+		slog.Error("Bucket keeps only the latest version of each save. Enable versioning on it (e.g. mc version enable <alias>/<bucket>) to keep a history from then on.",
+			"bucket", cfg.S3Config.BucketName)
+		return exitConfig
+	default:
+		slog.Error("history failed", "file", name, "err", err)
+		return exitSync
+	}
+}
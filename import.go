@@ -0,0 +1,39 @@
+package main
+
+import (
+	"context"
+	"log/slog"
+	"time"
+
+	"github.com/danielbehrens/cloudsync/internal/config"
+)
+
+// runImport seeds the bucket with every save file in cfg.ImportDir. It skips
+// the compare/download phase of a normal sync, so it is meant for migrating an
+// existing folder of saves into a fresh bucket. It returns the process exit
+// code.
+func runImport(ctx context.Context, cfg *config.Config) int {
+	syncer, err := newSyncer(cfg)
+	if err != nil {
+		slog.Error("could not create storage client", "err", err)
+		return exitConfig
+	}
+
+	if err := syncer.EnsureBucket(ctx); err != nil {
+		slog.Error("cannot use storage"+storageHint(err), "err", err)
+		return exitConnectivity
+	}
+
+	slog.Info("Importing", "path", cfg.ImportDir, "bucket", cfg.S3Config.BucketName)
+	summary, err := syncer.Import(ctx, cfg.ImportDir)
+	if summary != nil {
+		slog.Info("Import finished", "uploaded", summary.Uploaded, "skipped", summary.Skipped, "failed", summary.Failed,
+			"bytes", summary.Bytes, "duration", summary.Elapsed.Round(time.Millisecond))
+	}
+	if err != nil {
+		slog.Error("import failed", "err", err)
+		return exitSync
+	}
+
+	return exitOK
+}
@@ -0,0 +1,129 @@
+package main
+
+import (
+	"bufio"
+	"flag"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/danielbehrens/cloudsync/internal/config"
+)
+
+// runInit writes a commented config file for the init command. Settings
+// come from the flags in args; those not given are asked for on in when
+// interactive, and otherwise take their defaults. Credentials left empty
+// are read from environment variables when cloudsync loads the file. It
+// returns the process exit code.
+func runInit(args []string, in io.Reader, out io.Writer, interactive bool) int {
+	v := config.DefaultInitValues()
+
+	fs := flag.NewFlagSet("init", flag.ContinueOnError)
+	path := fs.String("config", "", "Where to write the config file (default: cloudsync/cloudsync.yaml in the user config directory)")
+	force := fs.Bool("force", false, "Overwrite an existing config file")
+	fs.StringVar(&v.WatchPath, "watch-path", v.WatchPath, "Folder holding the game's saves")
+	fs.StringVar(&v.Endpoint, "cloud-endpoint", v.Endpoint, "MinIO/S3 cloud endpoint")
+	fs.BoolVar(&v.UseSSL, "use-ssl", v.UseSSL, "Connect to the endpoint over HTTPS")
+	fs.StringVar(&v.BucketName, "bucket-name", v.BucketName, "Bucket name in cloud storage")
+	fs.StringVar(&v.AccessKey, "access-key", "", "Access key to write into the file (default: read from $"+config.EnvAccessKey+")")
+	fs.StringVar(&v.SecretKey, "secret-key", "", "Secret key to write into the file (default: read from $"+config.EnvSecretKey+")")
+	if err := fs.Parse(args); err != nil {
+		return exitConfig
+	}
+	if fs.NArg() != 0 {
+		slog.Error("usage: cloudsync init [flags]")
+		return exitConfig
+	}
+
+	if *path == "" {
+		var err error
+		if *path, err = config.DefaultConfigPath(); err != nil {
+			slog.Error("failed to find the config directory (use -config)", "err", err)
+			return exitConfig
+		}
+	}
+	if _, err := os.Stat(*path); err == nil && !*force {
+		slog.Error("config file already exists (use -force to overwrite it)", "path", *path)
+		return exitConfig
+	}
+
+	if interactive {
+		given := make(map[string]bool)
+		fs.Visit(func(f *flag.Flag) { given[f.Name] = true })
+
+		p := &prompter{in: bufio.NewReader(in), out: out}
+		if !given["watch-path"] {
+			v.WatchPath = p.ask("Save folder to watch", v.WatchPath)
+		}
+		if !given["cloud-endpoint"] {
+			v.Endpoint = p.ask("S3 endpoint (host:port)", v.Endpoint)
+		}
+		if !given["use-ssl"] {
+			v.UseSSL = p.confirm("Connect over HTTPS?", v.UseSSL)
+		}
+		if !given["bucket-name"] {
+			v.BucketName = p.ask("Bucket name", v.BucketName)
+		}
+		if !given["access-key"] && !given["secret-key"] {
+			fmt.Fprintf(out, "Credentials left empty are read from $%s and $%s at startup instead of being stored in the file.\n", config.EnvAccessKey, config.EnvSecretKey)
+			v.AccessKey = p.ask("Access key", "")
+			if v.AccessKey != "" {
+				v.SecretKey = p.ask("Secret key", "")
+			}
+		}
+	}
+
+	if err := config.WriteInitFile(*path, v, *force); err != nil {
+		slog.Error("failed to write config file", "err", err)
+		return exitConfig
+	}
+
+	fmt.Fprintf(out, "Wrote %s\n", *path)
+	if v.AccessKey == "" {
+		fmt.Fprintf(out, "Set %s and %s, then run: cloudsync -config %s\n", config.EnvAccessKey, config.EnvSecretKey, strconv.Quote(*path))
+	} else {
+		fmt.Fprintf(out, "Run: cloudsync -config %s\n", strconv.Quote(*path))
+	}
+	return exitOK
+}
+
+// prompter asks the questions of the interactive init command
+type prompter struct {
+	in  *bufio.Reader
+	out io.Writer
+}
+
+// ask prints question with its default and returns the answer, or def when
+// the answer is empty
+func (p *prompter) ask(question, def string) string {
+	if def != "" {
+		fmt.Fprintf(p.out, "%s [%s]: ", question, def)
+	} else {
+		fmt.Fprintf(p.out, "%s: ", question)
+	}
+	answer, _ := p.in.ReadString('\n')
+	if answer = strings.TrimSpace(answer); answer != "" {
+		return answer
+	}
+	return def
+}
+
+// confirm asks a yes/no question, returning def when the answer is empty
+func (p *prompter) confirm(question string, def bool) bool {
+	hint := "[y/N]"
+	if def {
+		hint = "[Y/n]"
+	}
+	fmt.Fprintf(p.out, "%s %s ", question, hint)
+	answer, _ := p.in.ReadString('\n')
+	switch strings.ToLower(strings.TrimSpace(answer)) {
+	case "y", "yes":
+		return true
+	case "n", "no":
+		return false
+	}
+	return def
+}
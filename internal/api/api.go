@@ -0,0 +1,256 @@
+// Package api serves the HTTP control API, for triggering syncs, reading
+// the status of every file and pausing sync from a dashboard or script.
+// Responses are JSON.
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net"
+	"net/http"
+	"sort"
+	gosync "sync"
+	"time"
+
+	"github.com/danielbehrens/cloudsync/internal/sync"
+)
+
+// shutdownTimeout bounds how long Serve waits for requests in flight
+const shutdownTimeout = 5 * time.Second
+
+// Server serves the API for the Syncers of the running watches. The zero
+// value is not usable, call New.
+type Server struct {
+	mu      gosync.Mutex
+	syncers map[string]*sync.Syncer // by watch path
+	paused  bool                    // set by SetPaused, applied to every Syncer
+}
+
+// New returns a Server with no watches
+func New() *Server {
+	return &Server{syncers: make(map[string]*sync.Syncer)}
+}
+
+// Register makes syncer the Syncer of the watch of watchPath, replacing
+// the one it had. A Syncer registered while sync is paused starts paused.
+func (s *Server) Register(watchPath string, syncer *sync.Syncer) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.paused {
+		syncer.Pause()
+	}
+	s.syncers[watchPath] = syncer
+}
+
+// Unregister removes the watch of watchPath, unless it has been given
+// another Syncer than syncer meanwhile
+func (s *Server) Unregister(watchPath string, syncer *sync.Syncer) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.syncers[watchPath] == syncer {
+		delete(s.syncers, watchPath)
+	}
+}
+
+// watches returns the registered watch paths, sorted, their Syncers and
+// whether sync is paused
+func (s *Server) watches() ([]string, map[string]*sync.Syncer, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	paths := make([]string, 0, len(s.syncers))
+	syncers := make(map[string]*sync.Syncer, len(s.syncers))
+	for p, syncer := range s.syncers {
+		paths = append(paths, p)
+		syncers[p] = syncer
+	}
+	sort.Strings(paths)
+	return paths, syncers, s.paused
+}
+
+// statusResponse is the body of GET /status
+type statusResponse struct {
+	Paused  bool          `json:"paused"`
+	Watches []watchStatus `json:"watches"`
+}
+
+// watchStatus is one watch in GET /status: its manifest, or the error
+// that kept it from being built
+type watchStatus struct {
+	WatchPath   string               `json:"watch_path"`
+	PauseReason string               `json:"pause_reason,omitempty"`
+	Files       []sync.ManifestEntry `json:"files"`
+	Error       string               `json:"error,omitempty"`
+}
+
+// syncResponse is the body of POST /sync
+type syncResponse struct {
+	Watches []watchSync `json:"watches"`
+}
+
+// watchSync is what POST /sync did for one watch, or why it couldn't
+type watchSync struct {
+	WatchPath  string `json:"watch_path"`
+	Uploaded   int    `json:"uploaded"`
+	Downloaded int    `json:"downloaded"`
+	Skipped    int    `json:"skipped"`
+	Conflicts  int    `json:"conflicts"`
+	Deleted    int    `json:"deleted"`
+	Failed     int    `json:"failed"`
+	BytesUp    int64  `json:"bytes_up"`
+	BytesDown  int64  `json:"bytes_down"`
+	Elapsed    string `json:"elapsed,omitempty"`
+	Error      string `json:"error,omitempty"`
+}
+
+// pauseResponse is the body of POST /pause and POST /resume
+type pauseResponse struct {
+	Paused bool `json:"paused"`
+}
+
+// Handler returns the handler serving the API's endpoints
+func (s *Server) Handler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /status", s.handleStatus)
+	mux.HandleFunc("POST /sync", s.handleSync)
+	mux.HandleFunc("POST /pause", s.handlePause)
+	mux.HandleFunc("POST /resume", s.handleResume)
+	return mux
+}
+
+// handleStatus reports every tracked file of every watch, as the status
+// command does
+func (s *Server) handleStatus(w http.ResponseWriter, r *http.Request) {
+	paths, syncers, paused := s.watches()
+
+	resp := statusResponse{Paused: paused, Watches: []watchStatus{}}
+	for _, p := range paths {
+		status := watchStatus{WatchPath: p, PauseReason: syncers[p].PauseReason(), Files: []sync.ManifestEntry{}}
+		manifest, err := syncers[p].BuildManifest(r.Context())
+		if err != nil {
+			status.Error = err.Error()
+		} else if manifest.Files != nil {
+			status.Files = manifest.Files
+		}
+		resp.Watches = append(resp.Watches, status)
+	}
+
+	writeJSON(w, http.StatusOK, resp)
+}
+
+// handleSync runs a full sync of every watch. It responds 409 Conflict if
+// a watch is paused and 500 if a sync failed; the body tells which.
+func (s *Server) handleSync(w http.ResponseWriter, r *http.Request) {
+	paths, syncers, _ := s.watches()
+
+	code := http.StatusOK
+	resp := syncResponse{Watches: []watchSync{}}
+	for _, p := range paths {
+		result := watchSync{WatchPath: p}
+		summary, err := syncers[p].SyncNow(r.Context())
+		switch {
+		case errors.Is(err, sync.ErrPaused):
+			result.Error = err.Error()
+			if code == http.StatusOK {
+				code = http.StatusConflict
+			}
+		case err != nil:
+			result.Error = err.Error()
+			code = http.StatusInternalServerError
+		default:
+			result.Uploaded = summary.Uploaded
+			result.Downloaded = summary.Downloaded
+			result.Skipped = summary.Skipped
+			result.Conflicts = summary.Conflicts
+			result.Deleted = summary.Deleted
+			result.Failed = summary.Failed
+			result.BytesUp = summary.BytesUp
+			result.BytesDown = summary.BytesDown
+			result.Elapsed = summary.Elapsed.Round(time.Millisecond).String()
+		}
+		resp.Watches = append(resp.Watches, result)
+	}
+
+	writeJSON(w, code, resp)
+}
+
+// handlePause pauses every watch, including those started later, until
+// POST /resume
+func (s *Server) handlePause(w http.ResponseWriter, r *http.Request) {
+	s.SetPaused(true)
+	writeJSON(w, http.StatusOK, pauseResponse{Paused: true})
+}
+
+// handleResume undoes POST /pause
+func (s *Server) handleResume(w http.ResponseWriter, r *http.Request) {
+	s.SetPaused(false)
+	writeJSON(w, http.StatusOK, pauseResponse{Paused: false})
+}
+
+// SetPaused pauses or resumes every registered Syncer, and those
+// registered later, as POST /pause and POST /resume do
+func (s *Server) SetPaused(paused bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.paused = paused
+	for _, syncer := range s.syncers {
+		if paused {
+			syncer.Pause()
+		} else {
+			syncer.Resume()
+		}
+	}
+}
+
+// writeJSON writes v as the response body with status code
+func writeJSON(w http.ResponseWriter, code int, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	enc.Encode(v)
+}
+
+// Listen opens addr for Serve, so a bad address fails at startup. An
+// address without a host, such as ":8080", listens on localhost only,
+// since the API has no authentication; give the host explicitly, e.g.
+// "0.0.0.0:8080", to listen on other interfaces.
+func Listen(addr string) (net.Listener, error) {
+	host, port, err := net.SplitHostPort(addr)
+	if err != nil {
+		return nil, fmt.Errorf("invalid API address %q: %w", addr, err)
+	}
+	if host == "" {
+		host = "localhost"
+	}
+
+	ln, err := net.Listen("tcp", net.JoinHostPort(host, port))
+	if err != nil {
+		return nil, fmt.Errorf("cannot listen for the API on %s: %w", addr, err)
+	}
+	return ln, nil
+}
+
+// Serve serves the API on ln until ctx is cancelled, which also aborts
+// syncs requested through it
+func (s *Server) Serve(ctx context.Context, ln net.Listener) {
+	srv := &http.Server{
+		Handler:           s.Handler(),
+		ReadHeaderTimeout: 10 * time.Second,
+		BaseContext:       func(net.Listener) context.Context { return ctx },
+	}
+
+	go func() {
+		<-ctx.Done()
+		shutdownCtx, cancel := context.WithTimeout(context.Background(), shutdownTimeout)
+		defer cancel()
+		srv.Shutdown(shutdownCtx)
+	}()
+
+	slog.Info("Serving the control API", "url", "http://"+ln.Addr().String())
+	if err := srv.Serve(ln); err != nil && !errors.Is(err, http.ErrServerClosed) {
+		slog.Warn("API server stopped", "err", err)
+	}
+}
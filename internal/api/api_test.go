@@ -0,0 +1,127 @@
+package api
+
+import (
+	"encoding/json"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/danielbehrens/cloudsync/internal/sync"
+	"github.com/danielbehrens/cloudsync/internal/sync/storagetest"
+)
+
+func newTestServer(t *testing.T) (*Server, *storagetest.FakeStorage, string) {
+	t.Helper()
+	dir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(dir, "game.sav"), []byte("save"), 0644); err != nil {
+		t.Fatalf("WriteFile() error = %v", err)
+	}
+
+	store := storagetest.New()
+	s := New()
+	s.Register(dir, sync.NewSyncer(store, dir, t.TempDir(), nil, 500*time.Millisecond))
+	return s, store, dir
+}
+
+// request serves method path and decodes the JSON response into v
+func request(t *testing.T, s *Server, method, path string, v any) int {
+	t.Helper()
+	rec := httptest.NewRecorder()
+	s.Handler().ServeHTTP(rec, httptest.NewRequest(method, path, nil))
+	if ct := rec.Header().Get("Content-Type"); ct != "application/json" {
+		t.Errorf("%s %s: Content-Type = %q, want application/json", method, path, ct)
+	}
+	if err := json.NewDecoder(rec.Body).Decode(v); err != nil {
+		t.Fatalf("%s %s: decoding response: %v", method, path, err)
+	}
+	return rec.Code
+}
+
+func TestSyncAndStatus(t *testing.T) {
+	s, store, dir := newTestServer(t)
+
+	var status statusResponse
+	if code := request(t, s, http.MethodGet, "/status", &status); code != http.StatusOK {
+		t.Fatalf("GET /status = %d, want 200", code)
+	}
+	if len(status.Watches) != 1 || status.Watches[0].WatchPath != dir {
+		t.Fatalf("status watches = %+v, want %s", status.Watches, dir)
+	}
+	if files := status.Watches[0].Files; len(files) != 1 || files[0].Verdict != sync.VerdictLocalOnly {
+		t.Errorf("status files = %+v, want game.sav local only", files)
+	}
+
+	var synced syncResponse
+	if code := request(t, s, http.MethodPost, "/sync", &synced); code != http.StatusOK {
+		t.Fatalf("POST /sync = %d, want 200", code)
+	}
+	if len(synced.Watches) != 1 || synced.Watches[0].Uploaded != 1 {
+		t.Errorf("sync watches = %+v, want 1 upload", synced.Watches)
+	}
+	if _, ok := store.Data("game.sav"); !ok {
+		t.Error("game.sav was not uploaded")
+	}
+
+	request(t, s, http.MethodGet, "/status", &status)
+	if files := status.Watches[0].Files; len(files) != 1 || files[0].Verdict != sync.VerdictInSync {
+		t.Errorf("status files after sync = %+v, want game.sav in sync", files)
+	}
+}
+
+func TestPauseResume(t *testing.T) {
+	s, store, dir := newTestServer(t)
+
+	var paused pauseResponse
+	if code := request(t, s, http.MethodPost, "/pause", &paused); code != http.StatusOK || !paused.Paused {
+		t.Fatalf("POST /pause = %d %+v, want 200 paused", code, paused)
+	}
+
+	var synced syncResponse
+	if code := request(t, s, http.MethodPost, "/sync", &synced); code != http.StatusConflict {
+		t.Errorf("POST /sync while paused = %d, want 409", code)
+	}
+	if store.Calls(storagetest.OpUpload) != 0 {
+		t.Error("POST /sync uploaded while paused")
+	}
+
+	// A Syncer replacing the paused one, as on reload, starts paused
+	next := sync.NewSyncer(store, dir, t.TempDir(), nil, 500*time.Millisecond)
+	s.Register(dir, next)
+	if got := next.PauseReason(); got != sync.PauseRequested {
+		t.Errorf("PauseReason() of a new Syncer = %q, want %q", got, sync.PauseRequested)
+	}
+
+	var status statusResponse
+	request(t, s, http.MethodGet, "/status", &status)
+	if !status.Paused || status.Watches[0].PauseReason != sync.PauseRequested {
+		t.Errorf("status = %+v, want paused by request", status)
+	}
+
+	if code := request(t, s, http.MethodPost, "/resume", &paused); code != http.StatusOK || paused.Paused {
+		t.Fatalf("POST /resume = %d %+v, want 200 not paused", code, paused)
+	}
+	if code := request(t, s, http.MethodPost, "/sync", &synced); code != http.StatusOK {
+		t.Errorf("POST /sync after resume = %d, want 200", code)
+	}
+}
+
+func TestListenDefaultsToLocalhost(t *testing.T) {
+	ln, err := Listen(":0")
+	if err != nil {
+		t.Fatalf("Listen() error = %v", err)
+	}
+	defer ln.Close()
+
+	addr := ln.Addr().(*net.TCPAddr)
+	if !addr.IP.IsLoopback() {
+		t.Errorf("Listen(\":0\") listens on %v, want a loopback address", addr)
+	}
+
+	if _, err := Listen("8080"); err == nil {
+		t.Error("Listen(\"8080\") error = nil, want an invalid address error")
+	}
+}
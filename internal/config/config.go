@@ -1,55 +1,1043 @@
 package config
 
 import (
+	"crypto/sha256"
+	"encoding/hex"
 	"flag"
 	"fmt"
 	"os"
 	"path/filepath"
+	"runtime"
 	"strings"
+	"time"
+	"unicode"
+
+	"github.com/danielbehrens/cloudsync/internal/fsutil"
+	"gopkg.in/yaml.v3"
 )
 
-// Config holds all application configuration
+// Config holds all application configuration. The yaml tags name the keys
+// accepted by LoadFromFile; fields tagged "-" are command-line only.
 type Config struct {
-	WatchPath   string
-	ProcessName string
-	BackupDir   string
-	S3Config    S3Config
+	WatchPath   string   `yaml:"watch_path"`
+	ProcessName string   `yaml:"process_name"`
+	BackupDir   string   `yaml:"backup_dir"`
+	S3Config    S3Config `yaml:"s3"`
+
+	// TempDir is where downloads are written before they replace the save.
+	// Empty writes them beside the save, on the same file system, so the
+	// replace is an atomic rename; elsewhere it is a copy.
+	TempDir string `yaml:"temp_dir"`
+
+	// WatchRetries is how many times to retry watching WatchPath at startup
+	// while it isn't ready, backing off between tries
+	WatchRetries int `yaml:"watch_retries"`
+
+	// CreateWatchPath creates WatchPath if it doesn't exist yet
+	CreateWatchPath bool `yaml:"create_watch_path"`
+
+	// WaitForWatchPath starts without a WatchPath that doesn't exist yet,
+	// such as the save folder of a game never launched, and watches and
+	// syncs it once it is created. Unset, a missing WatchPath is retried
+	// WatchRetries times and then fails.
+	WaitForWatchPath bool `yaml:"wait_for_watch_path"`
+
+	// ShutdownGrace is how long a sync in flight at SIGINT/SIGTERM may take
+	// to wind down before the process exits anyway
+	ShutdownGrace time.Duration `yaml:"shutdown_grace"`
+
+	// TimeTolerance is how far apart local and cloud modification times may
+	// be and still count as in sync. Zero requires an exact match.
+	TimeTolerance time.Duration `yaml:"time_tolerance"`
+
+	// CoarseTimeTolerance is the tolerance for cloud objects without the
+	// nanosecond Modtime metadata, whose mod time is the server's
+	// second-resolution upload time. It applies only when larger than
+	// TimeTolerance.
+	CoarseTimeTolerance time.Duration `yaml:"coarse_time_tolerance"`
+
+	// DebounceMode selects how bursts of events on a save are collapsed:
+	// DebounceLeading (default) syncs on the first event and ignores the
+	// rest of the cooldown, DebounceTrailing syncs once the file has been
+	// quiet for the cooldown
+	DebounceMode string `yaml:"debounce_mode"`
+
+	// SyncInterval is how often a watch with a process name runs a full
+	// sync, to catch changes made while the game ran. Zero disables it.
+	SyncInterval time.Duration `yaml:"sync_interval"`
+
+	// FullSyncInterval is how often every watch runs a full sync, with or
+	// without a process name, in case a file event was missed. Zero
+	// disables it.
+	FullSyncInterval time.Duration `yaml:"full_sync_interval"`
+
+	// ProcessMatch selects how ProcessName is compared with running
+	// processes: ProcessMatchSubstring (default, any name containing it),
+	// ProcessMatchExact or ProcessMatchPath (each name is the full path
+	// of the executable)
+	ProcessMatch string `yaml:"process_match"`
+
+	// ProcessCacheTTL is how long a scan of the running processes is
+	// reused, so bursts of events don't each list every process. A game
+	// that exits is noticed at most this late. Zero scans every time.
+	ProcessCacheTTL time.Duration `yaml:"process_cache_ttl"`
+
+	// TriggerOps names the file system operations that trigger a sync
+	// (create, write, remove, rename, chmod). Empty means write and create.
+	TriggerOps []string `yaml:"trigger_ops"`
+
+	// IncludePatterns and ExcludePatterns select the files to sync by base
+	// name using filepath.Match globs, ignoring case. A file syncs if it
+	// matches an include pattern and no exclude pattern.
+	IncludePatterns []string `yaml:"include_patterns"`
+	ExcludePatterns []string `yaml:"exclude_patterns"`
+
+	// PriorityPatterns are glob patterns for files that sync before others
+	PriorityPatterns []string `yaml:"priority_patterns"`
+
+	// ChecksumMode skips transfers when local and cloud content hashes
+	// match, whatever the modification times say
+	ChecksumMode bool `yaml:"checksum_mode"`
+
+	// MinFileSize and MinSizeRatio keep saves a crash left empty or
+	// truncated from spreading: smaller files aren't uploaded, and a cloud
+	// copy below MinFileSize, or below MinSizeRatio times the local file,
+	// doesn't replace it. Zero turns either guard off.
+	MinFileSize  int64   `yaml:"min_file_size"`
+	MinSizeRatio float64 `yaml:"min_size_ratio"`
+
+	// VerifyAfterUpload reads every upload back and compares checksums
+	VerifyAfterUpload bool `yaml:"verify_after_upload"`
+
+	// DryRun logs the transfers and backups sync would make without making
+	// them
+	DryRun bool `yaml:"dry_run"`
+
+	// Progress shows a progress line for each upload and download when
+	// stdout is a terminal
+	Progress bool `yaml:"progress"`
+
+	// PauseOnBattery pauses sync while the system runs on battery power
+	PauseOnBattery bool `yaml:"pause_on_battery"`
+
+	// HardlinkBackups hardlinks backups instead of copying them when the
+	// backup dir shares a file system with the watch path
+	HardlinkBackups bool `yaml:"hardlink_backups"`
+
+	// MaxBackups keeps at most this many timestamped backup folders and
+	// MaxBackupAge removes folders older than it. Zero means no limit.
+	MaxBackups   int           `yaml:"max_backups"`
+	MaxBackupAge time.Duration `yaml:"max_backup_age"`
+
+	// BackupWindow coalesces the backups of a burst of changes into one
+	// folder and skips backing up content already backed up within it.
+	// Zero gives every backup its own folder.
+	BackupWindow time.Duration `yaml:"backup_window"`
+
+	// KeepGoodCopy maintains a latest-known-good copy of each save in
+	// GoodCopyDir, refreshed only after a checksum-verified sync
+	KeepGoodCopy bool   `yaml:"keep_good_copy"`
+	GoodCopyDir  string `yaml:"-"`
+
+	// ConflictStrategy resolves files changed both locally and in the cloud
+	// since the last sync, as recorded in StateFile: ConflictNewerWins
+	// (default), ConflictKeepBoth, ConflictLocalWins or ConflictCloudWins
+	ConflictStrategy string `yaml:"conflict_strategy"`
+	StateFile        string `yaml:"-"`
+
+	// Direction limits syncing to one way: DirectionBidirectional
+	// (default), DirectionDownloadOnly or DirectionUploadOnly
+	Direction string `yaml:"direction"`
+
+	// JournalFile records downloads in progress, so one interrupted by a
+	// crash is cleaned up or finished at the next start
+	JournalFile string `yaml:"-"`
+
+	// Concurrency is how many files the initial sync transfers at once
+	Concurrency int `yaml:"concurrency"`
+
+	// ForceFullSync stats every cloud object at startup instead of reusing
+	// the metadata cached in StateFile for objects whose ETag is unchanged
+	ForceFullSync bool `yaml:"force_full_sync"`
+
+	// PropagateDeletes syncs deletions: a save deleted on one machine is
+	// deleted from the cloud and, via a tombstone, on the others
+	PropagateDeletes bool `yaml:"propagate_deletes"`
+
+	// Recursive watches and syncs the subdirectories of each watch path
+	// too. Object names are then paths relative to the watch path.
+	Recursive bool `yaml:"recursive"`
+
+	// SyncEmptyDirs, with Recursive, stores each empty subdirectory as an
+	// empty marker object so it is recreated on the other machines
+	SyncEmptyDirs bool `yaml:"sync_empty_dirs"`
+
+	// LogFormat selects the log output: LogFormatText (default) or
+	// LogFormatJSON, one object per line for log shippers
+	LogFormat string `yaml:"log_format"`
+
+	// LogLevel is the least severe level logged: LogLevelError,
+	// LogLevelWarn, LogLevelInfo (default) or LogLevelDebug, which adds
+	// the routine per-file comparisons and backups
+	LogLevel string `yaml:"log_level"`
+
+	// NotifyURL, when set, receives a JSON POST after every completed
+	// upload or download matching NotifyEvents (upload, download or both).
+	// NotifyTemplate is a text/template for the message; empty uses the
+	// notify package's default.
+	NotifyURL      string `yaml:"notify_url"`
+	NotifyEvents   string `yaml:"notify_events"`
+	NotifyTemplate string `yaml:"notify_template"`
+
+	// MetricsAddr, when set, is the host:port serving Prometheus metrics at
+	// /metrics, e.g. ":9090"
+	MetricsAddr string `yaml:"metrics_addr"`
+
+	// APIAddr, when set, is the host:port serving the HTTP control API.
+	// Without a host, e.g. ":8080", it listens on localhost only.
+	APIAddr string `yaml:"api_addr"`
+
+	// Watches lists the folders the daemon syncs, each in its own bucket.
+	// parseFlags fills it in: from the config file's watches, or else as
+	// a single entry built from WatchPath and the other top-level settings.
+	// The top-level fields then mirror the first entry, which is the one
+	// the one-shot commands act on.
+	Watches []WatchConfig `yaml:"watches"`
+
+	// The fields below select one-shot commands and are flag-only
+
+	// ShareFile, set by the share command, names the save to print a
+	// download link for
+	ShareFile string `yaml:"-"`
+
+	// HistoryFile, set by the history command, names the save whose
+	// stored versions are listed, or restored from with RestoreVersion
+	HistoryFile    string `yaml:"-"`
+	RestoreVersion string `yaml:"-"`
+
+	// Doctor, set by the doctor command, checks the watch paths, backup
+	// dirs and storage end to end and prints what is wrong
+	Doctor bool `yaml:"-"`
+
+	// PruneCloud, set by the prune-cloud command, deletes cloud objects
+	// with no local counterpart that are older than PruneAge. Yes skips
+	// its confirmation prompt.
+	PruneCloud bool          `yaml:"-"`
+	Yes        bool          `yaml:"-"`
+	PruneAge   time.Duration `yaml:"prune_age"`
+
+	// ShareExpiry is how long a share link stays valid, at most
+	// MaxShareExpiry
+	ShareExpiry time.Duration `yaml:"share_expiry"`
+
+	// ShowVersion prints the build metadata and exits. parseFlags returns
+	// as soon as it is set, without loading or validating anything else.
+	ShowVersion bool `yaml:"-"`
+
+	// RestoreGood, when set, restores the named save (or "all") from its
+	// latest-known-good copy and exits
+	RestoreGood string `yaml:"-"`
+
+	// ListBackups prints the timestamped backups and exits
+	ListBackups bool `yaml:"-"`
+
+	// RestoreBackup, when set, restores the saves in the named timestamped
+	// backup (or the newest, for "latest") and exits
+	RestoreBackup string `yaml:"-"`
+
+	// DedupeCloud reports cloud objects with identical content under
+	// different keys and, once confirmed, removes the redundant copies
+	DedupeCloud bool `yaml:"-"`
+
+	// ImportDir, when set, uploads every save in the directory and exits
+	ImportDir string `yaml:"-"`
+
+	// ExportManifest, when set, writes a JSON snapshot of local and cloud
+	// file state to this path and exits
+	ExportManifest string `yaml:"-"`
+
+	// Status prints each tracked file's local and cloud mod time and
+	// verdict, then exits
+	Status bool `yaml:"-"`
+
+	// Verify, set by the verify command or -verify, downloads the cloud
+	// copy of each save and compares its content hash with the local file,
+	// then exits. VerifySample is the fraction of saves it checks, picked
+	// at random.
+	Verify       bool    `yaml:"-"`
+	VerifySample float64 `yaml:"-"`
+
+	// Once runs a single full sync of every watch and exits, for running
+	// from a scheduler instead of as a daemon
+	Once bool `yaml:"-"`
+
+	// ConfigFile is the -config file, which Reload reads again. args are
+	// the command-line arguments it was parsed with.
+	ConfigFile string `yaml:"-"`
+	args       []string
+}
+
+// WatchConfig is one folder synced by the daemon. Fields left empty take
+// the top-level value, except BackupDir, which defaults to a folder of its
+// own in the OS data directory (see defaultBackupDir).
+type WatchConfig struct {
+	WatchPath   string `yaml:"watch_path"`
+	BackupDir   string `yaml:"backup_dir"`
+	ProcessName string `yaml:"process_name"`
+
+	// ProcessNames is ProcessName split at commas: sync pauses while any
+	// of them runs
+	ProcessNames []string `yaml:"-"`
+
+	// BucketName (or LocalDir, for BackendLocal) is where this folder's
+	// saves are stored. It must differ between watches.
+	BucketName string `yaml:"bucket_name"`
+	LocalDir   string `yaml:"local_dir"`
+
+	// ObjectPrefix namespaces this folder's saves within the bucket, so
+	// watches may share one
+	ObjectPrefix string `yaml:"object_prefix"`
+
+	IncludePatterns []string `yaml:"include_patterns"`
+	ExcludePatterns []string `yaml:"exclude_patterns"`
+
+	// GoodCopyDir, StateFile and JournalFile are derived from BackupDir
+	GoodCopyDir string `yaml:"-"`
+	StateFile   string `yaml:"-"`
+	JournalFile string `yaml:"-"`
 }
 
-// S3Config holds S3/MinIO connection details
+// S3Config holds the storage connection details. Despite the name it also
+// selects and configures the other backends.
 type S3Config struct {
-	Endpoint   string
-	AccessKey  string
-	SecretKey  string
-	BucketName string
-	UseSSL     bool
+	// Backend selects the storage: BackendS3 (default), BackendAzure or
+	// BackendLocal
+	Backend string `yaml:"backend"`
+
+	// LocalDir is the directory objects are stored in by BackendLocal
+	LocalDir string `yaml:"local_dir"`
+
+	Endpoint   string `yaml:"endpoint"`
+	AccessKey  string `yaml:"access_key"`
+	SecretKey  string `yaml:"secret_key"`
+	BucketName string `yaml:"bucket_name"`
+
+	// SessionToken goes with temporary AccessKey and SecretKey, e.g. from
+	// STS or an assumed role
+	SessionToken string `yaml:"session_token"`
+
+	// Credentials selects where the S3 credentials come from:
+	// CredentialsStatic (default) uses AccessKey and SecretKey, which are
+	// then required; CredentialsChain tries them first, then the standard
+	// AWS sources
+	Credentials string `yaml:"credentials"`
+
+	// ObjectPrefix is prepended to every object name, e.g. "dragonwilds/",
+	// to keep several games or machines apart in one bucket. It is empty
+	// or ends in a slash.
+	ObjectPrefix string `yaml:"object_prefix"`
+
+	// PerMachine stores each machine's saves as separate objects, named
+	// with "@" and MachineID appended, and syncs only this machine's. No
+	// machine overwrites another's copy.
+	PerMachine bool `yaml:"per_machine"`
+
+	// MachineID names this machine's objects with PerMachine. Empty means
+	// the hostname.
+	MachineID string `yaml:"machine_id"`
+
+	// UseSSL connects over HTTPS. An endpoint given as https://host also
+	// sets it.
+	UseSSL bool `yaml:"use_ssl"`
+
+	// CACert is a PEM file of CA certificates trusted besides the system
+	// ones, for servers with a certificate from a private CA
+	CACert string `yaml:"ca_cert"`
+
+	// InsecureSkipVerify accepts any server certificate. It leaves the
+	// connection open to interception and is meant only for testing
+	// against self-signed certificates.
+	InsecureSkipVerify bool `yaml:"insecure_skip_verify"`
+
+	// Region is the bucket's region. Empty looks it up from the bucket,
+	// which most S3-compatible servers support.
+	Region string `yaml:"region"`
+
+	// ListStatConcurrency bounds how many StatObject calls List issues in
+	// parallel. Higher values list large buckets faster against AWS but can
+	// overwhelm small self-hosted MinIO servers.
+	ListStatConcurrency int `yaml:"list_stat_concurrency"`
+
+	// PartSizeMiB is the size of each part of a multipart upload, used for
+	// files larger than one part. A failed part is retried on its own
+	// instead of restarting the upload. S3 needs at least MinPartSizeMiB.
+	PartSizeMiB int `yaml:"part_size_mib"`
+
+	// UploadThreads is how many parts of one multipart upload are sent at
+	// once.
+	UploadThreads int `yaml:"upload_threads"`
+
+	// EncryptionPassphrase, when set, encrypts uploads client-side with
+	// AES-256-GCM under a key derived from it. Downloading an encrypted
+	// object needs the same passphrase.
+	EncryptionPassphrase string `yaml:"encryption_passphrase"`
+
+	// SSE, when set, asks the server to encrypt uploads at rest with its
+	// own keys (SSES3) or with a KMS key (SSEKMS), and makes downloads
+	// check that objects report that encryption. It is independent of
+	// EncryptionPassphrase and can be combined with it.
+	SSE string `yaml:"sse"`
+
+	// SSEKMSKeyID is the KMS key SSEKMS encrypts with; empty uses the
+	// bucket's default key
+	SSEKMSKeyID string `yaml:"sse_kms_key_id"`
+
+	// Compress gzips uploads. Compressed objects are decompressed on
+	// download either way.
+	Compress bool `yaml:"compress"`
+
+	// Metadata is extra user metadata stored on every upload, e.g. to tag
+	// objects for bucket lifecycle rules
+	Metadata map[string]string `yaml:"metadata"`
+
+	// VersionRetentionDays, when positive, makes EnsureBucket add a
+	// lifecycle rule to the bucket that expires versions this many days
+	// after a newer upload replaced them. It needs a bucket with
+	// versioning enabled.
+	VersionRetentionDays int `yaml:"version_retention_days"`
+
+	// Retry controls how calls failing with transient errors are retried
+	Retry RetryConfig `yaml:"retry"`
+
+	// Azure holds the storage account of BackendAzure
+	Azure AzureConfig `yaml:"azure"`
+}
+
+// AzureConfig is the Azure storage account used by BackendAzure. Each
+// bucket is a blob container in it.
+type AzureConfig struct {
+	// Account and Key are the storage account's name and one of its
+	// access keys
+	Account string `yaml:"account"`
+	Key     string `yaml:"key"`
+
+	// ConnectionString replaces Account and Key, e.g. to use a SAS token
+	// or the Azurite emulator
+	ConnectionString string `yaml:"connection_string"`
+}
+
+// RetryConfig controls retries of storage calls that fail with transient
+// errors (timeouts, 5xx responses, reset connections). Permanent errors
+// such as 403 or 404 are never retried.
+type RetryConfig struct {
+	// MaxAttempts is the total number of tries per call, including the
+	// first
+	MaxAttempts int `yaml:"max_attempts"`
+
+	// InitialBackoff is the delay after the first failure. It doubles after
+	// each further failure, up to MaxBackoff.
+	InitialBackoff time.Duration `yaml:"initial_backoff"`
+	MaxBackoff     time.Duration `yaml:"max_backoff"`
+}
+
+// Storage backends selectable with S3Config.Backend
+const (
+	BackendS3    = "s3"    // S3 or MinIO
+	BackendAzure = "azure" // Azure Blob Storage
+	BackendLocal = "local" // a local directory, e.g. a NAS mount
+)
+
+// Server-side encryption modes selectable with S3Config.SSE
+const (
+	SSES3  = "s3"  // SSE-S3, keys managed by the server
+	SSEKMS = "kms" // SSE-KMS, keys managed by a key management service
+)
+
+// Credential sources selectable with S3Config.Credentials
+const (
+	// CredentialsStatic uses the access key, secret key and session token
+	// as given
+	CredentialsStatic = "static"
+
+	// CredentialsChain uses the first credentials found among the given
+	// keys, the AWS_ACCESS_KEY_ID, AWS_SECRET_ACCESS_KEY and
+	// AWS_SESSION_TOKEN environment variables, the shared credentials
+	// file (~/.aws/credentials) and the IAM role of the EC2 instance,
+	// ECS task or EKS pod
+	CredentialsChain = "chain"
+)
+
+// Conflict strategies selectable with ConflictStrategy
+const (
+	ConflictNewerWins = "newer-wins" // transfer the newer side
+	ConflictKeepBoth  = "keep-both"  // keep local, save the cloud version beside it
+	ConflictLocalWins = "local-wins"
+	ConflictCloudWins = "cloud-wins"
+)
+
+// Sync directions selectable with Direction
+const (
+	DirectionBidirectional = "bidirectional"
+	DirectionDownloadOnly  = "download-only" // never change the cloud
+	DirectionUploadOnly    = "upload-only"   // never change local files
+)
+
+// Process matching modes selectable with ProcessMatch
+const (
+	ProcessMatchSubstring = "substring"
+	ProcessMatchExact     = "exact"
+	ProcessMatchPath      = "path"
+)
+
+// Debounce modes selectable with DebounceMode
+const (
+	DebounceLeading  = "leading"
+	DebounceTrailing = "trailing"
+)
+
+// Log formats selectable with LogFormat
+const (
+	LogFormatText = "text"
+	LogFormatJSON = "json"
+)
+
+// Log levels selectable with LogLevel
+const (
+	LogLevelError = "error"
+	LogLevelWarn  = "warn"
+	LogLevelInfo  = "info"
+	LogLevelDebug = "debug"
+)
+
+// DefaultListStatConcurrency is used when ListStatConcurrency is unset
+const DefaultListStatConcurrency = 8
+
+// Multipart upload limits and defaults. S3 rejects parts below 5 MiB,
+// except the last, and above 5 GiB. The defaults are minio-go's.
+const (
+	MinPartSizeMiB       = 5
+	MaxPartSizeMiB       = 5 * 1024
+	DefaultPartSizeMiB   = 16
+	DefaultUploadThreads = 4
+)
+
+// Retry defaults, used when RetryConfig.MaxAttempts is unset
+const (
+	DefaultRetryAttempts   = 4
+	DefaultRetryBackoff    = 500 * time.Millisecond
+	DefaultRetryMaxBackoff = 10 * time.Second
+)
+
+// DefaultConcurrency is used when Concurrency is unset
+const DefaultConcurrency = 4
+
+// DefaultShutdownGrace is used when ShutdownGrace is unset
+const DefaultShutdownGrace = 10 * time.Second
+
+// DefaultSyncInterval is used when SyncInterval is unset
+const DefaultSyncInterval = 10 * time.Second
+
+// DefaultProcessCacheTTL is used when ProcessCacheTTL is unset
+const DefaultProcessCacheTTL = 2 * time.Second
+
+// DefaultShareExpiry is used when ShareExpiry is unset
+const DefaultShareExpiry = 24 * time.Hour
+
+// DefaultPruneAge is used when PruneAge is unset
+const DefaultPruneAge = 30 * 24 * time.Hour
+
+// MaxShareExpiry is the longest S3 allows a presigned URL to stay valid
+const MaxShareExpiry = 7 * 24 * time.Hour
+
+// DefaultWatchRetries covers roughly 30 seconds of backoff at startup
+const DefaultWatchRetries = 5
+
+// DefaultMinFileSize keeps empty files, which a game crashing mid-save
+// commonly leaves, from being uploaded
+const DefaultMinFileSize = 1
+
+// defaults returns a Config holding the default value of every setting
+func defaults() *Config {
+	return &Config{
+		ProcessName:         "RSDragonwilds-Win64-Shipping.exe",
+		WatchRetries:        DefaultWatchRetries,
+		WaitForWatchPath:    true,
+		ShutdownGrace:       DefaultShutdownGrace,
+		ShareExpiry:         DefaultShareExpiry,
+		PruneAge:            DefaultPruneAge,
+		VerifySample:        1,
+		SyncInterval:        DefaultSyncInterval,
+		TimeTolerance:       500 * time.Millisecond,
+		CoarseTimeTolerance: 2 * time.Second,
+		TriggerOps:          []string{"write", "create"},
+		ProcessMatch:        ProcessMatchSubstring,
+		DebounceMode:        DebounceLeading,
+
+		ConflictStrategy: ConflictNewerWins,
+		Direction:        DirectionBidirectional,
+		Concurrency:      DefaultConcurrency,
+		LogFormat:        LogFormatText,
+		LogLevel:         LogLevelInfo,
+		NotifyEvents:     "both",
+		ProcessCacheTTL:  DefaultProcessCacheTTL,
+		MinFileSize:      DefaultMinFileSize,
+
+		IncludePatterns: fsutil.DefaultIncludePatterns,
+		ExcludePatterns: fsutil.DefaultExcludePatterns,
+		S3Config: S3Config{
+			Backend:             BackendS3,
+			Credentials:         CredentialsStatic,
+			Endpoint:            "localhost:9000",
+			BucketName:          "gamesync-dragonwilds",
+			ListStatConcurrency: DefaultListStatConcurrency,
+			PartSizeMiB:         DefaultPartSizeMiB,
+			UploadThreads:       DefaultUploadThreads,
+			Retry: RetryConfig{
+				MaxAttempts:    DefaultRetryAttempts,
+				InitialBackoff: DefaultRetryBackoff,
+				MaxBackoff:     DefaultRetryMaxBackoff,
+			},
+		},
+	}
 }
 
-// LoadFromFlags parses command-line flags and returns a Config
+// LoadFromFile reads a YAML or JSON config file (JSON is parsed as the YAML
+// subset it is) over the defaults. Unknown keys are ignored so older files
+// keep working as fields are added. Credentials written as "${NAME}" are
+// read from environment variable NAME. The result is not validated, since
+// required settings such as credentials may still come from flags.
+func LoadFromFile(path string) (*Config, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read config file: %w", err)
+	}
+
+	cfg := defaults()
+	if err := yaml.Unmarshal(data, cfg); err != nil {
+		return nil, fmt.Errorf("failed to parse config file %s: %w", path, err)
+	}
+	if err := cfg.expandSecrets(); err != nil {
+		return nil, fmt.Errorf("config file %s: %w", path, err)
+	}
+
+	return cfg, nil
+}
+
+// LoadFromFlags parses command-line flags and returns a Config. When -config
+// names a file its values replace the defaults, and flags given on the
+// command line override both. A leading "share <file>" or "history <file>"
+// command sets ShareFile or HistoryFile; its flags go between the two. A
+// leading "doctor", "prune-cloud" or "verify" sets Doctor, PruneCloud or
+// Verify.
 func LoadFromFlags() (*Config, error) {
-	cfg := &Config{}
+	return parseFlags(flag.CommandLine, os.Args[1:])
+}
 
-	flag.StringVar(&cfg.WatchPath, "watch-path", "", "Path to watch for file changes (auto-generated if empty)")
-	flag.StringVar(&cfg.ProcessName, "process-name", "RSDragonwilds-Win64-Shipping.exe", "Process name to pause sync when running")
-	flag.StringVar(&cfg.BackupDir, "backup-dir", "", "Directory to store backups (auto-generated if empty)")
-	flag.StringVar(&cfg.S3Config.Endpoint, "cloud-endpoint", "localhost:9000", "MinIO/S3 cloud endpoint")
-	flag.StringVar(&cfg.S3Config.AccessKey, "access-key", "", "Cloud storage access key")
-	flag.StringVar(&cfg.S3Config.SecretKey, "secret-key", "", "Cloud storage secret key")
-	flag.StringVar(&cfg.S3Config.BucketName, "bucket-name", "gamesync-dragonwilds", "Bucket name in cloud storage")
+func parseFlags(fs *flag.FlagSet, args []string) (*Config, error) {
+	cfg := defaults()
+	allArgs := args
+
+	// The share and history commands take the save they act on as an
+	// argument after the flags; doctor, prune-cloud and verify take none
+	var command string
+	if len(args) > 0 && (args[0] == "share" || args[0] == "history" || args[0] == "doctor" || args[0] == "prune-cloud" || args[0] == "verify") {
+		command, args = args[0], args[1:]
+	}
+
+	configPath := fs.String("config", "", "YAML or JSON config file (flags override its values)")
+	fs.BoolVar(&cfg.ShowVersion, "version", false, "Print the version and build details and exit")
+	fs.StringVar(&cfg.WatchPath, "watch-path", cfg.WatchPath, "Path to watch for file changes (auto-generated if empty)")
+	fs.IntVar(&cfg.WatchRetries, "watch-retries", cfg.WatchRetries, "Retries with backoff while the watch path isn't ready at startup (e.g. drive still mounting)")
+	fs.BoolVar(&cfg.CreateWatchPath, "create-watch-path", cfg.CreateWatchPath, "Create the watch path if it doesn't exist yet")
+	fs.BoolVar(&cfg.WaitForWatchPath, "wait-for-watch-path", cfg.WaitForWatchPath, "Start without a watch path that doesn't exist yet (e.g. a game never launched) and sync it once created; false fails after -watch-retries")
+	fs.DurationVar(&cfg.ShutdownGrace, "shutdown-grace", cfg.ShutdownGrace, "How long an in-flight sync may take to stop on Ctrl+C or SIGTERM before exiting anyway")
+	fs.StringVar(&cfg.ProcessName, "process-name", cfg.ProcessName, "Comma-separated process names that pause sync while any of them runs (e.g. a launcher and the game)")
+	fs.StringVar(&cfg.DebounceMode, "debounce-mode", cfg.DebounceMode, "When a burst of writes to a save syncs: leading (on the first write) or trailing (once the file has been quiet for a second)")
+	fs.DurationVar(&cfg.SyncInterval, "sync-interval", cfg.SyncInterval, "How often to run a full sync while no -process-name process runs, catching up on changes made while it ran (0 disables)")
+	fs.DurationVar(&cfg.FullSyncInterval, "full-sync-interval", cfg.FullSyncInterval, "How often to run a full sync regardless of -process-name, as a safety net against missed file events, e.g. 5m (0 disables)")
+	fs.DurationVar(&cfg.ProcessCacheTTL, "process-cache-ttl", cfg.ProcessCacheTTL, "How long a scan of the running processes is reused before -process-name is checked again (0 scans every time)")
+	fs.StringVar(&cfg.ProcessMatch, "process-match", cfg.ProcessMatch, "How -process-name matches running processes: substring (name contains it), exact (whole name) or path (full executable path)")
+	fs.StringVar(&cfg.BackupDir, "backup-dir", cfg.BackupDir, "Directory to store backups and sync state (default: a folder per watch path under the OS data directory)")
+	fs.StringVar(&cfg.TempDir, "temp-dir", cfg.TempDir, "Directory for downloads in progress (default: beside each save, so replacing it is an atomic rename)")
+	fs.StringVar(&cfg.ConflictStrategy, "conflict-strategy", cfg.ConflictStrategy, "How to resolve saves changed both locally and in the cloud since the last sync: newer-wins, keep-both, local-wins or cloud-wins")
+	fs.StringVar(&cfg.Direction, "direction", cfg.Direction, "Which way to sync: bidirectional, download-only (never change the cloud) or upload-only (never change local saves)")
+	fs.IntVar(&cfg.Concurrency, "concurrency", cfg.Concurrency, "Files transferred at once during the initial sync")
+	fs.BoolVar(&cfg.ForceFullSync, "force-full-sync", cfg.ForceFullSync, "Stat every cloud object at startup instead of trusting the cached listing")
+	fs.BoolVar(&cfg.PropagateDeletes, "propagate-deletes", cfg.PropagateDeletes, "Delete saves from the cloud and other machines when they are deleted locally (backups are kept)")
+	fs.BoolVar(&cfg.Recursive, "recursive", cfg.Recursive, "Sync the subdirectories of the watch path too (except the backup dir)")
+	fs.BoolVar(&cfg.SyncEmptyDirs, "sync-empty-dirs", cfg.SyncEmptyDirs, "With -recursive, recreate empty subdirectories on the other machines")
+	fs.StringVar(&cfg.LogFormat, "log-format", cfg.LogFormat, "Log output format: text or json")
+	fs.StringVar(&cfg.LogLevel, "log-level", cfg.LogLevel, "Least severe messages logged: error, warn, info or debug (adds per-file comparisons)")
+	fs.StringVar(&cfg.NotifyURL, "notify-url", cfg.NotifyURL, "Webhook URL to POST a JSON message to after each completed sync (e.g. a Discord webhook)")
+	fs.StringVar(&cfg.NotifyEvents, "notify-events", cfg.NotifyEvents, "Transfers that trigger -notify-url: upload, download or both")
+	fs.StringVar(&cfg.NotifyTemplate, "notify-template", cfg.NotifyTemplate, "Go template for the notification message, over .File, .Direction, .Time and .Size")
+	fs.StringVar(&cfg.MetricsAddr, "metrics-addr", cfg.MetricsAddr, "Serve Prometheus metrics at http://<addr>/metrics, e.g. :9090 (off if empty)")
+	fs.StringVar(&cfg.APIAddr, "api-addr", cfg.APIAddr, "Serve the HTTP control API at this address, e.g. :8080 for localhost:8080 (off if empty)")
+	fs.DurationVar(&cfg.TimeTolerance, "time-tolerance", cfg.TimeTolerance, "Max mod time difference treated as in sync (0 = exact match)")
+	fs.DurationVar(&cfg.CoarseTimeTolerance, "coarse-time-tolerance", cfg.CoarseTimeTolerance, "Time tolerance for cloud objects with only a second-resolution upload time")
+	fs.StringVar(&cfg.S3Config.Backend, "backend", cfg.S3Config.Backend, "Storage backend: s3, azure or local")
+	fs.StringVar(&cfg.S3Config.LocalDir, "local-dir", cfg.S3Config.LocalDir, "Directory to sync against with -backend local (e.g. a NAS mount)")
+	fs.StringVar(&cfg.S3Config.Azure.Account, "azure-account", cfg.S3Config.Azure.Account, "Azure storage account name with -backend azure")
+	fs.StringVar(&cfg.S3Config.Azure.Key, "azure-key", cfg.S3Config.Azure.Key, "Access key of -azure-account")
+	fs.StringVar(&cfg.S3Config.Azure.ConnectionString, "azure-connection-string", cfg.S3Config.Azure.ConnectionString, "Azure storage connection string, instead of -azure-account and -azure-key")
+	fs.StringVar(&cfg.S3Config.Endpoint, "cloud-endpoint", cfg.S3Config.Endpoint, "MinIO/S3 cloud endpoint")
+	fs.BoolVar(&cfg.S3Config.UseSSL, "use-ssl", cfg.S3Config.UseSSL, "Connect to the endpoint over HTTPS")
+	fs.StringVar(&cfg.S3Config.CACert, "ca-cert", cfg.S3Config.CACert, "PEM file of extra CA certificates to trust, e.g. for a self-hosted MinIO with a private CA")
+	fs.BoolVar(&cfg.S3Config.InsecureSkipVerify, "insecure-skip-verify", cfg.S3Config.InsecureSkipVerify, "UNSAFE: accept any TLS certificate, e.g. a self-signed one; anyone on the network can intercept the connection")
+	fs.StringVar(&cfg.S3Config.Region, "region", cfg.S3Config.Region, "Bucket region, e.g. eu-west-1 (looked up from the bucket if empty)")
+	fs.StringVar(&cfg.S3Config.AccessKey, "access-key", cfg.S3Config.AccessKey, "Cloud storage access key")
+	fs.StringVar(&cfg.S3Config.SecretKey, "secret-key", cfg.S3Config.SecretKey, "Cloud storage secret key")
+	fs.StringVar(&cfg.S3Config.SessionToken, "session-token", cfg.S3Config.SessionToken, "Session token of temporary credentials, e.g. from STS (S3 backend)")
+	fs.StringVar(&cfg.S3Config.Credentials, "credentials", cfg.S3Config.Credentials, "Where S3 credentials come from: static (-access-key and -secret-key) or chain (those, then the AWS environment variables, ~/.aws/credentials and IAM roles)")
+	fs.StringVar(&cfg.S3Config.EncryptionPassphrase, "encryption-passphrase", cfg.S3Config.EncryptionPassphrase, "Encrypt uploads client-side with a key derived from this passphrase (S3 backend)")
+	fs.StringVar(&cfg.S3Config.SSE, "sse", cfg.S3Config.SSE, "Have the server encrypt uploads at rest: s3 (SSE-S3) or kms (SSE-KMS); downloads check objects report it (S3 backend)")
+	fs.StringVar(&cfg.S3Config.SSEKMSKeyID, "sse-kms-key-id", cfg.S3Config.SSEKMSKeyID, "KMS key to encrypt uploads with for -sse kms (default: the bucket's key)")
+	fs.BoolVar(&cfg.S3Config.Compress, "compress", cfg.S3Config.Compress, "Gzip saves before upload (S3 backend)")
+	fs.StringVar(&cfg.S3Config.ObjectPrefix, "object-prefix", cfg.S3Config.ObjectPrefix, "Folder within the bucket to store saves under, e.g. dragonwilds/ (S3 backend)")
+	fs.BoolVar(&cfg.S3Config.PerMachine, "per-machine", cfg.S3Config.PerMachine, "Keep a separate copy of each save per machine instead of syncing one copy between machines (S3 backend)")
+	fs.StringVar(&cfg.S3Config.MachineID, "machine-id", cfg.S3Config.MachineID, "Name of this machine's copies with -per-machine (default: the hostname)")
+	fs.IntVar(&cfg.S3Config.VersionRetentionDays, "version-retention-days", cfg.S3Config.VersionRetentionDays, "Add a bucket lifecycle rule expiring replaced versions after this many days (S3 backend with versioning; 0 = off)")
+	metadata := fs.String("metadata", "", "Comma-separated key=value pairs stored as metadata on every upload (S3 backend)")
+	fs.StringVar(&cfg.S3Config.BucketName, "bucket-name", cfg.S3Config.BucketName, "Bucket name in cloud storage")
+	fs.IntVar(&cfg.S3Config.ListStatConcurrency, "list-stat-concurrency", cfg.S3Config.ListStatConcurrency, "Parallel metadata requests when listing the bucket (lower for small MinIO servers)")
+	fs.IntVar(&cfg.S3Config.PartSizeMiB, "part-size-mib", cfg.S3Config.PartSizeMiB, "Part size in MiB of multipart uploads, used for larger files; failed parts are retried alone (S3 backend, 5 to 5120)")
+	fs.IntVar(&cfg.S3Config.UploadThreads, "upload-threads", cfg.S3Config.UploadThreads, "Parts of a multipart upload sent at once (S3 backend)")
+	fs.IntVar(&cfg.S3Config.Retry.MaxAttempts, "retry-attempts", cfg.S3Config.Retry.MaxAttempts, "Tries per storage call before giving up on transient errors (1 = no retries)")
+	fs.DurationVar(&cfg.S3Config.Retry.InitialBackoff, "retry-backoff", cfg.S3Config.Retry.InitialBackoff, "Delay before the first retry; doubles after each further failure")
+	fs.DurationVar(&cfg.S3Config.Retry.MaxBackoff, "retry-max-backoff", cfg.S3Config.Retry.MaxBackoff, "Longest delay between retries")
+	triggerOps := fs.String("trigger-ops", strings.Join(cfg.TriggerOps, ","), "Comma-separated file operations that trigger a sync (create, write, remove, rename, chmod)")
+	includePatterns := fs.String("include-patterns", strings.Join(cfg.IncludePatterns, ","), "Comma-separated glob patterns for file names to sync")
+	excludePatterns := fs.String("exclude-patterns", strings.Join(cfg.ExcludePatterns, ","), "Comma-separated glob patterns for file names never to sync (empty to exclude nothing)")
+	priorityPatterns := fs.String("priority-patterns", "", "Comma-separated glob patterns for saves that sync first (others go smallest first)")
+	fs.BoolVar(&cfg.ChecksumMode, "checksum-mode", cfg.ChecksumMode, "Compare SHA-256 content hashes before mod times and skip transfers when they match")
+	fs.Int64Var(&cfg.MinFileSize, "min-file-size", cfg.MinFileSize, "Never upload files smaller than this many bytes, nor let a smaller cloud copy replace a local save (0 = off)")
+	fs.Float64Var(&cfg.MinSizeRatio, "min-size-ratio", cfg.MinSizeRatio, "Don't let a cloud copy smaller than this fraction of the local save replace it, e.g. 0.5 (0 = off)")
+	fs.BoolVar(&cfg.VerifyAfterUpload, "verify-after-upload", cfg.VerifyAfterUpload, "Re-download each upload and compare checksums (doubles upload traffic)")
+	fs.BoolVar(&cfg.DryRun, "dry-run", cfg.DryRun, "Log the uploads, downloads and backups sync would make without making them")
+	fs.BoolVar(&cfg.Progress, "progress", cfg.Progress, "Show upload and download progress per file (only when output is a terminal)")
+	fs.BoolVar(&cfg.PauseOnBattery, "pause-on-battery", cfg.PauseOnBattery, "Pause sync while the system runs on battery power")
+	fs.BoolVar(&cfg.HardlinkBackups, "hardlink-backups", cfg.HardlinkBackups, "Hardlink backups instead of copying when on the same file system (falls back to copying)")
+	fs.IntVar(&cfg.MaxBackups, "max-backups", cfg.MaxBackups, "Keep at most this many timestamped backup folders (0 = unlimited)")
+	fs.DurationVar(&cfg.MaxBackupAge, "max-backup-age", cfg.MaxBackupAge, "Remove backup folders older than this, e.g. 720h (0 = keep forever)")
+	fs.DurationVar(&cfg.BackupWindow, "backup-window", cfg.BackupWindow, "Put the backups made within this long of each other in one folder, skipping content already backed up, e.g. 1m (0 = a folder per backup)")
+	fs.BoolVar(&cfg.KeepGoodCopy, "keep-good-copy", cfg.KeepGoodCopy, "Keep a latest-known-good copy of each save under <backup-dir>/LatestGood")
+	fs.StringVar(&cfg.RestoreGood, "restore-good", "", "Restore the named save (or \"all\") from its latest-known-good copy and exit")
+	fs.BoolVar(&cfg.ListBackups, "list-backups", false, "List the timestamped backups and the saves in each, then exit")
+	fs.StringVar(&cfg.RestoreBackup, "restore-backup", "", "Restore the saves in the named backup (or \"latest\"), backing up the current files first, and exit")
+	fs.BoolVar(&cfg.DedupeCloud, "dedupe-cloud", false, "Report cloud objects with identical content under different keys, offer to remove the extras, and exit")
+	fs.StringVar(&cfg.ImportDir, "import", "", "Upload every save file in this directory to the bucket and exit")
+	fs.BoolVar(&cfg.Once, "once", false, "Run one full sync and exit, for cron or Task Scheduler (exit 0: nothing to do, 5: files synced, 1: errors, 3: storage unreachable)")
+	fs.BoolVar(&cfg.Status, "status", false, "Print whether each save is in sync with the cloud and exit (non-zero if any isn't)")
+	fs.BoolVar(&cfg.Verify, "verify", false, "Download each save's cloud copy, compare content hashes with the local file and exit (non-zero on any mismatch)")
+	fs.Float64Var(&cfg.VerifySample, "sample", cfg.VerifySample, "Fraction of saves -verify checks, picked at random, e.g. 0.1 for a tenth")
+	fs.StringVar(&cfg.ExportManifest, "export-manifest", "", "Write a JSON snapshot of local and cloud file state to this path and exit")
+	fs.StringVar(&cfg.RestoreVersion, "restore-version", "", "With the history command, restore the save from this stored version (backing up the current file first)")
+	fs.DurationVar(&cfg.ShareExpiry, "share-expiry", cfg.ShareExpiry, "How long the link printed by the share command stays valid (at most 168h)")
+	fs.DurationVar(&cfg.PruneAge, "prune-age", cfg.PruneAge, "How long a cloud object must have gone unmodified before the prune-cloud command deletes it")
+	fs.BoolVar(&cfg.Yes, "yes", false, "Delete without asking for confirmation (prune-cloud command)")
+
+	if err := fs.Parse(args); err != nil {
+		return nil, err
+	}
+	if cfg.ShowVersion {
+		return cfg, nil
+	}
+
+	// Remember what was given on the command line so it can be re-applied
+	// over the config file
+	given := make(map[string]string)
+	fs.Visit(func(f *flag.Flag) {
+		given[f.Name] = f.Value.String()
+	})
+
+	if *configPath != "" {
+		fileCfg, err := LoadFromFile(*configPath)
+		if err != nil {
+			return nil, err
+		}
+
+		// The flags point into cfg, so overwrite it in place and set the
+		// given flags again
+		*cfg = *fileCfg
+		for name, value := range given {
+			if err := fs.Set(name, value); err != nil {
+				return nil, fmt.Errorf("invalid value %q for flag -%s: %w", value, name, err)
+			}
+		}
+	}
+	cfg.ConfigFile = *configPath
+	cfg.args = allArgs
+
+	switch command {
+	case "doctor", "prune-cloud", "verify":
+		if fs.NArg() != 0 {
+			return nil, fmt.Errorf("usage: cloudsync %s [flags]", command)
+		}
+		cfg.Doctor = command == "doctor"
+		cfg.PruneCloud = command == "prune-cloud"
+		cfg.Verify = cfg.Verify || command == "verify"
+	case "share", "history":
+		if fs.NArg() != 1 {
+			return nil, fmt.Errorf("usage: cloudsync %s [flags] <file>", command)
+		}
+		if command == "share" {
+			cfg.ShareFile = fs.Arg(0)
+		} else {
+			cfg.HistoryFile = fs.Arg(0)
+		}
+	}
+	if cfg.SyncEmptyDirs && !cfg.Recursive {
+		return nil, fmt.Errorf("sync-empty-dirs needs recursive")
+	}
+	if cfg.VerifySample <= 0 || cfg.VerifySample > 1 {
+		return nil, fmt.Errorf("sample must be above 0 and at most 1, got %v", cfg.VerifySample)
+	}
+	if cfg.RestoreVersion != "" && cfg.HistoryFile == "" {
+		return nil, fmt.Errorf("restore-version needs the history command: cloudsync history -restore-version <id> <file>")
+	}
 
-	flag.Parse()
+	if _, ok := given["trigger-ops"]; ok {
+		cfg.TriggerOps = splitList(*triggerOps)
+	}
+	if _, ok := given["include-patterns"]; ok {
+		cfg.IncludePatterns = splitList(*includePatterns)
+	}
+	if _, ok := given["exclude-patterns"]; ok {
+		cfg.ExcludePatterns = splitList(*excludePatterns)
+	}
+	if _, ok := given["priority-patterns"]; ok {
+		cfg.PriorityPatterns = splitList(*priorityPatterns)
+	}
+	if _, ok := given["metadata"]; ok {
+		meta, err := parseMetadata(*metadata)
+		if err != nil {
+			return nil, err
+		}
+		cfg.S3Config.Metadata = meta
+	}
 
 	// Validate required fields
-	if cfg.S3Config.Endpoint == "" || cfg.S3Config.AccessKey == "" || 
-	   cfg.S3Config.SecretKey == "" || cfg.S3Config.BucketName == "" {
-		return nil, fmt.Errorf("missing required arguments: cloud-endpoint, access-key, secret-key, or bucket-name")
+	switch cfg.S3Config.Backend {
+	// Watches may each name their own bucket or directory instead; those
+	// are checked by resolveWatches
+	case BackendS3:
+		switch cfg.S3Config.Credentials {
+		case CredentialsStatic:
+			if cfg.S3Config.AccessKey == "" || cfg.S3Config.SecretKey == "" {
+				return nil, fmt.Errorf("missing required arguments: access-key and secret-key, or credentials %s", CredentialsChain)
+			}
+		case CredentialsChain:
+		default:
+			return nil, fmt.Errorf("unknown credentials %q (want %s or %s)", cfg.S3Config.Credentials, CredentialsStatic, CredentialsChain)
+		}
+		if cfg.S3Config.Endpoint == "" || (cfg.S3Config.BucketName == "" && len(cfg.Watches) == 0) {
+			return nil, fmt.Errorf("missing required arguments: cloud-endpoint or bucket-name")
+		}
+	case BackendAzure:
+		azure := cfg.S3Config.Azure
+		if azure.ConnectionString == "" && (azure.Account == "" || azure.Key == "") {
+			return nil, fmt.Errorf("missing required arguments: azure-account and azure-key, or azure-connection-string")
+		}
+		if cfg.S3Config.BucketName == "" && len(cfg.Watches) == 0 {
+			return nil, fmt.Errorf("missing required argument: bucket-name")
+		}
+	case BackendLocal:
+		if cfg.S3Config.LocalDir == "" && len(cfg.Watches) == 0 {
+			return nil, fmt.Errorf("missing required argument: local-dir")
+		}
+	default:
+		return nil, fmt.Errorf("unknown backend %q (want %s, %s or %s)", cfg.S3Config.Backend,
+			BackendS3, BackendAzure, BackendLocal)
+	}
+
+	switch cfg.Direction {
+	case DirectionBidirectional, DirectionDownloadOnly, DirectionUploadOnly:
+	default:
+		return nil, fmt.Errorf("unknown direction %q (want %s, %s or %s)", cfg.Direction,
+			DirectionBidirectional, DirectionDownloadOnly, DirectionUploadOnly)
+	}
+
+	switch cfg.ConflictStrategy {
+	case ConflictNewerWins, ConflictKeepBoth, ConflictLocalWins, ConflictCloudWins:
+	default:
+		return nil, fmt.Errorf("unknown conflict-strategy %q (want %s, %s, %s or %s)", cfg.ConflictStrategy,
+			ConflictNewerWins, ConflictKeepBoth, ConflictLocalWins, ConflictCloudWins)
+	}
+
+	switch cfg.ProcessMatch {
+	case ProcessMatchSubstring, ProcessMatchExact, ProcessMatchPath:
+	default:
+		return nil, fmt.Errorf("unknown process-match %q (want %s, %s or %s)", cfg.ProcessMatch,
+			ProcessMatchSubstring, ProcessMatchExact, ProcessMatchPath)
+	}
+
+	switch cfg.DebounceMode {
+	case DebounceLeading, DebounceTrailing:
+	default:
+		return nil, fmt.Errorf("unknown debounce-mode %q (want %s or %s)", cfg.DebounceMode, DebounceLeading, DebounceTrailing)
+	}
+
+	switch cfg.LogFormat {
+	case LogFormatText, LogFormatJSON:
+	default:
+		return nil, fmt.Errorf("unknown log-format %q (want %s or %s)", cfg.LogFormat, LogFormatText, LogFormatJSON)
+	}
+
+	switch cfg.LogLevel {
+	case LogLevelError, LogLevelWarn, LogLevelInfo, LogLevelDebug:
+	default:
+		return nil, fmt.Errorf("unknown log-level %q (want %s, %s, %s or %s)", cfg.LogLevel,
+			LogLevelError, LogLevelWarn, LogLevelInfo, LogLevelDebug)
+	}
+
+	if cfg.TimeTolerance < 0 {
+		return nil, fmt.Errorf("time-tolerance cannot be negative")
+	}
+
+	if cfg.CoarseTimeTolerance < 0 {
+		return nil, fmt.Errorf("coarse-time-tolerance cannot be negative")
+	}
+
+	// With no grace, every signal would force an exit before the sync in
+	// flight could stop
+	if cfg.ShutdownGrace <= 0 {
+		return nil, fmt.Errorf("shutdown-grace must be positive")
+	}
+
+	if cfg.ShareExpiry < time.Second || cfg.ShareExpiry > MaxShareExpiry {
+		return nil, fmt.Errorf("share-expiry %v must be between 1s and %v, the longest S3 allows", cfg.ShareExpiry, MaxShareExpiry)
+	}
+
+	if cfg.PruneAge < 0 {
+		return nil, fmt.Errorf("prune-age cannot be negative")
+	}
+
+	if cfg.SyncInterval < 0 {
+		return nil, fmt.Errorf("sync-interval cannot be negative")
+	}
+
+	if cfg.FullSyncInterval < 0 {
+		return nil, fmt.Errorf("full-sync-interval cannot be negative")
+	}
+
+	if cfg.ProcessCacheTTL < 0 {
+		return nil, fmt.Errorf("process-cache-ttl cannot be negative")
+	}
+
+	if cfg.WatchRetries < 0 {
+		return nil, fmt.Errorf("watch-retries cannot be negative")
+	}
+
+	if cfg.MinFileSize < 0 {
+		return nil, fmt.Errorf("min-file-size cannot be negative")
+	}
+
+	if cfg.MinSizeRatio < 0 || cfg.MinSizeRatio > 1 {
+		return nil, fmt.Errorf("min-size-ratio %v must be between 0 and 1", cfg.MinSizeRatio)
+	}
+
+	if cfg.MaxBackups < 0 {
+		return nil, fmt.Errorf("max-backups cannot be negative")
+	}
+
+	if cfg.MaxBackupAge < 0 {
+		return nil, fmt.Errorf("max-backup-age cannot be negative")
+	}
+
+	if cfg.BackupWindow < 0 {
+		return nil, fmt.Errorf("backup-window cannot be negative")
+	}
+
+	if cfg.Concurrency < 1 {
+		return nil, fmt.Errorf("concurrency must be at least 1")
+	}
+
+	if cfg.S3Config.ListStatConcurrency < 1 {
+		return nil, fmt.Errorf("list-stat-concurrency must be at least 1")
+	}
+	if cfg.S3Config.PartSizeMiB < MinPartSizeMiB || cfg.S3Config.PartSizeMiB > MaxPartSizeMiB {
+		return nil, fmt.Errorf("part-size-mib must be between %d, the S3 minimum, and %d", MinPartSizeMiB, MaxPartSizeMiB)
+	}
+	if cfg.S3Config.UploadThreads < 1 {
+		return nil, fmt.Errorf("upload-threads must be at least 1")
+	}
+
+	if cfg.S3Config.Retry.MaxAttempts < 1 {
+		return nil, fmt.Errorf("retry-attempts must be at least 1")
+	}
+
+	// An https:// endpoint implies -use-ssl; only this decides it, the checks
+	// below and the client look at UseSSL alone
+	if strings.HasPrefix(cfg.S3Config.Endpoint, "https://") {
+		cfg.S3Config.UseSSL = true
+	}
+	// The client takes a bare host[:port]
+	endpoint := strings.TrimPrefix(strings.TrimPrefix(cfg.S3Config.Endpoint, "https://"), "http://")
+	cfg.S3Config.Endpoint = strings.TrimSuffix(endpoint, "/")
+
+	if (cfg.S3Config.CACert != "" || cfg.S3Config.InsecureSkipVerify) && !cfg.S3Config.UseSSL {
+		return nil, fmt.Errorf("ca-cert and insecure-skip-verify need -use-ssl")
+	}
+
+	if cfg.S3Config.Retry.InitialBackoff < 0 || cfg.S3Config.Retry.MaxBackoff < 0 {
+		return nil, fmt.Errorf("retry-backoff and retry-max-backoff cannot be negative")
+	}
+
+	if err := validateMetadata(cfg.S3Config.Metadata); err != nil {
+		return nil, err
+	}
+
+	if cfg.S3Config.PerMachine {
+		if cfg.S3Config.Backend != BackendS3 {
+			return nil, fmt.Errorf("per-machine is only supported by the S3 backend")
+		}
+		if cfg.S3Config.MachineID == "" {
+			hostname, err := os.Hostname()
+			if err != nil {
+				return nil, fmt.Errorf("failed to determine the machine id, set -machine-id: %w", err)
+			}
+			cfg.S3Config.MachineID = hostname
+		}
+		if !validMachineID(cfg.S3Config.MachineID) {
+			return nil, fmt.Errorf("machine-id %q may only contain letters, digits, dots, dashes and underscores", cfg.S3Config.MachineID)
+		}
+	}
+
+	if cfg.S3Config.VersionRetentionDays < 0 {
+		return nil, fmt.Errorf("version-retention-days cannot be negative")
+	}
+	if cfg.S3Config.VersionRetentionDays > 0 && cfg.S3Config.Backend != BackendS3 {
+		return nil, fmt.Errorf("version-retention-days is only supported by the S3 backend")
+	}
+
+	switch cfg.S3Config.SSE {
+	case "", SSES3, SSEKMS:
+	default:
+		return nil, fmt.Errorf("unknown sse %q (want %s or %s)", cfg.S3Config.SSE, SSES3, SSEKMS)
+	}
+	if cfg.S3Config.SSE != "" && cfg.S3Config.Backend != BackendS3 {
+		return nil, fmt.Errorf("sse is only supported by the S3 backend")
+	}
+	if cfg.S3Config.SSEKMSKeyID != "" && cfg.S3Config.SSE != SSEKMS {
+		return nil, fmt.Errorf("sse-kms-key-id needs -sse %s", SSEKMS)
 	}
 
-	// Determine SSL from endpoint
-	cfg.S3Config.UseSSL = strings.HasPrefix(cfg.S3Config.Endpoint, "https://")
+	if len(cfg.IncludePatterns) == 0 {
+		return nil, fmt.Errorf("include-patterns cannot be empty")
+	}
+	if err := fsutil.ValidatePatterns(cfg.IncludePatterns); err != nil {
+		return nil, fmt.Errorf("include-patterns: %w", err)
+	}
+	if err := fsutil.ValidatePatterns(cfg.ExcludePatterns); err != nil {
+		return nil, fmt.Errorf("exclude-patterns: %w", err)
+	}
+	if err := fsutil.ValidatePatterns(cfg.PriorityPatterns); err != nil {
+		return nil, fmt.Errorf("priority-patterns: %w", err)
+	}
 
-	// Auto-generate watchPath if not provided
-	if cfg.WatchPath == "" {
+	// Auto-generate watchPath if not provided. With a watches list, each
+	// entry names its own.
+	if cfg.WatchPath == "" && len(cfg.Watches) == 0 {
 		var err error
 		cfg.WatchPath, err = getDefaultWatchPath()
 		if err != nil {
@@ -57,21 +1045,148 @@ func LoadFromFlags() (*Config, error) {
 		}
 	}
 
-	// Auto-generate backupDir if not provided
-	if cfg.BackupDir == "" {
-		cfg.BackupDir = filepath.Join(cfg.WatchPath, "Backup")
+	if err := cfg.resolveWatches(); err != nil {
+		return nil, err
+	}
+
+	if cfg.ProcessMatch == ProcessMatchPath {
+		for _, w := range cfg.Watches {
+			for _, name := range w.ProcessNames {
+				if !filepath.IsAbs(name) {
+					return nil, fmt.Errorf("process-match %s needs the full path of the executable, not %q", ProcessMatchPath, name)
+				}
+			}
+		}
 	}
 
 	return cfg, nil
 }
 
-// Validate checks if the configuration is valid
+// resolveWatches fills in Watches: each entry's unset fields from the
+// top-level settings, or a single entry mirroring them if the list is
+// empty. The top-level fields then take the first entry's values.
+func (c *Config) resolveWatches() error {
+	if len(c.Watches) == 0 {
+		c.Watches = []WatchConfig{{WatchPath: c.WatchPath, BackupDir: c.BackupDir}}
+	}
+
+	paths := make(map[string]int)
+	backups := make(map[string]int)
+	targets := make(map[string]int)
+	for i := range c.Watches {
+		w := &c.Watches[i]
+		if w.WatchPath == "" {
+			return fmt.Errorf("watches[%d]: watch_path is required", i)
+		}
+		if w.BackupDir == "" {
+			dir, err := defaultBackupDir(w.WatchPath)
+			if err != nil {
+				return fmt.Errorf("watches[%d]: failed to determine default backup dir, set backup_dir: %w", i, err)
+			}
+			w.BackupDir = dir
+		}
+		if isWithin(w.WatchPath, w.BackupDir) {
+			return fmt.Errorf("watches[%d]: backup_dir %s can't be the watch path or contain it", i, w.BackupDir)
+		}
+		if w.ProcessName == "" {
+			w.ProcessName = c.ProcessName
+		}
+		w.ProcessNames = splitList(w.ProcessName)
+		if w.BucketName == "" {
+			w.BucketName = c.S3Config.BucketName
+		}
+		if w.LocalDir == "" {
+			w.LocalDir = c.S3Config.LocalDir
+		}
+		if w.ObjectPrefix == "" {
+			w.ObjectPrefix = c.S3Config.ObjectPrefix
+		}
+		w.ObjectPrefix = cleanObjectPrefix(w.ObjectPrefix)
+		if w.ObjectPrefix != "" && c.S3Config.Backend != BackendS3 {
+			return fmt.Errorf("watches[%d]: object_prefix is only supported by the S3 backend", i)
+		}
+		if c.S3Config.Backend == BackendLocal && w.LocalDir == "" {
+			return fmt.Errorf("watches[%d]: local_dir is required with backend local", i)
+		}
+		if c.S3Config.Backend != BackendLocal && w.BucketName == "" {
+			return fmt.Errorf("watches[%d]: bucket_name is required", i)
+		}
+		if len(w.IncludePatterns) == 0 {
+			w.IncludePatterns = c.IncludePatterns
+		}
+		if len(w.ExcludePatterns) == 0 {
+			w.ExcludePatterns = c.ExcludePatterns
+		}
+		w.GoodCopyDir = filepath.Join(w.BackupDir, "LatestGood")
+		w.StateFile = filepath.Join(w.BackupDir, "sync-state.json")
+		w.JournalFile = filepath.Join(w.BackupDir, "journal.json")
+
+		if err := fsutil.ValidatePatterns(w.IncludePatterns); err != nil {
+			return fmt.Errorf("watches[%d]: include_patterns: %w", i, err)
+		}
+		if err := fsutil.ValidatePatterns(w.ExcludePatterns); err != nil {
+			return fmt.Errorf("watches[%d]: exclude_patterns: %w", i, err)
+		}
+
+		// Watches sharing a folder, backup dir or bucket would overwrite
+		// each other's files
+		target := w.BucketName + "/" + w.ObjectPrefix
+		if c.S3Config.Backend == BackendLocal {
+			target = filepath.Clean(w.LocalDir)
+		}
+		for _, check := range []struct {
+			seen  map[string]int
+			key   string
+			field string
+		}{
+			{paths, filepath.Clean(w.WatchPath), "watch_path"},
+			{backups, filepath.Clean(w.BackupDir), "backup_dir"},
+			{targets, target, "storage location (bucket_name or local_dir)"},
+		} {
+			if j, ok := check.seen[check.key]; ok {
+				return fmt.Errorf("watches[%d] and watches[%d] have the same %s", j, i, check.field)
+			}
+			check.seen[check.key] = i
+		}
+
+		// A prefix inside another would list the other watch's saves
+		for j, other := range c.Watches[:i] {
+			if c.S3Config.Backend != BackendS3 || other.BucketName != w.BucketName {
+				continue
+			}
+			if strings.HasPrefix(w.ObjectPrefix, other.ObjectPrefix) || strings.HasPrefix(other.ObjectPrefix, w.ObjectPrefix) {
+				return fmt.Errorf("watches[%d] and watches[%d] share bucket %s with nested object prefixes %q and %q", j, i, w.BucketName, other.ObjectPrefix, w.ObjectPrefix)
+			}
+		}
+	}
+
+	first := c.Watches[0]
+	c.WatchPath = first.WatchPath
+	c.BackupDir = first.BackupDir
+	c.ProcessName = first.ProcessName
+	c.S3Config.BucketName = first.BucketName
+	c.S3Config.ObjectPrefix = first.ObjectPrefix
+	c.S3Config.LocalDir = first.LocalDir
+	c.IncludePatterns = first.IncludePatterns
+	c.ExcludePatterns = first.ExcludePatterns
+	c.GoodCopyDir = first.GoodCopyDir
+	c.StateFile = first.StateFile
+	c.JournalFile = first.JournalFile
+
+	return nil
+}
+
+// Validate checks if the configuration is valid. A missing watch path is
+// only accepted with WaitForWatchPath.
 func (c *Config) Validate() error {
 	if c.WatchPath == "" {
 		return fmt.Errorf("watch path cannot be empty")
 	}
 
 	info, err := os.Stat(c.WatchPath)
+	if os.IsNotExist(err) && c.WaitForWatchPath {
+		return nil
+	}
 	if err != nil {
 		return fmt.Errorf("watch path does not exist: %w", err)
 	}
@@ -82,6 +1197,136 @@ func (c *Config) Validate() error {
 	return nil
 }
 
+// reservedMetadataKeys are the user metadata keys cloudsync writes itself
+var reservedMetadataKeys = []string{
+	"Modtime", "ModtimeString", "Sha256", "Mode", "Atime",
+	"Size", "Compressed", "Encrypted", "Nonce", "Salt",
+}
+
+// parseMetadata parses the -metadata flag: comma-separated key=value pairs
+func parseMetadata(value string) (map[string]string, error) {
+	meta := make(map[string]string)
+	for _, item := range splitList(value) {
+		k, v, ok := strings.Cut(item, "=")
+		if !ok {
+			return nil, fmt.Errorf("metadata: %q is not key=value", item)
+		}
+		meta[strings.TrimSpace(k)] = strings.TrimSpace(v)
+	}
+	return meta, nil
+}
+
+// validateMetadata checks that metadata keys are usable as HTTP header names
+// and don't clash with cloudsync's own
+func validateMetadata(meta map[string]string) error {
+	for k := range meta {
+		if k == "" || strings.IndexFunc(k, func(r rune) bool {
+			return !(r == '-' || r >= '0' && r <= '9' || r >= 'A' && r <= 'Z' || r >= 'a' && r <= 'z')
+		}) >= 0 {
+			return fmt.Errorf("metadata: invalid key %q (want letters, digits and dashes)", k)
+		}
+		for _, reserved := range reservedMetadataKeys {
+			if strings.EqualFold(k, reserved) {
+				return fmt.Errorf("metadata: key %q is reserved for cloudsync", k)
+			}
+		}
+	}
+	return nil
+}
+
+// validMachineID reports whether id is non-empty and safe in object names
+func validMachineID(id string) bool {
+	return id != "" && strings.IndexFunc(id, func(r rune) bool {
+		return !(r == '.' || r == '-' || r == '_' || r >= '0' && r <= '9' || r >= 'A' && r <= 'Z' || r >= 'a' && r <= 'z')
+	}) < 0
+}
+
+// cleanObjectPrefix makes an object prefix end in a single slash, without
+// a leading one, or returns "" for none
+func cleanObjectPrefix(prefix string) string {
+	prefix = strings.Trim(prefix, "/")
+	if prefix == "" {
+		return ""
+	}
+	return prefix + "/"
+}
+
+// splitList splits a comma-separated flag value, dropping empty items
+func splitList(value string) []string {
+	var items []string
+	for _, item := range strings.Split(value, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			items = append(items, item)
+		}
+	}
+	return items
+}
+
+// isWithin reports whether path is dir or inside it
+func isWithin(path, dir string) bool {
+	rel, err := filepath.Rel(dir, path)
+	return err == nil && rel != ".." && !strings.HasPrefix(rel, ".."+string(filepath.Separator))
+}
+
+// defaultBackupDir returns the backup dir of a watch that doesn't set one:
+// cloudsync/backups/<folder>-<hash of the watch path> in the user's data
+// directory, outside the game's save folder. A Backup folder inside the
+// watch path, the default of earlier versions, is kept if it exists, so
+// upgrading loses neither the backups nor the sync state.
+func defaultBackupDir(watchPath string) (string, error) {
+	legacy := filepath.Join(watchPath, "Backup")
+	if info, err := os.Stat(legacy); err == nil && info.IsDir() {
+		return legacy, nil
+	}
+
+	dataDir, err := userDataDir()
+	if err != nil {
+		return "", err
+	}
+
+	abs, err := filepath.Abs(watchPath)
+	if err != nil {
+		return "", err
+	}
+	sum := sha256.Sum256([]byte(abs))
+	name := strings.Map(func(r rune) rune {
+		if r < 0x80 && (unicode.IsLetter(r) || unicode.IsDigit(r) || r == '.' || r == '-' || r == '_') {
+			return r
+		}
+		return '_'
+	}, filepath.Base(abs))
+	return filepath.Join(dataDir, "cloudsync", "backups", name+"-"+hex.EncodeToString(sum[:4])), nil
+}
+
+// userDataDir returns the directory for the user's application data:
+// %LOCALAPPDATA% on Windows, ~/Library/Application Support on macOS and
+// $XDG_DATA_HOME (default ~/.local/share) elsewhere
+func userDataDir() (string, error) {
+	switch runtime.GOOS {
+	case "windows":
+		if dir := os.Getenv("LOCALAPPDATA"); dir != "" {
+			return dir, nil
+		}
+		return "", fmt.Errorf("LOCALAPPDATA environment variable is not set")
+	case "darwin":
+		home, err := os.UserHomeDir()
+		if err != nil {
+			return "", err
+		}
+		return filepath.Join(home, "Library", "Application Support"), nil
+	default:
+		// The XDG spec says to ignore relative paths
+		if dir := os.Getenv("XDG_DATA_HOME"); filepath.IsAbs(dir) {
+			return dir, nil
+		}
+		home, err := os.UserHomeDir()
+		if err != nil {
+			return "", err
+		}
+		return filepath.Join(home, ".local", "share"), nil
+	}
+}
+
 func getDefaultWatchPath() (string, error) {
 	localAppData := os.Getenv("LOCALAPPDATA")
 	if localAppData == "" {
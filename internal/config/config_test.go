@@ -1,8 +1,15 @@
 package config
 
 import (
+	"flag"
 	"os"
+	"path/filepath"
+	"reflect"
+	"runtime"
+	"slices"
+	"strings"
 	"testing"
+	"time"
 )
 
 func TestLoadFromFlags(t *testing.T) {
@@ -59,6 +66,13 @@ func TestConfigValidate(t *testing.T) {
 			},
 			wantErr: true,
 		},
+		{
+			name: "non-existent path created later",
+			cfg: Config{
+				WatchPath:        "/non/existent/path/12345",
+				WaitForWatchPath: true,
+			},
+		},
 	}
 
 	for _, tt := range tests {
@@ -70,3 +84,787 @@ func TestConfigValidate(t *testing.T) {
 		})
 	}
 }
+
+func writeConfigFile(t *testing.T, name, content string) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), name)
+	if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+		t.Fatalf("WriteFile() error = %v", err)
+	}
+	return path
+}
+
+func TestLoadFromFile(t *testing.T) {
+	tests := []struct {
+		name    string
+		file    string
+		content string
+	}{
+		{
+			name: "yaml",
+			file: "cloudsync.yaml",
+			content: `
+watch_path: /saves
+time_tolerance: 2s
+trigger_ops:
+  - write
+  - rename
+s3:
+  endpoint: minio.lan:9000
+  access_key: filekey
+  secret_key: filesecret
+# added by a newer version
+future_option: true
+`,
+		},
+		{
+			name: "json",
+			file: "cloudsync.json",
+			content: `{
+  "watch_path": "/saves",
+  "time_tolerance": "2s",
+  "trigger_ops": ["write", "rename"],
+  "s3": {
+    "endpoint": "minio.lan:9000",
+    "access_key": "filekey",
+    "secret_key": "filesecret"
+  },
+  "future_option": true
+}`,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg, err := LoadFromFile(writeConfigFile(t, tt.file, tt.content))
+			if err != nil {
+				t.Fatalf("LoadFromFile() error = %v", err)
+			}
+
+			if cfg.WatchPath != "/saves" {
+				t.Errorf("WatchPath = %v, want %v", cfg.WatchPath, "/saves")
+			}
+			if cfg.TimeTolerance != 2*time.Second {
+				t.Errorf("TimeTolerance = %v, want %v", cfg.TimeTolerance, 2*time.Second)
+			}
+			if len(cfg.TriggerOps) != 2 || cfg.TriggerOps[1] != "rename" {
+				t.Errorf("TriggerOps = %v, want [write rename]", cfg.TriggerOps)
+			}
+			if cfg.S3Config.Endpoint != "minio.lan:9000" {
+				t.Errorf("Endpoint = %v, want %v", cfg.S3Config.Endpoint, "minio.lan:9000")
+			}
+
+			// Keys missing from the file keep their defaults
+			if cfg.S3Config.BucketName != "gamesync-dragonwilds" {
+				t.Errorf("BucketName = %v, want default", cfg.S3Config.BucketName)
+			}
+			if cfg.S3Config.ListStatConcurrency != DefaultListStatConcurrency {
+				t.Errorf("ListStatConcurrency = %v, want default", cfg.S3Config.ListStatConcurrency)
+			}
+		})
+	}
+}
+
+func TestLoadFromFileErrors(t *testing.T) {
+	if _, err := LoadFromFile(filepath.Join(t.TempDir(), "missing.yaml")); err == nil {
+		t.Error("LoadFromFile() missing file error = nil, want error")
+	}
+
+	path := writeConfigFile(t, "bad.yaml", "time_tolerance: soon\n")
+	if _, err := LoadFromFile(path); err == nil {
+		t.Error("LoadFromFile() bad duration error = nil, want error")
+	}
+}
+
+func TestParseFlagsPrecedence(t *testing.T) {
+	path := writeConfigFile(t, "cloudsync.yaml", `
+watch_path: /from/file
+process_name: file.exe
+time_tolerance: 2s
+s3:
+  access_key: filekey
+  secret_key: filesecret
+  bucket_name: filebucket
+`)
+
+	fs := flag.NewFlagSet("cloudsync", flag.ContinueOnError)
+	cfg, err := parseFlags(fs, []string{
+		"-config", path,
+		"-bucket-name", "flagbucket",
+		"-time-tolerance", "0",
+	})
+	if err != nil {
+		t.Fatalf("parseFlags() error = %v", err)
+	}
+
+	// Flag beats file
+	if cfg.S3Config.BucketName != "flagbucket" {
+		t.Errorf("BucketName = %v, want %v", cfg.S3Config.BucketName, "flagbucket")
+	}
+	if cfg.TimeTolerance != 0 {
+		t.Errorf("TimeTolerance = %v, want 0", cfg.TimeTolerance)
+	}
+
+	// File beats default
+	if cfg.ProcessName != "file.exe" {
+		t.Errorf("ProcessName = %v, want %v", cfg.ProcessName, "file.exe")
+	}
+	if cfg.S3Config.AccessKey != "filekey" {
+		t.Errorf("AccessKey = %v, want %v", cfg.S3Config.AccessKey, "filekey")
+	}
+
+	// Default when neither sets it
+	if cfg.S3Config.Endpoint != "localhost:9000" {
+		t.Errorf("Endpoint = %v, want %v", cfg.S3Config.Endpoint, "localhost:9000")
+	}
+	if len(cfg.TriggerOps) != 2 {
+		t.Errorf("TriggerOps = %v, want default [write create]", cfg.TriggerOps)
+	}
+
+	// Derived paths follow the merged watch path
+	if want, _ := defaultBackupDir("/from/file"); cfg.BackupDir != want {
+		t.Errorf("BackupDir = %v, want %v", cfg.BackupDir, want)
+	}
+}
+
+func TestParseFlagsBackend(t *testing.T) {
+	tests := []struct {
+		name    string
+		args    []string
+		wantErr bool
+	}{
+		{name: "s3 needs credentials", args: []string{"-backend", "s3"}, wantErr: true},
+		{name: "s3 with temporary credentials", args: []string{"-access-key", "key", "-secret-key", "secret", "-session-token", "token"}},
+		{name: "s3 with the credential chain", args: []string{"-credentials", "chain"}},
+		{name: "unknown credentials", args: []string{"-credentials", "vault", "-access-key", "key", "-secret-key", "secret"}, wantErr: true},
+		{name: "local with dir", args: []string{"-backend", "local", "-local-dir", "/mnt/nas/saves"}},
+		{name: "local without dir", args: []string{"-backend", "local"}, wantErr: true},
+		{name: "azure with key", args: []string{"-backend", "azure", "-azure-account", "saves", "-azure-key", "a2V5"}},
+		{name: "azure with connection string", args: []string{"-backend", "azure", "-azure-connection-string", "UseDevelopmentStorage=true"}},
+		{name: "azure without key", args: []string{"-backend", "azure", "-azure-account", "saves"}, wantErr: true},
+		{name: "unknown backend", args: []string{"-backend", "ftp"}, wantErr: true},
+		{name: "s3 with version retention", args: []string{"-access-key", "key", "-secret-key", "secret", "-version-retention-days", "30"}},
+		{name: "negative version retention", args: []string{"-access-key", "key", "-secret-key", "secret", "-version-retention-days", "-1"}, wantErr: true},
+		{name: "negative min file size", args: []string{"-access-key", "key", "-secret-key", "secret", "-min-file-size", "-1"}, wantErr: true},
+		{name: "min size ratio above 1", args: []string{"-access-key", "key", "-secret-key", "secret", "-min-size-ratio", "1.5"}, wantErr: true},
+		{name: "backup window", args: []string{"-access-key", "key", "-secret-key", "secret", "-backup-window", "1m"}},
+		{name: "sync empty dirs", args: []string{"-access-key", "key", "-secret-key", "secret", "-recursive", "-sync-empty-dirs"}},
+		{name: "sync empty dirs needs recursive", args: []string{"-access-key", "key", "-secret-key", "secret", "-sync-empty-dirs"}, wantErr: true},
+		{name: "negative backup window", args: []string{"-access-key", "key", "-secret-key", "secret", "-backup-window", "-1m"}, wantErr: true},
+		{name: "zero shutdown grace", args: []string{"-access-key", "key", "-secret-key", "secret", "-shutdown-grace", "0"}, wantErr: true},
+		{name: "version retention needs s3", args: []string{"-backend", "local", "-local-dir", "/mnt/nas/saves", "-version-retention-days", "30"}, wantErr: true},
+		{name: "sse s3", args: []string{"-access-key", "key", "-secret-key", "secret", "-sse", "s3"}},
+		{name: "sse kms with key id", args: []string{"-access-key", "key", "-secret-key", "secret", "-sse", "kms", "-sse-kms-key-id", "alias/saves"}},
+		{name: "unknown sse", args: []string{"-access-key", "key", "-secret-key", "secret", "-sse", "aes"}, wantErr: true},
+		{name: "kms key id without kms", args: []string{"-access-key", "key", "-secret-key", "secret", "-sse", "s3", "-sse-kms-key-id", "alias/saves"}, wantErr: true},
+		{name: "sse needs s3", args: []string{"-backend", "local", "-local-dir", "/mnt/nas/saves", "-sse", "s3"}, wantErr: true},
+		{name: "part size", args: []string{"-access-key", "key", "-secret-key", "secret", "-part-size-mib", "64", "-upload-threads", "8"}},
+		{name: "part size below the S3 minimum", args: []string{"-access-key", "key", "-secret-key", "secret", "-part-size-mib", "4"}, wantErr: true},
+		{name: "part size above the S3 maximum", args: []string{"-access-key", "key", "-secret-key", "secret", "-part-size-mib", "5121"}, wantErr: true},
+		{name: "no upload threads", args: []string{"-access-key", "key", "-secret-key", "secret", "-upload-threads", "0"}, wantErr: true},
+		{name: "log level", args: []string{"-access-key", "key", "-secret-key", "secret", "-log-level", "debug"}},
+		{name: "unknown log level", args: []string{"-access-key", "key", "-secret-key", "secret", "-log-level", "verbose"}, wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			fs := flag.NewFlagSet("cloudsync", flag.ContinueOnError)
+			args := append([]string{"-watch-path", t.TempDir()}, tt.args...)
+			_, err := parseFlags(fs, args)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("parseFlags(%v) error = %v, wantErr %v", tt.args, err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestParseFlagsVersion(t *testing.T) {
+	// No credentials and a missing config file: -version must not care
+	fs := flag.NewFlagSet("cloudsync", flag.ContinueOnError)
+	cfg, err := parseFlags(fs, []string{"-backend", "s3", "-config", "/nonexistent/cloudsync.yaml", "-version"})
+	if err != nil {
+		t.Fatalf("parseFlags(-version) error = %v", err)
+	}
+	if !cfg.ShowVersion {
+		t.Error("ShowVersion = false, want true")
+	}
+}
+
+func TestParseFlagsEndpoint(t *testing.T) {
+	tests := []struct {
+		endpoint string
+		want     string
+		wantSSL  bool
+	}{
+		{"minio.lan:9000", "minio.lan:9000", false},
+		{"http://minio.lan:9000/", "minio.lan:9000", false},
+		{"https://s3.eu-west-1.amazonaws.com", "s3.eu-west-1.amazonaws.com", true},
+	}
+
+	for _, tt := range tests {
+		fs := flag.NewFlagSet("cloudsync", flag.ContinueOnError)
+		cfg, err := parseFlags(fs, []string{"-watch-path", t.TempDir(), "-access-key", "key", "-secret-key", "secret", "-cloud-endpoint", tt.endpoint})
+		if err != nil {
+			t.Fatalf("parseFlags(-cloud-endpoint %s) error = %v", tt.endpoint, err)
+		}
+		if cfg.S3Config.Endpoint != tt.want || cfg.S3Config.UseSSL != tt.wantSSL {
+			t.Errorf("-cloud-endpoint %s: Endpoint = %q, UseSSL = %v, want %q, %v", tt.endpoint, cfg.S3Config.Endpoint, cfg.S3Config.UseSSL, tt.want, tt.wantSSL)
+		}
+	}
+}
+
+func TestParseFlagsTLS(t *testing.T) {
+	tests := []struct {
+		name    string
+		args    []string
+		wantErr bool
+	}{
+		{name: "use-ssl", args: []string{"-use-ssl"}},
+		{name: "ca-cert", args: []string{"-use-ssl", "-ca-cert", "ca.pem"}},
+		{name: "ca-cert with https endpoint", args: []string{"-cloud-endpoint", "https://minio.lan", "-ca-cert", "ca.pem"}},
+		{name: "ca-cert without ssl", args: []string{"-ca-cert", "ca.pem"}, wantErr: true},
+		{name: "skip verify without ssl", args: []string{"-insecure-skip-verify"}, wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			fs := flag.NewFlagSet("cloudsync", flag.ContinueOnError)
+			args := append([]string{"-watch-path", t.TempDir(), "-access-key", "key", "-secret-key", "secret"}, tt.args...)
+			cfg, err := parseFlags(fs, args)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("parseFlags(%v) error = %v, wantErr %v", tt.args, err, tt.wantErr)
+			}
+			if err == nil && !cfg.S3Config.UseSSL {
+				t.Error("UseSSL = false, want true")
+			}
+		})
+	}
+}
+
+func TestParseFlagsFileCommands(t *testing.T) {
+	tests := []struct {
+		name      string
+		args      []string
+		wantError bool
+	}{
+		{name: "file", args: []string{"share", "World1.sav"}},
+		{name: "flags before the file", args: []string{"share", "-share-expiry", "1h", "World1.sav"}},
+		{name: "week", args: []string{"share", "-share-expiry", "168h", "World1.sav"}},
+		{name: "over a week", args: []string{"share", "-share-expiry", "169h", "World1.sav"}, wantError: true},
+		{name: "no file", args: []string{"share"}, wantError: true},
+		{name: "two files", args: []string{"share", "a.sav", "b.sav"}, wantError: true},
+		{name: "history", args: []string{"history", "World1.sav"}},
+		{name: "restore version", args: []string{"history", "-restore-version", "v1", "World1.sav"}},
+		{name: "restore version without history", args: []string{"share", "-restore-version", "v1", "World1.sav"}, wantError: true},
+		{name: "doctor", args: []string{"doctor"}},
+		{name: "doctor with a file", args: []string{"doctor", "World1.sav"}, wantError: true},
+		{name: "prune-cloud", args: []string{"prune-cloud", "-yes", "-prune-age", "72h"}},
+		{name: "prune-cloud with a file", args: []string{"prune-cloud", "World1.sav"}, wantError: true},
+		{name: "negative prune age", args: []string{"prune-cloud", "-prune-age", "-1h"}, wantError: true},
+		{name: "verify", args: []string{"verify", "-sample", "0.25"}},
+		{name: "verify with a file", args: []string{"verify", "World1.sav"}, wantError: true},
+		{name: "verify sample of zero", args: []string{"verify", "-sample", "0"}, wantError: true},
+		{name: "verify sample above one", args: []string{"verify", "-sample", "1.5"}, wantError: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			fs := flag.NewFlagSet("cloudsync", flag.ContinueOnError)
+			args := append(tt.args[:1:1], append([]string{"-watch-path", t.TempDir(), "-access-key", "key", "-secret-key", "secret"}, tt.args[1:]...)...)
+			cfg, err := parseFlags(fs, args)
+			if (err != nil) != tt.wantError {
+				t.Fatalf("parseFlags(%v) error = %v, wantErr %v", tt.args, err, tt.wantError)
+			}
+			if err == nil && !cfg.Doctor && !cfg.PruneCloud && !cfg.Verify && cfg.ShareFile+cfg.HistoryFile != "World1.sav" {
+				t.Errorf("ShareFile = %q, HistoryFile = %q, want the file argument", cfg.ShareFile, cfg.HistoryFile)
+			}
+		})
+	}
+}
+
+func TestParseFlagsProcessNames(t *testing.T) {
+	fs := flag.NewFlagSet("cloudsync", flag.ContinueOnError)
+	cfg, err := parseFlags(fs, []string{
+		"-watch-path", t.TempDir(),
+		"-backend", "local",
+		"-local-dir", t.TempDir(),
+		"-process-name", "Launcher.exe, Game.exe",
+	})
+	if err != nil {
+		t.Fatalf("parseFlags() error = %v", err)
+	}
+
+	want := []string{"Launcher.exe", "Game.exe"}
+	if got := cfg.Watches[0].ProcessNames; !reflect.DeepEqual(got, want) {
+		t.Errorf("ProcessNames = %v, want %v", got, want)
+	}
+}
+
+func TestParseFlagsProcessMatch(t *testing.T) {
+	tests := []struct {
+		name    string
+		args    []string
+		wantErr bool
+	}{
+		{name: "default", args: nil},
+		{name: "exact", args: []string{"-process-match", "exact"}},
+		{name: "path", args: []string{"-process-match", "path", "-process-name", filepath.Join(t.TempDir(), "game.exe")}},
+		{name: "path without a path", args: []string{"-process-match", "path", "-process-name", "game.exe"}, wantErr: true},
+		{name: "path without a process", args: []string{"-process-match", "path", "-process-name", ""}},
+		{name: "unknown mode", args: []string{"-process-match", "regex"}, wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			fs := flag.NewFlagSet("cloudsync", flag.ContinueOnError)
+			args := append([]string{"-watch-path", t.TempDir(), "-backend", "local", "-local-dir", t.TempDir()}, tt.args...)
+			_, err := parseFlags(fs, args)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("parseFlags(%v) error = %v, wantErr %v", tt.args, err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestParseFlagsDirection(t *testing.T) {
+	tests := []struct {
+		name    string
+		args    []string
+		want    string
+		wantErr bool
+	}{
+		{name: "default", args: nil, want: DirectionBidirectional},
+		{name: "download only", args: []string{"-direction", "download-only"}, want: DirectionDownloadOnly},
+		{name: "upload only", args: []string{"-direction", "upload-only"}, want: DirectionUploadOnly},
+		{name: "unknown", args: []string{"-direction", "push"}, wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			fs := flag.NewFlagSet("cloudsync", flag.ContinueOnError)
+			args := append([]string{"-watch-path", t.TempDir(), "-backend", "local", "-local-dir", t.TempDir()}, tt.args...)
+			cfg, err := parseFlags(fs, args)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("parseFlags(%v) error = %v, wantErr %v", tt.args, err, tt.wantErr)
+			}
+			if err == nil && cfg.Direction != tt.want {
+				t.Errorf("Direction = %q, want %q", cfg.Direction, tt.want)
+			}
+		})
+	}
+}
+
+func TestParseFlagsMetadata(t *testing.T) {
+	tests := []struct {
+		name    string
+		value   string
+		want    map[string]string
+		wantErr bool
+	}{
+		{name: "pairs", value: "owner=alice, retention=30d", want: map[string]string{"owner": "alice", "retention": "30d"}},
+		{name: "empty value", value: "tag=", want: map[string]string{"tag": ""}},
+		{name: "no value", value: "owner", wantErr: true},
+		{name: "empty key", value: "=alice", wantErr: true},
+		{name: "invalid key", value: "my owner=alice", wantErr: true},
+		{name: "reserved key", value: "modtime=0", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			fs := flag.NewFlagSet("cloudsync", flag.ContinueOnError)
+			cfg, err := parseFlags(fs, []string{
+				"-watch-path", t.TempDir(),
+				"-backend", "local",
+				"-local-dir", t.TempDir(),
+				"-metadata", tt.value,
+			})
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("parseFlags() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if err == nil && !reflect.DeepEqual(cfg.S3Config.Metadata, tt.want) {
+				t.Errorf("Metadata = %v, want %v", cfg.S3Config.Metadata, tt.want)
+			}
+		})
+	}
+}
+
+func TestParseFlagsWatches(t *testing.T) {
+	path := writeConfigFile(t, "cloudsync.yaml", `
+process_name: default.exe
+s3:
+  access_key: key
+  secret_key: secret
+watches:
+  - watch_path: /saves/dragonwilds
+    bucket_name: dragonwilds
+    process_name: RSDragonwilds.exe
+  - watch_path: /saves/valheim
+    bucket_name: valheim
+    backup_dir: /backups/valheim
+    include_patterns: ["*.db", "*.fwl"]
+`)
+
+	fs := flag.NewFlagSet("cloudsync", flag.ContinueOnError)
+	cfg, err := parseFlags(fs, []string{"-config", path})
+	if err != nil {
+		t.Fatalf("parseFlags() error = %v", err)
+	}
+
+	if len(cfg.Watches) != 2 {
+		t.Fatalf("len(Watches) = %d, want 2", len(cfg.Watches))
+	}
+	first, second := cfg.Watches[0], cfg.Watches[1]
+	if want, _ := defaultBackupDir("/saves/dragonwilds"); first.BackupDir != want {
+		t.Errorf("Watches[0].BackupDir = %v, want %v", first.BackupDir, want)
+	}
+	if second.ProcessName != "default.exe" {
+		t.Errorf("Watches[1].ProcessName = %v, want the top-level %v", second.ProcessName, "default.exe")
+	}
+	if len(second.IncludePatterns) != 2 || second.IncludePatterns[0] != "*.db" {
+		t.Errorf("Watches[1].IncludePatterns = %v, want [*.db *.fwl]", second.IncludePatterns)
+	}
+	if second.StateFile != filepath.Join("/backups/valheim", "sync-state.json") {
+		t.Errorf("Watches[1].StateFile = %v, want it in the watch's backup dir", second.StateFile)
+	}
+
+	// The top-level settings mirror the first watch for the one-shot commands
+	if cfg.WatchPath != first.WatchPath || cfg.S3Config.BucketName != "dragonwilds" || cfg.ProcessName != "RSDragonwilds.exe" {
+		t.Errorf("top level = %v %v %v, want the first watch's", cfg.WatchPath, cfg.S3Config.BucketName, cfg.ProcessName)
+	}
+}
+
+func TestReload(t *testing.T) {
+	path := writeConfigFile(t, "cloudsync.yaml", `
+sync_interval: 10s
+s3:
+  access_key: key
+  secret_key: secret
+watches:
+  - watch_path: /saves/dragonwilds
+    bucket_name: dragonwilds
+  - watch_path: /saves/valheim
+    bucket_name: valheim
+`)
+
+	fs := flag.NewFlagSet("cloudsync", flag.ContinueOnError)
+	cfg, err := parseFlags(fs, []string{"-config", path, "-concurrency", "2"})
+	if err != nil {
+		t.Fatalf("parseFlags() error = %v", err)
+	}
+
+	if err := os.WriteFile(path, []byte(`
+sync_interval: 30s
+concurrency: 8
+s3:
+  access_key: newkey
+  secret_key: secret
+watches:
+  - watch_path: /saves/dragonwilds
+    bucket_name: dragonwilds
+    include_patterns: ["*.sav", "*.dat"]
+  - watch_path: /saves/enshrouded
+    bucket_name: enshrouded
+`), 0644); err != nil {
+		t.Fatalf("WriteFile() error = %v", err)
+	}
+
+	reloaded, err := cfg.Reload()
+	if err != nil {
+		t.Fatalf("Reload() error = %v", err)
+	}
+	if reloaded.SyncInterval != 30*time.Second {
+		t.Errorf("SyncInterval = %v, want the file's new 30s", reloaded.SyncInterval)
+	}
+	if reloaded.Concurrency != 2 {
+		t.Errorf("Concurrency = %v, want 2 from the command line", reloaded.Concurrency)
+	}
+	if cfg.SyncInterval != 10*time.Second {
+		t.Errorf("original SyncInterval = %v, want it unchanged", cfg.SyncInterval)
+	}
+
+	if got, want := reloaded.Changes(cfg), []string{"s3.access_key", "sync_interval"}; !sameKeys(got, want) {
+		t.Errorf("Changes() = %v, want %v", got, want)
+	}
+	if got, want := WatchChanges(cfg.Watches[0], reloaded.Watches[0]), []string{"include_patterns"}; !sameKeys(got, want) {
+		t.Errorf("WatchChanges() = %v, want %v", got, want)
+	}
+	if got := WatchChanges(cfg.Watches[0], cfg.Watches[0]); len(got) != 0 {
+		t.Errorf("WatchChanges() of the same watch = %v, want none", got)
+	}
+
+	// A broken file is reported, so the caller can keep the old settings
+	if err := os.WriteFile(path, []byte("sync_interval: soon\n"), 0644); err != nil {
+		t.Fatalf("WriteFile() error = %v", err)
+	}
+	if _, err := cfg.Reload(); err == nil {
+		t.Error("Reload() of a broken file error = nil, want error")
+	}
+
+	// Without a config file there is nothing to reload
+	fs = flag.NewFlagSet("cloudsync", flag.ContinueOnError)
+	cfg, err = parseFlags(fs, []string{"-access-key", "key", "-secret-key", "secret", "-bucket-name", "saves", "-watch-path", "/saves"})
+	if err != nil {
+		t.Fatalf("parseFlags() error = %v", err)
+	}
+	if _, err := cfg.Reload(); err == nil {
+		t.Error("Reload() without -config error = nil, want error")
+	}
+}
+
+// sameKeys reports whether got and want hold the same keys in any order
+func sameKeys(got, want []string) bool {
+	got, want = append([]string(nil), got...), append([]string(nil), want...)
+	slices.Sort(got)
+	slices.Sort(want)
+	return slices.Equal(got, want)
+}
+
+func TestParseFlagsObjectPrefix(t *testing.T) {
+	path := writeConfigFile(t, "cloudsync.yaml", `
+s3:
+  access_key: key
+  secret_key: secret
+  bucket_name: games
+  object_prefix: /desktop
+watches:
+  - watch_path: /saves/dragonwilds
+    object_prefix: dragonwilds
+  - watch_path: /saves/valheim
+    object_prefix: valheim/
+`)
+
+	fs := flag.NewFlagSet("cloudsync", flag.ContinueOnError)
+	cfg, err := parseFlags(fs, []string{"-config", path})
+	if err != nil {
+		t.Fatalf("parseFlags() error = %v", err)
+	}
+	for i, want := range []string{"dragonwilds/", "valheim/"} {
+		if got := cfg.Watches[i].ObjectPrefix; got != want {
+			t.Errorf("Watches[%d].ObjectPrefix = %q, want %q", i, got, want)
+		}
+	}
+
+	fs = flag.NewFlagSet("cloudsync", flag.ContinueOnError)
+	cfg, err = parseFlags(fs, []string{"-config", path, "-object-prefix", "laptop"})
+	if err != nil {
+		t.Fatalf("parseFlags() error = %v", err)
+	}
+	if got := cfg.S3Config.ObjectPrefix; got != "dragonwilds/" {
+		t.Errorf("S3Config.ObjectPrefix = %q, want the first watch's", got)
+	}
+
+	fs = flag.NewFlagSet("cloudsync", flag.ContinueOnError)
+	if _, err := parseFlags(fs, []string{"-watch-path", t.TempDir(), "-backend", "local", "-local-dir", t.TempDir(), "-object-prefix", "x"}); err == nil {
+		t.Error("parseFlags() accepted -object-prefix with the local backend")
+	}
+}
+
+func TestParseFlagsPerMachine(t *testing.T) {
+	hostname, err := os.Hostname()
+	if err != nil {
+		t.Skipf("no hostname: %v", err)
+	}
+	s3 := []string{"-cloud-endpoint", "localhost:9000", "-access-key", "key", "-secret-key", "secret", "-per-machine"}
+
+	tests := []struct {
+		name    string
+		args    []string
+		want    string
+		wantErr bool
+	}{
+		{name: "hostname", args: s3, want: hostname},
+		{name: "machine id", args: append(s3, "-machine-id", "laptop"), want: "laptop"},
+		{name: "invalid machine id", args: append(s3, "-machine-id", "my laptop"), wantErr: true},
+		{name: "local backend", args: []string{"-backend", "local", "-local-dir", t.TempDir(), "-per-machine"}, wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			fs := flag.NewFlagSet("cloudsync", flag.ContinueOnError)
+			cfg, err := parseFlags(fs, append([]string{"-watch-path", t.TempDir()}, tt.args...))
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("parseFlags() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if err == nil && cfg.S3Config.MachineID != tt.want {
+				t.Errorf("MachineID = %q, want %q", cfg.S3Config.MachineID, tt.want)
+			}
+		})
+	}
+}
+
+func TestParseFlagsSingleWatch(t *testing.T) {
+	dir := t.TempDir()
+	fs := flag.NewFlagSet("cloudsync", flag.ContinueOnError)
+	cfg, err := parseFlags(fs, []string{
+		"-watch-path", dir,
+		"-access-key", "key",
+		"-secret-key", "secret",
+		"-bucket-name", "saves",
+	})
+	if err != nil {
+		t.Fatalf("parseFlags() error = %v", err)
+	}
+
+	backupDir, err := defaultBackupDir(dir)
+	if err != nil {
+		t.Fatalf("defaultBackupDir() error = %v", err)
+	}
+	want := WatchConfig{
+		WatchPath:       dir,
+		BackupDir:       backupDir,
+		ProcessName:     cfg.ProcessName,
+		ProcessNames:    []string{cfg.ProcessName},
+		BucketName:      "saves",
+		IncludePatterns: cfg.IncludePatterns,
+		ExcludePatterns: cfg.ExcludePatterns,
+		GoodCopyDir:     filepath.Join(backupDir, "LatestGood"),
+		StateFile:       filepath.Join(backupDir, "sync-state.json"),
+		JournalFile:     filepath.Join(backupDir, "journal.json"),
+	}
+	if len(cfg.Watches) != 1 || !reflect.DeepEqual(cfg.Watches[0], want) {
+		t.Errorf("Watches = %+v, want [%+v]", cfg.Watches, want)
+	}
+}
+
+func TestDefaultBackupDir(t *testing.T) {
+	t.Setenv("XDG_DATA_HOME", t.TempDir())
+	t.Setenv("LOCALAPPDATA", t.TempDir())
+	dataDir, err := userDataDir()
+	if err != nil {
+		t.Fatalf("userDataDir() error = %v", err)
+	}
+
+	root := t.TempDir()
+	first, second := filepath.Join(root, "a", "SaveGames"), filepath.Join(root, "b", "SaveGames")
+	dirA, err := defaultBackupDir(first)
+	if err != nil {
+		t.Fatalf("defaultBackupDir() error = %v", err)
+	}
+	dirB, err := defaultBackupDir(second)
+	if err != nil {
+		t.Fatalf("defaultBackupDir() error = %v", err)
+	}
+
+	if !isWithin(dirA, filepath.Join(dataDir, "cloudsync", "backups")) {
+		t.Errorf("defaultBackupDir(%s) = %s, want a folder under the data dir %s", first, dirA, dataDir)
+	}
+	if !strings.HasPrefix(filepath.Base(dirA), "SaveGames-") {
+		t.Errorf("defaultBackupDir(%s) = %s, want it named after the watch path", first, dirA)
+	}
+	if dirA == dirB {
+		t.Errorf("defaultBackupDir() = %s for both %s and %s", dirA, first, second)
+	}
+
+	// A Backup folder left by earlier versions is kept
+	legacy := filepath.Join(first, "Backup")
+	if err := os.MkdirAll(legacy, 0755); err != nil {
+		t.Fatalf("MkdirAll() error = %v", err)
+	}
+	if got, err := defaultBackupDir(first); err != nil || got != legacy {
+		t.Errorf("defaultBackupDir() with an existing Backup folder = %s, %v, want %s", got, err, legacy)
+	}
+}
+
+func TestParseFlagsWatchesErrors(t *testing.T) {
+	tests := []struct {
+		name    string
+		watches string
+	}{
+		{name: "missing watch path", watches: `
+  - bucket_name: one`},
+		{name: "same watch path", watches: `
+  - {watch_path: /saves/a, bucket_name: one}
+  - {watch_path: /saves/a/, bucket_name: two}`},
+		{name: "same bucket", watches: `
+  - {watch_path: /saves/a, bucket_name: one}
+  - {watch_path: /saves/b, bucket_name: one}`},
+		{name: "same backup dir", watches: `
+  - {watch_path: /saves/a, bucket_name: one, backup_dir: /backups}
+  - {watch_path: /saves/b, bucket_name: two, backup_dir: /backups}`},
+		{name: "backup dir is the watch path", watches: `
+  - {watch_path: /saves/a, bucket_name: one, backup_dir: /saves/a}`},
+		{name: "backup dir contains the watch path", watches: `
+  - {watch_path: /saves/a, bucket_name: one, backup_dir: /saves}`},
+		{name: "bad pattern", watches: `
+  - {watch_path: /saves/a, bucket_name: one, include_patterns: ["[.sav"]}`},
+		{name: "same object prefix", watches: `
+  - {watch_path: /saves/a, bucket_name: one, object_prefix: games}
+  - {watch_path: /saves/b, bucket_name: one, object_prefix: /games/}`},
+		{name: "nested object prefix", watches: `
+  - {watch_path: /saves/a, bucket_name: one, object_prefix: games}
+  - {watch_path: /saves/b, bucket_name: one, object_prefix: games/valheim}`},
+		{name: "object prefix beside the bucket root", watches: `
+  - {watch_path: /saves/a, bucket_name: one}
+  - {watch_path: /saves/b, bucket_name: one, object_prefix: valheim}`},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path := writeConfigFile(t, "cloudsync.yaml", "s3: {access_key: key, secret_key: secret}\nwatches:"+tt.watches+"\n")
+			fs := flag.NewFlagSet("cloudsync", flag.ContinueOnError)
+			if _, err := parseFlags(fs, []string{"-config", path}); err == nil {
+				t.Error("parseFlags() error = nil, want error")
+			}
+		})
+	}
+}
+
+func TestWriteInitFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "cloudsync", "cloudsync.yaml")
+	v := InitValues{
+		WatchPath:  `C:\Games\Dragonwilds\Saves`,
+		Endpoint:   "minio.lan:9000",
+		UseSSL:     true,
+		BucketName: "saves",
+	}
+	if err := WriteInitFile(path, v, false); err != nil {
+		t.Fatalf("WriteInitFile() error = %v", err)
+	}
+	if info, err := os.Stat(path); err != nil {
+		t.Fatalf("Stat() error = %v", err)
+	} else if runtime.GOOS != "windows" && info.Mode().Perm() != 0600 {
+		t.Errorf("config file mode = %v, want 0600", info.Mode().Perm())
+	}
+
+	// Without credentials the file refers to the environment variables
+	t.Setenv(EnvAccessKey, "envkey")
+	t.Setenv(EnvSecretKey, "env$secret")
+	cfg, err := LoadFromFile(path)
+	if err != nil {
+		t.Fatalf("LoadFromFile() error = %v", err)
+	}
+	if cfg.WatchPath != v.WatchPath || cfg.S3Config.Endpoint != v.Endpoint || !cfg.S3Config.UseSSL || cfg.S3Config.BucketName != v.BucketName {
+		t.Errorf("LoadFromFile() = watch path %q, endpoint %q, ssl %v, bucket %q, want %+v",
+			cfg.WatchPath, cfg.S3Config.Endpoint, cfg.S3Config.UseSSL, cfg.S3Config.BucketName, v)
+	}
+	if cfg.S3Config.AccessKey != "envkey" || cfg.S3Config.SecretKey != "env$secret" {
+		t.Errorf("credentials = %q, %q, want them from the environment", cfg.S3Config.AccessKey, cfg.S3Config.SecretKey)
+	}
+
+	// An existing file is only replaced with force
+	v.AccessKey, v.SecretKey = "filekey", `se"cr${ET}`
+	if err := WriteInitFile(path, v, false); err == nil {
+		t.Error("WriteInitFile() over an existing file error = nil, want error")
+	}
+	if err := WriteInitFile(path, v, true); err != nil {
+		t.Fatalf("WriteInitFile() with force error = %v", err)
+	}
+	if cfg, err = LoadFromFile(path); err != nil {
+		t.Fatalf("LoadFromFile() error = %v", err)
+	}
+	if cfg.S3Config.AccessKey != v.AccessKey || cfg.S3Config.SecretKey != v.SecretKey {
+		t.Errorf("credentials = %q, %q, want %q, %q", cfg.S3Config.AccessKey, cfg.S3Config.SecretKey, v.AccessKey, v.SecretKey)
+	}
+
+	v.SecretKey = ""
+	if err := WriteInitFile(path, v, true); err == nil {
+		t.Error("WriteInitFile() with only an access key error = nil, want error")
+	}
+}
+
+func TestLoadFromFileUnsetEnv(t *testing.T) {
+	path := writeConfigFile(t, "cloudsync.yaml", "s3:\n  secret_key: ${CLOUDSYNC_TEST_UNSET}\n")
+	if _, err := LoadFromFile(path); err == nil {
+		t.Error("LoadFromFile() with an unset variable error = nil, want error")
+	}
+}
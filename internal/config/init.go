@@ -0,0 +1,173 @@
+package config
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"text/template"
+)
+
+// Environment variables the credentials of a config file written by
+// WriteInitFile are read from, unless they were given outright
+const (
+	EnvAccessKey = "CLOUDSYNC_ACCESS_KEY"
+	EnvSecretKey = "CLOUDSYNC_SECRET_KEY"
+)
+
+// InitValues are the settings cloudsync init fills into a new config file
+type InitValues struct {
+	WatchPath  string
+	Endpoint   string
+	UseSSL     bool
+	BucketName string
+
+	// AccessKey and SecretKey are written as given. Left empty, the file
+	// refers to EnvAccessKey and EnvSecretKey instead.
+	AccessKey string
+	SecretKey string
+}
+
+// DefaultInitValues returns the values cloudsync init offers when neither a
+// flag nor an answer sets them
+func DefaultInitValues() InitValues {
+	d := defaults()
+	v := InitValues{
+		Endpoint:   d.S3Config.Endpoint,
+		UseSSL:     d.S3Config.UseSSL,
+		BucketName: d.S3Config.BucketName,
+	}
+	if watchPath, err := getDefaultWatchPath(); err == nil {
+		v.WatchPath = watchPath
+	}
+	return v
+}
+
+// DefaultConfigPath returns where cloudsync init writes the config file:
+// cloudsync/cloudsync.yaml in the user's config directory (%AppData% on
+// Windows, ~/Library/Application Support on macOS, $XDG_CONFIG_HOME or
+// ~/.config elsewhere)
+func DefaultConfigPath() (string, error) {
+	dir, err := os.UserConfigDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(dir, "cloudsync", "cloudsync.yaml"), nil
+}
+
+var initTemplate = template.Must(template.New("init").Funcs(template.FuncMap{
+	"quote": strconv.Quote,
+	"env":   func(name string) string { return strconv.Quote("${" + name + "}") },
+}).Parse(`# cloudsync configuration, written by cloudsync init.
+# Load it with: cloudsync -config {{.Path}}
+# Flags given on the command line override the values here. Every flag can
+# also be set in this file, named with underscores (-time-tolerance becomes
+# time_tolerance); see the README for the full list.
+
+# Folder holding the game's saves
+watch_path: {{quote .WatchPath}}
+
+s3:
+  # S3 or MinIO server, as host:port without a scheme
+  endpoint: {{quote .Endpoint}}
+  # Connect over HTTPS (true for AWS S3 and most hosted services)
+  use_ssl: {{.UseSSL}}
+  # Bucket the saves are stored in
+  bucket_name: {{quote .BucketName}}
+{{- if .AccessKey}}
+
+  # Credentials. Anyone who can read this file can use them, so keep it
+  # private, or replace them with "${VARIABLE}" references to read them
+  # from environment variables.
+  access_key: {{quote .AccessKey}}
+  secret_key: {{quote .SecretKey}}
+{{- else}}
+
+  # Credentials, read from these environment variables when cloudsync
+  # starts so they aren't stored in this file. Set them before running
+  # cloudsync, or replace the references with the keys themselves.
+  access_key: {{env .EnvAccessKey}}
+  secret_key: {{env .EnvSecretKey}}
+{{- end}}
+
+# Uncomment to pause syncing while the game runs, so saves are only
+# transferred once it has written them completely
+# process_name: RSDragonwilds.exe
+
+# Uncomment to keep at most this many timestamped backup folders
+# max_backups: 20
+`))
+
+// WriteInitFile writes a commented config file with v filled in to path,
+// creating its directory. It refuses to replace an existing file unless
+// force is set. The file is only readable by the user, since it may hold
+// credentials.
+func WriteInitFile(path string, v InitValues, force bool) error {
+	if v.WatchPath == "" || v.Endpoint == "" || v.BucketName == "" {
+		return fmt.Errorf("watch path, endpoint and bucket name are required")
+	}
+	if (v.AccessKey == "") != (v.SecretKey == "") {
+		return fmt.Errorf("give both the access key and the secret key, or neither to read them from %s and %s", EnvAccessKey, EnvSecretKey)
+	}
+
+	if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+		return fmt.Errorf("failed to create config directory: %w", err)
+	}
+
+	flags := os.O_WRONLY | os.O_CREATE | os.O_EXCL
+	if force {
+		flags = os.O_WRONLY | os.O_CREATE | os.O_TRUNC
+	}
+	f, err := os.OpenFile(path, flags, 0600)
+	if errors.Is(err, os.ErrExist) {
+		return fmt.Errorf("config file %s already exists (use -force to overwrite it)", path)
+	}
+	if err != nil {
+		return fmt.Errorf("failed to create config file: %w", err)
+	}
+
+	err = initTemplate.Execute(f, struct {
+		InitValues
+		Path         string
+		EnvAccessKey string
+		EnvSecretKey string
+	}{v, path, EnvAccessKey, EnvSecretKey})
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return fmt.Errorf("failed to write config file: %w", err)
+	}
+	return nil
+}
+
+// envRef matches a setting that is wholly a reference to an environment
+// variable, such as "${CLOUDSYNC_SECRET_KEY}"
+var envRef = regexp.MustCompile(`^\$\{([A-Za-z_][A-Za-z0-9_]*)\}$`)
+
+// expandSecrets replaces credentials in a config file that are "${NAME}"
+// references with the value of environment variable NAME. Anything else,
+// including a secret that merely contains a $, is kept as written.
+func (c *Config) expandSecrets() error {
+	for _, s := range []*string{
+		&c.S3Config.AccessKey,
+		&c.S3Config.SecretKey,
+		&c.S3Config.SessionToken,
+		&c.S3Config.EncryptionPassphrase,
+		&c.S3Config.Azure.Key,
+		&c.S3Config.Azure.ConnectionString,
+	} {
+		m := envRef.FindStringSubmatch(*s)
+		if m == nil {
+			continue
+		}
+		value, ok := os.LookupEnv(m[1])
+		if !ok {
+			return fmt.Errorf("environment variable %s is not set", m[1])
+		}
+		*s = value
+	}
+	return nil
+}
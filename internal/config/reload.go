@@ -0,0 +1,80 @@
+package config
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"reflect"
+	"strings"
+)
+
+// Reload reads the -config file again and returns the resulting Config.
+// The command-line flags c was parsed with are applied again, so they
+// still override the file. c itself is left unchanged.
+func (c *Config) Reload() (*Config, error) {
+	if c.ConfigFile == "" {
+		return nil, fmt.Errorf("no -config file to reload")
+	}
+
+	fs := flag.NewFlagSet("cloudsync", flag.ContinueOnError)
+	fs.SetOutput(io.Discard)
+	return parseFlags(fs, c.args)
+}
+
+// watchKeys are the top-level keys each watch takes over unless it sets
+// its own. Changes lists them per watch, with WatchChanges, rather than
+// once at the top level.
+var watchKeys = map[string]bool{
+	"watch_path":       true,
+	"backup_dir":       true,
+	"process_name":     true,
+	"include_patterns": true,
+	"exclude_patterns": true,
+	"s3.bucket_name":   true,
+	"s3.local_dir":     true,
+	"s3.object_prefix": true,
+}
+
+// Changes returns the config file keys whose values differ between old and
+// c, such as "sync_interval" or "s3.retry.max_attempts", leaving out the
+// watches and the per-watch keys; see WatchChanges. Only the keys are
+// returned, since values may be secrets.
+func (c *Config) Changes(old *Config) []string {
+	var keys []string
+	for _, key := range changedKeys("", reflect.ValueOf(*old), reflect.ValueOf(*c)) {
+		if key != "watches" && !watchKeys[key] {
+			keys = append(keys, key)
+		}
+	}
+	return keys
+}
+
+// WatchChanges returns the config file keys whose values differ between
+// the watches old and w
+func WatchChanges(old, w WatchConfig) []string {
+	return changedKeys("", reflect.ValueOf(old), reflect.ValueOf(w))
+}
+
+// changedKeys compares the yaml-tagged fields of the structs a and b,
+// descending into nested structs, and returns the keys of those that
+// differ joined with dots under prefix
+func changedKeys(prefix string, a, b reflect.Value) []string {
+	var keys []string
+	for i := 0; i < a.NumField(); i++ {
+		tag, _, _ := strings.Cut(a.Type().Field(i).Tag.Get("yaml"), ",")
+		if tag == "" || tag == "-" {
+			continue
+		}
+		key := prefix + tag
+
+		fa, fb := a.Field(i), b.Field(i)
+		if fa.Kind() == reflect.Struct {
+			keys = append(keys, changedKeys(key+".", fa, fb)...)
+			continue
+		}
+		if !reflect.DeepEqual(fa.Interface(), fb.Interface()) {
+			keys = append(keys, key)
+		}
+	}
+	return keys
+}
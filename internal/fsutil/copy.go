@@ -0,0 +1,41 @@
+package fsutil
+
+import (
+	"fmt"
+	"io"
+	"os"
+)
+
+// copyBufferSize is the chunk CopyFile reads and writes at a time where the
+// OS can't copy between the files directly
+const copyBufferSize = 1 << 20
+
+// CopyFile copies src to dst a chunk at a time, so saves of hundreds of
+// megabytes don't have to fit in memory, and flushes dst to disk before
+// returning, so a backup or a copy that is then renamed into place is
+// complete even after a crash
+func CopyFile(src, dst string) error {
+	in, err := os.Open(src)
+	if err != nil {
+		return fmt.Errorf("failed to open source: %w", err)
+	}
+	defer in.Close()
+
+	out, err := os.OpenFile(dst, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0644)
+	if err != nil {
+		return fmt.Errorf("failed to create destination: %w", err)
+	}
+	defer out.Close()
+
+	if _, err := io.CopyBuffer(out, in, make([]byte, copyBufferSize)); err != nil {
+		return fmt.Errorf("failed to copy data: %w", err)
+	}
+	if err := out.Sync(); err != nil {
+		return fmt.Errorf("failed to flush destination: %w", err)
+	}
+	if err := out.Close(); err != nil {
+		return fmt.Errorf("failed to write destination: %w", err)
+	}
+
+	return nil
+}
@@ -0,0 +1,82 @@
+package fsutil
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"errors"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestCopyFileLarge(t *testing.T) {
+	dir := t.TempDir()
+	src := filepath.Join(dir, "large.sav")
+	dst := filepath.Join(dir, "large.sav.bak")
+
+	// Several copy buffers' worth, ending mid-chunk
+	f, err := os.Create(src)
+	if err != nil {
+		t.Fatalf("Create() error = %v", err)
+	}
+	srcHash := sha256.New()
+	chunk := make([]byte, 64<<10)
+	for i := 0; i < 5*copyBufferSize/len(chunk)+1; i++ {
+		for j := range chunk {
+			chunk[j] = byte(i + j)
+		}
+		if _, err := io.MultiWriter(f, srcHash).Write(chunk[:len(chunk)-i%7]); err != nil {
+			t.Fatalf("Write() error = %v", err)
+		}
+	}
+	if err := f.Close(); err != nil {
+		t.Fatalf("Close() error = %v", err)
+	}
+
+	// An existing, longer destination is truncated
+	if err := os.WriteFile(dst, bytes.Repeat([]byte("x"), 8*copyBufferSize), 0644); err != nil {
+		t.Fatalf("WriteFile() error = %v", err)
+	}
+
+	if err := CopyFile(src, dst); err != nil {
+		t.Fatalf("CopyFile() error = %v", err)
+	}
+
+	srcInfo, err := os.Stat(src)
+	if err != nil {
+		t.Fatalf("Stat() error = %v", err)
+	}
+	dstInfo, err := os.Stat(dst)
+	if err != nil {
+		t.Fatalf("Stat() error = %v", err)
+	}
+	if dstInfo.Size() != srcInfo.Size() {
+		t.Errorf("copy size = %d, want %d", dstInfo.Size(), srcInfo.Size())
+	}
+
+	out, err := os.Open(dst)
+	if err != nil {
+		t.Fatalf("Open() error = %v", err)
+	}
+	defer out.Close()
+	dstHash := sha256.New()
+	if _, err := io.Copy(dstHash, out); err != nil {
+		t.Fatalf("Copy() error = %v", err)
+	}
+	if !bytes.Equal(dstHash.Sum(nil), srcHash.Sum(nil)) {
+		t.Error("copy content differs from the source")
+	}
+}
+
+func TestCopyFileMissingSource(t *testing.T) {
+	dir := t.TempDir()
+	dst := filepath.Join(dir, "copy")
+	if err := CopyFile(filepath.Join(dir, "missing"), dst); !errors.Is(err, fs.ErrNotExist) {
+		t.Errorf("CopyFile() error = %v, want a not-exist error", err)
+	}
+	if _, err := os.Stat(dst); !os.IsNotExist(err) {
+		t.Errorf("destination created for a missing source: %v", err)
+	}
+}
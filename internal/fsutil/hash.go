@@ -0,0 +1,36 @@
+package fsutil
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"hash"
+	"io"
+	"os"
+)
+
+// FileSHA256 returns the hex-encoded SHA-256 of the file at path, the
+// checksum uploads record and syncs compare. Any extra hashes are fed the
+// same content, so a caller that also needs, say, the MD5 an S3 ETag holds
+// reads the file only once.
+func FileSHA256(path string, extra ...hash.Hash) (string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+
+	h := sha256.New()
+	w := io.Writer(h)
+	if len(extra) > 0 {
+		writers := []io.Writer{h}
+		for _, e := range extra {
+			writers = append(writers, e)
+		}
+		w = io.MultiWriter(writers...)
+	}
+	if _, err := io.Copy(w, f); err != nil {
+		return "", err
+	}
+
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
@@ -0,0 +1,32 @@
+package fsutil
+
+import (
+	"crypto/md5"
+	"encoding/hex"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestFileSHA256(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "slot1.sav")
+	if err := os.WriteFile(path, []byte("hello"), 0644); err != nil {
+		t.Fatalf("WriteFile() error = %v", err)
+	}
+
+	md := md5.New()
+	sum, err := FileSHA256(path, md)
+	if err != nil {
+		t.Fatalf("FileSHA256() error = %v", err)
+	}
+	if want := "2cf24dba5fb0a30e26e83b2ac5b9e29e1b161e5c1fa7425e73043362938b9824"; sum != want {
+		t.Errorf("FileSHA256() = %s, want %s", sum, want)
+	}
+	if got, want := hex.EncodeToString(md.Sum(nil)), "5d41402abc4b2a76b9719d911017c592"; got != want {
+		t.Errorf("extra MD5 = %s, want %s", got, want)
+	}
+
+	if _, err := FileSHA256(filepath.Join(t.TempDir(), "missing.sav")); !os.IsNotExist(err) {
+		t.Errorf("FileSHA256() of a missing file error = %v, want not exist", err)
+	}
+}
@@ -0,0 +1,130 @@
+package fsutil
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"path"
+	"strings"
+)
+
+// IgnoreFileName is the file in a watch path listing what not to sync
+const IgnoreFileName = ".cloudsyncignore"
+
+// IgnoreRules is a parsed .cloudsyncignore file. It follows gitignore
+// syntax: blank lines and lines starting with # are skipped, a leading !
+// re-includes what an earlier rule ignored, a trailing / matches only
+// directories, a / anywhere else anchors the pattern to the watch path, and
+// ** matches any number of directories. Like the include and exclude
+// patterns, rules ignore case. A nil *IgnoreRules ignores nothing.
+type IgnoreRules struct {
+	rules []ignoreRule
+}
+
+type ignoreRule struct {
+	segments []string // lowercased, split on /
+	negate   bool
+	dirOnly  bool
+}
+
+// ParseIgnore parses the rules in data. Malformed patterns are reported
+// with their line number.
+func ParseIgnore(data []byte) (*IgnoreRules, error) {
+	r := &IgnoreRules{}
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	for n := 1; scanner.Scan(); n++ {
+		line := strings.TrimRight(scanner.Text(), " \t\r")
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		var rule ignoreRule
+		if strings.HasPrefix(line, "!") {
+			rule.negate = true
+			line = line[1:]
+		} else if strings.HasPrefix(line, `\#`) || strings.HasPrefix(line, `\!`) {
+			line = line[1:]
+		}
+		if strings.HasSuffix(line, "/") {
+			rule.dirOnly = true
+			line = strings.TrimRight(line, "/")
+		}
+		if line == "" {
+			continue
+		}
+
+		// A pattern without a slash matches at any depth
+		anchored := strings.Contains(line, "/")
+		line = strings.TrimPrefix(line, "/")
+		if !anchored {
+			line = "**/" + line
+		}
+
+		for _, seg := range strings.Split(strings.ToLower(line), "/") {
+			if _, err := path.Match(seg, ""); err != nil {
+				return nil, fmt.Errorf("line %d: invalid pattern %q: %w", n, scanner.Text(), err)
+			}
+			rule.segments = append(rule.segments, seg)
+		}
+		r.rules = append(r.rules, rule)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	return r, nil
+}
+
+// Ignored reports whether the file at rel, a slash-separated path relative
+// to the watch path, is ignored. As in git, a file in an ignored directory
+// stays ignored even if a later rule re-includes the file itself.
+func (r *IgnoreRules) Ignored(rel string) bool {
+	if r == nil || len(r.rules) == 0 {
+		return false
+	}
+
+	parts := strings.Split(strings.ToLower(strings.Trim(rel, "/")), "/")
+	for i := 1; i < len(parts); i++ {
+		if r.match(parts[:i], true) {
+			return true
+		}
+	}
+	return r.match(parts, false)
+}
+
+// match applies the rules in order to the path parts; the last rule
+// matching decides
+func (r *IgnoreRules) match(parts []string, isDir bool) bool {
+	ignored := false
+	for _, rule := range r.rules {
+		if rule.dirOnly && !isDir {
+			continue
+		}
+		if matchSegments(rule.segments, parts) {
+			ignored = !rule.negate
+		}
+	}
+	return ignored
+}
+
+// matchSegments matches path parts against pattern segments, where a **
+// segment matches zero or more parts
+func matchSegments(pattern, parts []string) bool {
+	for len(pattern) > 0 {
+		if pattern[0] == "**" {
+			for i := 0; i <= len(parts); i++ {
+				if matchSegments(pattern[1:], parts[i:]) {
+					return true
+				}
+			}
+			return false
+		}
+		if len(parts) == 0 {
+			return false
+		}
+		if ok, _ := path.Match(pattern[0], parts[0]); !ok {
+			return false
+		}
+		pattern, parts = pattern[1:], parts[1:]
+	}
+	return len(parts) == 0
+}
@@ -0,0 +1,61 @@
+package fsutil
+
+import "testing"
+
+func TestIgnoreRules(t *testing.T) {
+	rules, err := ParseIgnore([]byte(`# backups made by the game
+*.bak.sav
+   
+/Temp.sav
+autosaves/
+!autosaves/keep.sav
+old/**/draft.sav
+profiles/*/cache.sav
+Slot*.sav
+!Slot1.sav
+\#hash.sav
+`))
+	if err != nil {
+		t.Fatalf("ParseIgnore() error = %v", err)
+	}
+
+	tests := []struct {
+		rel  string
+		want bool
+	}{
+		{rel: "World.sav", want: false},
+		{rel: "World.bak.sav", want: true},
+		{rel: "sub/World.bak.sav", want: true},
+		{rel: "Temp.sav", want: true},
+		{rel: "sub/Temp.sav", want: false},
+		{rel: "autosaves/1.sav", want: true},
+		{rel: "sub/autosaves/1.sav", want: true},
+		{rel: "autosaves/keep.sav", want: true}, // its directory stays ignored
+		{rel: "autosaves.sav", want: false},
+		{rel: "old/draft.sav", want: true},
+		{rel: "old/a/b/draft.sav", want: true},
+		{rel: "new/draft.sav", want: false},
+		{rel: "profiles/p1/cache.sav", want: true},
+		{rel: "profiles/p1/x/cache.sav", want: false},
+		{rel: "Slot2.sav", want: true},
+		{rel: "slot1.SAV", want: false},
+		{rel: "#hash.sav", want: true},
+	}
+
+	for _, tt := range tests {
+		if got := rules.Ignored(tt.rel); got != tt.want {
+			t.Errorf("Ignored(%q) = %v, want %v", tt.rel, got, tt.want)
+		}
+	}
+}
+
+func TestIgnoreRulesEmpty(t *testing.T) {
+	var rules *IgnoreRules
+	if rules.Ignored("World.sav") {
+		t.Error("nil rules ignored World.sav")
+	}
+
+	if _, err := ParseIgnore([]byte("[broken\n")); err == nil {
+		t.Error("ParseIgnore() of a malformed pattern error = nil, want error")
+	}
+}
@@ -0,0 +1,44 @@
+package fsutil
+
+import (
+	"fmt"
+	"path/filepath"
+	"strings"
+)
+
+// Default file filter: every .sav file except the per-machine input
+// settings, which must not follow the user between computers
+var (
+	DefaultIncludePatterns = []string{"*.sav"}
+	DefaultExcludePatterns = []string{"EnhancedInputUserSettings.sav"}
+)
+
+// MatchFile reports whether the base name of path matches at least one of
+// the include patterns and none of the exclude patterns. Patterns use
+// filepath.Match syntax and ignore case, since Windows games may write
+// Game.SAV as readily as game.sav; malformed patterns never match.
+func MatchFile(path string, include, exclude []string) bool {
+	return MatchAny(path, include) && !MatchAny(path, exclude)
+}
+
+// MatchAny reports whether the base name of path matches at least one of
+// patterns, ignoring case like MatchFile
+func MatchAny(path string, patterns []string) bool {
+	name := strings.ToLower(filepath.Base(path))
+	for _, pattern := range patterns {
+		if ok, _ := filepath.Match(strings.ToLower(pattern), name); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// ValidatePatterns reports the first malformed pattern
+func ValidatePatterns(patterns []string) error {
+	for _, pattern := range patterns {
+		if _, err := filepath.Match(pattern, ""); err != nil {
+			return fmt.Errorf("invalid pattern %q: %w", pattern, err)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,136 @@
+package fsutil
+
+import "testing"
+
+func TestMatchFile(t *testing.T) {
+	tests := []struct {
+		name     string
+		filePath string
+		include  []string
+		exclude  []string
+		want     bool
+	}{
+		{
+			name:     "valid sav file",
+			filePath: "/path/to/game.sav",
+			include:  DefaultIncludePatterns,
+			exclude:  DefaultExcludePatterns,
+			want:     true,
+		},
+		{
+			name:     "excluded settings file",
+			filePath: "/path/to/EnhancedInputUserSettings.sav",
+			include:  DefaultIncludePatterns,
+			exclude:  DefaultExcludePatterns,
+			want:     false,
+		},
+		{
+			name:     "non-sav file",
+			filePath: "/path/to/game.txt",
+			include:  DefaultIncludePatterns,
+			exclude:  DefaultExcludePatterns,
+			want:     false,
+		},
+		{
+			name:     "custom extensions",
+			filePath: "/path/to/profile.dat",
+			include:  []string{"*.sav", "*.dat"},
+			exclude:  nil,
+			want:     true,
+		},
+		{
+			name:     "custom exclude glob",
+			filePath: "/path/to/autosave_3.sav",
+			include:  DefaultIncludePatterns,
+			exclude:  []string{"autosave_*.sav"},
+			want:     false,
+		},
+		{
+			name:     "no excludes syncs settings file",
+			filePath: "/path/to/EnhancedInputUserSettings.sav",
+			include:  DefaultIncludePatterns,
+			exclude:  nil,
+			want:     true,
+		},
+		{
+			name:     "no includes matches nothing",
+			filePath: "/path/to/game.sav",
+			include:  nil,
+			exclude:  nil,
+			want:     false,
+		},
+		{
+			name:     "upper-case extension",
+			filePath: "/path/to/GAME.SAV",
+			include:  DefaultIncludePatterns,
+			exclude:  DefaultExcludePatterns,
+			want:     true,
+		},
+		{
+			name:     "mixed-case extension",
+			filePath: "/path/to/Slot1.Sav",
+			include:  DefaultIncludePatterns,
+			exclude:  DefaultExcludePatterns,
+			want:     true,
+		},
+		{
+			name:     "mixed-case custom extensions",
+			filePath: "/path/to/Player.PROFILE",
+			include:  []string{"*.sav", "*.dat", "*.profile"},
+			exclude:  nil,
+			want:     true,
+		},
+		{
+			name:     "upper-case pattern",
+			filePath: "/path/to/world.dat",
+			include:  []string{"*.DAT"},
+			exclude:  nil,
+			want:     true,
+		},
+		{
+			name:     "excluded settings file in other case",
+			filePath: "/path/to/enhancedinputusersettings.SAV",
+			include:  DefaultIncludePatterns,
+			exclude:  DefaultExcludePatterns,
+			want:     false,
+		},
+		{
+			name:     "mixed-case exclude glob",
+			filePath: "/path/to/AutoSave_3.sav",
+			include:  DefaultIncludePatterns,
+			exclude:  []string{"autosave_*.sav"},
+			want:     false,
+		},
+		{
+			name:     "temp file in other case",
+			filePath: "/path/to/GAME.SAV.TMP",
+			include:  DefaultIncludePatterns,
+			exclude:  DefaultExcludePatterns,
+			want:     false,
+		},
+		{
+			name:     "patterns match the base name only",
+			filePath: "/saves/sub/game.sav",
+			include:  []string{"saves/*.sav"},
+			exclude:  nil,
+			want:     false,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := MatchFile(tt.filePath, tt.include, tt.exclude); got != tt.want {
+				t.Errorf("MatchFile() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestValidatePatterns(t *testing.T) {
+	if err := ValidatePatterns([]string{"*.sav", "slot[0-9].dat"}); err != nil {
+		t.Errorf("ValidatePatterns() error = %v, want nil", err)
+	}
+	if err := ValidatePatterns([]string{"*.sav", "slot[.dat"}); err == nil {
+		t.Error("ValidatePatterns() error = nil, want error")
+	}
+}
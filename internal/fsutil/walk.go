@@ -0,0 +1,83 @@
+package fsutil
+
+import (
+	"errors"
+	"io/fs"
+	"log/slog"
+	"os"
+	"path/filepath"
+)
+
+// Walk walks the tree rooted at root like filepath.WalkDir, but also descends
+// into symlinked directories. Each physical directory (resolved with
+// filepath.EvalSymlinks) is visited at most once, so a symlink pointing back
+// up the tree is logged and skipped instead of recursing forever.
+//
+// Paths passed to fn are the logical paths under root, i.e. they go through
+// the symlink rather than its target. Returning fs.SkipDir from fn for a
+// directory skips its contents.
+func Walk(root string, fn fs.WalkDirFunc) error {
+	info, err := os.Stat(root)
+	if err != nil {
+		return fn(root, nil, err)
+	}
+
+	err = walkDir(root, fs.FileInfoToDirEntry(info), make(map[string]bool), fn)
+	if errors.Is(err, fs.SkipDir) || errors.Is(err, fs.SkipAll) {
+		return nil
+	}
+	return err
+}
+
+func walkDir(dir string, d fs.DirEntry, visited map[string]bool, fn fs.WalkDirFunc) error {
+	realPath, err := filepath.EvalSymlinks(dir)
+	if err != nil {
+		return fn(dir, d, err)
+	}
+	if visited[realPath] {
+		slog.Warn("Skipping a directory already visited (symlink loop?)", "path", dir, "resolved", realPath)
+		return nil
+	}
+	visited[realPath] = true
+
+	if err := fn(dir, d, nil); err != nil {
+		if errors.Is(err, fs.SkipDir) {
+			return nil
+		}
+		return err
+	}
+
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return fn(dir, d, err)
+	}
+
+	for _, entry := range entries {
+		path := filepath.Join(dir, entry.Name())
+
+		if entry.Type()&fs.ModeSymlink != 0 {
+			info, err := os.Stat(path)
+			if err != nil {
+				slog.Warn("Skipping broken symlink", "path", path, "err", err)
+				continue
+			}
+			entry = fs.FileInfoToDirEntry(info)
+		}
+
+		if entry.IsDir() {
+			if err := walkDir(path, entry, visited, fn); err != nil {
+				return err
+			}
+			continue
+		}
+
+		if err := fn(path, entry, nil); err != nil {
+			if errors.Is(err, fs.SkipDir) {
+				return nil
+			}
+			return err
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,101 @@
+package fsutil
+
+import (
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"testing"
+	"time"
+)
+
+func TestWalkFollowsSymlinkedDirectories(t *testing.T) {
+	root := t.TempDir()
+	outside := t.TempDir()
+
+	if err := os.WriteFile(filepath.Join(outside, "profile.sav"), []byte("save"), 0644); err != nil {
+		t.Fatalf("WriteFile() error = %v", err)
+	}
+	if err := os.Symlink(outside, filepath.Join(root, "linked")); err != nil {
+		t.Skipf("symlinks not supported: %v", err)
+	}
+
+	var files []string
+	err := Walk(root, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if !d.IsDir() {
+			rel, _ := filepath.Rel(root, path)
+			files = append(files, rel)
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("Walk() error = %v", err)
+	}
+
+	want := filepath.Join("linked", "profile.sav")
+	if len(files) != 1 || files[0] != want {
+		t.Errorf("files = %v, want [%v]", files, want)
+	}
+}
+
+func TestWalkTerminatesOnSymlinkCycle(t *testing.T) {
+	root := t.TempDir()
+	sub := filepath.Join(root, "profiles", "slot1")
+
+	if err := os.MkdirAll(sub, 0755); err != nil {
+		t.Fatalf("MkdirAll() error = %v", err)
+	}
+	if err := os.WriteFile(filepath.Join(sub, "game.sav"), []byte("save"), 0644); err != nil {
+		t.Fatalf("WriteFile() error = %v", err)
+	}
+	// slot1/up -> root creates a cycle root/profiles/slot1/up/profiles/...
+	if err := os.Symlink(root, filepath.Join(sub, "up")); err != nil {
+		t.Skipf("symlinks not supported: %v", err)
+	}
+
+	done := make(chan struct{})
+	var dirs, files []string
+
+	go func() {
+		defer close(done)
+		err := Walk(root, func(path string, d fs.DirEntry, err error) error {
+			if err != nil {
+				return err
+			}
+			rel, _ := filepath.Rel(root, path)
+			if d.IsDir() {
+				dirs = append(dirs, rel)
+			} else {
+				files = append(files, rel)
+			}
+			return nil
+		})
+		if err != nil {
+			t.Errorf("Walk() error = %v", err)
+		}
+	}()
+
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("Walk() did not terminate on a symlink cycle")
+	}
+
+	sort.Strings(dirs)
+	wantDirs := []string{".", "profiles", filepath.Join("profiles", "slot1")}
+	if len(dirs) != len(wantDirs) {
+		t.Fatalf("dirs = %v, want %v", dirs, wantDirs)
+	}
+	for i := range dirs {
+		if dirs[i] != wantDirs[i] {
+			t.Errorf("dirs[%d] = %v, want %v", i, dirs[i], wantDirs[i])
+		}
+	}
+
+	if len(files) != 1 {
+		t.Errorf("files = %v, want exactly one save", files)
+	}
+}
@@ -0,0 +1,111 @@
+// Package lock keeps two cloudsync instances from syncing the same folder,
+// where they would fight over uploads and backups.
+package lock
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/shirou/gopsutil/v4/process"
+)
+
+// FileName is the lock file created in the directory passed to Acquire
+const FileName = ".cloudsync.lock"
+
+// errWouldBlock is returned by tryLock when another process holds the lock
+var errWouldBlock = errors.New("lock is held")
+
+// HeldError reports that another instance holds the lock
+type HeldError struct {
+	Path string
+	PID  int // 0 if the holder's PID could not be read
+}
+
+func (e *HeldError) Error() string {
+	if e.PID == 0 {
+		return fmt.Sprintf("cloudsync is already running (lock %s is held)", e.Path)
+	}
+	return fmt.Sprintf("cloudsync is already running (pid %d, lock %s)", e.PID, e.Path)
+}
+
+// Lock is an acquired lock file
+type Lock struct {
+	f *os.File
+}
+
+// Acquire locks the lock file in dir, creating both if needed, and records
+// this process's PID in it. While the lock is held, Acquire in another
+// process returns a *HeldError. The operating system drops the lock when
+// its holder exits, so a crashed instance never blocks the next one; where
+// file locks aren't supported, a lock whose recorded PID is no longer
+// running is taken over.
+func Acquire(dir string) (*Lock, error) {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, fmt.Errorf("failed to create lock directory: %w", err)
+	}
+	path := filepath.Join(dir, FileName)
+	f, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE, 0644)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open lock file: %w", err)
+	}
+
+	err = tryLock(f)
+	switch {
+	case errors.Is(err, errWouldBlock):
+		pid := readPID(f)
+		f.Close()
+		return nil, &HeldError{Path: path, PID: pid}
+	case errors.Is(err, errors.ErrUnsupported):
+		if pid := readPID(f); pid != 0 && pid != os.Getpid() && pidAlive(pid) {
+			f.Close()
+			return nil, &HeldError{Path: path, PID: pid}
+		}
+	case err != nil:
+		f.Close()
+		return nil, fmt.Errorf("failed to lock %s: %w", path, err)
+	}
+
+	if err := writePID(f); err != nil {
+		f.Close()
+		return nil, fmt.Errorf("failed to write lock file: %w", err)
+	}
+	return &Lock{f: f}, nil
+}
+
+// Release clears the recorded PID and releases the lock. The file is left
+// in place: removing it would race with an instance about to lock it.
+func (l *Lock) Release() error {
+	l.f.Truncate(0)
+	return l.f.Close() // closing drops the lock
+}
+
+func readPID(f *os.File) int {
+	buf := make([]byte, 32)
+	n, _ := f.ReadAt(buf, 0)
+	pid, err := strconv.Atoi(strings.TrimSpace(string(buf[:n])))
+	if err != nil || pid <= 0 {
+		return 0
+	}
+	return pid
+}
+
+func writePID(f *os.File) error {
+	if err := f.Truncate(0); err != nil {
+		return err
+	}
+	if _, err := f.WriteAt([]byte(strconv.Itoa(os.Getpid())+"\n"), 0); err != nil {
+		return err
+	}
+	return f.Sync()
+}
+
+func pidAlive(pid int) bool {
+	alive, err := process.PidExists(int32(pid))
+	// If it can't be told, assume the holder runs rather than risk two
+	// instances
+	return alive || err != nil
+}
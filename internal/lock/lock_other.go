@@ -0,0 +1,12 @@
+//go:build !linux && !darwin && !freebsd && !netbsd && !openbsd && !dragonfly && !windows
+
+package lock
+
+import (
+	"errors"
+	"os"
+)
+
+func tryLock(f *os.File) error {
+	return errors.ErrUnsupported
+}
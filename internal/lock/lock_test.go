@@ -0,0 +1,62 @@
+package lock
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+func TestAcquire(t *testing.T) {
+	dir := filepath.Join(t.TempDir(), "backups")
+
+	l, err := Acquire(dir)
+	if err != nil {
+		t.Fatalf("Acquire() error = %v", err)
+	}
+	got, err := os.ReadFile(filepath.Join(dir, FileName))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if pid := strings.TrimSpace(string(got)); pid != strconv.Itoa(os.Getpid()) {
+		t.Errorf("lock file holds %q, want our pid %d", pid, os.Getpid())
+	}
+
+	// A second lock, as another instance would take, is refused
+	_, err = Acquire(dir)
+	var held *HeldError
+	if !errors.As(err, &held) {
+		t.Fatalf("second Acquire() error = %v, want a *HeldError", err)
+	}
+	if held.PID != os.Getpid() {
+		t.Errorf("HeldError.PID = %d, want %d", held.PID, os.Getpid())
+	}
+	if !strings.Contains(err.Error(), "already running (pid "+strconv.Itoa(os.Getpid())) {
+		t.Errorf("error = %q, want it to name the running pid", err)
+	}
+
+	if err := l.Release(); err != nil {
+		t.Fatalf("Release() error = %v", err)
+	}
+	l, err = Acquire(dir)
+	if err != nil {
+		t.Fatalf("Acquire() after Release() error = %v", err)
+	}
+	l.Release()
+}
+
+func TestAcquireStaleLockFile(t *testing.T) {
+	dir := t.TempDir()
+	// Left behind by a crashed instance: a PID, but no lock held
+	if err := os.WriteFile(filepath.Join(dir, FileName), []byte("999999\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	l, err := Acquire(dir)
+	if err != nil {
+		t.Fatalf("Acquire() error = %v, want the stale lock taken over", err)
+	}
+	l.Release()
+}
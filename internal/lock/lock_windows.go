@@ -0,0 +1,37 @@
+package lock
+
+import (
+	"errors"
+	"os"
+	"syscall"
+	"unsafe"
+)
+
+var procLockFileEx = syscall.NewLazyDLL("kernel32.dll").NewProc("LockFileEx")
+
+const (
+	lockfileFailImmediately = 0x1
+	lockfileExclusiveLock   = 0x2
+
+	// errorLockViolation is ERROR_LOCK_VIOLATION, returned when another
+	// process holds the lock
+	errorLockViolation syscall.Errno = 33
+)
+
+func tryLock(f *os.File) error {
+	var overlapped syscall.Overlapped
+	ret, _, err := procLockFileEx.Call(
+		f.Fd(),
+		lockfileExclusiveLock|lockfileFailImmediately,
+		0,
+		1, 0, // lock the first byte
+		uintptr(unsafe.Pointer(&overlapped)),
+	)
+	if ret != 0 {
+		return nil
+	}
+	if errors.Is(err, errorLockViolation) {
+		return errWouldBlock
+	}
+	return err
+}
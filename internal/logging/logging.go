@@ -0,0 +1,130 @@
+// Package logging provides the plain text log/slog handler cloudsync logs
+// through unless -log-format json is set
+package logging
+
+import (
+	"context"
+	"io"
+	"log/slog"
+	"strconv"
+	"strings"
+	"sync"
+	"unicode"
+)
+
+// timeFormat matches the date and time the log package's standard logger
+// prints
+const timeFormat = "2006/01/02 15:04:05"
+
+// attrTimeFormat is how slog's own handlers print time attributes
+const attrTimeFormat = "2006-01-02T15:04:05.000Z07:00"
+
+// TextHandler writes records as lines like the log package's standard
+// logger: the date and time, the level unless it is INFO, the message and
+// then the attributes as key=value.
+type TextHandler struct {
+	mu    *sync.Mutex
+	w     io.Writer
+	level slog.Leveler
+	attrs string // preformatted, each with a leading space
+	group string // prefix of attribute keys, "" or ending in "."
+}
+
+// NewTextHandler returns a TextHandler writing records of at least level
+// to w
+func NewTextHandler(w io.Writer, level slog.Leveler) *TextHandler {
+	return &TextHandler{mu: new(sync.Mutex), w: w, level: level}
+}
+
+// Enabled reports whether records of level are written
+func (h *TextHandler) Enabled(_ context.Context, level slog.Level) bool {
+	return level >= h.level.Level()
+}
+
+// Handle writes r as one line
+func (h *TextHandler) Handle(_ context.Context, r slog.Record) error {
+	var b strings.Builder
+	if !r.Time.IsZero() {
+		b.WriteString(r.Time.Format(timeFormat))
+		b.WriteByte(' ')
+	}
+	if r.Level != slog.LevelInfo {
+		b.WriteString(r.Level.String())
+		b.WriteByte(' ')
+	}
+	b.WriteString(r.Message)
+	b.WriteString(h.attrs)
+	r.Attrs(func(a slog.Attr) bool {
+		appendAttr(&b, h.group, a)
+		return true
+	})
+	b.WriteByte('\n')
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	_, err := io.WriteString(h.w, b.String())
+	return err
+}
+
+// WithAttrs returns a handler adding attrs to every record
+func (h *TextHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	var b strings.Builder
+	for _, a := range attrs {
+		appendAttr(&b, h.group, a)
+	}
+	h2 := *h
+	h2.attrs += b.String()
+	return &h2
+}
+
+// WithGroup returns a handler qualifying the keys of later attributes with
+// name
+func (h *TextHandler) WithGroup(name string) slog.Handler {
+	if name == "" {
+		return h
+	}
+	h2 := *h
+	h2.group += name + "."
+	return &h2
+}
+
+// appendAttr writes a as " key=value", descending into groups and leaving
+// out empty attributes as slog handlers do
+func appendAttr(b *strings.Builder, group string, a slog.Attr) {
+	a.Value = a.Value.Resolve()
+	if a.Equal(slog.Attr{}) {
+		return
+	}
+	if a.Value.Kind() == slog.KindGroup {
+		if a.Key != "" {
+			group += a.Key + "."
+		}
+		for _, ga := range a.Value.Group() {
+			appendAttr(b, group, ga)
+		}
+		return
+	}
+
+	b.WriteByte(' ')
+	b.WriteString(group + a.Key)
+	b.WriteByte('=')
+	if a.Value.Kind() == slog.KindTime {
+		b.WriteString(a.Value.Time().Format(attrTimeFormat))
+		return
+	}
+	b.WriteString(quoteIfNeeded(a.Value.String()))
+}
+
+// quoteIfNeeded quotes values that are empty or hold spaces, quotes, '='
+// or unprintable characters, so each line splits back into its attributes
+func quoteIfNeeded(s string) string {
+	if s == "" {
+		return `""`
+	}
+	for _, r := range s {
+		if unicode.IsSpace(r) || r == '"' || r == '=' || !unicode.IsPrint(r) {
+			return strconv.Quote(s)
+		}
+	}
+	return s
+}
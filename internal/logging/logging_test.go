@@ -0,0 +1,76 @@
+package logging
+
+import (
+	"bytes"
+	"log"
+	"log/slog"
+	"os"
+	"regexp"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestTextHandler(t *testing.T) {
+	var buf bytes.Buffer
+	var level slog.LevelVar
+	logger := slog.New(NewTextHandler(&buf, &level))
+
+	logger.Info("Uploaded game.sav", "file", "game.sav", "local_mod_time", time.Date(2024, 3, 1, 12, 30, 0, 0, time.UTC), "reason", "not in the cloud", "empty", "")
+	logger.Debug("Created backup")
+	logger.Warn("Failed to checksum game.sav")
+	logger.WithGroup("s3").With("bucket", "saves").Error("Upload failed", slog.Group("retry", "attempt", 2))
+
+	lines := strings.Split(strings.TrimSuffix(buf.String(), "\n"), "\n")
+	want := []string{
+		`Uploaded game.sav file=game.sav local_mod_time=2024-03-01T12:30:00.000Z reason="not in the cloud" empty=""`,
+		`WARN Failed to checksum game.sav`,
+		`ERROR Upload failed s3.bucket=saves s3.retry.attempt=2`,
+	}
+	if len(lines) != len(want) {
+		t.Fatalf("logged %d lines, want %d:\n%s", len(lines), len(want), buf.String())
+	}
+	stamp := regexp.MustCompile(`^\d{4}/\d{2}/\d{2} \d{2}:\d{2}:\d{2} `)
+	for i, line := range lines {
+		if !stamp.MatchString(line) {
+			t.Errorf("line %q lacks the log package's date and time", line)
+		}
+		if got := stamp.ReplaceAllString(line, ""); got != want[i] {
+			t.Errorf("line %d = %q, want %q", i, got, want[i])
+		}
+	}
+
+	// Lowering the level takes effect at once
+	buf.Reset()
+	level.Set(slog.LevelDebug)
+	logger.Debug("Created backup")
+	if !strings.Contains(buf.String(), "DEBUG Created backup") {
+		t.Errorf("debug output = %q, want the debug line", buf.String())
+	}
+}
+
+func TestTextHandlerLogPackage(t *testing.T) {
+	var buf bytes.Buffer
+	var level slog.LevelVar
+	level.Set(slog.LevelWarn)
+
+	old := slog.Default()
+	flags := log.Flags()
+	t.Cleanup(func() {
+		slog.SetDefault(old)
+		log.SetOutput(os.Stderr)
+		log.SetFlags(flags)
+	})
+	slog.SetDefault(slog.New(NewTextHandler(&buf, &level)))
+
+	// The log package logs at info, so a higher level hides it
+	log.Print("starting cloudsync")
+	if buf.Len() != 0 {
+		t.Errorf("log.Print() at level warn wrote %q", buf.String())
+	}
+	level.Set(slog.LevelInfo)
+	log.Print("starting cloudsync")
+	if !strings.HasSuffix(buf.String(), " starting cloudsync\n") || strings.Contains(buf.String(), "INFO") {
+		t.Errorf("log.Print() wrote %q, want a plain line", buf.String())
+	}
+}
@@ -0,0 +1,163 @@
+// Package metrics counts sync activity and serves it in the Prometheus
+// text exposition format, for graphing a headless install.
+package metrics
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net"
+	"net/http"
+	"sort"
+	"sync"
+	"time"
+)
+
+// shutdownTimeout bounds how long Serve waits for scrapes in flight
+const shutdownTimeout = 5 * time.Second
+
+// Registry holds the counters of all watches. It implements sync.Metrics;
+// the zero value is not usable, call New.
+type Registry struct {
+	mu          sync.Mutex
+	syncs       map[string]int64 // completed transfers by direction
+	bytes       map[string]int64 // bytes transferred by direction
+	errors      int64
+	backups     int64
+	started     time.Time
+	lastSuccess time.Time // zero until the first successful sync
+
+	now func() time.Time
+}
+
+// New returns an empty Registry
+func New() *Registry {
+	r := &Registry{
+		syncs: make(map[string]int64),
+		bytes: make(map[string]int64),
+		now:   time.Now,
+	}
+	r.started = r.now()
+	return r
+}
+
+// Transferred counts a completed upload or download of size bytes
+func (r *Registry) Transferred(direction string, size int64) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.syncs[direction]++
+	r.bytes[direction] += size
+}
+
+// BackedUp counts a backup written before a file was replaced
+func (r *Registry) BackedUp() {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.backups++
+}
+
+// Synced records a file or full sync that succeeded
+func (r *Registry) Synced() {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.lastSuccess = r.now()
+}
+
+// SyncFailed counts a file or full sync that failed
+func (r *Registry) SyncFailed() {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.errors++
+}
+
+// WriteTo writes the metrics in the Prometheus text format
+func (r *Registry) WriteTo(w io.Writer) (int64, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	// Until a sync succeeds the gauge counts from startup, so an alert on
+	// it also fires for an instance that never synced
+	since := r.lastSuccess
+	if since.IsZero() {
+		since = r.started
+	}
+
+	cw := &countingWriter{w: w}
+	writeByDirection(cw, "cloudsync_syncs_total", "counter", "Completed transfers by direction.", r.syncs)
+	writeByDirection(cw, "cloudsync_transferred_bytes_total", "counter", "Bytes transferred by direction.", r.bytes)
+	writeMetric(cw, "cloudsync_sync_errors_total", "counter", "File and full syncs that failed.", r.errors)
+	writeMetric(cw, "cloudsync_backups_total", "counter", "Backups written before replacing a file.", r.backups)
+	fmt.Fprintf(cw, "# HELP cloudsync_seconds_since_last_sync Seconds since the last successful sync, or since startup if none has succeeded.\n")
+	fmt.Fprintf(cw, "# TYPE cloudsync_seconds_since_last_sync gauge\n")
+	fmt.Fprintf(cw, "cloudsync_seconds_since_last_sync %g\n", r.now().Sub(since).Seconds())
+	return cw.n, cw.err
+}
+
+func writeMetric(w io.Writer, name, typ, help string, value int64) {
+	fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s %s\n%s %d\n", name, help, name, typ, name, value)
+}
+
+func writeByDirection(w io.Writer, name, typ, help string, values map[string]int64) {
+	fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s %s\n", name, help, name, typ)
+	directions := make([]string, 0, len(values))
+	for d := range values {
+		directions = append(directions, d)
+	}
+	sort.Strings(directions)
+	for _, d := range directions {
+		fmt.Fprintf(w, "%s{direction=%q} %d\n", name, d, values[d])
+	}
+}
+
+// ServeHTTP serves the metrics
+func (r *Registry) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+	r.WriteTo(w)
+}
+
+// Listen opens addr for Serve, so a bad address fails at startup
+func Listen(addr string) (net.Listener, error) {
+	ln, err := net.Listen("tcp", addr)
+	if err != nil {
+		return nil, fmt.Errorf("cannot listen for metrics on %s: %w", addr, err)
+	}
+	return ln, nil
+}
+
+// Serve serves the metrics at /metrics on ln until ctx is cancelled
+func (r *Registry) Serve(ctx context.Context, ln net.Listener) {
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", r)
+	srv := &http.Server{Handler: mux, ReadHeaderTimeout: 10 * time.Second}
+
+	go func() {
+		<-ctx.Done()
+		shutdownCtx, cancel := context.WithTimeout(context.Background(), shutdownTimeout)
+		defer cancel()
+		srv.Shutdown(shutdownCtx)
+	}()
+
+	slog.Info("Serving metrics", "url", "http://"+ln.Addr().String()+"/metrics")
+	if err := srv.Serve(ln); err != nil && !errors.Is(err, http.ErrServerClosed) {
+		slog.Warn("Metrics server stopped", "err", err)
+	}
+}
+
+// countingWriter counts the bytes written and keeps the first error
+type countingWriter struct {
+	w   io.Writer
+	n   int64
+	err error
+}
+
+func (c *countingWriter) Write(p []byte) (int, error) {
+	if c.err != nil {
+		return 0, c.err
+	}
+	n, err := c.w.Write(p)
+	c.n += int64(n)
+	c.err = err
+	return n, err
+}
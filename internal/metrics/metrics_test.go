@@ -0,0 +1,67 @@
+package metrics
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestRegistryWriteTo(t *testing.T) {
+	start := time.Date(2025, 6, 1, 12, 0, 0, 0, time.UTC)
+	now := start
+	r := New()
+	r.started = start
+	r.now = func() time.Time { return now }
+
+	r.Transferred("upload", 100)
+	r.Transferred("upload", 50)
+	r.Transferred("download", 7)
+	r.BackedUp()
+	r.SyncFailed()
+
+	now = start.Add(30 * time.Second)
+	var b strings.Builder
+	if _, err := r.WriteTo(&b); err != nil {
+		t.Fatalf("WriteTo() error = %v", err)
+	}
+	for _, want := range []string{
+		`cloudsync_syncs_total{direction="download"} 1`,
+		`cloudsync_syncs_total{direction="upload"} 2`,
+		`cloudsync_transferred_bytes_total{direction="upload"} 150`,
+		`cloudsync_sync_errors_total 1`,
+		`cloudsync_backups_total 1`,
+		// No sync succeeded yet: counts from startup
+		`cloudsync_seconds_since_last_sync 30`,
+	} {
+		if !strings.Contains(b.String(), want+"\n") {
+			t.Errorf("output lacks %q:\n%s", want, b.String())
+		}
+	}
+
+	r.Synced()
+	now = now.Add(5 * time.Second)
+	b.Reset()
+	r.WriteTo(&b)
+	if !strings.Contains(b.String(), "cloudsync_seconds_since_last_sync 5\n") {
+		t.Errorf("after Synced, output lacks 5 seconds:\n%s", b.String())
+	}
+}
+
+func TestRegistryServeHTTP(t *testing.T) {
+	r := New()
+	r.Transferred("upload", 1)
+
+	rec := httptest.NewRecorder()
+	r.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/metrics", nil))
+
+	if ct := rec.Header().Get("Content-Type"); !strings.HasPrefix(ct, "text/plain; version=0.0.4") {
+		t.Errorf("Content-Type = %q", ct)
+	}
+	body, _ := io.ReadAll(rec.Body)
+	if !strings.Contains(string(body), `cloudsync_syncs_total{direction="upload"} 1`) {
+		t.Errorf("body lacks upload count:\n%s", body)
+	}
+}
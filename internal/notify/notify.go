@@ -0,0 +1,145 @@
+// Package notify posts a webhook after each completed transfer, e.g. so a
+// Discord channel shows when a save is safely in the cloud.
+package notify
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"strings"
+	"sync"
+	"text/template"
+	"time"
+)
+
+// Transfer directions
+const (
+	Upload   = "upload"
+	Download = "download"
+)
+
+// Event filters selectable with NewWebhook
+const (
+	EventsUpload   = "upload"   // notify on uploads only
+	EventsDownload = "download" // notify on downloads only
+	EventsBoth     = "both"
+)
+
+// DefaultTemplate renders the message when none is configured
+const DefaultTemplate = `{{if eq .Direction "upload"}}Uploaded{{else}}Downloaded{{end}} {{.File}} ({{.Size}} bytes)`
+
+// sendTimeout bounds a single webhook request
+const sendTimeout = 10 * time.Second
+
+// Event is a completed transfer
+type Event struct {
+	File      string    `json:"file"`
+	Direction string    `json:"direction"`
+	Time      time.Time `json:"timestamp"`
+	Size      int64     `json:"size"`
+}
+
+// payload is the JSON body posted to the webhook. Content holds the
+// rendered template, the field Discord displays.
+type payload struct {
+	Event
+	Content string `json:"content"`
+}
+
+// Webhook POSTs a JSON payload for each event to a URL. Requests are sent
+// in the background; failures are logged and never reach the caller.
+type Webhook struct {
+	url    string
+	events string
+	tmpl   *template.Template
+	client *http.Client
+
+	wg sync.WaitGroup
+}
+
+// NewWebhook creates a Webhook posting to url. events is EventsUpload,
+// EventsDownload or EventsBoth (the default when empty). tmpl is a
+// text/template over Event for the message; empty means DefaultTemplate.
+func NewWebhook(url, events, tmpl string) (*Webhook, error) {
+	if !strings.HasPrefix(url, "http://") && !strings.HasPrefix(url, "https://") {
+		return nil, fmt.Errorf("notify URL %q must start with http:// or https://", url)
+	}
+
+	switch events {
+	case "":
+		events = EventsBoth
+	case EventsUpload, EventsDownload, EventsBoth:
+	default:
+		return nil, fmt.Errorf("unknown notify events %q (want %s, %s or %s)", events, EventsUpload, EventsDownload, EventsBoth)
+	}
+
+	if tmpl == "" {
+		tmpl = DefaultTemplate
+	}
+	t, err := template.New("notify").Parse(tmpl)
+	if err != nil {
+		return nil, fmt.Errorf("invalid notify template: %w", err)
+	}
+
+	return &Webhook{
+		url:    url,
+		events: events,
+		tmpl:   t,
+		client: &http.Client{Timeout: sendTimeout},
+	}, nil
+}
+
+// Notify sends event in the background if it passes the events filter
+func (w *Webhook) Notify(event Event) {
+	if w.events != EventsBoth && w.events != event.Direction {
+		return
+	}
+
+	w.wg.Add(1)
+	go func() {
+		defer w.wg.Done()
+		if err := w.send(event); err != nil {
+			slog.Warn("Failed to send notification", "file", event.File, "err", err)
+		}
+	}()
+}
+
+// Wait blocks until the notifications in flight have been sent or failed
+func (w *Webhook) Wait() {
+	w.wg.Wait()
+}
+
+func (w *Webhook) send(event Event) error {
+	var content strings.Builder
+	if err := w.tmpl.Execute(&content, event); err != nil {
+		return fmt.Errorf("failed to render template: %w", err)
+	}
+
+	body, err := json.Marshal(payload{Event: event, Content: content.String()})
+	if err != nil {
+		return fmt.Errorf("failed to encode payload: %w", err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), sendTimeout)
+	defer cancel()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, w.url, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := w.client.Do(req)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return fmt.Errorf("webhook returned %s", resp.Status)
+	}
+	return nil
+}
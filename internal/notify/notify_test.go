@@ -0,0 +1,88 @@
+package notify
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	gosync "sync"
+	"testing"
+	"time"
+)
+
+func TestWebhookNotify(t *testing.T) {
+	var mu gosync.Mutex
+	var got []payload
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var p payload
+		if err := json.NewDecoder(r.Body).Decode(&p); err != nil {
+			t.Errorf("Decode() error = %v", err)
+		}
+		mu.Lock()
+		got = append(got, p)
+		mu.Unlock()
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	defer server.Close()
+
+	hook, err := NewWebhook(server.URL, EventsUpload, "")
+	if err != nil {
+		t.Fatalf("NewWebhook() error = %v", err)
+	}
+
+	when := time.Date(2025, 6, 1, 12, 0, 0, 0, time.UTC)
+	hook.Notify(Event{File: "game.sav", Direction: Upload, Time: when, Size: 42})
+	hook.Notify(Event{File: "other.sav", Direction: Download, Time: when, Size: 7})
+	hook.Wait()
+
+	if len(got) != 1 {
+		t.Fatalf("got %d notifications, want 1 (downloads filtered out)", len(got))
+	}
+	p := got[0]
+	if p.File != "game.sav" || p.Direction != Upload || !p.Time.Equal(when) || p.Size != 42 {
+		t.Errorf("payload = %+v, want the upload event", p)
+	}
+	if want := "Uploaded game.sav (42 bytes)"; p.Content != want {
+		t.Errorf("content = %q, want %q", p.Content, want)
+	}
+}
+
+func TestWebhookFailureIsLogged(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusInternalServerError)
+	}))
+	defer server.Close()
+
+	hook, err := NewWebhook(server.URL, "", "{{.File}}")
+	if err != nil {
+		t.Fatalf("NewWebhook() error = %v", err)
+	}
+
+	// Must not panic or block the caller
+	hook.Notify(Event{File: "game.sav", Direction: Download})
+	hook.Wait()
+
+	if err := hook.send(Event{File: "game.sav", Direction: Download}); err == nil {
+		t.Error("send() error = nil, want the 500 reported")
+	}
+}
+
+func TestNewWebhookErrors(t *testing.T) {
+	tests := []struct {
+		name   string
+		url    string
+		events string
+		tmpl   string
+	}{
+		{name: "not http", url: "ftp://example.com"},
+		{name: "unknown events", url: "https://example.com", events: "sometimes"},
+		{name: "bad template", url: "https://example.com", tmpl: "{{.File"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := NewWebhook(tt.url, tt.events, tt.tmpl); err == nil {
+				t.Error("NewWebhook() error = nil, want error")
+			}
+		})
+	}
+}
@@ -0,0 +1,87 @@
+// Package power reports whether the system is running on battery so sync
+// can pause instead of draining it.
+package power
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// State is the system power source
+type State int
+
+const (
+	// StateUnknown means the power source could not be determined, e.g. on
+	// platforms without a detector. It never pauses sync.
+	StateUnknown State = iota
+	StateAC
+	StateBattery
+)
+
+func (s State) String() string {
+	switch s {
+	case StateAC:
+		return "ac"
+	case StateBattery:
+		return "battery"
+	default:
+		return "unknown"
+	}
+}
+
+// Provider reports the current power state
+type Provider interface {
+	State() (State, error)
+}
+
+// ProviderFunc adapts a function to the Provider interface
+type ProviderFunc func() (State, error)
+
+// State implements Provider
+func (f ProviderFunc) State() (State, error) {
+	return f()
+}
+
+// NewProvider returns the detector for the current platform. Platforms
+// without one get a provider that always reports StateUnknown.
+func NewProvider() Provider {
+	return ProviderFunc(platformState)
+}
+
+// sysfsState reads the Linux power_supply class under root
+// (normally /sys/class/power_supply). Any online mains adapter means AC; a
+// discharging battery or an offline adapter means battery. A machine with
+// no supplies listed, such as a desktop or VM, is unknown.
+func sysfsState(root string) (State, error) {
+	entries, err := os.ReadDir(root)
+	if err != nil {
+		return StateUnknown, err
+	}
+
+	state := StateUnknown
+	for _, entry := range entries {
+		dir := filepath.Join(root, entry.Name())
+		switch readAttr(dir, "type") {
+		case "Mains":
+			if readAttr(dir, "online") == "1" {
+				return StateAC, nil
+			}
+			state = StateBattery
+		case "Battery":
+			if readAttr(dir, "status") == "Discharging" {
+				state = StateBattery
+			}
+		}
+	}
+
+	return state, nil
+}
+
+func readAttr(dir, name string) string {
+	data, err := os.ReadFile(filepath.Join(dir, name))
+	if err != nil {
+		return ""
+	}
+	return strings.TrimSpace(string(data))
+}
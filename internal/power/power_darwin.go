@@ -0,0 +1,24 @@
+package power
+
+import (
+	"bytes"
+	"os/exec"
+)
+
+// platformState asks pmset, whose first line names the current source,
+// e.g. "Now drawing from 'Battery Power'"
+func platformState() (State, error) {
+	out, err := exec.Command("pmset", "-g", "batt").Output()
+	if err != nil {
+		return StateUnknown, err
+	}
+
+	switch {
+	case bytes.Contains(out, []byte("'Battery Power'")):
+		return StateBattery, nil
+	case bytes.Contains(out, []byte("'AC Power'")):
+		return StateAC, nil
+	default:
+		return StateUnknown, nil
+	}
+}
@@ -0,0 +1,5 @@
+package power
+
+func platformState() (State, error) {
+	return sysfsState("/sys/class/power_supply")
+}
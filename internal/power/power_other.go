@@ -0,0 +1,7 @@
+//go:build !linux && !darwin && !windows
+
+package power
+
+func platformState() (State, error) {
+	return StateUnknown, nil
+}
@@ -0,0 +1,91 @@
+package power
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func writeSupply(t *testing.T, root, name string, attrs map[string]string) {
+	t.Helper()
+	dir := filepath.Join(root, name)
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		t.Fatalf("MkdirAll() error = %v", err)
+	}
+	for attr, value := range attrs {
+		if err := os.WriteFile(filepath.Join(dir, attr), []byte(value+"\n"), 0644); err != nil {
+			t.Fatalf("WriteFile() error = %v", err)
+		}
+	}
+}
+
+func TestSysfsState(t *testing.T) {
+	tests := []struct {
+		name     string
+		supplies map[string]map[string]string
+		want     State
+	}{
+		{
+			name:     "no supplies (desktop)",
+			supplies: nil,
+			want:     StateUnknown,
+		},
+		{
+			name: "adapter online",
+			supplies: map[string]map[string]string{
+				"AC":   {"type": "Mains", "online": "1"},
+				"BAT0": {"type": "Battery", "status": "Charging"},
+			},
+			want: StateAC,
+		},
+		{
+			name: "adapter offline",
+			supplies: map[string]map[string]string{
+				"AC":   {"type": "Mains", "online": "0"},
+				"BAT0": {"type": "Battery", "status": "Discharging"},
+			},
+			want: StateBattery,
+		},
+		{
+			name: "battery discharging without adapter entry",
+			supplies: map[string]map[string]string{
+				"BAT0": {"type": "Battery", "status": "Discharging"},
+			},
+			want: StateBattery,
+		},
+		{
+			name: "battery full without adapter entry",
+			supplies: map[string]map[string]string{
+				"BAT0": {"type": "Battery", "status": "Full"},
+			},
+			want: StateUnknown,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			root := t.TempDir()
+			for name, attrs := range tt.supplies {
+				writeSupply(t, root, name, attrs)
+			}
+
+			got, err := sysfsState(root)
+			if err != nil {
+				t.Fatalf("sysfsState() error = %v", err)
+			}
+			if got != tt.want {
+				t.Errorf("sysfsState() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestSysfsStateMissingRoot(t *testing.T) {
+	got, err := sysfsState(filepath.Join(t.TempDir(), "missing"))
+	if err == nil {
+		t.Error("sysfsState() error = nil, want error")
+	}
+	if got != StateUnknown {
+		t.Errorf("sysfsState() = %v, want %v", got, StateUnknown)
+	}
+}
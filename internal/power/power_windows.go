@@ -0,0 +1,40 @@
+package power
+
+import (
+	"syscall"
+	"unsafe"
+)
+
+var procGetSystemPowerStatus = syscall.NewLazyDLL("kernel32.dll").NewProc("GetSystemPowerStatus")
+
+// systemPowerStatus mirrors the Win32 SYSTEM_POWER_STATUS structure
+type systemPowerStatus struct {
+	ACLineStatus        byte
+	BatteryFlag         byte
+	BatteryLifePercent  byte
+	SystemStatusFlag    byte
+	BatteryLifeTime     uint32
+	BatteryFullLifeTime uint32
+}
+
+const (
+	acLineOffline = 0
+	acLineOnline  = 1
+)
+
+func platformState() (State, error) {
+	var status systemPowerStatus
+	ret, _, err := procGetSystemPowerStatus.Call(uintptr(unsafe.Pointer(&status)))
+	if ret == 0 {
+		return StateUnknown, err
+	}
+
+	switch status.ACLineStatus {
+	case acLineOnline:
+		return StateAC, nil
+	case acLineOffline:
+		return StateBattery, nil
+	default:
+		return StateUnknown, nil
+	}
+}
@@ -2,7 +2,12 @@ package storage
 
 import (
 	"context"
+	"errors"
+	"fmt"
 	"time"
+
+	"github.com/danielbehrens/cloudsync/internal/sync"
+	"github.com/minio/minio-go/v7"
 )
 
 // Adapter wraps S3Client to implement sync.Storage interface
@@ -10,11 +15,30 @@ type Adapter struct {
 	client *S3Client
 }
 
+var (
+	_ sync.Storage        = (*Adapter)(nil)
+	_ sync.ETagLister     = (*Adapter)(nil)
+	_ sync.Versioner      = (*Adapter)(nil)
+	_ sync.ClockSkewer    = (*Adapter)(nil)
+	_ sync.FilteredLister = (*Adapter)(nil)
+)
+
 // NewAdapter creates a new storage adapter
 func NewAdapter(client *S3Client) *Adapter {
 	return &Adapter{client: client}
 }
 
+// SetProgress makes transfers report their progress, see
+// S3Client.SetProgress
+func (a *Adapter) SetProgress(fn ProgressFunc) {
+	a.client.SetProgress(fn)
+}
+
+// ClockSkew implements sync.ClockSkewer
+func (a *Adapter) ClockSkew(ctx context.Context) (time.Duration, error) {
+	return a.client.ClockSkew(ctx)
+}
+
 // Upload implements sync.Storage
 func (a *Adapter) Upload(ctx context.Context, localPath, objectName string) error {
 	return a.client.Upload(ctx, localPath, objectName)
@@ -25,37 +49,86 @@ func (a *Adapter) Download(ctx context.Context, objectName, localPath string) er
 	return a.client.Download(ctx, objectName, localPath)
 }
 
+// Delete implements sync.Storage. S3 deletes succeed whether or not the
+// object exists, so it is looked up first to report a missing one with
+// sync.ErrNotExist.
+func (a *Adapter) Delete(ctx context.Context, objectName string) error {
+	if _, err := a.Stat(ctx, objectName); err != nil {
+		if errors.Is(err, sync.ErrNotExist) {
+			return fmt.Errorf("failed to delete object %s: %w", objectName, sync.ErrNotExist)
+		}
+		return err
+	}
+	return a.client.Delete(ctx, objectName)
+}
+
 // Stat implements sync.Storage
-func (a *Adapter) Stat(ctx context.Context, objectName string) (*SyncFileInfo, error) {
+func (a *Adapter) Stat(ctx context.Context, objectName string) (*sync.SyncFileInfo, error) {
 	info, err := a.client.Stat(ctx, objectName)
 	if err != nil {
+		var resp minio.ErrorResponse
+		if errors.As(err, &resp) && resp.Code == "NoSuchKey" {
+			return nil, fmt.Errorf("failed to stat object %s: %w", objectName, sync.ErrNotExist)
+		}
 		return nil, err
 	}
 
-	return &SyncFileInfo{
-		Name:    info.Name,
-		ModTime: info.ModTime,
-		Size:    info.Size,
+	return &sync.SyncFileInfo{
+		Name:          info.Name,
+		ModTime:       info.ModTime,
+		Size:          info.Size,
+		ETag:          info.ETag,
+		Checksum:      info.Checksum,
+		CoarseModTime: info.CoarseModTime,
 	}, nil
 }
 
 // List implements sync.Storage
-func (a *Adapter) List(ctx context.Context) ([]*SyncFileInfo, error) {
+func (a *Adapter) List(ctx context.Context) ([]*sync.SyncFileInfo, error) {
 	files, err := a.client.List(ctx)
 	if err != nil {
 		return nil, err
 	}
+	return syncFileInfos(files), nil
+}
+
+// ListFiltered implements sync.FilteredLister
+func (a *Adapter) ListFiltered(ctx context.Context, prefix string, keep func(name string) bool) ([]*sync.SyncFileInfo, error) {
+	files, err := a.client.ListFiltered(ctx, prefix, keep)
+	if err != nil {
+		return nil, err
+	}
+	return syncFileInfos(files), nil
+}
 
-	var result []*SyncFileInfo
+// syncFileInfos converts a listing to sync's representation
+func syncFileInfos(files []*FileInfo) []*sync.SyncFileInfo {
+	var result []*sync.SyncFileInfo
 	for _, f := range files {
-		result = append(result, &SyncFileInfo{
-			Name:    f.Name,
-			ModTime: f.ModTime,
-			Size:    f.Size,
+		result = append(result, &sync.SyncFileInfo{
+			Name:          f.Name,
+			ModTime:       f.ModTime,
+			Size:          f.Size,
+			ETag:          f.ETag,
+			Checksum:      f.Checksum,
+			CoarseModTime: f.CoarseModTime,
 		})
 	}
+	return result
+}
 
-	return result, nil
+// ListETags implements sync.ETagLister
+func (a *Adapter) ListETags(ctx context.Context) (map[string]string, error) {
+	return a.client.ListETags(ctx)
+}
+
+// StatAll implements sync.ETagLister
+func (a *Adapter) StatAll(ctx context.Context, names []string) ([]*sync.SyncFileInfo, error) {
+	files, err := a.client.StatAll(ctx, names)
+	if err != nil {
+		return nil, err
+	}
+	return syncFileInfos(files), nil
 }
 
 // EnsureBucket implements sync.Storage
@@ -63,9 +136,27 @@ func (a *Adapter) EnsureBucket(ctx context.Context) error {
 	return a.client.EnsureBucket(ctx)
 }
 
-// SyncFileInfo is the file info type used by sync package
-type SyncFileInfo struct {
-	Name    string
-	ModTime time.Time
-	Size    int64
+// ListVersions implements sync.Versioner
+func (a *Adapter) ListVersions(ctx context.Context, objectName string) ([]*sync.VersionInfo, error) {
+	versions, err := a.client.ListVersions(ctx, objectName)
+	if err != nil {
+		return nil, err
+	}
+
+	result := make([]*sync.VersionInfo, len(versions))
+	for i, v := range versions {
+		result[i] = &sync.VersionInfo{
+			VersionID:    v.VersionID,
+			ModTime:      v.ModTime,
+			Size:         v.Size,
+			IsLatest:     v.IsLatest,
+			DeleteMarker: v.DeleteMarker,
+		}
+	}
+	return result, nil
+}
+
+// DownloadVersion implements sync.Versioner
+func (a *Adapter) DownloadVersion(ctx context.Context, objectName, versionID, localPath string) error {
+	return a.client.DownloadVersion(ctx, objectName, versionID, localPath)
 }
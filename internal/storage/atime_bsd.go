@@ -0,0 +1,16 @@
+//go:build darwin || freebsd || netbsd
+
+package storage
+
+import (
+	"os"
+	"syscall"
+	"time"
+)
+
+func accessTime(info os.FileInfo) time.Time {
+	if st, ok := info.Sys().(*syscall.Stat_t); ok {
+		return time.Unix(st.Atimespec.Unix())
+	}
+	return time.Time{}
+}
@@ -0,0 +1,13 @@
+//go:build !linux && !darwin && !freebsd && !netbsd && !windows
+
+package storage
+
+import (
+	"os"
+	"time"
+)
+
+// accessTime is unknown here; the access time is then not recorded
+func accessTime(info os.FileInfo) time.Time {
+	return time.Time{}
+}
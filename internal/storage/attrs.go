@@ -0,0 +1,76 @@
+package storage
+
+import (
+	"fmt"
+	"os"
+	"runtime"
+	"strconv"
+	"time"
+)
+
+// User metadata keys of the file attributes kept beside the mod time
+const (
+	metaMode  = "Mode"  // permission bits in octal, not recorded on Windows
+	metaAtime = "Atime" // access time in Unix nanoseconds
+)
+
+// fileAttrs are the attributes of a file restored on download besides its
+// content and mod time. Zero fields were not recorded.
+type fileAttrs struct {
+	Mode  os.FileMode
+	Atime time.Time
+}
+
+// statAttrs returns the attributes of the file info describes. Windows only
+// has a read-only flag, which Go reports as 0444 or 0666; restoring that on
+// Unix would make the save world-writable, so no mode is recorded there.
+func statAttrs(info os.FileInfo) fileAttrs {
+	attrs := fileAttrs{Atime: accessTime(info)}
+	if runtime.GOOS != "windows" {
+		attrs.Mode = info.Mode().Perm()
+	}
+	return attrs
+}
+
+// metadata returns the recorded attributes as user metadata, keyed without
+// the X-Amz-Meta- prefix
+func (a fileAttrs) metadata() map[string]string {
+	meta := make(map[string]string)
+	if a.Mode != 0 {
+		meta[metaMode] = fmt.Sprintf("%o", a.Mode)
+	}
+	if !a.Atime.IsZero() {
+		meta[metaAtime] = strconv.FormatInt(a.Atime.UnixNano(), 10)
+	}
+	return meta
+}
+
+// parseAttrs reads the attributes from user metadata, skipping values that
+// are missing or malformed
+func parseAttrs(meta map[string]string) fileAttrs {
+	var attrs fileAttrs
+	if mode, err := strconv.ParseUint(meta[metaMode], 8, 32); err == nil {
+		attrs.Mode = os.FileMode(mode).Perm()
+	}
+	if ns, err := strconv.ParseInt(meta[metaAtime], 10, 64); err == nil && ns > 0 {
+		attrs.Atime = time.Unix(0, ns)
+	}
+	return attrs
+}
+
+// apply restores the attributes on path. Windows keeps its default
+// permissions: a read-only save couldn't be replaced by the next download.
+func (a fileAttrs) apply(path string) error {
+	if a.Mode != 0 && runtime.GOOS != "windows" {
+		if err := os.Chmod(path, a.Mode); err != nil {
+			return fmt.Errorf("failed to restore permissions: %w", err)
+		}
+	}
+	if !a.Atime.IsZero() {
+		// A zero mod time leaves it for the caller to set
+		if err := os.Chtimes(path, a.Atime, time.Time{}); err != nil {
+			return fmt.Errorf("failed to restore access time: %w", err)
+		}
+	}
+	return nil
+}
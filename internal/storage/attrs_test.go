@@ -0,0 +1,77 @@
+package storage
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"runtime"
+	"testing"
+	"time"
+)
+
+func TestParseAttrs(t *testing.T) {
+	atime := time.Date(2024, 3, 1, 12, 0, 0, 500, time.UTC)
+	want := fileAttrs{Mode: 0640, Atime: atime}
+
+	got := parseAttrs(want.metadata())
+	if got.Mode != want.Mode || !got.Atime.Equal(want.Atime) {
+		t.Errorf("parseAttrs(metadata()) = %+v, want %+v", got, want)
+	}
+
+	for _, meta := range []map[string]string{
+		nil,
+		{metaMode: "rw-r--r--", metaAtime: "yesterday"},
+		{metaMode: "", metaAtime: "-5"},
+	} {
+		if got := parseAttrs(meta); got != (fileAttrs{}) {
+			t.Errorf("parseAttrs(%v) = %+v, want nothing recorded", meta, got)
+		}
+	}
+}
+
+func TestLocalBackendKeepsAttributes(t *testing.T) {
+	ctx := context.Background()
+	b := NewLocalBackend(filepath.Join(t.TempDir(), "bucket"))
+	if err := b.EnsureBucket(ctx); err != nil {
+		t.Fatalf("EnsureBucket() error = %v", err)
+	}
+
+	src := filepath.Join(t.TempDir(), "game.sav")
+	modTime := time.Date(2024, 3, 1, 12, 0, 0, 0, time.UTC)
+	atime := modTime.Add(time.Hour)
+	writeTestFile(t, src, "save data", modTime)
+	if err := os.Chmod(src, 0600); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chtimes(src, atime, modTime); err != nil {
+		t.Fatal(err)
+	}
+	if accessTime(mustStat(t, src)).IsZero() {
+		t.Skip("access times are not available on this platform")
+	}
+
+	if err := b.Upload(ctx, src, "game.sav"); err != nil {
+		t.Fatalf("Upload() error = %v", err)
+	}
+	dst := filepath.Join(t.TempDir(), "game.sav")
+	if err := b.Download(ctx, "game.sav", dst); err != nil {
+		t.Fatalf("Download() error = %v", err)
+	}
+
+	info := mustStat(t, dst)
+	if got := accessTime(info); !got.Equal(atime) {
+		t.Errorf("access time = %v, want %v", got, atime)
+	}
+	if runtime.GOOS != "windows" && info.Mode().Perm() != 0600 {
+		t.Errorf("mode = %v, want -rw-------", info.Mode().Perm())
+	}
+}
+
+func mustStat(t *testing.T, path string) os.FileInfo {
+	t.Helper()
+	info, err := os.Stat(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return info
+}
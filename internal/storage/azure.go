@@ -0,0 +1,262 @@
+//go:build azure
+
+package storage
+
+import (
+	"context"
+	"encoding/hex"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/storage/azblob"
+	"github.com/Azure/azure-sdk-for-go/sdk/storage/azblob/blob"
+	"github.com/Azure/azure-sdk-for-go/sdk/storage/azblob/bloberror"
+	"github.com/Azure/azure-sdk-for-go/sdk/storage/azblob/container"
+	"github.com/danielbehrens/cloudsync/internal/config"
+	"github.com/danielbehrens/cloudsync/internal/fsutil"
+	"github.com/danielbehrens/cloudsync/internal/sync"
+)
+
+// AzureBlobBackend stores objects as blobs in an Azure storage container,
+// with the same Modtime/ModtimeString/Sha256 custom metadata
+// S3Client.Upload writes
+type AzureBlobBackend struct {
+	client        *azblob.Client
+	container     *container.Client
+	containerName string
+}
+
+var _ sync.Storage = (*AzureBlobBackend)(nil)
+
+// NewAzureBlobBackend creates a backend storing objects in the container
+// named bucketName, logging in with cfg.ConnectionString if set and
+// otherwise with cfg.Account and cfg.Key
+func NewAzureBlobBackend(cfg config.AzureConfig, bucketName string) (*AzureBlobBackend, error) {
+	var client *azblob.Client
+	var err error
+	if cfg.ConnectionString != "" {
+		client, err = azblob.NewClientFromConnectionString(cfg.ConnectionString, nil)
+	} else {
+		var cred *azblob.SharedKeyCredential
+		cred, err = azblob.NewSharedKeyCredential(cfg.Account, cfg.Key)
+		if err != nil {
+			return nil, fmt.Errorf("invalid Azure account key: %w", err)
+		}
+		serviceURL := fmt.Sprintf("https://%s.blob.core.windows.net/", cfg.Account)
+		client, err = azblob.NewClientWithSharedKeyCredential(serviceURL, cred, nil)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to create Azure client: %w", err)
+	}
+
+	return &AzureBlobBackend{
+		client:        client,
+		container:     client.ServiceClient().NewContainerClient(bucketName),
+		containerName: bucketName,
+	}, nil
+}
+
+func newAzureStorage(cfg config.AzureConfig, bucketName string) (sync.Storage, error) {
+	return NewAzureBlobBackend(cfg, bucketName)
+}
+
+// EnsureBucket ensures the container exists, creating it if necessary
+func (a *AzureBlobBackend) EnsureBucket(ctx context.Context) error {
+	_, err := a.container.Create(ctx, nil)
+	if err == nil || bloberror.HasCode(err, bloberror.ContainerAlreadyExists) {
+		return nil
+	}
+
+	return fmt.Errorf("failed to create container: %w", err)
+}
+
+// Upload uploads a file with its mod time and checksum as metadata
+func (a *AzureBlobBackend) Upload(ctx context.Context, localPath, objectName string) error {
+	info, err := os.Stat(localPath)
+	if err != nil {
+		return fmt.Errorf("failed to stat file: %w", err)
+	}
+
+	modTime := info.ModTime().UTC()
+
+	checksum, err := fsutil.FileSHA256(localPath)
+	if err != nil {
+		return fmt.Errorf("failed to checksum file: %w", err)
+	}
+
+	f, err := os.Open(localPath)
+	if err != nil {
+		return fmt.Errorf("failed to open file: %w", err)
+	}
+	defer f.Close()
+
+	metadata := map[string]*string{
+		"Modtime":       toPtr(fmt.Sprintf("%d", modTime.UnixNano())),
+		"ModtimeString": toPtr(modTime.Format("2006-01-02_15-04-05.000000")),
+		"Sha256":        toPtr(checksum),
+	}
+	_, err = a.client.UploadFile(ctx, a.containerName, objectName, f, &azblob.UploadFileOptions{
+		Metadata: metadata,
+	})
+	if err != nil {
+		return fmt.Errorf("failed to upload file: %w", err)
+	}
+
+	return nil
+}
+
+// Download downloads a blob to localPath
+func (a *AzureBlobBackend) Download(ctx context.Context, objectName, localPath string) error {
+	props, err := a.container.NewBlobClient(objectName).GetProperties(ctx, nil)
+	if bloberror.HasCode(err, bloberror.BlobNotFound) {
+		return fmt.Errorf("failed to download object %s: %w", objectName, sync.ErrNotExist)
+	}
+	if err != nil {
+		return fmt.Errorf("failed to download file: %w", err)
+	}
+
+	f, err := os.Create(localPath)
+	if err != nil {
+		return fmt.Errorf("failed to create file: %w", err)
+	}
+	defer f.Close()
+
+	// The ETag condition makes the download fail rather than mix the
+	// blob's content with the metadata of an earlier version
+	_, err = a.client.DownloadFile(ctx, a.containerName, objectName, f, &azblob.DownloadFileOptions{
+		AccessConditions: &blob.AccessConditions{
+			ModifiedAccessConditions: &blob.ModifiedAccessConditions{IfMatch: props.ETag},
+		},
+	})
+	if err != nil {
+		return fmt.Errorf("failed to download file: %w", err)
+	}
+	if err := f.Close(); err != nil {
+		return fmt.Errorf("failed to download file: %w", err)
+	}
+
+	if err := verifyDownload(localPath, derefInt64(props.ContentLength), "", azureMetadata(props.Metadata, "Sha256")); err != nil {
+		return fmt.Errorf("failed to download file: %w", err)
+	}
+
+	return nil
+}
+
+// Delete removes a blob
+func (a *AzureBlobBackend) Delete(ctx context.Context, objectName string) error {
+	_, err := a.client.DeleteBlob(ctx, a.containerName, objectName, nil)
+	if bloberror.HasCode(err, bloberror.BlobNotFound) {
+		return fmt.Errorf("failed to delete object %s: %w", objectName, sync.ErrNotExist)
+	}
+	if err != nil {
+		return fmt.Errorf("failed to delete object: %w", err)
+	}
+
+	return nil
+}
+
+// Stat retrieves metadata about a blob
+func (a *AzureBlobBackend) Stat(ctx context.Context, objectName string) (*sync.SyncFileInfo, error) {
+	props, err := a.container.NewBlobClient(objectName).GetProperties(ctx, nil)
+	if bloberror.HasCode(err, bloberror.BlobNotFound) {
+		return nil, fmt.Errorf("failed to stat object %s: %w", objectName, sync.ErrNotExist)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to stat object: %w", err)
+	}
+
+	var etag string
+	if props.ETag != nil {
+		etag = string(*props.ETag)
+	}
+
+	return azureFileInfo(objectName, props.Metadata, props.LastModified, props.ContentLength, etag, props.ContentMD5), nil
+}
+
+// List returns all blobs in the container. Listing includes the metadata
+// when asked to, so no per-blob request is needed.
+func (a *AzureBlobBackend) List(ctx context.Context) ([]*sync.SyncFileInfo, error) {
+	var files []*sync.SyncFileInfo
+
+	pager := a.client.NewListBlobsFlatPager(a.containerName, &azblob.ListBlobsFlatOptions{
+		Include: azblob.ListBlobsInclude{Metadata: true},
+	})
+	for pager.More() {
+		page, err := pager.NextPage(ctx)
+		if err != nil {
+			return nil, fmt.Errorf("error listing objects: %w", err)
+		}
+		for _, item := range page.Segment.BlobItems {
+			if item.Name == nil || item.Properties == nil {
+				continue
+			}
+			p := item.Properties
+			var etag string
+			if p.ETag != nil {
+				etag = string(*p.ETag)
+			}
+			files = append(files, azureFileInfo(*item.Name, item.Metadata, p.LastModified, p.ContentLength, etag, p.ContentMD5))
+		}
+	}
+
+	return files, nil
+}
+
+// azureFileInfo converts blob properties. Azure ETags aren't content
+// hashes, so the ETag field carries the blob's MD5 instead when the
+// service computed one.
+func azureFileInfo(name string, metadata map[string]*string, lastModified *time.Time, size *int64, etag string, md5 []byte) *sync.SyncFileInfo {
+	if len(md5) > 0 {
+		etag = hex.EncodeToString(md5)
+	}
+
+	modTime, precise := extractAzureModTime(metadata, lastModified)
+	return &sync.SyncFileInfo{
+		Name:          name,
+		ModTime:       modTime,
+		Size:          derefInt64(size),
+		ETag:          etag,
+		Checksum:      azureMetadata(metadata, "Sha256"),
+		CoarseModTime: !precise,
+	}
+}
+
+// extractAzureModTime is the Azure equivalent of extractModTime: the
+// Modtime metadata if present, else the time the blob was last written
+func extractAzureModTime(metadata map[string]*string, lastModified *time.Time) (modTime time.Time, precise bool) {
+	if raw := azureMetadata(metadata, "Modtime"); raw != "" {
+		if ts, err := strconv.ParseInt(raw, 10, 64); err == nil {
+			return time.Unix(0, ts).UTC(), true
+		}
+	}
+
+	if lastModified != nil {
+		modTime = lastModified.UTC()
+	}
+	return modTime, false
+}
+
+// azureMetadata returns the metadata value named key. Azure treats
+// metadata names case-insensitively and doesn't keep the case they were
+// written with (listings return them lowercased), so they are matched
+// ignoring case.
+func azureMetadata(metadata map[string]*string, key string) string {
+	for k, v := range metadata {
+		if strings.EqualFold(k, key) && v != nil {
+			return *v
+		}
+	}
+	return ""
+}
+
+func toPtr(s string) *string { return &s }
+
+func derefInt64(n *int64) int64 {
+	if n == nil {
+		return 0
+	}
+	return *n
+}
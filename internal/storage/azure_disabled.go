@@ -0,0 +1,16 @@
+//go:build !azure
+
+package storage
+
+import (
+	"errors"
+
+	"github.com/danielbehrens/cloudsync/internal/config"
+	"github.com/danielbehrens/cloudsync/internal/sync"
+)
+
+// newAzureStorage fails in builds without the azure tag, which leave out
+// the Azure SDK
+func newAzureStorage(cfg config.AzureConfig, bucketName string) (sync.Storage, error) {
+	return nil, errors.New("this build has no Azure Blob Storage support; rebuild with -tags azure")
+}
@@ -0,0 +1,27 @@
+package storage
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/danielbehrens/cloudsync/internal/config"
+	"github.com/danielbehrens/cloudsync/internal/sync"
+)
+
+// New creates the storage backend selected by cfg.Backend
+func New(ctx context.Context, cfg config.S3Config) (sync.Storage, error) {
+	switch cfg.Backend {
+	case "", config.BackendS3:
+		client, err := NewS3Client(cfg)
+		if err != nil {
+			return nil, err
+		}
+		return NewAdapter(client), nil
+	case config.BackendAzure:
+		return newAzureStorage(cfg.Azure, cfg.BucketName)
+	case config.BackendLocal:
+		return NewLocalBackend(cfg.LocalDir), nil
+	default:
+		return nil, fmt.Errorf("unknown backend %q", cfg.Backend)
+	}
+}
@@ -0,0 +1,46 @@
+package storage
+
+import (
+	"bytes"
+	"compress/gzip"
+	"fmt"
+	"io"
+)
+
+// compressionAlgorithm is the X-Amz-Meta-Compressed value of gzipped objects
+const compressionAlgorithm = "gzip"
+
+// Metadata keys, as read back from an object's user metadata
+const (
+	metaCompressed = "Compressed"
+	metaSize       = "Size" // size of the original file when the object is transformed
+)
+
+// compress gzips data
+func compress(data []byte) ([]byte, error) {
+	var buf bytes.Buffer
+	zw := gzip.NewWriter(&buf)
+	if _, err := zw.Write(data); err != nil {
+		return nil, err
+	}
+	if err := zw.Close(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// decompressReader undoes the compression recorded in meta, if any
+func decompressReader(r io.Reader, meta map[string]string) (io.Reader, error) {
+	switch alg := meta[metaCompressed]; alg {
+	case "":
+		return r, nil
+	case compressionAlgorithm:
+		zr, err := gzip.NewReader(r)
+		if err != nil {
+			return nil, fmt.Errorf("failed to decompress: %w", err)
+		}
+		return zr, nil
+	default:
+		return nil, fmt.Errorf("unsupported compression %q", alg)
+	}
+}
@@ -0,0 +1,79 @@
+package storage
+
+import (
+	"bytes"
+	"encoding/binary"
+	"io"
+	"math/rand"
+	"testing"
+)
+
+// sampleSave builds save-like data: fixed-layout records of names, counters
+// and coordinates with a little noise, like a serialized game world
+func sampleSave(size int) []byte {
+	rng := rand.New(rand.NewSource(1))
+	names := []string{"IronOre", "OakLog", "Stone", "Copper", "Bread", "Arrow"}
+
+	var buf bytes.Buffer
+	for buf.Len() < size {
+		buf.WriteString(names[rng.Intn(len(names))])
+		binary.Write(&buf, binary.LittleEndian, int32(rng.Intn(100)))
+		binary.Write(&buf, binary.LittleEndian, float32(rng.Intn(4096)))
+		binary.Write(&buf, binary.LittleEndian, float32(rng.Intn(4096)))
+		buf.Write(make([]byte, 16))
+	}
+	return buf.Bytes()[:size]
+}
+
+func TestCompressRoundTrip(t *testing.T) {
+	data := sampleSave(1 << 20)
+
+	compressed, err := compress(data)
+	if err != nil {
+		t.Fatalf("compress() error = %v", err)
+	}
+	if len(compressed) >= len(data) {
+		t.Errorf("compressed size %d >= original %d", len(compressed), len(data))
+	}
+
+	r, err := decompressReader(bytes.NewReader(compressed), map[string]string{metaCompressed: compressionAlgorithm})
+	if err != nil {
+		t.Fatalf("decompressReader() error = %v", err)
+	}
+	got, err := io.ReadAll(r)
+	if err != nil {
+		t.Fatalf("ReadAll() error = %v", err)
+	}
+	if !bytes.Equal(got, data) {
+		t.Error("decompressed data differs from the original")
+	}
+}
+
+func TestDecompressReader(t *testing.T) {
+	plain := bytes.NewReader([]byte("save"))
+	if r, err := decompressReader(plain, map[string]string{}); err != nil || r != plain {
+		t.Errorf("decompressReader() without flag = %v, %v, want the reader unchanged", r, err)
+	}
+
+	if _, err := decompressReader(plain, map[string]string{metaCompressed: "zstd"}); err == nil {
+		t.Error("decompressReader() with unknown algorithm succeeded, want error")
+	}
+}
+
+// BenchmarkCompress reports the compression ratio (original/compressed) and
+// throughput on a 16 MiB save-like file
+func BenchmarkCompress(b *testing.B) {
+	data := sampleSave(16 << 20)
+	b.SetBytes(int64(len(data)))
+	b.ResetTimer()
+
+	var compressed []byte
+	for i := 0; i < b.N; i++ {
+		var err error
+		if compressed, err = compress(data); err != nil {
+			b.Fatalf("compress() error = %v", err)
+		}
+	}
+
+	b.ReportMetric(float64(len(data))/float64(len(compressed)), "ratio")
+}
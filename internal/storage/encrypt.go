@@ -0,0 +1,107 @@
+package storage
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/pbkdf2"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"fmt"
+)
+
+// Client-side encryption: each object is sealed with AES-256-GCM under a key
+// derived from the passphrase and a random per-object salt. The salt and
+// nonce go in the object's metadata next to the (cleartext) mod time.
+const (
+	encryptionAlgorithm = "aes-gcm"
+
+	// encryptionOverhead is how much larger an encrypted object is than
+	// its content (the GCM tag)
+	encryptionOverhead = 16
+
+	kdfIterations = 600_000
+	kdfSaltSize   = 16
+)
+
+// Metadata keys, as read back from an object's user metadata
+const (
+	metaEncrypted = "Encrypted"
+	metaNonce     = "Nonce"
+	metaSalt      = "Salt"
+)
+
+// newGCM derives the key for salt and returns the AEAD built on it
+func newGCM(passphrase string, salt []byte) (cipher.AEAD, error) {
+	key, err := pbkdf2.Key(sha256.New, passphrase, salt, kdfIterations, 32)
+	if err != nil {
+		return nil, fmt.Errorf("failed to derive key: %w", err)
+	}
+
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+// encrypt seals plaintext and returns the ciphertext with the user metadata
+// needed to open it again
+func encrypt(passphrase string, plaintext []byte) ([]byte, map[string]string, error) {
+	salt := make([]byte, kdfSaltSize)
+	if _, err := rand.Read(salt); err != nil {
+		return nil, nil, err
+	}
+
+	gcm, err := newGCM(passphrase, salt)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	nonce := make([]byte, gcm.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, nil, err
+	}
+
+	meta := map[string]string{
+		metaEncrypted: encryptionAlgorithm,
+		metaNonce:     base64.StdEncoding.EncodeToString(nonce),
+		metaSalt:      base64.StdEncoding.EncodeToString(salt),
+	}
+
+	return gcm.Seal(nil, nonce, plaintext, nil), meta, nil
+}
+
+// decrypt opens an object sealed by encrypt, given its user metadata
+func decrypt(passphrase string, ciphertext []byte, meta map[string]string) ([]byte, error) {
+	if alg := meta[metaEncrypted]; alg != encryptionAlgorithm {
+		return nil, fmt.Errorf("unsupported encryption %q", alg)
+	}
+	if passphrase == "" {
+		return nil, fmt.Errorf("object is encrypted but no encryption passphrase is set")
+	}
+
+	nonce, err := base64.StdEncoding.DecodeString(meta[metaNonce])
+	if err != nil {
+		return nil, fmt.Errorf("invalid nonce metadata: %w", err)
+	}
+	salt, err := base64.StdEncoding.DecodeString(meta[metaSalt])
+	if err != nil || len(salt) == 0 {
+		return nil, fmt.Errorf("invalid salt metadata")
+	}
+
+	gcm, err := newGCM(passphrase, salt)
+	if err != nil {
+		return nil, err
+	}
+	if len(nonce) != gcm.NonceSize() {
+		return nil, fmt.Errorf("invalid nonce metadata: %d bytes, want %d", len(nonce), gcm.NonceSize())
+	}
+
+	plaintext, err := gcm.Open(nil, nonce, ciphertext, nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to decrypt (wrong passphrase or corrupted object): %w", err)
+	}
+
+	return plaintext, nil
+}
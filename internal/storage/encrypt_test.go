@@ -0,0 +1,71 @@
+package storage
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestEncryptRoundTrip(t *testing.T) {
+	plaintext := []byte("save data")
+
+	ciphertext, meta, err := encrypt("correct horse", plaintext)
+	if err != nil {
+		t.Fatalf("encrypt() error = %v", err)
+	}
+	if len(ciphertext) != len(plaintext)+encryptionOverhead {
+		t.Errorf("len(ciphertext) = %d, want %d", len(ciphertext), len(plaintext)+encryptionOverhead)
+	}
+	if bytes.Contains(ciphertext, plaintext) {
+		t.Error("ciphertext contains the plaintext")
+	}
+	if meta[metaEncrypted] != encryptionAlgorithm {
+		t.Errorf("Encrypted metadata = %q, want %q", meta[metaEncrypted], encryptionAlgorithm)
+	}
+
+	got, err := decrypt("correct horse", ciphertext, meta)
+	if err != nil {
+		t.Fatalf("decrypt() error = %v", err)
+	}
+	if !bytes.Equal(got, plaintext) {
+		t.Errorf("decrypt() = %q, want %q", got, plaintext)
+	}
+
+	// Nonce and salt are fresh for every object
+	again, meta2, err := encrypt("correct horse", plaintext)
+	if err != nil {
+		t.Fatalf("encrypt() error = %v", err)
+	}
+	if bytes.Equal(again, ciphertext) || meta2[metaNonce] == meta[metaNonce] {
+		t.Error("encrypting twice gave the same ciphertext or nonce")
+	}
+}
+
+func TestDecryptErrors(t *testing.T) {
+	ciphertext, meta, err := encrypt("correct horse", []byte("save data"))
+	if err != nil {
+		t.Fatalf("encrypt() error = %v", err)
+	}
+
+	tampered := append([]byte{}, ciphertext...)
+	tampered[0] ^= 0xff
+
+	tests := []struct {
+		name       string
+		passphrase string
+		ciphertext []byte
+		meta       map[string]string
+	}{
+		{name: "wrong passphrase", passphrase: "battery staple", ciphertext: ciphertext, meta: meta},
+		{name: "no passphrase", passphrase: "", ciphertext: ciphertext, meta: meta},
+		{name: "tampered content", passphrase: "correct horse", ciphertext: tampered, meta: meta},
+		{name: "unknown algorithm", passphrase: "correct horse", ciphertext: ciphertext, meta: map[string]string{metaEncrypted: "rot13"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := decrypt(tt.passphrase, tt.ciphertext, tt.meta); err == nil {
+				t.Error("decrypt() succeeded, want error")
+			}
+		})
+	}
+}
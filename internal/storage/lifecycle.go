@@ -0,0 +1,82 @@
+package storage
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/minio/minio-go/v7"
+	"github.com/minio/minio-go/v7/pkg/lifecycle"
+)
+
+// retentionRuleID names the lifecycle rule cloudsync manages, telling it
+// apart from rules set up by hand, which are left alone
+const retentionRuleID = "cloudsync-version-retention"
+
+// retentionRule returns the lifecycle rule expiring the versions under
+// prefix days after a newer upload replaced them. Each object prefix has
+// its own rule, so watches sharing a bucket don't overwrite each other's.
+func retentionRule(prefix string, days int) lifecycle.Rule {
+	id := retentionRuleID
+	if prefix != "" {
+		id += "-" + strings.TrimSuffix(prefix, "/")
+	}
+	return lifecycle.Rule{
+		ID:         id,
+		Status:     "Enabled",
+		RuleFilter: lifecycle.Filter{Prefix: prefix},
+		NoncurrentVersionExpiration: lifecycle.NoncurrentVersionExpiration{
+			NoncurrentDays: lifecycle.ExpirationDays(days),
+		},
+	}
+}
+
+// withRule returns cfg with rule added, or replacing the rule of the same
+// ID, and whether that changed anything
+func withRule(cfg *lifecycle.Configuration, rule lifecycle.Rule) (*lifecycle.Configuration, bool) {
+	for i, r := range cfg.Rules {
+		if r.ID != rule.ID {
+			continue
+		}
+		if r.Status == rule.Status && r.RuleFilter.Prefix == rule.RuleFilter.Prefix &&
+			r.NoncurrentVersionExpiration.NoncurrentDays == rule.NoncurrentVersionExpiration.NoncurrentDays {
+			return cfg, false
+		}
+		cfg.Rules[i] = rule
+		return cfg, true
+	}
+	cfg.Rules = append(cfg.Rules, rule)
+	return cfg, true
+}
+
+// ensureVersionRetention adds the retention rule to the bucket's lifecycle
+// configuration unless it is already there, keeping the other rules
+func (s *S3Client) ensureVersionRetention(ctx context.Context) error {
+	if s.retentionDays <= 0 {
+		return nil
+	}
+
+	var cfg *lifecycle.Configuration
+	err := withRetry(ctx, s.retry, "get bucket lifecycle", func() (err error) {
+		cfg, err = s.client.GetBucketLifecycle(ctx, s.bucketName)
+		return err
+	})
+	if minio.ToErrorResponse(err).Code == "NoSuchLifecycleConfiguration" {
+		cfg, err = lifecycle.NewConfiguration(), nil
+	}
+	if err != nil {
+		return fmt.Errorf("failed to get the lifecycle of bucket %s: %w", s.bucketName, classifyPingError(err))
+	}
+
+	cfg, changed := withRule(cfg, retentionRule(s.prefix, s.retentionDays))
+	if !changed {
+		return nil
+	}
+	err = withRetry(ctx, s.retry, "set bucket lifecycle", func() error {
+		return s.client.SetBucketLifecycle(ctx, s.bucketName, cfg)
+	})
+	if err != nil {
+		return fmt.Errorf("failed to set the lifecycle of bucket %s: %w", s.bucketName, classifyPingError(err))
+	}
+	return nil
+}
@@ -0,0 +1,87 @@
+package storage
+
+import (
+	"context"
+	"encoding/xml"
+	"testing"
+
+	"github.com/minio/minio-go/v7/pkg/lifecycle"
+)
+
+func TestWithRule(t *testing.T) {
+	manual := lifecycle.Rule{ID: "expire-logs", Status: "Enabled", RuleFilter: lifecycle.Filter{Prefix: "logs/"}}
+
+	tests := []struct {
+		name        string
+		rules       []lifecycle.Rule
+		rule        lifecycle.Rule
+		wantChanged bool
+		wantRules   int
+	}{
+		{name: "no rules", rule: retentionRule("", 30), wantChanged: true, wantRules: 1},
+		{name: "keeps other rules", rules: []lifecycle.Rule{manual}, rule: retentionRule("", 30), wantChanged: true, wantRules: 2},
+		{name: "already present", rules: []lifecycle.Rule{manual, retentionRule("", 30)}, rule: retentionRule("", 30), wantChanged: false, wantRules: 2},
+		{name: "new day count", rules: []lifecycle.Rule{retentionRule("", 7)}, rule: retentionRule("", 30), wantChanged: true, wantRules: 1},
+		{name: "other prefix", rules: []lifecycle.Rule{retentionRule("valheim/", 30)}, rule: retentionRule("dragonwilds/", 30), wantChanged: true, wantRules: 2},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := &lifecycle.Configuration{Rules: append([]lifecycle.Rule(nil), tt.rules...)}
+			got, changed := withRule(cfg, tt.rule)
+			if changed != tt.wantChanged {
+				t.Errorf("withRule() changed = %v, want %v", changed, tt.wantChanged)
+			}
+			if len(got.Rules) != tt.wantRules {
+				t.Fatalf("withRule() has %d rules, want %d", len(got.Rules), tt.wantRules)
+			}
+			found := false
+			for _, r := range got.Rules {
+				if r.ID == tt.rule.ID {
+					found = r.NoncurrentVersionExpiration.NoncurrentDays == tt.rule.NoncurrentVersionExpiration.NoncurrentDays
+				}
+			}
+			if !found {
+				t.Errorf("withRule() rules = %+v, want %+v among them", got.Rules, tt.rule)
+			}
+		})
+	}
+}
+
+func TestEnsureVersionRetention(t *testing.T) {
+	ctx := context.Background()
+	fake := &fakeS3{}
+	client := newFakeS3Client(t, fake)
+	client.prefix = "dragonwilds/"
+
+	// Off by default
+	if err := client.ensureVersionRetention(ctx); err != nil {
+		t.Fatalf("ensureVersionRetention() error = %v", err)
+	}
+	if fake.lifecyclePuts != 0 {
+		t.Errorf("lifecycle set %d times with retention off, want 0", fake.lifecyclePuts)
+	}
+
+	client.retentionDays = 30
+	for range 2 {
+		if err := client.ensureVersionRetention(ctx); err != nil {
+			t.Fatalf("ensureVersionRetention() error = %v", err)
+		}
+	}
+	if fake.lifecyclePuts != 1 {
+		t.Errorf("lifecycle set %d times, want once", fake.lifecyclePuts)
+	}
+
+	var cfg lifecycle.Configuration
+	if err := xml.Unmarshal(fake.lifecycle, &cfg); err != nil {
+		t.Fatalf("stored lifecycle is not valid XML: %v", err)
+	}
+	if len(cfg.Rules) != 1 {
+		t.Fatalf("stored lifecycle has %d rules, want 1", len(cfg.Rules))
+	}
+	rule := cfg.Rules[0]
+	if rule.ID != "cloudsync-version-retention-dragonwilds" || rule.RuleFilter.Prefix != "dragonwilds/" ||
+		rule.NoncurrentVersionExpiration.NoncurrentDays != 30 || rule.Status != "Enabled" {
+		t.Errorf("stored rule = %+v, want noncurrent versions under dragonwilds/ expiring after 30 days", rule)
+	}
+}
@@ -0,0 +1,295 @@
+package storage
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/danielbehrens/cloudsync/internal/fsutil"
+	"github.com/danielbehrens/cloudsync/internal/sync"
+)
+
+// LocalBackend stores objects as files below a local directory, such as a
+// NAS mount. Each object's mod time and checksum go in a sidecar file next
+// to it, named after the object plus metaSuffix, since the file's own mtime
+// changes when it is copied in.
+type LocalBackend struct {
+	root string
+}
+
+var _ sync.Storage = (*LocalBackend)(nil)
+
+const (
+	// metaSuffix names the sidecar file holding an object's metadata
+	metaSuffix = ".meta"
+
+	// localTempPrefix marks partially written objects and sidecars
+	localTempPrefix = ".cloudsync-"
+)
+
+// localMeta is the sidecar content, matching the S3 user metadata
+type localMeta struct {
+	Modtime       int64  `json:"modtime"` // Unix nanoseconds
+	ModtimeString string `json:"modtime_string"`
+	Sha256        string `json:"sha256"`
+	Mode          string `json:"mode,omitempty"`  // octal permission bits
+	Atime         string `json:"atime,omitempty"` // Unix nanoseconds
+}
+
+// attrs returns the file attributes recorded in the sidecar
+func (m localMeta) attrs() fileAttrs {
+	return parseAttrs(map[string]string{metaMode: m.Mode, metaAtime: m.Atime})
+}
+
+// newLocalMeta returns the sidecar content of a file uploaded from info
+func newLocalMeta(info os.FileInfo, checksum string) localMeta {
+	modTime := info.ModTime().UTC()
+	attrs := statAttrs(info).metadata()
+	return localMeta{
+		Modtime:       modTime.UnixNano(),
+		ModtimeString: modTime.Format("2006-01-02_15-04-05.000000"),
+		Sha256:        checksum,
+		Mode:          attrs[metaMode],
+		Atime:         attrs[metaAtime],
+	}
+}
+
+// parseMeta decodes sidecar content, returning the zero localMeta if it is
+// malformed
+func parseMeta(data []byte) localMeta {
+	var meta localMeta
+	if json.Unmarshal(data, &meta) != nil {
+		return localMeta{}
+	}
+	return meta
+}
+
+// readMeta reads the sidecar of the object file at path, returning the
+// zero localMeta if there is none
+func readMeta(path string) localMeta {
+	data, err := os.ReadFile(path + metaSuffix)
+	if err != nil {
+		return localMeta{}
+	}
+	return parseMeta(data)
+}
+
+// fileInfo describes the object file info describes, with the mod time and
+// checksum from the sidecar where it has them
+func (m localMeta) fileInfo(objectName string, info os.FileInfo) *sync.SyncFileInfo {
+	result := &sync.SyncFileInfo{
+		Name:     objectName,
+		ModTime:  info.ModTime().UTC(),
+		Size:     info.Size(),
+		Checksum: m.Sha256,
+	}
+	if m.Modtime != 0 {
+		result.ModTime = time.Unix(0, m.Modtime).UTC()
+	}
+	return result
+}
+
+// checkObjectName rejects object names that would escape the storage root
+// or collide with a sidecar or temp file
+func checkObjectName(objectName string) error {
+	if strings.HasSuffix(objectName, metaSuffix) {
+		return fmt.Errorf("invalid object name %q: %s is reserved for metadata", objectName, metaSuffix)
+	}
+
+	name := filepath.FromSlash(objectName)
+	if !filepath.IsLocal(name) || strings.HasPrefix(filepath.Base(name), localTempPrefix) {
+		return fmt.Errorf("invalid object name %q", objectName)
+	}
+	return nil
+}
+
+// NewLocalBackend creates a backend storing objects below root. The
+// directory is created by EnsureBucket.
+func NewLocalBackend(root string) *LocalBackend {
+	return &LocalBackend{root: filepath.Clean(root)}
+}
+
+// path maps an object name to its file, rejecting names that would escape
+// the root or collide with a sidecar
+func (b *LocalBackend) path(objectName string) (string, error) {
+	if err := checkObjectName(objectName); err != nil {
+		return "", err
+	}
+	return filepath.Join(b.root, filepath.FromSlash(objectName)), nil
+}
+
+// EnsureBucket creates the root directory if it doesn't exist
+func (b *LocalBackend) EnsureBucket(ctx context.Context) error {
+	if err := EnsureDir(b.root); err != nil {
+		return fmt.Errorf("failed to create storage directory: %w", err)
+	}
+	return nil
+}
+
+// Upload copies a file into the backend and records its mod time and
+// checksum in the sidecar
+func (b *LocalBackend) Upload(ctx context.Context, localPath, objectName string) error {
+	dst, err := b.path(objectName)
+	if err != nil {
+		return err
+	}
+
+	info, err := os.Stat(localPath)
+	if err != nil {
+		return fmt.Errorf("failed to stat file: %w", err)
+	}
+
+	checksum, err := fsutil.FileSHA256(localPath)
+	if err != nil {
+		return fmt.Errorf("failed to checksum file: %w", err)
+	}
+
+	if err := EnsureDir(filepath.Dir(dst)); err != nil {
+		return fmt.Errorf("failed to create object directory: %w", err)
+	}
+
+	if err := writeAtomic(dst, func(tmp string) error { return fsutil.CopyFile(localPath, tmp) }); err != nil {
+		return fmt.Errorf("failed to upload file: %w", err)
+	}
+
+	meta, err := json.Marshal(newLocalMeta(info, checksum))
+	if err != nil {
+		return fmt.Errorf("failed to encode metadata: %w", err)
+	}
+
+	err = writeAtomic(dst+metaSuffix, func(tmp string) error { return os.WriteFile(tmp, meta, 0644) })
+	if err != nil {
+		return fmt.Errorf("failed to write metadata: %w", err)
+	}
+
+	return nil
+}
+
+// Download copies an object to localPath and checks the copy against the
+// object's size and recorded checksum
+func (b *LocalBackend) Download(ctx context.Context, objectName, localPath string) error {
+	info, err := b.Stat(ctx, objectName)
+	if err != nil {
+		return fmt.Errorf("failed to download file: %w", err)
+	}
+	src, err := b.path(objectName)
+	if err != nil {
+		return err
+	}
+
+	if err := fsutil.CopyFile(src, localPath); err != nil {
+		return fmt.Errorf("failed to download file: %w", err)
+	}
+
+	if err := verifyDownload(localPath, info.Size, "", info.Checksum); err != nil {
+		return fmt.Errorf("failed to download file: %w", err)
+	}
+
+	if err := readMeta(src).attrs().apply(localPath); err != nil {
+		return fmt.Errorf("failed to download file: %w", err)
+	}
+
+	return nil
+}
+
+// Delete removes an object and its sidecar
+func (b *LocalBackend) Delete(ctx context.Context, objectName string) error {
+	path, err := b.path(objectName)
+	if err != nil {
+		return err
+	}
+
+	if err := os.Remove(path); os.IsNotExist(err) {
+		return fmt.Errorf("failed to delete object %s: %w", objectName, sync.ErrNotExist)
+	} else if err != nil {
+		return fmt.Errorf("failed to delete object: %w", err)
+	}
+	if err := os.Remove(path + metaSuffix); err != nil && !os.IsNotExist(err) {
+		return fmt.Errorf("failed to delete metadata: %w", err)
+	}
+
+	return nil
+}
+
+// Stat retrieves an object's metadata. Without a readable sidecar the
+// file's own mtime is used and the checksum is unknown.
+func (b *LocalBackend) Stat(ctx context.Context, objectName string) (*sync.SyncFileInfo, error) {
+	path, err := b.path(objectName)
+	if err != nil {
+		return nil, err
+	}
+
+	info, err := os.Stat(path)
+	if os.IsNotExist(err) {
+		return nil, fmt.Errorf("failed to stat object %s: %w", objectName, sync.ErrNotExist)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to stat object: %w", err)
+	}
+	if !info.Mode().IsRegular() {
+		return nil, fmt.Errorf("failed to stat object: %s is not a file", objectName)
+	}
+
+	return readMeta(path).fileInfo(objectName, info), nil
+}
+
+// List returns every object below the root
+func (b *LocalBackend) List(ctx context.Context) ([]*sync.SyncFileInfo, error) {
+	var files []*sync.SyncFileInfo
+
+	err := filepath.WalkDir(b.root, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		if d.IsDir() || strings.HasSuffix(d.Name(), metaSuffix) || strings.HasPrefix(d.Name(), localTempPrefix) {
+			return nil
+		}
+
+		rel, err := filepath.Rel(b.root, path)
+		if err != nil {
+			return err
+		}
+
+		info, err := b.Stat(ctx, filepath.ToSlash(rel))
+		if err != nil {
+			return err
+		}
+		files = append(files, info)
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("error listing objects: %w", err)
+	}
+
+	return files, nil
+}
+
+// writeAtomic lets write fill a temp file next to dst, then renames it over
+// dst so readers never see a partial file
+func writeAtomic(dst string, write func(tmp string) error) error {
+	f, err := os.CreateTemp(filepath.Dir(dst), localTempPrefix+"*")
+	if err != nil {
+		return err
+	}
+	tmp := f.Name()
+	f.Close()
+
+	if err := write(tmp); err != nil {
+		os.Remove(tmp)
+		return err
+	}
+	if err := os.Rename(tmp, dst); err != nil {
+		os.Remove(tmp)
+		return err
+	}
+
+	return nil
+}
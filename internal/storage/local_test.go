@@ -0,0 +1,201 @@
+package storage
+
+import (
+	"context"
+	"crypto/md5"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/danielbehrens/cloudsync/internal/sync"
+)
+
+func writeTestFile(t *testing.T, path, content string, modTime time.Time) {
+	t.Helper()
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		t.Fatalf("MkdirAll() error = %v", err)
+	}
+	if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+		t.Fatalf("WriteFile() error = %v", err)
+	}
+	if err := os.Chtimes(path, modTime, modTime); err != nil {
+		t.Fatalf("Chtimes() error = %v", err)
+	}
+}
+
+func TestLocalBackendRoundTrip(t *testing.T) {
+	ctx := context.Background()
+	b := NewLocalBackend(filepath.Join(t.TempDir(), "bucket"))
+	if err := b.EnsureBucket(ctx); err != nil {
+		t.Fatalf("EnsureBucket() error = %v", err)
+	}
+
+	src := filepath.Join(t.TempDir(), "game.sav")
+	modTime := time.Date(2024, 3, 1, 12, 30, 0, 123456789, time.UTC)
+	writeTestFile(t, src, "save data", modTime)
+
+	for _, name := range []string{"game.sav", "Profile1/game.sav"} {
+		if err := b.Upload(ctx, src, name); err != nil {
+			t.Fatalf("Upload(%s) error = %v", name, err)
+		}
+	}
+
+	// The copy gets a fresh mtime, but Stat reports the sidecar's
+	info, err := b.Stat(ctx, "Profile1/game.sav")
+	if err != nil {
+		t.Fatalf("Stat() error = %v", err)
+	}
+	if !info.ModTime.Equal(modTime) {
+		t.Errorf("ModTime = %v, want %v", info.ModTime, modTime)
+	}
+	if info.Size != int64(len("save data")) || info.Checksum == "" {
+		t.Errorf("Stat() = %+v, want size %d and a checksum", info, len("save data"))
+	}
+
+	files, err := b.List(ctx)
+	if err != nil {
+		t.Fatalf("List() error = %v", err)
+	}
+	var names []string
+	for _, f := range files {
+		names = append(names, f.Name)
+	}
+	if len(names) != 2 || names[0] != "Profile1/game.sav" || names[1] != "game.sav" {
+		t.Errorf("List() names = %v, want [Profile1/game.sav game.sav]", names)
+	}
+
+	dst := filepath.Join(t.TempDir(), "download.sav")
+	if err := b.Download(ctx, "game.sav", dst); err != nil {
+		t.Fatalf("Download() error = %v", err)
+	}
+	if got, _ := os.ReadFile(dst); string(got) != "save data" {
+		t.Errorf("downloaded content = %q, want %q", got, "save data")
+	}
+
+	if err := b.Delete(ctx, "game.sav"); err != nil {
+		t.Fatalf("Delete() error = %v", err)
+	}
+	if _, err := b.Stat(ctx, "game.sav"); err == nil {
+		t.Error("Stat() after Delete() succeeded")
+	}
+	if _, err := os.Stat(filepath.Join(b.root, "game.sav"+metaSuffix)); !os.IsNotExist(err) {
+		t.Error("sidecar survived Delete()")
+	}
+	if err := b.Delete(ctx, "game.sav"); !errors.Is(err, sync.ErrNotExist) {
+		t.Errorf("second Delete() error = %v, want %v", err, sync.ErrNotExist)
+	}
+}
+
+func TestLocalBackendRejectsBadNames(t *testing.T) {
+	ctx := context.Background()
+	b := NewLocalBackend(t.TempDir())
+
+	src := filepath.Join(t.TempDir(), "game.sav")
+	writeTestFile(t, src, "save", time.Now())
+
+	for _, name := range []string{"../escape.sav", "/abs.sav", "game.sav.meta", ".cloudsync-123", ""} {
+		if err := b.Upload(ctx, src, name); err == nil {
+			t.Errorf("Upload(%q) succeeded, want error", name)
+		}
+	}
+}
+
+func TestLocalBackendSyncsTwoFolders(t *testing.T) {
+	ctx := context.Background()
+	b := NewLocalBackend(filepath.Join(t.TempDir(), "nas"))
+
+	dirA, dirB := t.TempDir(), t.TempDir()
+	modTime := time.Now().Add(-time.Hour).Truncate(time.Second)
+	writeTestFile(t, filepath.Join(dirA, "game.sav"), "from A", modTime)
+
+	a := sync.NewSyncer(b, dirA, filepath.Join(t.TempDir(), "backupA"), nil, 500*time.Millisecond)
+	if _, err := a.InitialSync(ctx); err != nil {
+		t.Fatalf("InitialSync(A) error = %v", err)
+	}
+
+	syncB := sync.NewSyncer(b, dirB, filepath.Join(t.TempDir(), "backupB"), nil, 500*time.Millisecond)
+	if _, err := syncB.InitialSync(ctx); err != nil {
+		t.Fatalf("InitialSync(B) error = %v", err)
+	}
+
+	path := filepath.Join(dirB, "game.sav")
+	got, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatalf("ReadFile() error = %v", err)
+	}
+	if string(got) != "from A" {
+		t.Errorf("content = %q, want %q", got, "from A")
+	}
+
+	// The mod time travels through the sidecar
+	info, err := os.Stat(path)
+	if err != nil {
+		t.Fatalf("Stat() error = %v", err)
+	}
+	if !info.ModTime().Equal(modTime) {
+		t.Errorf("mod time = %v, want %v", info.ModTime(), modTime)
+	}
+}
+
+func TestLocalBackendDownloadVerifies(t *testing.T) {
+	ctx := context.Background()
+	root := filepath.Join(t.TempDir(), "bucket")
+	b := NewLocalBackend(root)
+
+	src := filepath.Join(t.TempDir(), "game.sav")
+	writeTestFile(t, src, "save data", time.Now())
+	if err := b.Upload(ctx, src, "game.sav"); err != nil {
+		t.Fatalf("Upload() error = %v", err)
+	}
+
+	// Same length, different content: only the checksum catches it
+	if err := os.WriteFile(filepath.Join(root, "game.sav"), []byte("SAVE DATA"), 0644); err != nil {
+		t.Fatalf("WriteFile() error = %v", err)
+	}
+
+	dst := filepath.Join(t.TempDir(), "game.sav")
+	if err := b.Download(ctx, "game.sav", dst); !errors.Is(err, ErrDownloadCorrupt) {
+		t.Errorf("Download() error = %v, want %v", err, ErrDownloadCorrupt)
+	}
+}
+
+func TestVerifyDownload(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "game.sav")
+	writeTestFile(t, path, "save data", time.Now())
+
+	md5sum := md5.Sum([]byte("save data"))
+	sha := sha256.Sum256([]byte("save data"))
+	realMD5, realSHA := hex.EncodeToString(md5sum[:]), hex.EncodeToString(sha[:])
+	wrong := strings.Repeat("0", 32)
+
+	tests := []struct {
+		name     string
+		size     int64
+		etag     string
+		checksum string
+		wantErr  bool
+	}{
+		{name: "nothing to check", size: -1},
+		{name: "all match", size: 9, etag: `"` + realMD5 + `"`, checksum: realSHA},
+		{name: "short", size: 10, wantErr: true},
+		{name: "wrong checksum", size: 9, checksum: wrong + wrong, wantErr: true},
+		{name: "wrong etag", size: 9, etag: wrong, wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := verifyDownload(path, tt.size, tt.etag, tt.checksum)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("verifyDownload() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if err != nil && !errors.Is(err, ErrDownloadCorrupt) {
+				t.Errorf("verifyDownload() error = %v, want %v", err, ErrDownloadCorrupt)
+			}
+		})
+	}
+}
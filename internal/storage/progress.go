@@ -0,0 +1,69 @@
+package storage
+
+import "io"
+
+// Transfer directions reported in Progress
+const (
+	DirectionUpload   = "upload"
+	DirectionDownload = "download"
+)
+
+// Progress is a snapshot of one object transfer
+type Progress struct {
+	Object      string
+	Direction   string // DirectionUpload or DirectionDownload
+	Transferred int64  // bytes so far
+	Total       int64  // bytes in all, as stored (compressed or encrypted)
+}
+
+// ProgressFunc is called as a transfer proceeds. Calls for one transfer are
+// sequential, but concurrent transfers call it concurrently. A transfer
+// that is retried starts over from zero.
+type ProgressFunc func(Progress)
+
+// progressReader reports the bytes read through it
+type progressReader struct {
+	r        io.Reader
+	progress Progress
+	report   ProgressFunc
+}
+
+// newProgressReader wraps r, reporting each read to report, or returns r
+// itself when report is nil
+func newProgressReader(r io.Reader, object, direction string, total int64, report ProgressFunc) io.Reader {
+	if report == nil {
+		return r
+	}
+	return &progressReader{
+		r:        r,
+		progress: Progress{Object: object, Direction: direction, Total: total},
+		report:   report,
+	}
+}
+
+func (p *progressReader) Read(b []byte) (int, error) {
+	n, err := p.r.Read(b)
+	if n > 0 {
+		p.progress.Transferred += int64(n)
+		p.report(p.progress)
+	}
+	return n, err
+}
+
+// uploadProgress returns a reader for minio's PutObjectOptions.Progress,
+// which is read from by as many bytes as have been sent, or nil when
+// report is nil
+func uploadProgress(object string, total int64, report ProgressFunc) io.Reader {
+	if report == nil {
+		return nil
+	}
+	return newProgressReader(zeroReader{}, object, DirectionUpload, total, report)
+}
+
+// zeroReader reads endless zeros
+type zeroReader struct{}
+
+func (zeroReader) Read(b []byte) (int, error) {
+	clear(b)
+	return len(b), nil
+}
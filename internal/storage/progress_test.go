@@ -0,0 +1,56 @@
+package storage
+
+import (
+	"io"
+	"strings"
+	"testing"
+)
+
+func TestProgressReader(t *testing.T) {
+	var got []Progress
+	r := newProgressReader(strings.NewReader("hello world"), "save.dat", DirectionDownload, 11, func(p Progress) {
+		got = append(got, p)
+	})
+
+	data, err := io.ReadAll(io.LimitReader(r, 11))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(data) != "hello world" {
+		t.Fatalf("read %q, want %q", data, "hello world")
+	}
+	if len(got) == 0 {
+		t.Fatal("no progress reported")
+	}
+	last := got[len(got)-1]
+	want := Progress{Object: "save.dat", Direction: DirectionDownload, Transferred: 11, Total: 11}
+	if last != want {
+		t.Errorf("last progress = %+v, want %+v", last, want)
+	}
+}
+
+func TestProgressReaderNil(t *testing.T) {
+	src := strings.NewReader("x")
+	if r := newProgressReader(src, "save.dat", DirectionDownload, 1, nil); r != io.Reader(src) {
+		t.Error("nil report should return the reader unwrapped")
+	}
+	if r := uploadProgress("save.dat", 1, nil); r != nil {
+		t.Error("nil report should give no upload progress reader")
+	}
+}
+
+func TestUploadProgress(t *testing.T) {
+	var got Progress
+	r := uploadProgress("save.dat", 10, func(p Progress) { got = p })
+
+	// minio reads as many bytes as it has sent
+	for _, n := range []int{3, 7} {
+		if _, err := r.Read(make([]byte, n)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	want := Progress{Object: "save.dat", Direction: DirectionUpload, Transferred: 10, Total: 10}
+	if got != want {
+		t.Errorf("progress = %+v, want %+v", got, want)
+	}
+}
@@ -0,0 +1,77 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net"
+	"net/http"
+	"syscall"
+	"time"
+
+	"github.com/danielbehrens/cloudsync/internal/config"
+	"github.com/minio/minio-go/v7"
+)
+
+// withRetry calls fn until it succeeds, fails with a permanent error or runs
+// out of attempts, backing off exponentially between tries. The returned
+// error wraps the last one fn returned.
+func withRetry(ctx context.Context, cfg config.RetryConfig, op string, fn func() error) error {
+	delay := cfg.InitialBackoff
+	var err error
+
+	for attempt := 1; ; attempt++ {
+		if err = fn(); err == nil || !isRetryable(err) {
+			return err
+		}
+
+		if attempt >= cfg.MaxAttempts {
+			return fmt.Errorf("%s failed after %d attempts: %w", op, attempt, err)
+		}
+
+		slog.Warn("Storage request failed, retrying", "op", op, "attempt", attempt, "attempts", cfg.MaxAttempts, "delay", delay, "err", err)
+		select {
+		case <-ctx.Done():
+			return fmt.Errorf("%s interrupted after %d attempts: %w", op, attempt, err)
+		case <-time.After(delay):
+		}
+
+		delay *= 2
+		if delay > cfg.MaxBackoff {
+			delay = cfg.MaxBackoff
+		}
+	}
+}
+
+// isRetryable reports whether err is likely transient: a timeout, a reset or
+// refused connection, a corrupt download, or a 5xx (but 501), 408 or 429
+// response.
+// Other responses, such as 403 or 404, won't change on retry.
+func isRetryable(err error) bool {
+	if errors.Is(err, context.Canceled) || errors.Is(err, context.DeadlineExceeded) {
+		return false
+	}
+
+	if resp := minio.ToErrorResponse(err); resp.StatusCode != 0 {
+		// e.g. a server without KMS asked for SSE-KMS
+		if resp.Code == "NotImplemented" {
+			return false
+		}
+		return resp.StatusCode >= 500 ||
+			resp.StatusCode == http.StatusRequestTimeout ||
+			resp.StatusCode == http.StatusTooManyRequests
+	}
+
+	var netErr net.Error
+	if errors.As(err, &netErr) && netErr.Timeout() {
+		return true
+	}
+
+	return errors.Is(err, ErrDownloadCorrupt) ||
+		errors.Is(err, syscall.ECONNRESET) ||
+		errors.Is(err, syscall.ECONNREFUSED) ||
+		errors.Is(err, syscall.EPIPE) ||
+		errors.Is(err, io.ErrUnexpectedEOF)
+}
@@ -1,62 +1,238 @@
 package storage
 
 import (
+	"bytes"
 	"context"
+	"errors"
 	"fmt"
 	"io"
+	"mime"
+	"net"
+	"net/http"
+	"net/url"
 	"os"
+	"path"
 	"strconv"
+	"strings"
+	"sync"
 	"time"
 
+	"github.com/danielbehrens/cloudsync/internal/config"
+	"github.com/danielbehrens/cloudsync/internal/fsutil"
 	"github.com/minio/minio-go/v7"
 	"github.com/minio/minio-go/v7/pkg/credentials"
+	sse "github.com/minio/minio-go/v7/pkg/encrypt"
 )
 
 // S3Client wraps MinIO client for S3 operations
 type S3Client struct {
-	client     *minio.Client
-	bucketName string
+	client              *minio.Client
+	bucketName          string
+	prefix              string // of every object name, "" or ending in "/"
+	suffix              string // of every object name with -per-machine
+	listStatConcurrency int
+	partSize            uint64 // of multipart uploads, in bytes
+	uploadThreads       uint
+	retry               config.RetryConfig
+	region              string            // empty to look it up from the bucket
+	passphrase          string            // encrypts uploads when set
+	compress            bool              // gzips uploads
+	serverSide          sse.ServerSide    // asked of the server for uploads, nil for none
+	metadata            map[string]string // extra user metadata for uploads
+	retentionDays       int               // of replaced versions; 0 leaves the lifecycle alone
+	progress            ProgressFunc
+	httpClient          *http.Client // for requests minio doesn't make
 }
 
 // FileInfo represents metadata about a file in storage
 type FileInfo struct {
-	Name        string
-	ModTime     time.Time
-	Size        int64
-	ETag        string
+	Name     string
+	ModTime  time.Time
+	Size     int64
+	ETag     string
+	Checksum string // hex SHA-256 from upload metadata, empty if unknown
+
+	// CoarseModTime is set when ModTime is the server's LastModified,
+	// which has only second resolution, because the object lacks the
+	// Modtime metadata
+	CoarseModTime bool
 }
 
 // NewS3Client creates a new S3 client
-func NewS3Client(endpoint, accessKey, secretKey, bucketName string, useSSL bool) (*S3Client, error) {
-	client, err := minio.New(endpoint, &minio.Options{
-		Creds:  credentials.NewStaticV4(accessKey, secretKey, ""),
-		Secure: useSSL,
-	})
+func NewS3Client(cfg config.S3Config) (*S3Client, error) {
+	opts := &minio.Options{
+		Creds:  s3Credentials(cfg),
+		Secure: cfg.UseSSL,
+		Region: cfg.Region,
+	}
+	transport, err := newTransport(cfg)
+	if err != nil {
+		return nil, err
+	}
+	httpClient := &http.Client{Timeout: clockSkewTimeout}
+	if transport != nil {
+		opts.Transport = transport
+		httpClient.Transport = transport
+	}
+
+	client, err := minio.New(cfg.Endpoint, opts)
 	if err != nil {
 		return nil, fmt.Errorf("failed to create MinIO client: %w", err)
 	}
 
+	serverSide, err := serverSide(cfg)
+	if err != nil {
+		return nil, fmt.Errorf("failed to set up server-side encryption: %w", err)
+	}
+
+	concurrency := cfg.ListStatConcurrency
+	if concurrency < 1 {
+		concurrency = config.DefaultListStatConcurrency
+	}
+
+	partSize := cfg.PartSizeMiB
+	if partSize < config.MinPartSizeMiB {
+		partSize = config.DefaultPartSizeMiB
+	}
+	threads := cfg.UploadThreads
+	if threads < 1 {
+		threads = config.DefaultUploadThreads
+	}
+
+	retry := cfg.Retry
+	if retry.MaxAttempts < 1 {
+		retry = config.RetryConfig{
+			MaxAttempts:    config.DefaultRetryAttempts,
+			InitialBackoff: config.DefaultRetryBackoff,
+			MaxBackoff:     config.DefaultRetryMaxBackoff,
+		}
+	}
+
 	return &S3Client{
-		client:     client,
-		bucketName: bucketName,
+		client:              client,
+		bucketName:          cfg.BucketName,
+		prefix:              cfg.ObjectPrefix,
+		suffix:              machineSuffix(cfg),
+		listStatConcurrency: concurrency,
+		partSize:            uint64(partSize) << 20,
+		uploadThreads:       uint(threads),
+		retry:               retry,
+		region:              cfg.Region,
+		passphrase:          cfg.EncryptionPassphrase,
+		compress:            cfg.Compress,
+		serverSide:          serverSide,
+		metadata:            cfg.Metadata,
+		retentionDays:       cfg.VersionRetentionDays,
+		httpClient:          httpClient,
 	}, nil
 }
 
-// EnsureBucket ensures the bucket exists, creating it if necessary
-func (s *S3Client) EnsureBucket(ctx context.Context) error {
-	exists, err := s.client.BucketExists(ctx, s.bucketName)
-	if err != nil {
-		return fmt.Errorf("failed to check bucket existence: %w", err)
+// s3Credentials returns the credentials selected by cfg.Credentials
+func s3Credentials(cfg config.S3Config) *credentials.Credentials {
+	if cfg.Credentials != config.CredentialsChain {
+		return credentials.NewStaticV4(cfg.AccessKey, cfg.SecretKey, cfg.SessionToken)
 	}
 
+	// The chain takes the first provider that has keys, and refreshes
+	// expiring ones such as IAM role credentials
+	return credentials.NewChainCredentials([]credentials.Provider{
+		&credentials.Static{Value: credentials.Value{
+			AccessKeyID:     cfg.AccessKey,
+			SecretAccessKey: cfg.SecretKey,
+			SessionToken:    cfg.SessionToken,
+			SignerType:      credentials.SignatureV4,
+		}},
+		&credentials.EnvAWS{},
+		&credentials.FileAWSCredentials{},
+		&credentials.IAM{},
+	})
+}
+
+// SetProgress makes uploads and downloads report their progress to fn;
+// nil turns reporting off. Call it before any transfer starts.
+func (s *S3Client) SetProgress(fn ProgressFunc) {
+	s.progress = fn
+}
+
+// ClockSkew returns how far the storage server's clock is ahead of the
+// local one, to the nearest second or so
+func (s *S3Client) ClockSkew(ctx context.Context) (time.Duration, error) {
+	return serverClockSkew(ctx, s.httpClient, s.client.EndpointURL().String())
+}
+
+// Errors returned by Ping, wrapped with the endpoint and bucket
+var (
+	ErrUnreachable    = errors.New("endpoint unreachable")
+	ErrAccessDenied   = errors.New("credentials rejected or lacking permission")
+	ErrWrongRegion    = errors.New("bucket is in a different region")
+	ErrBucketNotFound = errors.New("bucket does not exist")
+)
+
+// Ping checks with a single bucket lookup that the endpoint answers, the
+// credentials are accepted and the bucket exists, so a misconfiguration
+// surfaces at startup instead of as a failed transfer. Its errors wrap
+// ErrUnreachable, ErrAccessDenied, ErrWrongRegion or ErrBucketNotFound
+// when the cause is one of those.
+func (s *S3Client) Ping(ctx context.Context) error {
+	var exists bool
+	err := withRetry(ctx, s.retry, "check bucket", func() (err error) {
+		exists, err = s.client.BucketExists(ctx, s.bucketName)
+		return err
+	})
+	if err != nil {
+		return fmt.Errorf("bucket %s at %s: %w", s.bucketName, s.client.EndpointURL().Host, classifyPingError(err))
+	}
 	if !exists {
-		err = s.client.MakeBucket(ctx, s.bucketName, minio.MakeBucketOptions{})
+		return fmt.Errorf("bucket %s at %s: %w", s.bucketName, s.client.EndpointURL().Host, ErrBucketNotFound)
+	}
+	return nil
+}
+
+// classifyPingError wraps err with the Ping error naming its likely cause
+func classifyPingError(err error) error {
+	if errors.Is(err, context.Canceled) || errors.Is(err, context.DeadlineExceeded) {
+		return err
+	}
+
+	resp := minio.ToErrorResponse(err)
+	switch {
+	case resp.Code == "AuthorizationHeaderMalformed" || resp.Code == "PermanentRedirect" ||
+		resp.StatusCode == http.StatusMovedPermanently:
+		if resp.Region != "" {
+			return fmt.Errorf("%w (it is in %s): %v", ErrWrongRegion, resp.Region, err)
+		}
+		return fmt.Errorf("%w: %v", ErrWrongRegion, err)
+	case resp.Code == "InvalidAccessKeyId" || resp.Code == "SignatureDoesNotMatch" ||
+		resp.Code == "AccessDenied" || resp.StatusCode == http.StatusForbidden:
+		return fmt.Errorf("%w: %v", ErrAccessDenied, err)
+	case resp.StatusCode != 0:
+		return err
+	}
+
+	var netErr net.Error
+	if errors.As(err, &netErr) {
+		return fmt.Errorf("%w: %v", ErrUnreachable, err)
+	}
+	return err
+}
+
+// EnsureBucket checks the storage with Ping, creating the bucket if it is
+// the only thing missing, then adds the version retention rule if one is
+// configured
+func (s *S3Client) EnsureBucket(ctx context.Context) error {
+	err := s.Ping(ctx)
+	if errors.Is(err, ErrBucketNotFound) {
+		err = withRetry(ctx, s.retry, "create bucket", func() error {
+			return s.client.MakeBucket(ctx, s.bucketName, minio.MakeBucketOptions{Region: s.region})
+		})
 		if err != nil {
-			return fmt.Errorf("failed to create bucket: %w", err)
+			return fmt.Errorf("failed to create bucket %s at %s: %w", s.bucketName, s.client.EndpointURL().Host, classifyPingError(err))
 		}
+	} else if err != nil {
+		return err
 	}
 
-	return nil
+	return s.ensureVersionRetention(ctx)
 }
 
 // Upload uploads a file to S3 with metadata
@@ -69,25 +245,129 @@ func (s *S3Client) Upload(ctx context.Context, localPath, objectName string) err
 
 	modTime := info.ModTime().UTC()
 
-	// Store full Unix nanoseconds timestamp in metadata
-	userMeta := map[string]string{
-		"X-Amz-Meta-Modtime":       fmt.Sprintf("%d", modTime.UnixNano()),
-		"X-Amz-Meta-ModtimeString": modTime.Format("2006-01-02_15-04-05.000000"),
+	checksum, err := fsutil.FileSHA256(localPath)
+	if err != nil {
+		return fmt.Errorf("failed to checksum file: %w", err)
+	}
+
+	// Store full Unix nanoseconds timestamp and content hash in metadata,
+	// with the permissions and access time to restore on download. They
+	// stay in the clear when the content is encrypted, so sync can still
+	// compare them. The keys are bare: minio-go adds the X-Amz-Meta- prefix
+	// when sending them, and StatObject reports them bare again.
+	userMeta := uploadMetadata(modTime, checksum)
+	for k, v := range statAttrs(info).metadata() {
+		userMeta[k] = v
+	}
+	for k, v := range s.metadata {
+		userMeta[k] = v
+	}
+
+	if s.compress || s.passphrase != "" {
+		return s.uploadTransformed(ctx, localPath, objectName, userMeta)
 	}
 
-	_, err = s.client.FPutObject(ctx, s.bucketName, objectName, localPath, minio.PutObjectOptions{
-		UserMetadata: userMeta,
+	var uploaded minio.UploadInfo
+	err = withRetry(ctx, s.retry, "upload "+objectName, func() (err error) {
+		uploaded, err = s.client.FPutObject(ctx, s.bucketName, s.key(objectName), localPath, minio.PutObjectOptions{
+			UserMetadata:         userMeta,
+			ContentType:          contentType(objectName),
+			Progress:             uploadProgress(objectName, info.Size(), s.progress),
+			ServerSideEncryption: s.serverSide,
+			PartSize:             s.partSize,
+			NumThreads:           s.uploadThreads,
+		})
+		return err
 	})
 	if err != nil {
-		return fmt.Errorf("failed to upload file: %w", err)
+		return fmt.Errorf("failed to upload file: %w", s.sseUploadError(err))
+	}
+
+	if uploaded.Size != info.Size() {
+		return fmt.Errorf("upload size mismatch: sent %d bytes, server stored %d", info.Size(), uploaded.Size)
 	}
 
 	return nil
 }
 
-// Download downloads a file from S3
+// uploadMetadata returns the user metadata recording a file's mod time and
+// checksum, under the bare keys extractModTime and objectFileInfo read
+func uploadMetadata(modTime time.Time, checksum string) map[string]string {
+	return map[string]string{
+		"Modtime":       fmt.Sprintf("%d", modTime.UnixNano()),
+		"ModtimeString": modTime.Format("2006-01-02_15-04-05.000000"),
+		"Sha256":        checksum,
+	}
+}
+
+// contentType returns the MIME type of an object by its extension, so
+// files browsed in the S3 console open correctly
+func contentType(objectName string) string {
+	if t := mime.TypeByExtension(path.Ext(objectName)); t != "" {
+		return t
+	}
+	return "application/octet-stream"
+}
+
+// uploadTransformed compresses and/or encrypts the file in memory (saves are
+// at most tens of megabytes) and uploads the result, recording each step
+// and the original size in userMeta
+func (s *S3Client) uploadTransformed(ctx context.Context, localPath, objectName string, userMeta map[string]string) error {
+	data, err := os.ReadFile(localPath)
+	if err != nil {
+		return fmt.Errorf("failed to read file: %w", err)
+	}
+	userMeta[metaSize] = strconv.Itoa(len(data))
+
+	// Compress first: ciphertext doesn't compress
+	if s.compress {
+		if data, err = compress(data); err != nil {
+			return fmt.Errorf("failed to compress file: %w", err)
+		}
+		userMeta[metaCompressed] = compressionAlgorithm
+	}
+
+	if s.passphrase != "" {
+		var encMeta map[string]string
+		if data, encMeta, err = encrypt(s.passphrase, data); err != nil {
+			return fmt.Errorf("failed to encrypt file: %w", err)
+		}
+		for k, v := range encMeta {
+			userMeta[k] = v
+		}
+	}
+
+	size := int64(len(data))
+	var uploaded minio.UploadInfo
+	err = withRetry(ctx, s.retry, "upload "+objectName, func() (err error) {
+		uploaded, err = s.client.PutObject(ctx, s.bucketName, s.key(objectName), bytes.NewReader(data), size, minio.PutObjectOptions{
+			UserMetadata: userMeta,
+			// The content is no longer the file's, whatever its extension
+			ContentType:          "application/octet-stream",
+			Progress:             uploadProgress(objectName, size, s.progress),
+			ServerSideEncryption: s.serverSide,
+			PartSize:             s.partSize,
+			NumThreads:           s.uploadThreads,
+		})
+		return err
+	})
+	if err != nil {
+		return fmt.Errorf("failed to upload file: %w", s.sseUploadError(err))
+	}
+
+	if uploaded.Size != size {
+		return fmt.Errorf("upload size mismatch: sent %d bytes, server stored %d", size, uploaded.Size)
+	}
+
+	return nil
+}
+
+// Download downloads a file from S3, decrypting and decompressing it if it
+// was uploaded that way
 func (s *S3Client) Download(ctx context.Context, objectName, localPath string) error {
-	err := s.client.FGetObject(ctx, s.bucketName, objectName, localPath, minio.GetObjectOptions{})
+	err := withRetry(ctx, s.retry, "download "+objectName, func() error {
+		return s.download(ctx, objectName, "", localPath)
+	})
 	if err != nil {
 		return fmt.Errorf("failed to download file: %w", err)
 	}
@@ -95,88 +375,383 @@ func (s *S3Client) Download(ctx context.Context, objectName, localPath string) e
 	return nil
 }
 
+// download fetches versionID of objectName, or the latest version if it is
+// empty
+func (s *S3Client) download(ctx context.Context, objectName, versionID, localPath string) error {
+	obj, err := s.client.GetObject(ctx, s.bucketName, s.key(objectName), minio.GetObjectOptions{VersionID: versionID})
+	if err != nil {
+		return err
+	}
+	defer obj.Close()
+
+	stat, err := obj.Stat()
+	if err != nil {
+		return err
+	}
+	if err := s.checkSSE(objectName, stat); err != nil {
+		return err
+	}
+
+	var content io.Reader = newProgressReader(obj, objectName, DirectionDownload, stat.Size, s.progress)
+	if stat.UserMetadata[metaEncrypted] != "" {
+		ciphertext, err := io.ReadAll(content)
+		if err != nil {
+			return err
+		}
+		plaintext, err := decrypt(s.passphrase, ciphertext, stat.UserMetadata)
+		if err != nil {
+			return err
+		}
+		content = bytes.NewReader(plaintext)
+	}
+
+	if content, err = decompressReader(content, stat.UserMetadata); err != nil {
+		return err
+	}
+
+	f, err := os.Create(localPath)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	if _, err := io.Copy(f, content); err != nil {
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+
+	// The ETag is the MD5 of the stored bytes, which differ from the file
+	// when they were compressed or encrypted. With SSE-KMS it isn't an MD5
+	// at all.
+	info := objectFileInfo(stat)
+	etag := stat.ETag
+	if stat.UserMetadata[metaEncrypted] != "" || stat.UserMetadata[metaCompressed] != "" || !isMD5ETag(etag) ||
+		stat.Metadata.Get(sse.SseGenericHeader) == sseHeader(sse.KMS) {
+		etag = ""
+	}
+	if err := verifyDownload(localPath, info.Size, etag, info.Checksum); err != nil {
+		return err
+	}
+	return parseAttrs(stat.UserMetadata).apply(localPath)
+}
+
+// Delete removes an object from S3
+func (s *S3Client) Delete(ctx context.Context, objectName string) error {
+	err := withRetry(ctx, s.retry, "delete "+objectName, func() error {
+		return s.client.RemoveObject(ctx, s.bucketName, s.key(objectName), minio.RemoveObjectOptions{})
+	})
+	if err != nil {
+		return fmt.Errorf("failed to delete object: %w", err)
+	}
+
+	return nil
+}
+
 // Stat retrieves metadata about an object in S3
 func (s *S3Client) Stat(ctx context.Context, objectName string) (*FileInfo, error) {
-	stat, err := s.client.StatObject(ctx, s.bucketName, objectName, minio.StatObjectOptions{})
+	var stat minio.ObjectInfo
+	err := withRetry(ctx, s.retry, "stat "+objectName, func() (err error) {
+		stat, err = s.client.StatObject(ctx, s.bucketName, s.key(objectName), minio.StatObjectOptions{})
+		return err
+	})
 	if err != nil {
 		return nil, fmt.Errorf("failed to stat object: %w", err)
 	}
 
-	modTime := extractModTime(stat)
+	return s.fileInfo(stat), nil
+}
+
+// ErrObjectEncrypted is returned by PresignedGetURL for objects stored
+// encrypted, which a link would serve as ciphertext
+var ErrObjectEncrypted = errors.New("object is stored encrypted")
+
+// PresignedGetURL returns a link that downloads objectName without
+// credentials until expiry has passed. expiry may be at most
+// config.MaxShareExpiry, the longest S3 signs for. The link serves the
+// object as stored: encrypted objects are refused, and compressed ones are
+// offered as <name>.gz.
+func (s *S3Client) PresignedGetURL(ctx context.Context, objectName string, expiry time.Duration) (*url.URL, error) {
+	if expiry < time.Second || expiry > config.MaxShareExpiry {
+		return nil, fmt.Errorf("link expiry %v must be between 1s and %v, the S3 maximum", expiry, config.MaxShareExpiry)
+	}
+
+	var stat minio.ObjectInfo
+	err := withRetry(ctx, s.retry, "stat "+objectName, func() (err error) {
+		stat, err = s.client.StatObject(ctx, s.bucketName, s.key(objectName), minio.StatObjectOptions{})
+		return err
+	})
+	if err != nil {
+		if minio.ToErrorResponse(err).Code == "NoSuchKey" {
+			return nil, fmt.Errorf("%s is not in bucket %s", objectName, s.bucketName)
+		}
+		return nil, fmt.Errorf("failed to stat object: %w", err)
+	}
+	if stat.UserMetadata[metaEncrypted] != "" {
+		return nil, fmt.Errorf("cannot share %s: %w", objectName, ErrObjectEncrypted)
+	}
+
+	filename := path.Base(objectName)
+	if stat.UserMetadata[metaCompressed] != "" {
+		filename += ".gz"
+	}
+	params := url.Values{}
+	params.Set("response-content-disposition", fmt.Sprintf("attachment; filename=%q", filename))
+
+	link, err := s.client.PresignedGetObject(ctx, s.bucketName, s.key(objectName), expiry, params)
+	if err != nil {
+		return nil, fmt.Errorf("failed to presign %s: %w", objectName, err)
+	}
+	return link, nil
+}
+
+// machineSuffix returns the suffix that keeps this machine's objects apart
+// from other machines' with PerMachine, or ""
+func machineSuffix(cfg config.S3Config) string {
+	if !cfg.PerMachine {
+		return ""
+	}
+	return "@" + cfg.MachineID
+}
+
+// key returns the key of objectName in the bucket
+func (s *S3Client) key(objectName string) string {
+	return s.prefix + objectName + s.suffix
+}
+
+// objectName returns the object name of a key in the bucket
+func (s *S3Client) objectName(key string) string {
+	return strings.TrimSuffix(strings.TrimPrefix(key, s.prefix), s.suffix)
+}
+
+// fileInfo is objectFileInfo with the object name in place of the key
+func (s *S3Client) fileInfo(object minio.ObjectInfo) *FileInfo {
+	info := objectFileInfo(object)
+	info.Name = s.objectName(info.Name)
+	return info
+}
+
+// objectFileInfo builds a FileInfo from an object's stat or listing entry,
+// whose UserMetadata holds the bare metadata keys
+func objectFileInfo(object minio.ObjectInfo) *FileInfo {
+	// Report the size of the original file, not of the stored bytes
+	size := object.Size
+	if raw := object.UserMetadata[metaSize]; raw != "" {
+		if n, err := strconv.ParseInt(raw, 10, 64); err == nil {
+			size = n
+		}
+	} else if object.UserMetadata[metaEncrypted] != "" {
+		size -= encryptionOverhead
+	}
 
+	modTime, precise := extractModTime(object)
 	return &FileInfo{
-		Name:    stat.Key,
-		ModTime: modTime,
-		Size:    stat.Size,
-		ETag:    stat.ETag,
-	}, nil
+		Name:          object.Key,
+		ModTime:       modTime,
+		Size:          size,
+		ETag:          object.ETag,
+		Checksum:      object.UserMetadata["Sha256"],
+		CoarseModTime: !precise,
+	}
 }
 
-// List returns all objects in the bucket
+// List returns all objects in the bucket, or under the object prefix,
+// named without it. Servers that support it (MinIO) return the user
+// metadata in the listing; objects listed without a Modtime are statted
+// individually to read it.
 func (s *S3Client) List(ctx context.Context) ([]*FileInfo, error) {
-	var files []*FileInfo
+	return s.ListFiltered(ctx, "", nil)
+}
 
-	objectCh := s.client.ListObjects(ctx, s.bucketName, minio.ListObjectsOptions{Recursive: true})
+// ListFiltered is List restricted to the objects whose names start with
+// prefix and, unless keep is nil, for which keep returns true. The prefix
+// is passed to the server, and keep is applied before any object is
+// statted, so objects that would be discarded cost nothing beyond the
+// listing.
+func (s *S3Client) ListFiltered(ctx context.Context, prefix string, keep func(name string) bool) ([]*FileInfo, error) {
+	listed, err := s.listObjects(ctx, prefix, true)
+	if err != nil {
+		return nil, err
+	}
 
-	for object := range objectCh {
-		if object.Err != nil {
-			return nil, fmt.Errorf("error listing objects: %w", object.Err)
+	objects := listed[:0]
+	for _, object := range listed {
+		if keep == nil || keep(s.objectName(object.Key)) {
+			objects = append(objects, object)
 		}
+	}
 
-		// Fetch full metadata (including custom mod time)
-		stat, err := s.client.StatObject(ctx, s.bucketName, object.Key, minio.StatObjectOptions{})
-		if err != nil {
-			return nil, fmt.Errorf("failed to stat object %s: %w", object.Key, err)
+	files := make([]*FileInfo, len(objects))
+	var missing []int
+	var keys []string
+	for i, object := range objects {
+		meta := listedUserMetadata(object.UserMetadata)
+		if meta["Modtime"] == "" {
+			missing = append(missing, i)
+			keys = append(keys, s.objectName(object.Key))
+			continue
 		}
 
-		modTime := extractModTime(stat)
+		object.UserMetadata = meta
+		files[i] = s.fileInfo(object)
+	}
 
-		files = append(files, &FileInfo{
-			Name:    object.Key,
-			ModTime: modTime,
-			Size:    object.Size,
-			ETag:    object.ETag,
-		})
+	// Fetch full metadata (including custom mod time) for the rest
+	statted, err := statAll(ctx, keys, s.listStatConcurrency, s.Stat)
+	if err != nil {
+		return nil, err
+	}
+	for j, i := range missing {
+		files[i] = statted[j]
 	}
 
 	return files, nil
 }
 
-// extractModTime extracts modification time from S3 object metadata
-func extractModTime(stat minio.ObjectInfo) time.Time {
-	cloudModTime := stat.LastModified
-
-	rawModTime := stat.UserMetadata["Modtime"]
-	if rawModTime != "" {
-		ts, err := strconv.ParseInt(rawModTime, 10, 64)
-		if err == nil {
-			cloudModTime = time.Unix(0, ts)
+// listedUserMetadata converts the metadata of a listing entry to the bare,
+// canonical keys StatObject reports (X-Amz-Meta-Modtime becomes Modtime).
+// Listings also carry system metadata such as content-type, which is
+// dropped.
+func listedUserMetadata(listed minio.StringMap) map[string]string {
+	const prefix = "X-Amz-Meta-"
+
+	meta := make(map[string]string, len(listed))
+	for key, value := range listed {
+		key = http.CanonicalHeaderKey(key)
+		if strings.HasPrefix(key, prefix) {
+			meta[strings.TrimPrefix(key, prefix)] = value
 		}
 	}
 
-	return cloudModTime.UTC()
+	return meta
 }
 
-// CopyFile is a utility function to copy files locally
-func CopyFile(src, dst string) error {
-	srcFile, err := os.Open(src)
+// StatAll stats every object in names, at most list-stat-concurrency at a
+// time, returning them in the same order
+func (s *S3Client) StatAll(ctx context.Context, names []string) ([]*FileInfo, error) {
+	return statAll(ctx, names, s.listStatConcurrency, s.Stat)
+}
+
+// ListETags returns the ETag of every object in the bucket, keyed by name,
+// from the listing alone
+func (s *S3Client) ListETags(ctx context.Context) (map[string]string, error) {
+	objects, err := s.listObjects(ctx, "", false)
 	if err != nil {
-		return fmt.Errorf("failed to open source: %w", err)
+		return nil, err
 	}
-	defer srcFile.Close()
 
-	dstFile, err := os.Create(dst)
-	if err != nil {
-		return fmt.Errorf("failed to create destination: %w", err)
+	etags := make(map[string]string, len(objects))
+	for _, object := range objects {
+		etags[s.objectName(object.Key)] = strings.Trim(object.ETag, `"`)
 	}
-	defer dstFile.Close()
 
-	_, err = io.Copy(dstFile, srcFile)
+	return etags, nil
+}
+
+// listObjects lists every object under the object prefix (and of this
+// machine, with -per-machine) whose name starts with prefix, without
+// statting them. With withMetadata, servers that support it also return
+// each object's metadata, with its X-Amz-Meta- prefixed header names.
+func (s *S3Client) listObjects(ctx context.Context, prefix string, withMetadata bool) ([]minio.ObjectInfo, error) {
+	var objects []minio.ObjectInfo
+
+	err := withRetry(ctx, s.retry, "list bucket", func() error {
+		// A failed listing starts over from the first page
+		objects = objects[:0]
+		listCtx, cancel := context.WithCancel(ctx)
+		defer cancel()
+
+		objectCh := s.client.ListObjects(listCtx, s.bucketName, minio.ListObjectsOptions{
+			Prefix:       s.prefix + prefix,
+			Recursive:    true,
+			WithMetadata: withMetadata,
+		})
+		for object := range objectCh {
+			if object.Err != nil {
+				return object.Err
+			}
+			// Other machines' objects, with -per-machine
+			if !strings.HasSuffix(object.Key, s.suffix) {
+				continue
+			}
+			objects = append(objects, object)
+		}
+		return nil
+	})
 	if err != nil {
-		return fmt.Errorf("failed to copy data: %w", err)
+		return nil, fmt.Errorf("error listing objects: %w", err)
 	}
 
-	return nil
+	return objects, nil
+}
+
+// statAll calls stat for every key with at most limit calls in flight,
+// returning results in the same order as keys. The first error cancels the
+// remaining calls.
+func statAll(ctx context.Context, keys []string, limit int, stat func(context.Context, string) (*FileInfo, error)) ([]*FileInfo, error) {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	files := make([]*FileInfo, len(keys))
+	sem := make(chan struct{}, limit)
+
+	var (
+		wg       sync.WaitGroup
+		errOnce  sync.Once
+		firstErr error
+	)
+
+	for i, key := range keys {
+		sem <- struct{}{}
+		if ctx.Err() != nil {
+			<-sem
+			break
+		}
+
+		wg.Add(1)
+		go func(i int, key string) {
+			defer wg.Done()
+			defer func() { <-sem }()
+
+			info, err := stat(ctx, key)
+			if err != nil {
+				errOnce.Do(func() {
+					firstErr = fmt.Errorf("%s: %w", key, err)
+					cancel()
+				})
+				return
+			}
+			files[i] = info
+		}(i, key)
+	}
+
+	wg.Wait()
+
+	if firstErr != nil {
+		return nil, firstErr
+	}
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	return files, nil
+}
+
+// extractModTime extracts modification time from S3 object metadata.
+// precise reports whether it came from the nanosecond Modtime metadata; if
+// not, it is LastModified, which S3 keeps to the second, and only tells
+// when the object was uploaded.
+func extractModTime(stat minio.ObjectInfo) (modTime time.Time, precise bool) {
+	rawModTime := stat.UserMetadata["Modtime"]
+	if rawModTime != "" {
+		ts, err := strconv.ParseInt(rawModTime, 10, 64)
+		if err == nil {
+			return time.Unix(0, ts).UTC(), true
+		}
+	}
+
+	return stat.LastModified.UTC(), false
 }
 
 // EnsureDir ensures a directory exists, creating it if necessary
@@ -0,0 +1,216 @@
+//go:build integration
+
+package storage
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/danielbehrens/cloudsync/internal/config"
+	"github.com/danielbehrens/cloudsync/internal/sync"
+	"github.com/minio/minio-go/v7"
+	"github.com/testcontainers/testcontainers-go"
+	tcminio "github.com/testcontainers/testcontainers-go/modules/minio"
+)
+
+// These tests run against a real MinIO server in a container, and need
+// Docker. Each test creates a bucket of its own and removes it afterwards.
+// Run them with make test-integration.
+
+const (
+	minioImage     = "minio/minio:RELEASE.2024-01-16T16-07-38Z"
+	minioAccessKey = "cloudsync"
+	minioSecretKey = "cloudsync-secret"
+)
+
+// minioEndpoint is the host:port of the container started by TestMain
+var minioEndpoint string
+
+func TestMain(m *testing.M) {
+	ctx := context.Background()
+
+	container, err := tcminio.Run(ctx, minioImage,
+		tcminio.WithUsername(minioAccessKey),
+		tcminio.WithPassword(minioSecretKey),
+	)
+	if err != nil {
+		log.Fatalf("failed to start MinIO container: %v", err)
+	}
+
+	minioEndpoint, err = container.ConnectionString(ctx)
+	if err != nil {
+		testcontainers.TerminateContainer(container)
+		log.Fatalf("failed to get MinIO endpoint: %v", err)
+	}
+
+	code := m.Run()
+
+	if err := testcontainers.TerminateContainer(container); err != nil {
+		log.Printf("failed to terminate MinIO container: %v", err)
+	}
+	os.Exit(code)
+}
+
+// newIntegrationClient returns a client for a fresh bucket on the MinIO
+// container, configured by cfg on top of the connection settings
+func newIntegrationClient(t *testing.T, cfg config.S3Config) *S3Client {
+	t.Helper()
+
+	cfg.Endpoint = minioEndpoint
+	cfg.AccessKey = minioAccessKey
+	cfg.SecretKey = minioSecretKey
+	cfg.BucketName = fmt.Sprintf("cloudsync-test-%d", time.Now().UnixNano())
+
+	s, err := NewS3Client(cfg)
+	if err != nil {
+		t.Fatalf("NewS3Client() error = %v", err)
+	}
+	if err := s.EnsureBucket(context.Background()); err != nil {
+		t.Fatalf("EnsureBucket() error = %v", err)
+	}
+	t.Cleanup(func() { removeBucket(t, s) })
+	return s
+}
+
+// removeBucket deletes the bucket of s with everything left in it
+func removeBucket(t *testing.T, s *S3Client) {
+	ctx := context.Background()
+	for obj := range s.client.ListObjects(ctx, s.bucketName, minio.ListObjectsOptions{Recursive: true}) {
+		if obj.Err != nil {
+			t.Errorf("listing %s for cleanup: %v", s.bucketName, obj.Err)
+			return
+		}
+		if err := s.client.RemoveObject(ctx, s.bucketName, obj.Key, minio.RemoveObjectOptions{}); err != nil {
+			t.Errorf("removing %s: %v", obj.Key, err)
+		}
+	}
+	if err := s.client.RemoveBucket(ctx, s.bucketName); err != nil {
+		t.Errorf("removing bucket %s: %v", s.bucketName, err)
+	}
+}
+
+func TestIntegrationRoundTrip(t *testing.T) {
+	tests := []struct {
+		name string
+		cfg  config.S3Config
+	}{
+		{name: "plain"},
+		{name: "compressed", cfg: config.S3Config{Compress: true}},
+		{name: "encrypted", cfg: config.S3Config{EncryptionPassphrase: "correct horse"}},
+		{name: "compressed and encrypted", cfg: config.S3Config{Compress: true, EncryptionPassphrase: "correct horse"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx := context.Background()
+			s := newIntegrationClient(t, tt.cfg)
+
+			// Nanoseconds catch any truncation of the mod time metadata
+			modTime := time.Date(2024, 3, 9, 17, 45, 12, 123456789, time.UTC)
+			content := "slot 1: level 42, 9000 gold"
+			src := filepath.Join(t.TempDir(), "slot1.sav")
+			writeTestFile(t, src, content, modTime)
+
+			if err := s.Upload(ctx, src, "saves/slot1.sav"); err != nil {
+				t.Fatalf("Upload() error = %v", err)
+			}
+
+			info, err := s.Stat(ctx, "saves/slot1.sav")
+			if err != nil {
+				t.Fatalf("Stat() error = %v", err)
+			}
+			if !info.ModTime.Equal(modTime) {
+				t.Errorf("Stat().ModTime = %v, want %v", info.ModTime, modTime)
+			}
+			if info.Size != int64(len(content)) {
+				t.Errorf("Stat().Size = %v, want %v", info.Size, len(content))
+			}
+
+			raw, err := s.client.StatObject(ctx, s.bucketName, "saves/slot1.sav", minio.StatObjectOptions{})
+			if err != nil {
+				t.Fatalf("StatObject() error = %v", err)
+			}
+			if got, want := raw.UserMetadata["Modtime"], fmt.Sprint(modTime.UnixNano()); got != want {
+				t.Errorf("Modtime metadata = %q, want %q", got, want)
+			}
+			if got, _ := extractModTime(raw); !got.Equal(modTime) || got.Nanosecond() != modTime.Nanosecond() {
+				t.Errorf("extractModTime() = %v, want %v", got, modTime)
+			}
+
+			files, err := s.List(ctx)
+			if err != nil {
+				t.Fatalf("List() error = %v", err)
+			}
+			if len(files) != 1 {
+				t.Fatalf("List() returned %d files, want 1", len(files))
+			}
+			if files[0].Name != "saves/slot1.sav" || !files[0].ModTime.Equal(modTime) {
+				t.Errorf("List()[0] = %s at %v, want saves/slot1.sav at %v", files[0].Name, files[0].ModTime, modTime)
+			}
+
+			dst := filepath.Join(t.TempDir(), "slot1.sav")
+			if err := s.Download(ctx, "saves/slot1.sav", dst); err != nil {
+				t.Fatalf("Download() error = %v", err)
+			}
+			got, err := os.ReadFile(dst)
+			if err != nil {
+				t.Fatalf("ReadFile() error = %v", err)
+			}
+			if string(got) != content {
+				t.Errorf("downloaded content = %q, want %q", got, content)
+			}
+
+			if err := s.Delete(ctx, "saves/slot1.sav"); err != nil {
+				t.Fatalf("Delete() error = %v", err)
+			}
+			if _, err := NewAdapter(s).Stat(ctx, "saves/slot1.sav"); !errors.Is(err, sync.ErrNotExist) {
+				t.Errorf("Stat() after Delete error = %v, want %v", err, sync.ErrNotExist)
+			}
+			if err := NewAdapter(s).Delete(ctx, "saves/slot1.sav"); !errors.Is(err, sync.ErrNotExist) {
+				t.Errorf("Delete() of a missing object error = %v, want %v", err, sync.ErrNotExist)
+			}
+		})
+	}
+}
+
+func TestIntegrationSyncsTwoFolders(t *testing.T) {
+	ctx := context.Background()
+	store := NewAdapter(newIntegrationClient(t, config.S3Config{}))
+
+	dirA, dirB := t.TempDir(), t.TempDir()
+	modTime := time.Now().Add(-time.Hour).Truncate(time.Second)
+	writeTestFile(t, filepath.Join(dirA, "game.sav"), "from A", modTime)
+
+	a := sync.NewSyncer(store, dirA, filepath.Join(t.TempDir(), "backupA"), nil, 500*time.Millisecond)
+	if _, err := a.InitialSync(ctx); err != nil {
+		t.Fatalf("InitialSync(A) error = %v", err)
+	}
+
+	syncB := sync.NewSyncer(store, dirB, filepath.Join(t.TempDir(), "backupB"), nil, 500*time.Millisecond)
+	if _, err := syncB.InitialSync(ctx); err != nil {
+		t.Fatalf("InitialSync(B) error = %v", err)
+	}
+
+	path := filepath.Join(dirB, "game.sav")
+	got, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatalf("ReadFile() error = %v", err)
+	}
+	if string(got) != "from A" {
+		t.Errorf("content = %q, want %q", got, "from A")
+	}
+
+	info, err := os.Stat(path)
+	if err != nil {
+		t.Fatalf("Stat() error = %v", err)
+	}
+	if !info.ModTime().Equal(modTime) {
+		t.Errorf("mod time = %v, want %v", info.ModTime(), modTime)
+	}
+}
@@ -0,0 +1,926 @@
+package storage
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/danielbehrens/cloudsync/internal/config"
+	"github.com/minio/minio-go/v7"
+)
+
+func TestStatAllRespectsConcurrency(t *testing.T) {
+	for _, limit := range []int{1, 3, 8} {
+		t.Run(fmt.Sprintf("limit %d", limit), func(t *testing.T) {
+			var inFlight, maxInFlight int32
+
+			stat := func(ctx context.Context, key string) (*FileInfo, error) {
+				n := atomic.AddInt32(&inFlight, 1)
+				defer atomic.AddInt32(&inFlight, -1)
+
+				for {
+					max := atomic.LoadInt32(&maxInFlight)
+					if n <= max || atomic.CompareAndSwapInt32(&maxInFlight, max, n) {
+						break
+					}
+				}
+
+				time.Sleep(5 * time.Millisecond)
+				return &FileInfo{Name: key}, nil
+			}
+
+			keys := make([]string, 20)
+			for i := range keys {
+				keys[i] = fmt.Sprintf("save%02d.sav", i)
+			}
+
+			files, err := statAll(context.Background(), keys, limit, stat)
+			if err != nil {
+				t.Fatalf("statAll() error = %v", err)
+			}
+
+			if got := atomic.LoadInt32(&maxInFlight); got > int32(limit) {
+				t.Errorf("max in-flight stats = %v, want <= %v", got, limit)
+			}
+
+			for i, f := range files {
+				if f.Name != keys[i] {
+					t.Errorf("files[%d].Name = %v, want %v", i, f.Name, keys[i])
+				}
+			}
+		})
+	}
+}
+
+func TestStatAllReturnsFirstError(t *testing.T) {
+	errBoom := errors.New("boom")
+
+	stat := func(ctx context.Context, key string) (*FileInfo, error) {
+		if key == "bad.sav" {
+			return nil, errBoom
+		}
+		return &FileInfo{Name: key}, nil
+	}
+
+	_, err := statAll(context.Background(), []string{"a.sav", "bad.sav", "c.sav"}, 2, stat)
+	if !errors.Is(err, errBoom) {
+		t.Errorf("statAll() error = %v, want %v", err, errBoom)
+	}
+}
+
+// timeoutError is a net.Error that reports a timeout
+type timeoutError struct{}
+
+func (timeoutError) Error() string   { return "i/o timeout" }
+func (timeoutError) Timeout() bool   { return true }
+func (timeoutError) Temporary() bool { return true }
+
+func TestWithRetry(t *testing.T) {
+	retry := config.RetryConfig{MaxAttempts: 3, InitialBackoff: time.Millisecond, MaxBackoff: 2 * time.Millisecond}
+
+	tests := []struct {
+		name      string
+		err       error
+		failures  int // calls that fail before one succeeds
+		wantCalls int
+		wantErr   bool
+	}{
+		{name: "success", failures: 0, wantCalls: 1},
+		{name: "5xx recovers", err: minio.ErrorResponse{StatusCode: 503}, failures: 2, wantCalls: 3},
+		{name: "timeout recovers", err: &url.Error{Op: "Put", URL: "http://minio", Err: timeoutError{}}, failures: 1, wantCalls: 2},
+		{name: "connection reset recovers", err: fmt.Errorf("read: %w", syscall.ECONNRESET), failures: 1, wantCalls: 2},
+		{name: "5xx exhausts attempts", err: minio.ErrorResponse{StatusCode: 500}, failures: 5, wantCalls: 3, wantErr: true},
+		{name: "403 is permanent", err: minio.ErrorResponse{StatusCode: 403}, failures: 5, wantCalls: 1, wantErr: true},
+		{name: "404 is permanent", err: minio.ErrorResponse{StatusCode: 404}, failures: 5, wantCalls: 1, wantErr: true},
+		{name: "unknown error is permanent", err: errors.New("boom"), failures: 5, wantCalls: 1, wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			calls := 0
+			err := withRetry(context.Background(), retry, "stat game.sav", func() error {
+				calls++
+				if calls <= tt.failures {
+					return tt.err
+				}
+				return nil
+			})
+
+			if calls != tt.wantCalls {
+				t.Errorf("calls = %d, want %d", calls, tt.wantCalls)
+			}
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("withRetry() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if err != nil && !errors.Is(err, tt.err) {
+				t.Errorf("withRetry() error = %v, want it to wrap %v", err, tt.err)
+			}
+		})
+	}
+}
+
+func TestWithRetryStopsOnCancel(t *testing.T) {
+	errUnavailable := minio.ErrorResponse{StatusCode: 503}
+	ctx, cancel := context.WithCancel(context.Background())
+	retry := config.RetryConfig{MaxAttempts: 5, InitialBackoff: time.Hour, MaxBackoff: time.Hour}
+
+	calls := 0
+	err := withRetry(ctx, retry, "upload game.sav", func() error {
+		calls++
+		cancel()
+		return errUnavailable
+	})
+
+	if calls != 1 {
+		t.Errorf("calls = %d, want 1", calls)
+	}
+	if !errors.Is(err, errUnavailable) {
+		t.Errorf("withRetry() error = %v, want it to wrap %v", err, errUnavailable)
+	}
+}
+
+func TestListedUserMetadata(t *testing.T) {
+	listed := minio.StringMap{
+		"X-Amz-Meta-Modtime":       "1709296200000000000",
+		"x-amz-meta-sha256":        "abc",
+		"X-Amz-Meta-Modtimestring": "2024-03-01_12-30-00.000000",
+		"content-type":             "application/octet-stream",
+		"Expires":                  "Mon, 01 Jan 0001 00:00:00 GMT",
+	}
+
+	got := listedUserMetadata(listed)
+	want := map[string]string{
+		"Modtime":       "1709296200000000000",
+		"Sha256":        "abc",
+		"Modtimestring": "2024-03-01_12-30-00.000000",
+	}
+	if len(got) != len(want) {
+		t.Fatalf("listedUserMetadata() = %v, want %v", got, want)
+	}
+	for k, v := range want {
+		if got[k] != v {
+			t.Errorf("listedUserMetadata()[%q] = %q, want %q", k, got[k], v)
+		}
+	}
+
+	// A listing entry with metadata yields the same FileInfo as a stat
+	modTime := time.Date(2024, 3, 1, 12, 30, 0, 0, time.UTC)
+	lastModified := modTime.Add(time.Hour)
+	stat := objectFileInfo(minio.ObjectInfo{
+		Key: "game.sav", ETag: "etag", Size: 10, LastModified: lastModified,
+		UserMetadata: minio.StringMap{"Modtime": fmt.Sprint(modTime.UnixNano()), "Sha256": "abc"},
+	})
+	fromList := objectFileInfo(minio.ObjectInfo{
+		Key: "game.sav", ETag: "etag", Size: 10, LastModified: lastModified,
+		UserMetadata: listedUserMetadata(minio.StringMap{"X-Amz-Meta-Modtime": fmt.Sprint(modTime.UnixNano()), "X-Amz-Meta-Sha256": "abc"}),
+	})
+	if *stat != *fromList {
+		t.Errorf("listing FileInfo = %+v, want %+v", fromList, stat)
+	}
+	if !stat.ModTime.Equal(modTime) {
+		t.Errorf("ModTime = %v, want %v", stat.ModTime, modTime)
+	}
+}
+
+// fakeS3 serves just enough of the S3 API for S3Client.List and Upload:
+// bucket location, ListObjectsV2 (with the MinIO metadata extension when
+// listMetadata is set), PUT object, multipart uploads and HEAD object.
+// HEAD reports the metadata, content type and server-side encryption
+// headers of the last PUT to the same path, if any. Every request waits
+// latency to stand in for a network round trip.
+type fakeS3 struct {
+	objects      int
+	keyPrefix    string // of the listed objects' keys
+	keySuffix    string
+	listMetadata bool
+	noSSE        bool // reject uploads asking for server-side encryption
+	latency      time.Duration
+	heads        atomic.Int32
+	parts        atomic.Int32 // parts of multipart uploads received
+	failParts    atomic.Int32 // part uploads still to fail with a 500
+	initiated    atomic.Int32 // multipart uploads started
+
+	mu            sync.Mutex
+	put           map[string]http.Header // metadata and content type headers by path
+	lifecycle     []byte                 // bucket lifecycle XML, nil for none
+	lifecyclePuts int
+}
+
+func (f *fakeS3) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	time.Sleep(f.latency)
+
+	modTime := time.Date(2024, 3, 1, 12, 30, 0, 0, time.UTC)
+	query := r.URL.Query()
+
+	switch {
+	case query.Has("location"):
+		fmt.Fprint(w, `<?xml version="1.0" encoding="UTF-8"?><LocationConstraint xmlns="http://s3.amazonaws.com/doc/2006-03-01/"></LocationConstraint>`)
+
+	case query.Get("list-type") == "2":
+		objects := f.objects
+		if !strings.HasPrefix(f.keyPrefix, query.Get("prefix")) {
+			objects = 0
+		}
+		var b strings.Builder
+		fmt.Fprintf(&b, `<?xml version="1.0" encoding="UTF-8"?><ListBucketResult xmlns="http://s3.amazonaws.com/doc/2006-03-01/"><Name>bucket</Name><KeyCount>%d</KeyCount><MaxKeys>1000</MaxKeys><IsTruncated>false</IsTruncated>`, objects)
+		for i := range objects {
+			fmt.Fprintf(&b, `<Contents><Key>%ssave%03d.sav%s</Key><LastModified>%s</LastModified><ETag>"etag%d"</ETag><Size>1024</Size><StorageClass>STANDARD</StorageClass>`,
+				f.keyPrefix, i, f.keySuffix, modTime.Add(time.Hour).Format(time.RFC3339), i)
+			if f.listMetadata && query.Get("metadata") == "true" {
+				fmt.Fprintf(&b, `<UserMetadata><content-type>application/octet-stream</content-type><X-Amz-Meta-Modtime>%d</X-Amz-Meta-Modtime></UserMetadata>`, modTime.UnixNano())
+			}
+			b.WriteString(`</Contents>`)
+		}
+		b.WriteString(`</ListBucketResult>`)
+		w.Header().Set("Content-Type", "application/xml")
+		fmt.Fprint(w, b.String())
+
+	case query.Has("lifecycle") && r.Method == http.MethodGet:
+		f.mu.Lock()
+		defer f.mu.Unlock()
+		if f.lifecycle == nil {
+			w.Header().Set("Content-Type", "application/xml")
+			w.WriteHeader(http.StatusNotFound)
+			fmt.Fprint(w, `<?xml version="1.0" encoding="UTF-8"?><Error><Code>NoSuchLifecycleConfiguration</Code><Message>The lifecycle configuration does not exist</Message></Error>`)
+			return
+		}
+		w.Header().Set("Content-Type", "application/xml")
+		w.Write(f.lifecycle)
+
+	case query.Has("lifecycle") && r.Method == http.MethodPut:
+		body, _ := io.ReadAll(r.Body)
+		f.mu.Lock()
+		f.lifecycle = body
+		f.lifecyclePuts++
+		f.mu.Unlock()
+
+	case r.Method == http.MethodPost && query.Has("uploads"):
+		f.initiated.Add(1)
+		f.mu.Lock()
+		if f.put == nil {
+			f.put = make(map[string]http.Header)
+		}
+		f.put[r.URL.Path] = uploadHeaders(r.Header)
+		f.mu.Unlock()
+		w.Header().Set("Content-Type", "application/xml")
+		fmt.Fprintf(w, `<?xml version="1.0" encoding="UTF-8"?><InitiateMultipartUploadResult xmlns="http://s3.amazonaws.com/doc/2006-03-01/"><Bucket>bucket</Bucket><Key>%s</Key><UploadId>upload</UploadId></InitiateMultipartUploadResult>`,
+			strings.TrimPrefix(r.URL.Path, "/bucket/"))
+
+	case r.Method == http.MethodPut && query.Has("partNumber"):
+		io.Copy(io.Discard, r.Body)
+		if f.failParts.Add(-1) >= 0 {
+			w.Header().Set("Content-Type", "application/xml")
+			w.WriteHeader(http.StatusInternalServerError)
+			fmt.Fprint(w, `<?xml version="1.0" encoding="UTF-8"?><Error><Code>InternalError</Code><Message>We encountered an internal error. Please try again.</Message></Error>`)
+			return
+		}
+		f.parts.Add(1)
+		w.Header().Set("ETag", fmt.Sprintf(`"part%s"`, query.Get("partNumber")))
+
+	case r.Method == http.MethodPost && query.Has("uploadId"):
+		io.Copy(io.Discard, r.Body)
+		w.Header().Set("Content-Type", "application/xml")
+		fmt.Fprintf(w, `<?xml version="1.0" encoding="UTF-8"?><CompleteMultipartUploadResult xmlns="http://s3.amazonaws.com/doc/2006-03-01/"><Bucket>bucket</Bucket><Key>%s</Key><ETag>"etag-multipart"</ETag></CompleteMultipartUploadResult>`,
+			strings.TrimPrefix(r.URL.Path, "/bucket/"))
+
+	case r.Method == http.MethodPut:
+		if f.noSSE && r.Header.Get("X-Amz-Server-Side-Encryption") != "" {
+			w.Header().Set("Content-Type", "application/xml")
+			w.WriteHeader(http.StatusNotImplemented)
+			fmt.Fprint(w, `<?xml version="1.0" encoding="UTF-8"?><Error><Code>NotImplemented</Code><Message>Server side encryption specified but KMS is not configured</Message></Error>`)
+			return
+		}
+		f.mu.Lock()
+		if f.put == nil {
+			f.put = make(map[string]http.Header)
+		}
+		f.put[r.URL.Path] = uploadHeaders(r.Header)
+		f.mu.Unlock()
+		io.Copy(io.Discard, r.Body)
+		w.Header().Set("ETag", `"etag"`)
+
+	case r.Method == http.MethodHead:
+		f.heads.Add(1)
+		w.Header().Set("Last-Modified", modTime.Add(time.Hour).Format(http.TimeFormat))
+		w.Header().Set("ETag", `"etag"`)
+		w.Header().Set("Content-Length", "1024")
+		w.Header().Set("Content-Type", "application/octet-stream")
+		f.mu.Lock()
+		meta, ok := f.put[r.URL.Path]
+		f.mu.Unlock()
+		if !ok {
+			meta = http.Header{"X-Amz-Meta-Modtime": {fmt.Sprint(modTime.UnixNano())}}
+		}
+		for key, values := range meta {
+			w.Header()[key] = values
+		}
+
+	default:
+		http.Error(w, "not implemented", http.StatusNotImplemented)
+	}
+}
+
+// uploadHeaders returns the metadata, content type and server-side
+// encryption headers of a PUT or multipart upload
+func uploadHeaders(header http.Header) http.Header {
+	meta := make(http.Header)
+	for key, values := range header {
+		if strings.HasPrefix(key, "X-Amz-Meta-") || strings.HasPrefix(key, "X-Amz-Server-Side-Encryption") || key == "Content-Type" {
+			meta[key] = values
+		}
+	}
+	return meta
+}
+
+func newFakeS3Client(t testing.TB, fake *fakeS3) *S3Client {
+	t.Helper()
+	server := httptest.NewServer(fake)
+	t.Cleanup(server.Close)
+
+	client, err := NewS3Client(config.S3Config{
+		Endpoint:   strings.TrimPrefix(server.URL, "http://"),
+		AccessKey:  "access",
+		SecretKey:  "secret",
+		BucketName: "bucket",
+	})
+	if err != nil {
+		t.Fatalf("NewS3Client() error = %v", err)
+	}
+	return client
+}
+
+func TestListUsesListingMetadata(t *testing.T) {
+	for _, listMetadata := range []bool{true, false} {
+		t.Run(fmt.Sprintf("listing metadata %v", listMetadata), func(t *testing.T) {
+			fake := &fakeS3{objects: 20, listMetadata: listMetadata}
+			files, err := newFakeS3Client(t, fake).List(context.Background())
+			if err != nil {
+				t.Fatalf("List() error = %v", err)
+			}
+
+			want := time.Date(2024, 3, 1, 12, 30, 0, 0, time.UTC)
+			if len(files) != fake.objects {
+				t.Fatalf("List() returned %d files, want %d", len(files), fake.objects)
+			}
+			for _, f := range files {
+				if !f.ModTime.Equal(want) {
+					t.Errorf("%s ModTime = %v, want %v", f.Name, f.ModTime, want)
+				}
+			}
+
+			wantHeads := int32(0)
+			if !listMetadata {
+				wantHeads = int32(fake.objects)
+			}
+			if got := fake.heads.Load(); got != wantHeads {
+				t.Errorf("HEAD requests = %d, want %d", got, wantHeads)
+			}
+		})
+	}
+}
+
+func TestListFiltered(t *testing.T) {
+	fake := &fakeS3{objects: 20, keyPrefix: "games/"}
+	client := newFakeS3Client(t, fake)
+
+	files, err := client.ListFiltered(context.Background(), "games/", func(name string) bool {
+		return strings.HasPrefix(name, "games/save00")
+	})
+	if err != nil {
+		t.Fatalf("ListFiltered() error = %v", err)
+	}
+	if len(files) != 10 {
+		t.Errorf("ListFiltered() returned %d files, want 10", len(files))
+	}
+	// Only the kept objects are statted for their mod time
+	if got := fake.heads.Load(); got != 10 {
+		t.Errorf("HEAD requests = %d, want 10", got)
+	}
+
+	files, err = client.ListFiltered(context.Background(), "other/", nil)
+	if err != nil {
+		t.Fatalf("ListFiltered() error = %v", err)
+	}
+	if len(files) != 0 {
+		t.Errorf("ListFiltered() under another prefix returned %d files, want 0", len(files))
+	}
+}
+
+func TestUploadMetadataKeys(t *testing.T) {
+	// Nanoseconds catch any truncation of the mod time
+	modTime := time.Date(2024, 3, 9, 17, 45, 12, 123456789, time.UTC)
+	path := filepath.Join(t.TempDir(), "game.sav")
+	if err := os.WriteFile(path, []byte("save data"), 0644); err != nil {
+		t.Fatalf("WriteFile() error = %v", err)
+	}
+	if err := os.Chtimes(path, modTime, modTime); err != nil {
+		t.Fatalf("Chtimes() error = %v", err)
+	}
+
+	fake := &fakeS3{}
+	client := newFakeS3Client(t, fake)
+	ctx := context.Background()
+	if err := client.Upload(ctx, path, "game.sav"); err != nil {
+		t.Fatalf("Upload() error = %v", err)
+	}
+
+	// Each key reaches the server with a single X-Amz-Meta- prefix
+	fake.mu.Lock()
+	sent := fake.put["/bucket/game.sav"]
+	fake.mu.Unlock()
+	for key := range uploadMetadata(modTime, "") {
+		header := http.CanonicalHeaderKey("X-Amz-Meta-" + key)
+		if _, ok := sent[header]; !ok {
+			t.Errorf("upload headers %v lack %s", sent, header)
+		}
+	}
+	for key := range sent {
+		if strings.HasPrefix(strings.TrimPrefix(key, "X-Amz-Meta-"), "X-Amz-Meta-") {
+			t.Errorf("upload header %s is prefixed twice", key)
+		}
+	}
+
+	// ...and StatObject reads back the key extractModTime looks up
+	stat, err := client.client.StatObject(ctx, "bucket", "game.sav", minio.StatObjectOptions{})
+	if err != nil {
+		t.Fatalf("StatObject() error = %v", err)
+	}
+	if got, want := stat.UserMetadata["Modtime"], fmt.Sprint(modTime.UnixNano()); got != want {
+		t.Errorf("Modtime metadata = %q, want %q", got, want)
+	}
+	if got, precise := extractModTime(stat); !got.Equal(modTime) || !precise {
+		t.Errorf("extractModTime() = %v, %v, want %v, true", got, precise, modTime)
+	}
+}
+
+func TestExtractModTime(t *testing.T) {
+	modTime := time.Date(2024, 3, 1, 12, 0, 0, 123456789, time.UTC)
+	lastModified := time.Date(2024, 3, 1, 12, 0, 5, 0, time.UTC)
+
+	tests := []struct {
+		name        string
+		metadata    map[string]string
+		want        time.Time
+		wantPrecise bool
+	}{
+		{name: "modtime metadata", metadata: map[string]string{"Modtime": fmt.Sprint(modTime.UnixNano())}, want: modTime, wantPrecise: true},
+		{name: "no metadata", want: lastModified},
+		{name: "unparsable metadata", metadata: map[string]string{"Modtime": "yesterday"}, want: lastModified},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, precise := extractModTime(minio.ObjectInfo{LastModified: lastModified, UserMetadata: tt.metadata})
+			if !got.Equal(tt.want) || precise != tt.wantPrecise {
+				t.Errorf("extractModTime() = %v, %v, want %v, %v", got, precise, tt.want, tt.wantPrecise)
+			}
+		})
+	}
+}
+
+func TestUploadContentTypeAndMetadata(t *testing.T) {
+	tests := []struct {
+		name     string
+		object   string
+		compress bool
+		want     string
+	}{
+		{name: "json", object: "settings.json", want: "application/json"},
+		{name: "unknown extension", object: "slot1.dwsave", want: "application/octet-stream"},
+		{name: "no extension", object: "saves/profile", want: "application/octet-stream"},
+		{name: "compressed", object: "settings.json", compress: true, want: "application/octet-stream"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), "file")
+			if err := os.WriteFile(path, []byte(`{"volume": 7}`), 0644); err != nil {
+				t.Fatalf("WriteFile() error = %v", err)
+			}
+
+			fake := &fakeS3{}
+			client := newFakeS3Client(t, fake)
+			client.compress = tt.compress
+			client.metadata = map[string]string{"Owner": "alice"}
+			if err := client.Upload(context.Background(), path, tt.object); err != nil {
+				t.Fatalf("Upload() error = %v", err)
+			}
+
+			fake.mu.Lock()
+			sent := fake.put["/bucket/"+tt.object]
+			fake.mu.Unlock()
+			if got := sent.Get("Content-Type"); got != tt.want {
+				t.Errorf("Content-Type = %q, want %q", got, tt.want)
+			}
+			if got := sent.Get("X-Amz-Meta-Owner"); got != "alice" {
+				t.Errorf("X-Amz-Meta-Owner = %q, want %q", got, "alice")
+			}
+			if sent.Get("X-Amz-Meta-Modtime") == "" {
+				t.Error("X-Amz-Meta-Modtime is missing")
+			}
+		})
+	}
+}
+
+func TestObjectPrefix(t *testing.T) {
+	ctx := context.Background()
+	fake := &fakeS3{objects: 3, keyPrefix: "laptop/", listMetadata: true}
+	client := newFakeS3Client(t, fake)
+	client.prefix = "laptop/"
+
+	path := filepath.Join(t.TempDir(), "game.sav")
+	if err := os.WriteFile(path, []byte("save data"), 0644); err != nil {
+		t.Fatalf("WriteFile() error = %v", err)
+	}
+	if err := client.Upload(ctx, path, "game.sav"); err != nil {
+		t.Fatalf("Upload() error = %v", err)
+	}
+	fake.mu.Lock()
+	_, ok := fake.put["/bucket/laptop/game.sav"]
+	fake.mu.Unlock()
+	if !ok {
+		t.Errorf("uploaded paths = %v, want /bucket/laptop/game.sav", fake.put)
+	}
+
+	info, err := client.Stat(ctx, "game.sav")
+	if err != nil {
+		t.Fatalf("Stat() error = %v", err)
+	}
+	if info.Name != "game.sav" {
+		t.Errorf("Stat().Name = %q, want game.sav", info.Name)
+	}
+
+	// Listed names lose the prefix, so sync sees the same names as without
+	for _, listMetadata := range []bool{true, false} {
+		client := newFakeS3Client(t, &fakeS3{objects: 3, keyPrefix: "laptop/", listMetadata: listMetadata})
+		client.prefix = "laptop/"
+		files, err := client.List(ctx)
+		if err != nil {
+			t.Fatalf("List() error = %v", err)
+		}
+		if len(files) != 3 {
+			t.Fatalf("List() returned %d files, want 3", len(files))
+		}
+		for i, f := range files {
+			if want := fmt.Sprintf("save%03d.sav", i); f.Name != want {
+				t.Errorf("List()[%d].Name = %q, want %q", i, f.Name, want)
+			}
+		}
+	}
+
+	etags, err := client.ListETags(ctx)
+	if err != nil {
+		t.Fatalf("ListETags() error = %v", err)
+	}
+	if _, ok := etags["save000.sav"]; !ok {
+		t.Errorf("ListETags() = %v, want names without the prefix", etags)
+	}
+}
+
+func TestPerMachine(t *testing.T) {
+	ctx := context.Background()
+	fake := &fakeS3{}
+	client := newFakeS3Client(t, fake)
+	client.suffix = machineSuffix(config.S3Config{PerMachine: true, MachineID: "laptop"})
+
+	path := filepath.Join(t.TempDir(), "game.sav")
+	if err := os.WriteFile(path, []byte("save data"), 0644); err != nil {
+		t.Fatalf("WriteFile() error = %v", err)
+	}
+	if err := client.Upload(ctx, path, "game.sav"); err != nil {
+		t.Fatalf("Upload() error = %v", err)
+	}
+	fake.mu.Lock()
+	_, ok := fake.put["/bucket/game.sav@laptop"]
+	fake.mu.Unlock()
+	if !ok {
+		t.Errorf("uploaded paths = %v, want /bucket/game.sav@laptop", fake.put)
+	}
+
+	// Only this machine's objects are listed, under their plain names
+	for _, tt := range []struct {
+		machine string
+		want    int
+	}{
+		{machine: "laptop", want: 3},
+		{machine: "desktop", want: 0},
+	} {
+		client := newFakeS3Client(t, &fakeS3{objects: 3, keySuffix: "@laptop", listMetadata: true})
+		client.suffix = "@" + tt.machine
+		files, err := client.List(ctx)
+		if err != nil {
+			t.Fatalf("List() error = %v", err)
+		}
+		if len(files) != tt.want {
+			t.Fatalf("List() on %s returned %d files, want %d", tt.machine, len(files), tt.want)
+		}
+		for i, f := range files {
+			if want := fmt.Sprintf("save%03d.sav", i); f.Name != want {
+				t.Errorf("List()[%d].Name = %q, want %q", i, f.Name, want)
+			}
+		}
+	}
+}
+
+func TestClassifyPingError(t *testing.T) {
+	tests := []struct {
+		name string
+		err  error
+		want error
+	}{
+		{"bad key", minio.ErrorResponse{Code: "InvalidAccessKeyId", StatusCode: http.StatusForbidden}, ErrAccessDenied},
+		{"bad secret", minio.ErrorResponse{Code: "SignatureDoesNotMatch", StatusCode: http.StatusForbidden}, ErrAccessDenied},
+		{"forbidden HEAD", minio.ErrorResponse{StatusCode: http.StatusForbidden}, ErrAccessDenied},
+		{"wrong region", minio.ErrorResponse{Code: "AuthorizationHeaderMalformed", StatusCode: http.StatusBadRequest, Region: "eu-west-1"}, ErrWrongRegion},
+		{"redirect", minio.ErrorResponse{StatusCode: http.StatusMovedPermanently}, ErrWrongRegion},
+		{"connection refused", &net.OpError{Op: "dial", Net: "tcp", Err: syscall.ECONNREFUSED}, ErrUnreachable},
+		{"timeout", timeoutError{}, ErrUnreachable},
+		{"server error", minio.ErrorResponse{Code: "InternalError", StatusCode: http.StatusInternalServerError}, nil},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := classifyPingError(tt.err)
+			for _, sentinel := range []error{ErrAccessDenied, ErrWrongRegion, ErrUnreachable} {
+				if errors.Is(got, sentinel) != (sentinel == tt.want) {
+					t.Errorf("classifyPingError(%v) = %v, want it to wrap %v", tt.err, got, tt.want)
+				}
+			}
+		})
+	}
+}
+
+func TestPingUnreachable(t *testing.T) {
+	client, err := NewS3Client(config.S3Config{
+		Endpoint:   "127.0.0.1:1",
+		AccessKey:  "access",
+		SecretKey:  "secret",
+		BucketName: "bucket",
+		Retry:      config.RetryConfig{MaxAttempts: 1},
+	})
+	if err != nil {
+		t.Fatalf("NewS3Client() error = %v", err)
+	}
+
+	err = client.Ping(context.Background())
+	if !errors.Is(err, ErrUnreachable) || !strings.Contains(err.Error(), "127.0.0.1:1") {
+		t.Errorf("Ping() error = %v, want ErrUnreachable naming the endpoint", err)
+	}
+}
+
+func TestS3Credentials(t *testing.T) {
+	t.Setenv("AWS_ACCESS_KEY_ID", "env-key")
+	t.Setenv("AWS_SECRET_ACCESS_KEY", "env-secret")
+	t.Setenv("AWS_SESSION_TOKEN", "env-token")
+
+	tests := []struct {
+		name      string
+		cfg       config.S3Config
+		wantKey   string
+		wantToken string
+	}{
+		{
+			name:      "static with session token",
+			cfg:       config.S3Config{Credentials: config.CredentialsStatic, AccessKey: "key", SecretKey: "secret", SessionToken: "token"},
+			wantKey:   "key",
+			wantToken: "token",
+		},
+		{
+			name:    "chain prefers given keys",
+			cfg:     config.S3Config{Credentials: config.CredentialsChain, AccessKey: "key", SecretKey: "secret"},
+			wantKey: "key",
+		},
+		{
+			name:      "chain falls back to the environment",
+			cfg:       config.S3Config{Credentials: config.CredentialsChain},
+			wantKey:   "env-key",
+			wantToken: "env-token",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			creds, err := s3Credentials(tt.cfg).Get()
+			if err != nil {
+				t.Fatalf("Get() error = %v", err)
+			}
+			if creds.AccessKeyID != tt.wantKey || creds.SessionToken != tt.wantToken {
+				t.Errorf("credentials = %q with token %q, want %q with token %q", creds.AccessKeyID, creds.SessionToken, tt.wantKey, tt.wantToken)
+			}
+		})
+	}
+}
+
+func TestPresignedGetURL(t *testing.T) {
+	client := newFakeS3Client(t, &fakeS3{})
+
+	link, err := client.PresignedGetURL(context.Background(), "saves/World1.sav", time.Hour)
+	if err != nil {
+		t.Fatalf("PresignedGetURL() error = %v", err)
+	}
+	if link.Path != "/bucket/saves/World1.sav" {
+		t.Errorf("link path = %q, want %q", link.Path, "/bucket/saves/World1.sav")
+	}
+	query := link.Query()
+	if got := query.Get("X-Amz-Expires"); got != "3600" {
+		t.Errorf("X-Amz-Expires = %q, want %q", got, "3600")
+	}
+	if got := query.Get("response-content-disposition"); got != `attachment; filename="World1.sav"` {
+		t.Errorf("response-content-disposition = %q, want the save's name", got)
+	}
+
+	for _, expiry := range []time.Duration{0, config.MaxShareExpiry + time.Second} {
+		if _, err := client.PresignedGetURL(context.Background(), "saves/World1.sav", expiry); err == nil {
+			t.Errorf("PresignedGetURL(expiry %v) succeeded, want an error", expiry)
+		}
+	}
+}
+
+// BenchmarkList lists a 500-object bucket with a 1ms round trip, once with
+// the metadata in the listing and once falling back to a stat per object
+// as servers without the extension (and List before it) require
+func BenchmarkList(b *testing.B) {
+	for _, listMetadata := range []bool{true, false} {
+		name := "stat per object"
+		if listMetadata {
+			name = "listing metadata"
+		}
+		b.Run(name, func(b *testing.B) {
+			client := newFakeS3Client(b, &fakeS3{objects: 500, listMetadata: listMetadata, latency: time.Millisecond})
+			for b.Loop() {
+				if _, err := client.List(context.Background()); err != nil {
+					b.Fatalf("List() error = %v", err)
+				}
+			}
+		})
+	}
+}
+
+func TestUploadMultipart(t *testing.T) {
+	// 12 MiB in 5 MiB parts: two full parts and a short last one
+	path := filepath.Join(t.TempDir(), "world.sav")
+	if err := os.WriteFile(path, bytes.Repeat([]byte("save"), 3<<20), 0644); err != nil {
+		t.Fatalf("WriteFile() error = %v", err)
+	}
+
+	fake := &fakeS3{}
+	fake.failParts.Store(1)
+	client := newFakeS3Client(t, fake)
+	client.partSize = config.MinPartSizeMiB << 20
+	if err := client.Upload(context.Background(), path, "world.sav"); err != nil {
+		t.Fatalf("Upload() error = %v", err)
+	}
+
+	// The failed part is sent again within the same upload
+	if got := fake.initiated.Load(); got != 1 {
+		t.Errorf("multipart uploads started = %d, want 1", got)
+	}
+	if got := fake.parts.Load(); got != 3 {
+		t.Errorf("parts received = %d, want 3", got)
+	}
+	fake.mu.Lock()
+	sent := fake.put["/bucket/world.sav"]
+	fake.mu.Unlock()
+	if sent.Get("X-Amz-Meta-Modtime") == "" {
+		t.Errorf("multipart upload headers %v lack the mod time", sent)
+	}
+}
+
+// BenchmarkUploadPartSize uploads a 64 MiB file with a 1ms round trip in
+// parts of several sizes, reporting the parts each upload takes. With
+// 64 MiB parts the file fits in one, so it goes up in a single PUT.
+func BenchmarkUploadPartSize(b *testing.B) {
+	path := filepath.Join(b.TempDir(), "world.sav")
+	if err := os.WriteFile(path, bytes.Repeat([]byte("save"), 16<<20), 0644); err != nil {
+		b.Fatalf("WriteFile() error = %v", err)
+	}
+
+	for _, mib := range []uint64{config.MinPartSizeMiB, config.DefaultPartSizeMiB, 64} {
+		b.Run(fmt.Sprintf("%d MiB", mib), func(b *testing.B) {
+			fake := &fakeS3{latency: time.Millisecond}
+			client := newFakeS3Client(b, fake)
+			client.partSize = mib << 20
+			b.SetBytes(64 << 20)
+			for b.Loop() {
+				if err := client.Upload(context.Background(), path, "world.sav"); err != nil {
+					b.Fatalf("Upload() error = %v", err)
+				}
+			}
+			b.ReportMetric(float64(fake.parts.Load())/float64(b.N), "parts/op")
+		})
+	}
+}
+
+func TestUploadServerSideEncryption(t *testing.T) {
+	tests := []struct {
+		name     string
+		sse      string
+		keyID    string
+		compress bool
+		want     string
+	}{
+		{name: "s3", sse: config.SSES3, want: "AES256"},
+		{name: "kms", sse: config.SSEKMS, keyID: "alias/saves", want: "aws:kms"},
+		{name: "kms compressed", sse: config.SSEKMS, compress: true, want: "aws:kms"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), "file")
+			if err := os.WriteFile(path, []byte("save data"), 0644); err != nil {
+				t.Fatalf("WriteFile() error = %v", err)
+			}
+
+			fake := &fakeS3{}
+			client := newFakeS3Client(t, fake)
+			var err error
+			if client.serverSide, err = serverSide(config.S3Config{SSE: tt.sse, SSEKMSKeyID: tt.keyID}); err != nil {
+				t.Fatalf("serverSide() error = %v", err)
+			}
+			client.compress = tt.compress
+			ctx := context.Background()
+			if err := client.Upload(ctx, path, "game.sav"); err != nil {
+				t.Fatalf("Upload() error = %v", err)
+			}
+
+			fake.mu.Lock()
+			sent := fake.put["/bucket/game.sav"]
+			fake.mu.Unlock()
+			if got := sent.Get("X-Amz-Server-Side-Encryption"); got != tt.want {
+				t.Errorf("X-Amz-Server-Side-Encryption = %q, want %q", got, tt.want)
+			}
+			if got := sent.Get("X-Amz-Server-Side-Encryption-Aws-Kms-Key-Id"); got != tt.keyID {
+				t.Errorf("KMS key id = %q, want %q", got, tt.keyID)
+			}
+
+			stat, err := client.client.StatObject(ctx, "bucket", "game.sav", minio.StatObjectOptions{})
+			if err != nil {
+				t.Fatalf("StatObject() error = %v", err)
+			}
+			if err := client.checkSSE("game.sav", stat); err != nil {
+				t.Errorf("checkSSE() error = %v", err)
+			}
+		})
+	}
+}
+
+func TestUploadServerSideEncryptionUnsupported(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "file")
+	if err := os.WriteFile(path, []byte("save data"), 0644); err != nil {
+		t.Fatalf("WriteFile() error = %v", err)
+	}
+
+	fake := &fakeS3{noSSE: true}
+	client := newFakeS3Client(t, fake)
+	client.serverSide, _ = serverSide(config.S3Config{SSE: config.SSEKMS})
+	err := client.Upload(context.Background(), path, "game.sav")
+	if err == nil || !strings.Contains(err.Error(), "cannot store objects with SSE-KMS") {
+		t.Errorf("Upload() error = %v, want one naming SSE-KMS", err)
+	}
+}
+
+func TestCheckSSE(t *testing.T) {
+	client := &S3Client{}
+	client.serverSide, _ = serverSide(config.S3Config{SSE: config.SSES3})
+
+	tests := []struct {
+		name    string
+		header  string
+		wantErr bool
+	}{
+		{name: "expected", header: "AES256"},
+		{name: "unencrypted", wantErr: true},
+		{name: "other mode", header: "aws:kms", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			stat := minio.ObjectInfo{Metadata: http.Header{}}
+			if tt.header != "" {
+				stat.Metadata.Set("X-Amz-Server-Side-Encryption", tt.header)
+			}
+			if err := client.checkSSE("game.sav", stat); (err != nil) != tt.wantErr {
+				t.Errorf("checkSSE() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+
+	// Without -sse any object will do
+	if err := (&S3Client{}).checkSSE("game.sav", minio.ObjectInfo{}); err != nil {
+		t.Errorf("checkSSE() without sse error = %v", err)
+	}
+}
@@ -0,0 +1,47 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+)
+
+// clockSkewTimeout bounds the request ClockSkew makes
+const clockSkewTimeout = 10 * time.Second
+
+// serverClockSkew reads the server's clock from the Date header that any
+// HTTP response from endpoint carries, even an access error, and returns
+// how far it is ahead of the local clock (negative when behind). The header
+// has a resolution of one second, so the result is only accurate to about
+// half a second.
+func serverClockSkew(ctx context.Context, client *http.Client, endpoint string) (time.Duration, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodHead, endpoint, nil)
+	if err != nil {
+		return 0, err
+	}
+
+	sent := time.Now()
+	resp, err := client.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	resp.Body.Close()
+	received := time.Now()
+
+	header := resp.Header.Get("Date")
+	if header == "" {
+		return 0, errors.New("server sent no Date header")
+	}
+	serverTime, err := http.ParseTime(header)
+	if err != nil {
+		return 0, fmt.Errorf("invalid Date header %q: %w", header, err)
+	}
+
+	// The header is truncated to the second and was stamped somewhere
+	// between sending and receiving
+	serverTime = serverTime.Add(500 * time.Millisecond)
+	local := sent.Add(received.Sub(sent) / 2)
+	return serverTime.Sub(local), nil
+}
@@ -0,0 +1,50 @@
+package storage
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestServerClockSkew(t *testing.T) {
+	tests := []struct {
+		name   string
+		offset time.Duration // server clock minus local clock
+	}{
+		{name: "in step", offset: 0},
+		{name: "server ahead", offset: 7 * time.Second},
+		{name: "server behind", offset: -90 * time.Second},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				w.Header().Set("Date", time.Now().Add(tt.offset).UTC().Format(http.TimeFormat))
+				// Access errors still carry the server's time
+				w.WriteHeader(http.StatusForbidden)
+			}))
+			defer server.Close()
+
+			skew, err := serverClockSkew(context.Background(), server.Client(), server.URL)
+			if err != nil {
+				t.Fatalf("serverClockSkew() error = %v", err)
+			}
+			if diff := skew - tt.offset; diff < -time.Second || diff > time.Second {
+				t.Errorf("serverClockSkew() = %v, want %v within a second", skew, tt.offset)
+			}
+		})
+	}
+}
+
+func TestServerClockSkewNoDate(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header()["Date"] = nil // suppress the default header
+	}))
+	defer server.Close()
+
+	if _, err := serverClockSkew(context.Background(), server.Client(), server.URL); err == nil {
+		t.Error("serverClockSkew() error = nil, want an error without a Date header")
+	}
+}
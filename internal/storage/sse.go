@@ -0,0 +1,70 @@
+package storage
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/danielbehrens/cloudsync/internal/config"
+	"github.com/minio/minio-go/v7"
+	sse "github.com/minio/minio-go/v7/pkg/encrypt"
+)
+
+// serverSide returns the server-side encryption cfg.SSE selects, or nil for
+// none
+func serverSide(cfg config.S3Config) (sse.ServerSide, error) {
+	switch cfg.SSE {
+	case config.SSES3:
+		return sse.NewSSE(), nil
+	case config.SSEKMS:
+		return sse.NewSSEKMS(cfg.SSEKMSKeyID, nil)
+	}
+	return nil, nil
+}
+
+// sseName returns the name of a server-side encryption type for messages
+func sseName(t sse.Type) string {
+	if t == sse.KMS {
+		return "SSE-KMS"
+	}
+	return "SSE-S3"
+}
+
+// sseHeader returns the X-Amz-Server-Side-Encryption value a server
+// reports for objects stored with a server-side encryption type
+func sseHeader(t sse.Type) string {
+	if t == sse.KMS {
+		return "aws:kms"
+	}
+	return "AES256"
+}
+
+// sseUploadError explains an upload the server rejected because it can't
+// encrypt with the requested server-side encryption, or returns err as is
+func (s *S3Client) sseUploadError(err error) error {
+	if s.serverSide == nil {
+		return err
+	}
+	code := minio.ToErrorResponse(err).Code
+	if code == "NotImplemented" || strings.HasPrefix(code, "KMS.") {
+		return fmt.Errorf("the server cannot store objects with %s, check -sse and -sse-kms-key-id: %w", sseName(s.serverSide.Type()), err)
+	}
+	return err
+}
+
+// checkSSE returns an error if stat doesn't report the server-side
+// encryption uploads ask for, e.g. for an object uploaded before -sse was
+// set or by a server that ignored the request
+func (s *S3Client) checkSSE(objectName string, stat minio.ObjectInfo) error {
+	if s.serverSide == nil {
+		return nil
+	}
+	want := sseHeader(s.serverSide.Type())
+	got := stat.Metadata.Get(sse.SseGenericHeader)
+	if got == want {
+		return nil
+	}
+	if got == "" {
+		return fmt.Errorf("%s is not stored with %s; upload it again to encrypt it", objectName, sseName(s.serverSide.Type()))
+	}
+	return fmt.Errorf("%s is stored with server-side encryption %s, not %s", objectName, got, sseName(s.serverSide.Type()))
+}
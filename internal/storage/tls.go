@@ -0,0 +1,51 @@
+package storage
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"os"
+
+	"github.com/danielbehrens/cloudsync/internal/config"
+)
+
+// newTransport returns the HTTP transport for the TLS settings in cfg, or
+// nil to use the client's default when there are none
+func newTransport(cfg config.S3Config) (*http.Transport, error) {
+	if cfg.CACert == "" && !cfg.InsecureSkipVerify {
+		return nil, nil
+	}
+
+	if cfg.InsecureSkipVerify {
+		slog.Warn("TLS certificate verification is off (-insecure-skip-verify); the connection to the endpoint can be intercepted")
+	}
+
+	tlsConfig := &tls.Config{
+		MinVersion:         tls.VersionTLS12,
+		InsecureSkipVerify: cfg.InsecureSkipVerify,
+	}
+
+	if cfg.CACert != "" {
+		pem, err := os.ReadFile(cfg.CACert)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read CA certificate: %w", err)
+		}
+
+		// Trust the CA on top of the system roots, so the same settings
+		// work when the endpoint later gets a public certificate
+		pool, err := x509.SystemCertPool()
+		if err != nil {
+			pool = x509.NewCertPool()
+		}
+		if !pool.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("no PEM certificates in %s", cfg.CACert)
+		}
+		tlsConfig.RootCAs = pool
+	}
+
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.TLSClientConfig = tlsConfig
+	return transport, nil
+}
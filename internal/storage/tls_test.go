@@ -0,0 +1,67 @@
+package storage
+
+import (
+	"encoding/pem"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/danielbehrens/cloudsync/internal/config"
+)
+
+func TestNewTransport(t *testing.T) {
+	server := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer server.Close()
+
+	caFile := filepath.Join(t.TempDir(), "ca.pem")
+	certPEM := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: server.Certificate().Raw})
+	if err := os.WriteFile(caFile, certPEM, 0644); err != nil {
+		t.Fatal(err)
+	}
+	badFile := filepath.Join(t.TempDir(), "bad.pem")
+	if err := os.WriteFile(badFile, []byte("not a certificate"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	get := func(transport *http.Transport) error {
+		client := &http.Client{}
+		if transport != nil {
+			client.Transport = transport
+		}
+		resp, err := client.Get(server.URL)
+		if err == nil {
+			resp.Body.Close()
+		}
+		return err
+	}
+
+	tests := []struct {
+		name       string
+		cfg        config.S3Config
+		wantErr    bool
+		wantVerify bool // the request to the self-signed server succeeds
+	}{
+		{name: "defaults", cfg: config.S3Config{}},
+		{name: "custom CA", cfg: config.S3Config{CACert: caFile}, wantVerify: true},
+		{name: "skip verify", cfg: config.S3Config{InsecureSkipVerify: true}, wantVerify: true},
+		{name: "missing CA file", cfg: config.S3Config{CACert: filepath.Join(t.TempDir(), "missing.pem")}, wantErr: true},
+		{name: "no certificates", cfg: config.S3Config{CACert: badFile}, wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			transport, err := newTransport(tt.cfg)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("newTransport() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if err != nil {
+				return
+			}
+			if err := get(transport); (err == nil) != tt.wantVerify {
+				t.Errorf("request to self-signed server error = %v, want success %v", err, tt.wantVerify)
+			}
+		})
+	}
+}
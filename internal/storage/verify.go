@@ -0,0 +1,61 @@
+package storage
+
+import (
+	"crypto/md5"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+)
+
+// ErrDownloadCorrupt means a downloaded file doesn't match the object it
+// was read from, e.g. because the transfer was cut short. It is retried
+// like other transient errors.
+var ErrDownloadCorrupt = errors.New("downloaded file does not match the stored object")
+
+// verifyDownload checks the file at path against what the server reported
+// for the object: its size, the SHA-256 recorded at upload and its MD5
+// ETag. Empty values (and sizes below zero) are not checked; callers pass
+// an empty ETag when it isn't a plain MD5 of the content, as for multipart
+// or transformed uploads.
+func verifyDownload(path string, size int64, etag, checksum string) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return fmt.Errorf("failed to verify download: %w", err)
+	}
+	defer f.Close()
+
+	md5Hash, shaHash := md5.New(), sha256.New()
+	n, err := io.Copy(io.MultiWriter(md5Hash, shaHash), f)
+	if err != nil {
+		return fmt.Errorf("failed to verify download: %w", err)
+	}
+
+	if size >= 0 && n != size {
+		return fmt.Errorf("%w: got %d bytes, want %d", ErrDownloadCorrupt, n, size)
+	}
+	if got := hex.EncodeToString(shaHash.Sum(nil)); checksum != "" && !strings.EqualFold(got, checksum) {
+		return fmt.Errorf("%w: SHA-256 is %s, want %s", ErrDownloadCorrupt, got, checksum)
+	}
+	etag = strings.Trim(etag, `"`)
+	if got := hex.EncodeToString(md5Hash.Sum(nil)); etag != "" && !strings.EqualFold(got, etag) {
+		return fmt.Errorf("%w: MD5 is %s, want ETag %s", ErrDownloadCorrupt, got, etag)
+	}
+
+	return nil
+}
+
+// isMD5ETag reports whether etag is a plain MD5 of the object's content,
+// which single-part uploads have and multipart uploads (with a -N suffix)
+// don't
+func isMD5ETag(etag string) bool {
+	etag = strings.Trim(etag, `"`)
+	if len(etag) != md5.Size*2 {
+		return false
+	}
+	_, err := hex.DecodeString(etag)
+	return err == nil
+}
@@ -0,0 +1,105 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/minio/minio-go/v7"
+)
+
+// ErrVersioningDisabled is returned by ListVersions for buckets that have
+// never had versioning enabled, which keep only the latest object
+var ErrVersioningDisabled = errors.New("bucket versioning is not enabled")
+
+// VersionInfo describes one stored version of an object
+type VersionInfo struct {
+	VersionID    string
+	ModTime      time.Time // of the file when uploaded, as for FileInfo
+	Size         int64
+	IsLatest     bool
+	DeleteMarker bool // the object was deleted; there is nothing to download
+}
+
+// ListVersions returns the versions of objectName kept by a versioned
+// bucket, newest first. Buckets whose versioning was enabled and later
+// suspended still return the versions kept meanwhile.
+func (s *S3Client) ListVersions(ctx context.Context, objectName string) ([]*VersionInfo, error) {
+	var versioning minio.BucketVersioningConfiguration
+	err := withRetry(ctx, s.retry, "check versioning", func() (err error) {
+		versioning, err = s.client.GetBucketVersioning(ctx, s.bucketName)
+		return err
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to check bucket versioning: %w", err)
+	}
+	if versioning.Status == "" {
+		return nil, fmt.Errorf("bucket %s: %w", s.bucketName, ErrVersioningDisabled)
+	}
+
+	var listed []minio.ObjectInfo
+	err = withRetry(ctx, s.retry, "list versions of "+objectName, func() error {
+		listed = listed[:0]
+		listCtx, cancel := context.WithCancel(ctx)
+		defer cancel()
+
+		objectCh := s.client.ListObjects(listCtx, s.bucketName, minio.ListObjectsOptions{
+			Prefix:       s.key(objectName),
+			Recursive:    true,
+			WithVersions: true,
+		})
+		for object := range objectCh {
+			if object.Err != nil {
+				return object.Err
+			}
+			// The prefix also matches longer names
+			if object.Key == s.key(objectName) {
+				listed = append(listed, object)
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("error listing versions: %w", err)
+	}
+
+	// The listing lacks the user metadata holding the file's mod time and
+	// size, so stat each version
+	versions := make([]*VersionInfo, 0, len(listed))
+	for _, object := range listed {
+		v := &VersionInfo{
+			VersionID:    object.VersionID,
+			ModTime:      object.LastModified,
+			IsLatest:     object.IsLatest,
+			DeleteMarker: object.IsDeleteMarker,
+		}
+		if !object.IsDeleteMarker {
+			var stat minio.ObjectInfo
+			err := withRetry(ctx, s.retry, "stat "+objectName, func() (err error) {
+				stat, err = s.client.StatObject(ctx, s.bucketName, s.key(objectName), minio.StatObjectOptions{VersionID: object.VersionID})
+				return err
+			})
+			if err != nil {
+				return nil, fmt.Errorf("failed to stat version %s: %w", object.VersionID, err)
+			}
+			info := objectFileInfo(stat)
+			v.ModTime, v.Size = info.ModTime, info.Size
+		}
+		versions = append(versions, v)
+	}
+
+	return versions, nil
+}
+
+// DownloadVersion downloads the given version of objectName like Download
+func (s *S3Client) DownloadVersion(ctx context.Context, objectName, versionID, localPath string) error {
+	err := withRetry(ctx, s.retry, "download "+objectName+" version "+versionID, func() error {
+		return s.download(ctx, objectName, versionID, localPath)
+	})
+	if err != nil {
+		return fmt.Errorf("failed to download version %s: %w", versionID, err)
+	}
+
+	return nil
+}
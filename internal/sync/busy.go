@@ -0,0 +1,78 @@
+package sync
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"time"
+)
+
+// ErrFileBusy is returned for a local file that another process, usually
+// the game in the middle of a save, holds open for writing. Uploading it
+// could send a torn write, so SyncFile retries it later instead.
+var ErrFileBusy = errors.New("file is busy")
+
+// busyAttempts is how many times SyncFile tries a busy file before giving
+// up on it until the next change or full sync
+const busyAttempts = 5
+
+// defaultBusyRetryDelay is the wait before the first retry of a busy file.
+// It doubles with each further attempt.
+const defaultBusyRetryDelay = time.Second
+
+// checkFileBusy returns an error wrapping ErrFileBusy if filePath is held
+// open for writing by another process. Only Windows has such locks.
+func (s *Syncer) checkFileBusy(filePath string) error {
+	check := s.fileBusy
+	if check == nil {
+		check = fileBusy
+	}
+	if check(filePath) {
+		return fmt.Errorf("%s: %w", filePath, ErrFileBusy)
+	}
+	return nil
+}
+
+// busyError wraps err with ErrFileBusy if it is a sharing violation, so a
+// lock taken after checkFileBusy still leads to a retry
+func busyError(err error) error {
+	if isSharingViolation(err) {
+		return fmt.Errorf("%w: %w", ErrFileBusy, err)
+	}
+	return err
+}
+
+// retryBusy schedules another sync of the busy filePath, unless one is
+// already pending. It reports false once the attempts are used up.
+func (s *Syncer) retryBusy(ctx context.Context, filePath string, attempt int) bool {
+	if attempt >= busyAttempts {
+		return false
+	}
+
+	s.busyMu.Lock()
+	defer s.busyMu.Unlock()
+	if s.busyPending[filePath] {
+		return true
+	}
+	if s.busyPending == nil {
+		s.busyPending = make(map[string]bool)
+	}
+	s.busyPending[filePath] = true
+
+	delay := s.busyRetryDelay << (attempt - 1)
+	slog.Info("File busy, will retry", "path", filePath, "delay", delay)
+	time.AfterFunc(delay, func() {
+		s.busyMu.Lock()
+		delete(s.busyPending, filePath)
+		s.busyMu.Unlock()
+
+		if ctx.Err() != nil || s.PauseReason() != "" {
+			return
+		}
+		if err := s.syncFileAttempt(ctx, filePath, attempt+1); err != nil && ctx.Err() == nil {
+			slog.Error("Failed to sync", "path", filePath, "err", err)
+		}
+	})
+	return true
+}
@@ -0,0 +1,14 @@
+//go:build !windows
+
+package sync
+
+// fileBusy always reports false: other systems have no mandatory locks, so
+// a file being written can't be detected by opening it
+func fileBusy(path string) bool {
+	return false
+}
+
+// isSharingViolation always reports false, see fileBusy
+func isSharingViolation(err error) bool {
+	return false
+}
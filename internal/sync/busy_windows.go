@@ -0,0 +1,34 @@
+package sync
+
+import (
+	"errors"
+	"syscall"
+)
+
+// Windows errors for a file locked by another process
+const (
+	errorSharingViolation syscall.Errno = 32
+	errorLockViolation    syscall.Errno = 33
+)
+
+// fileBusy opens path allowing others to read only. That fails with a
+// sharing violation while another process has it open for writing.
+func fileBusy(path string) bool {
+	p, err := syscall.UTF16PtrFromString(path)
+	if err != nil {
+		return false
+	}
+	h, err := syscall.CreateFile(p, syscall.GENERIC_READ, syscall.FILE_SHARE_READ, nil, syscall.OPEN_EXISTING, syscall.FILE_ATTRIBUTE_NORMAL, 0)
+	if err != nil {
+		// Other errors surface when the file is read for real
+		return isSharingViolation(err)
+	}
+	syscall.CloseHandle(h)
+	return false
+}
+
+// isSharingViolation reports whether err is due to another process locking
+// the file
+func isSharingViolation(err error) bool {
+	return errors.Is(err, errorSharingViolation) || errors.Is(err, errorLockViolation)
+}
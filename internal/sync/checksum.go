@@ -0,0 +1,62 @@
+package sync
+
+import (
+	"crypto/md5"
+	"encoding/hex"
+	"log/slog"
+	"strings"
+
+	"github.com/danielbehrens/cloudsync/internal/fsutil"
+)
+
+// contentMatches compares local hashes with what the cloud object records.
+// The stored SHA-256 is preferred. Without it, a plain ETag is the MD5 of
+// the object, except for multipart uploads ("<hash>-<parts>") and some
+// encrypted objects, whose ETags are not content hashes. known is false when
+// neither can be compared, in which case match is meaningless.
+func contentMatches(localSHA, localMD5 string, cloud *SyncFileInfo) (match, known bool) {
+	if cloud.Checksum != "" && localSHA != "" {
+		return strings.EqualFold(cloud.Checksum, localSHA), true
+	}
+
+	etag := strings.Trim(cloud.ETag, `"`)
+	if localMD5 != "" && len(etag) == md5.Size*2 && !strings.Contains(etag, "-") {
+		return strings.EqualFold(etag, localMD5), true
+	}
+
+	return false, false
+}
+
+// contentState is the outcome of comparing local and cloud content
+type contentState int
+
+const (
+	contentUnknown contentState = iota // ChecksumMode off or no comparable hash
+	contentSame
+	contentDifferent
+)
+
+// compareContent hashes localPath and compares it with the cloud object
+// when ChecksumMode is on
+func (s *Syncer) compareContent(localPath string, cloud *SyncFileInfo) contentState {
+	if !s.ChecksumMode {
+		return contentUnknown
+	}
+
+	md := md5.New()
+	sha, err := fsutil.FileSHA256(localPath, md)
+	if err != nil {
+		slog.Warn("Failed to checksum, comparing mod times instead", "path", localPath, "err", err)
+		return contentUnknown
+	}
+
+	match, known := contentMatches(sha, hex.EncodeToString(md.Sum(nil)), cloud)
+	switch {
+	case !known:
+		return contentUnknown
+	case match:
+		return contentSame
+	default:
+		return contentDifferent
+	}
+}
@@ -0,0 +1,81 @@
+package sync
+
+import (
+	"errors"
+	"fmt"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// ErrNameCollision is returned for a file whose object name differs from
+// another file's only in case. Windows and macOS file systems store the two
+// as one file, so syncing both would overwrite one with the other on some
+// machine.
+var ErrNameCollision = errors.New("object name collides with another file")
+
+// foldName returns the key two object names share when a case-insensitive
+// file system would store them as one file
+func foldName(name string) string {
+	return strings.ToLower(name)
+}
+
+// markCollisions refuses every item whose name folds to the same key as
+// another's, local or cloud, instead of letting them overwrite each other
+func markCollisions(items map[string]*PlanItem) {
+	groups := make(map[string][]string)
+	for name := range items {
+		key := foldName(name)
+		groups[key] = append(groups[key], name)
+	}
+
+	for _, names := range groups {
+		if len(names) < 2 {
+			continue
+		}
+		sort.Strings(names)
+		for _, name := range names {
+			err := collisionError(name, names)
+			slog.Warn("Not syncing", "file", name, "err", err)
+			items[name].Action, items[name].Reason = PlanSkip, err.Error()
+		}
+	}
+}
+
+// collisionError reports that name collides with the other names
+func collisionError(name string, names []string) error {
+	var others []string
+	for _, other := range names {
+		if other != name {
+			others = append(others, other)
+		}
+	}
+	return fmt.Errorf("%w: %s and %s differ only in case; rename one", ErrNameCollision, name, strings.Join(others, ", "))
+}
+
+// checkLocalCollision returns an ErrNameCollision if another syncable file
+// beside filePath has a name differing from it only in case
+func (s *Syncer) checkLocalCollision(filePath, objectName string) error {
+	dir, base := filepath.Split(filePath)
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil
+	}
+
+	names := []string{objectName}
+	prefix := strings.TrimSuffix(objectName, base)
+	for _, e := range entries {
+		if e.IsDir() || e.Name() == base || !strings.EqualFold(e.Name(), base) {
+			continue
+		}
+		if s.shouldSyncFile(filepath.Join(dir, e.Name())) {
+			names = append(names, prefix+e.Name())
+		}
+	}
+	if len(names) == 1 {
+		return nil
+	}
+	return collisionError(objectName, names)
+}
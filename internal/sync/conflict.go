@@ -0,0 +1,210 @@
+package sync
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"os"
+	"time"
+
+	"github.com/danielbehrens/cloudsync/internal/fsutil"
+)
+
+// ConflictStrategy decides which side wins when a file changed both locally
+// and in the cloud since it was last synced
+type ConflictStrategy string
+
+const (
+	// ConflictNewerWins transfers the side with the newer mod time, backing
+	// up the other as for any sync. This is the default.
+	ConflictNewerWins ConflictStrategy = "newer-wins"
+
+	// ConflictKeepBoth keeps the local file, saves the cloud version beside
+	// it as <name>.conflict-<timestamp> and uploads the local file
+	ConflictKeepBoth ConflictStrategy = "keep-both"
+
+	// ConflictLocalWins always uploads the local file
+	ConflictLocalWins ConflictStrategy = "local-wins"
+
+	// ConflictCloudWins always downloads the cloud version
+	ConflictCloudWins ConflictStrategy = "cloud-wins"
+)
+
+// conflictSuffix is inserted between a file name and the timestamp of a
+// kept conflicting copy
+const conflictSuffix = ".conflict-"
+
+// baseline returns the recorded state of objectName, if any
+func (s *Syncer) baseline(objectName string) (fileBaseline, bool) {
+	if s.StateFile == "" {
+		return fileBaseline{}, false
+	}
+
+	s.stateMu.Lock()
+	defer s.stateMu.Unlock()
+
+	state, err := s.loadState()
+	if err != nil {
+		slog.Warn("Conflict detection disabled", "file", objectName, "err", err)
+		return fileBaseline{}, false
+	}
+
+	base, ok := state.Files[objectName]
+	return base, ok
+}
+
+// recordSync stores localPath's current content and mod time as the
+// baseline for objectName. Failures are logged; without a baseline the next
+// sync of the file simply can't detect a conflict.
+func (s *Syncer) recordSync(objectName, localPath string) {
+	if s.DryRun {
+		return
+	}
+	syncedAt := time.Now().UTC()
+	s.setLastSynced(objectName, syncedAt)
+	if s.StateFile == "" {
+		return
+	}
+
+	info, err := os.Stat(localPath)
+	if err != nil {
+		slog.Warn("Failed to record sync state", "file", objectName, "err", err)
+		return
+	}
+	sha, err := fsutil.FileSHA256(localPath)
+	if err != nil {
+		slog.Warn("Failed to record sync state", "file", objectName, "err", err)
+		return
+	}
+
+	s.stateMu.Lock()
+	defer s.stateMu.Unlock()
+
+	state, err := s.loadState()
+	if err != nil {
+		slog.Warn("Failed to record sync state", "file", objectName, "err", err)
+		return
+	}
+	state.Files[objectName] = fileBaseline{SHA256: sha, ModTime: info.ModTime().UTC(), SyncedAt: syncedAt}
+
+	if err := s.saveState(state); err != nil {
+		slog.Warn("Failed to record sync state", "file", objectName, "err", err)
+	}
+}
+
+// forgetSync drops the baseline of objectName once it has been deleted, so
+// a file of the same name later is treated as new
+func (s *Syncer) forgetSync(objectName string) {
+	if s.DryRun {
+		return
+	}
+
+	s.stateMu.Lock()
+	defer s.stateMu.Unlock()
+
+	delete(s.lastSynced, objectName)
+	if s.StateFile == "" {
+		return
+	}
+
+	state, err := s.loadState()
+	if err != nil {
+		slog.Warn("Failed to update sync state", "file", objectName, "err", err)
+		return
+	}
+	if _, ok := state.Files[objectName]; !ok {
+		return
+	}
+	delete(state.Files, objectName)
+
+	if err := s.saveState(state); err != nil {
+		slog.Warn("Failed to update sync state", "file", objectName, "err", err)
+	}
+}
+
+// inConflict reports whether localPath and the cloud object both changed
+// since the recorded baseline and now differ from each other. Without a
+// baseline nothing is a conflict. The local side is compared by content;
+// the cloud side by its stored checksum or, lacking one, its mod time.
+func (s *Syncer) inConflict(objectName, localPath string, cloud *SyncFileInfo) bool {
+	base, ok := s.baseline(objectName)
+	if !ok {
+		return false
+	}
+
+	localSHA, err := fsutil.FileSHA256(localPath)
+	if err != nil {
+		slog.Warn("Failed to checksum, skipping conflict check", "path", localPath, "err", err)
+		return false
+	}
+	if localSHA == base.SHA256 {
+		return false
+	}
+
+	if cloud.Checksum != "" {
+		return cloud.Checksum != base.SHA256 && cloud.Checksum != localSHA
+	}
+	return decideAction(base.ModTime, cloud.ModTime, s.toleranceFor(cloud.CoarseModTime)) != actionNone
+}
+
+// resolveConflict applies ConflictStrategy to a file that changed on both
+// sides
+func (s *Syncer) resolveConflict(ctx context.Context, objectName, localPath string, cloud *SyncFileInfo) error {
+	strategy := s.ConflictStrategy
+	if strategy == "" {
+		strategy = ConflictNewerWins
+	}
+	// A one-way sync only ever moves the file its way
+	if !s.canDownload() {
+		strategy = ConflictLocalWins
+	} else if !s.canUpload() {
+		strategy = ConflictCloudWins
+	}
+	slog.Info("Conflict: changed both locally and in the cloud since the last sync", "file", objectName, "strategy", strategy)
+
+	switch strategy {
+	case ConflictLocalWins:
+		return s.backupAndUpload(ctx, localPath, objectName, cloud.ModTime)
+	case ConflictCloudWins:
+		return s.downloadAndReplace(ctx, objectName, localPath, cloud)
+	case ConflictKeepBoth:
+		if err := s.keepConflictCopy(ctx, objectName, localPath, cloud.ModTime); err != nil {
+			return err
+		}
+		return s.backupAndUpload(ctx, localPath, objectName, cloud.ModTime)
+	case ConflictNewerWins:
+		info, err := os.Stat(localPath)
+		if err != nil {
+			return fmt.Errorf("failed to stat file: %w", err)
+		}
+		if decideAction(info.ModTime().UTC(), cloud.ModTime, s.toleranceFor(cloud.CoarseModTime)) == actionDownload {
+			return s.downloadAndReplace(ctx, objectName, localPath, cloud)
+		}
+		return s.backupAndUpload(ctx, localPath, objectName, cloud.ModTime)
+	default:
+		return fmt.Errorf("unknown conflict strategy %q", strategy)
+	}
+}
+
+// keepConflictCopy downloads the cloud version of objectName next to
+// localPath as <name>.conflict-<timestamp>, which the default file filter
+// never syncs
+func (s *Syncer) keepConflictCopy(ctx context.Context, objectName, localPath string, modTime time.Time) error {
+	conflictPath := localPath + conflictSuffix + time.Now().Format(backupTimeLayout)
+
+	if s.DryRun {
+		slog.Info("[dry-run] Would save cloud version", "file", objectName, "path", conflictPath)
+		return nil
+	}
+
+	if err := s.storage.Download(ctx, objectName, conflictPath); err != nil {
+		os.Remove(conflictPath)
+		return fmt.Errorf("failed to download conflicting version: %w", err)
+	}
+	if err := os.Chtimes(conflictPath, modTime, modTime); err != nil {
+		slog.Warn("Failed to set mod time", "path", conflictPath, "err", err)
+	}
+
+	slog.Info("Saved cloud version", "file", objectName, "path", conflictPath)
+	return nil
+}
@@ -0,0 +1,155 @@
+package sync
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// DuplicateGroup is a set of cloud objects holding byte-identical content
+type DuplicateGroup struct {
+	Checksum string
+	Size     int64
+
+	// Keep is the canonical key that survives deduplication
+	Keep string
+
+	// Redundant are the other keys holding the same content
+	Redundant []string
+}
+
+// FindDuplicates groups cloud objects by checksum and size and returns every
+// group with more than one key, sorted by canonical key. Objects without a
+// stored checksum (uploaded before checksums were recorded) cannot be proven
+// identical and are never reported. Nothing is modified.
+func (s *Syncer) FindDuplicates(ctx context.Context) ([]DuplicateGroup, error) {
+	cloudFiles, err := s.storage.List(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list cloud files: %w", err)
+	}
+
+	type contentKey struct {
+		checksum string
+		size     int64
+	}
+
+	byContent := make(map[contentKey][]string)
+	unchecked := 0
+	for _, f := range cloudFiles {
+		if f.Checksum == "" {
+			unchecked++
+			continue
+		}
+		k := contentKey{f.Checksum, f.Size}
+		byContent[k] = append(byContent[k], f.Name)
+	}
+
+	if unchecked > 0 {
+		slog.Info("Skipped objects without a stored checksum", "count", unchecked)
+	}
+
+	// Compare against the directory listing rather than stat so a
+	// case-insensitive file system does not match a differently cased key
+	local := make(map[string]bool)
+	if files, _, err := s.listFiles(s.watchPath); err == nil {
+		for _, f := range files {
+			local[f.name] = true
+		}
+	}
+
+	var groups []DuplicateGroup
+	for k, names := range byContent {
+		if len(names) < 2 {
+			continue
+		}
+
+		sort.Slice(names, func(i, j int) bool {
+			return canonicalBefore(local, names[i], names[j])
+		})
+
+		groups = append(groups, DuplicateGroup{
+			Checksum:  k.checksum,
+			Size:      k.size,
+			Keep:      names[0],
+			Redundant: names[1:],
+		})
+	}
+
+	sort.Slice(groups, func(i, j int) bool {
+		return groups[i].Keep < groups[j].Keep
+	})
+
+	return groups, nil
+}
+
+// canonicalBefore reports whether key a is a better canonical key than b.
+// Keys naming a file in the watch path win, then flat keys, then the
+// shortest, then the lexically smallest.
+func canonicalBefore(local map[string]bool, a, b string) bool {
+	if local[a] != local[b] {
+		return local[a]
+	}
+
+	aFlat, bFlat := !strings.Contains(a, "/"), !strings.Contains(b, "/")
+	if aFlat != bFlat {
+		return aFlat
+	}
+
+	if len(a) != len(b) {
+		return len(a) < len(b)
+	}
+	return a < b
+}
+
+// RemoveDuplicates deletes the redundant keys of each group, returning how
+// many objects were removed. Every redundant object is first downloaded into
+// a timestamped backup folder, and the canonical key is re-checked before
+// each delete so the last copy of any content is never removed.
+func (s *Syncer) RemoveDuplicates(ctx context.Context, groups []DuplicateGroup) (int, error) {
+	if len(groups) == 0 {
+		return 0, nil
+	}
+
+	backupPath, err := s.createTimestampedBackupDir()
+	if err != nil {
+		return 0, fmt.Errorf("failed to create backup directory: %w", err)
+	}
+
+	removed := 0
+	for _, g := range groups {
+		for _, key := range g.Redundant {
+			if err := ctx.Err(); err != nil {
+				return removed, err
+			}
+			if key == g.Keep {
+				continue
+			}
+
+			keep, err := s.storage.Stat(ctx, g.Keep)
+			if err != nil {
+				return removed, fmt.Errorf("canonical copy %s is unavailable, not removing %s: %w", g.Keep, key, err)
+			}
+			if keep.Checksum != g.Checksum || keep.Size != g.Size {
+				return removed, fmt.Errorf("canonical copy %s changed, not removing %s", g.Keep, key)
+			}
+
+			backupFile := filepath.Join(backupPath, strings.ReplaceAll(key, "/", "_"))
+			if err := s.storage.Download(ctx, key, backupFile); err != nil {
+				return removed, fmt.Errorf("failed to back up %s: %w", key, err)
+			}
+
+			if err := s.storage.Delete(ctx, key); err != nil && !errors.Is(err, ErrNotExist) {
+				return removed, fmt.Errorf("failed to delete %s: %w", key, err)
+			}
+
+			slog.Info("Removed duplicate", "file", key, "kept", g.Keep, "backup", backupFile)
+			removed++
+		}
+	}
+
+	return removed, nil
+}
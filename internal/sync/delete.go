@@ -0,0 +1,226 @@
+package sync
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/danielbehrens/cloudsync/internal/fsutil"
+)
+
+// tombstoneSuffix names the object recording that the object without the
+// suffix was deleted. The tombstone's mod time is the time of the deletion.
+const tombstoneSuffix = ".cloudsync-deleted"
+
+// tombstoneName returns the name of objectName's tombstone
+func tombstoneName(objectName string) string {
+	return objectName + tombstoneSuffix
+}
+
+// isTombstone reports whether name is a tombstone rather than a save
+func isTombstone(name string) bool {
+	return strings.HasSuffix(name, tombstoneSuffix)
+}
+
+// DeleteFile propagates the local deletion of localPath when
+// PropagateDeletes is set: the cloud copy is saved to a backup folder,
+// a tombstone tells other machines to remove their copy, and the object is
+// deleted. Nothing happens with DirectionDownloadOnly, if the file is
+// back, was never synced from this machine, or changed in the cloud since it
+// was last synced; in the last case the next sync downloads it again.
+func (s *Syncer) DeleteFile(ctx context.Context, localPath string) error {
+	if !s.PropagateDeletes || !s.canUpload() || fileExists(localPath) || !s.shouldSyncFile(localPath) {
+		return nil
+	}
+
+	objectName, err := s.objectName(localPath)
+	if err != nil {
+		return err
+	}
+
+	cloud, err := s.storage.Stat(ctx, objectName)
+	if errors.Is(err, ErrNotExist) {
+		s.forgetSync(objectName)
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("failed to stat cloud copy: %w", err)
+	}
+
+	if !s.deletedLocally(cloud) {
+		slog.Info("Not deleting from the cloud: it changed there since this machine last synced it", "file", objectName)
+		return nil
+	}
+
+	return s.deleteFromCloud(ctx, objectName, cloud)
+}
+
+// deletedLocally reports whether the missing local copy of cloud was
+// deleted rather than never downloaded: it was synced before, and the cloud
+// version is still the one synced
+func (s *Syncer) deletedLocally(cloud *SyncFileInfo) bool {
+	base, ok := s.baseline(cloud.Name)
+	return ok && !s.changedSince(base, cloud)
+}
+
+// changedSince reports whether the cloud version differs from the baseline,
+// by its stored checksum or, lacking one, its mod time
+func (s *Syncer) changedSince(base fileBaseline, cloud *SyncFileInfo) bool {
+	if cloud.Checksum != "" {
+		return cloud.Checksum != base.SHA256
+	}
+	return decideAction(base.ModTime, cloud.ModTime, s.toleranceFor(cloud.CoarseModTime)) != actionNone
+}
+
+// deleteFromCloud backs up the cloud version of objectName, writes its
+// tombstone and deletes it. The tombstone goes first: if the delete then
+// fails, the object is still there and the next sync tries again.
+func (s *Syncer) deleteFromCloud(ctx context.Context, objectName string, cloud *SyncFileInfo) error {
+	if s.DryRun {
+		slog.Info("[dry-run] Would delete from the cloud and leave a tombstone", "file", objectName)
+		return nil
+	}
+
+	if err := s.backupCloudCopy(ctx, objectName, cloud.ModTime); err != nil {
+		return err
+	}
+	if err := s.writeTombstone(ctx, objectName, time.Now()); err != nil {
+		return err
+	}
+	if err := s.storage.Delete(ctx, objectName); err != nil && !errors.Is(err, ErrNotExist) {
+		return fmt.Errorf("failed to delete %s from the cloud: %w", objectName, err)
+	}
+
+	s.forgetSync(objectName)
+	slog.Info("Deleted from the cloud", "file", objectName)
+	return nil
+}
+
+// backupCloudCopy downloads objectName into a new backup folder, since no
+// local copy is left to back up
+func (s *Syncer) backupCloudCopy(ctx context.Context, objectName string, modTime time.Time) error {
+	localPath, err := s.localPath(objectName)
+	if err != nil {
+		return err
+	}
+
+	s.backupMu.Lock()
+	defer s.backupMu.Unlock()
+
+	backupPath, err := s.createTimestampedBackupDir()
+	if err != nil {
+		return fmt.Errorf("failed to create backup directory: %w", err)
+	}
+	backupFile := s.backupFile(backupPath, localPath)
+	if err := ensureDir(filepath.Dir(backupFile)); err != nil {
+		return fmt.Errorf("failed to create backup directory: %w", err)
+	}
+
+	if err := s.storage.Download(ctx, objectName, backupFile); err != nil {
+		os.Remove(backupFile)
+		return fmt.Errorf("failed to back up cloud copy of %s: %w", objectName, err)
+	}
+	if err := os.Chtimes(backupFile, modTime, modTime); err != nil {
+		slog.Warn("Failed to set mod time", "path", backupFile, "err", err)
+	}
+
+	slog.Debug("Created backup", "path", backupFile)
+	if s.Metrics != nil {
+		s.Metrics.BackedUp()
+	}
+	return nil
+}
+
+// writeTombstone uploads the tombstone of objectName, recording it as
+// deleted at time at
+func (s *Syncer) writeTombstone(ctx context.Context, objectName string, at time.Time) error {
+	tmp, err := os.CreateTemp(s.TempDir, tombstoneTempPattern)
+	if err != nil {
+		return fmt.Errorf("failed to create tombstone: %w", err)
+	}
+	tempPath := tmp.Name()
+	defer os.Remove(tempPath)
+
+	_, err = fmt.Fprintf(tmp, "%s deleted at %s\n", objectName, at.UTC().Format(time.RFC3339))
+	if closeErr := tmp.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = os.Chtimes(tempPath, at, at)
+	}
+	if err != nil {
+		return fmt.Errorf("failed to create tombstone: %w", err)
+	}
+
+	if err := s.storage.Upload(ctx, tempPath, tombstoneName(objectName)); err != nil {
+		return fmt.Errorf("failed to upload tombstone for %s: %w", objectName, err)
+	}
+	return nil
+}
+
+// clearTombstone deletes objectName's tombstone, if any, once the file has
+// been uploaded again
+func (s *Syncer) clearTombstone(ctx context.Context, objectName string) {
+	name := tombstoneName(objectName)
+	if _, err := s.storage.Stat(ctx, name); err != nil {
+		return
+	}
+	if err := s.storage.Delete(ctx, name); err != nil && !errors.Is(err, ErrNotExist) {
+		slog.Warn("Failed to remove tombstone", "file", objectName, "err", err)
+	}
+}
+
+// deletedInCloud reports why the local file of an object missing from the
+// cloud should be removed rather than uploaded, or "" if it shouldn't: a
+// tombstone newer than the local file, or, without one, a local file
+// unchanged since it was last synced
+func (s *Syncer) deletedInCloud(ctx context.Context, objectName, localPath string, local os.FileInfo) (string, error) {
+	tomb, err := s.storage.Stat(ctx, tombstoneName(objectName))
+	switch {
+	case err == nil:
+		if decideAction(local.ModTime().UTC(), tomb.ModTime, s.timeTolerance) == actionUpload {
+			// Edited after the deletion: the edit wins
+			return "", nil
+		}
+		return fmt.Sprintf("deleted on another machine at %v", tomb.ModTime), nil
+	case !errors.Is(err, ErrNotExist):
+		return "", fmt.Errorf("failed to check for a tombstone: %w", err)
+	}
+
+	base, ok := s.baseline(objectName)
+	if !ok {
+		return "", nil
+	}
+	sha, err := fsutil.FileSHA256(localPath)
+	if err != nil {
+		return "", fmt.Errorf("failed to checksum file: %w", err)
+	}
+	if sha != base.SHA256 {
+		return "", nil
+	}
+	return "deleted from the cloud", nil
+}
+
+// removeLocal backs up and removes localPath, whose object was deleted
+func (s *Syncer) removeLocal(objectName, localPath, reason string) error {
+	if s.DryRun {
+		slog.Info("[dry-run] Would back up and remove", "path", localPath, "reason", reason)
+		return nil
+	}
+
+	if err := s.createBackup(localPath); err != nil {
+		return fmt.Errorf("failed to create backup: %w", err)
+	}
+	if err := os.Remove(localPath); err != nil {
+		return fmt.Errorf("failed to remove %s: %w", localPath, err)
+	}
+
+	s.forgetSync(objectName)
+	slog.Info("Removed", "path", localPath, "reason", reason)
+	return nil
+}
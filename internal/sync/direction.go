@@ -0,0 +1,50 @@
+package sync
+
+import (
+	"log/slog"
+)
+
+// SyncDirection limits which way a Syncer transfers files
+type SyncDirection string
+
+const (
+	// DirectionBidirectional uploads and downloads. This is the default.
+	DirectionBidirectional SyncDirection = "bidirectional"
+
+	// DirectionDownloadOnly only pulls from the cloud. Newer local copies
+	// and local deletions stay local, and conflicts go to the cloud copy.
+	DirectionDownloadOnly SyncDirection = "download-only"
+
+	// DirectionUploadOnly only pushes to the cloud. Newer cloud copies and
+	// cloud deletions are never applied locally, and conflicts go to the
+	// local copy.
+	DirectionUploadOnly SyncDirection = "upload-only"
+)
+
+// canUpload reports whether Direction allows changing the cloud
+func (s *Syncer) canUpload() bool {
+	return s.Direction != DirectionDownloadOnly
+}
+
+// canDownload reports whether Direction allows changing local files
+func (s *Syncer) canDownload() bool {
+	return s.Direction != DirectionUploadOnly
+}
+
+// blocked logs and reports whether Direction rules out action for
+// objectName
+func (s *Syncer) blocked(objectName string, action syncAction) bool {
+	if (action == actionUpload && !s.canUpload()) || (action == actionDownload && !s.canDownload()) {
+		slog.Debug("Not syncing against the sync direction", "file", objectName, "sync_direction", s.Direction)
+		return true
+	}
+	return false
+}
+
+// restrict turns a planned transfer Direction rules out into a skip
+func (s *Syncer) restrict(item *PlanItem) {
+	if (item.Action == PlanUpload && !s.canUpload()) || (item.Action == PlanDownload && !s.canDownload()) {
+		item.Reason = string(item.Action) + " ruled out by direction " + string(s.Direction) + ": " + item.Reason
+		item.Action = PlanSkip
+	}
+}
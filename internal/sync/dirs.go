@@ -0,0 +1,185 @@
+package sync
+
+import (
+	"context"
+	"fmt"
+	"io/fs"
+	"log/slog"
+	"os"
+	"path"
+	"path/filepath"
+
+	"github.com/danielbehrens/cloudsync/internal/fsutil"
+)
+
+// dirMarkerName is the base name of the empty objects standing in for
+// empty directories with SyncEmptyDirs: "saves/slot2/.keep" recreates the
+// folder saves/slot2. Local files of that name are taken for markers too,
+// as they are conventionally empty placeholders.
+const dirMarkerName = ".keep"
+
+// isDirMarker reports whether name is a directory marker rather than a save
+func isDirMarker(name string) bool {
+	return path.Base(filepath.ToSlash(name)) == dirMarkerName
+}
+
+// listedInCloud reports whether the cloud listing of a full sync keeps the
+// object name: saves passing shouldSyncFile, and directory markers when
+// they are synced
+func (s *Syncer) listedInCloud(name string) bool {
+	return s.shouldSyncFile(name) || (s.syncsDirs() && isDirMarker(name))
+}
+
+// syncsDirs reports whether empty directories are synced, which needs
+// Recursive
+func (s *Syncer) syncsDirs() bool {
+	return s.SyncEmptyDirs && s.Recursive
+}
+
+// listEmptyDirs returns the slash-separated paths, relative to root, of the
+// directories below root with neither subdirectories nor files that sync.
+// Their parents need no marker of their own, since recreating the leaf
+// creates them too. The backup directory is skipped.
+func (s *Syncer) listEmptyDirs(root string) ([]string, error) {
+	type dirState struct{ hasDir, hasFile bool }
+	dirs := make(map[string]*dirState)
+
+	backupDir := filepath.Clean(s.backupDir)
+	err := fsutil.Walk(root, func(p string, d fs.DirEntry, err error) error {
+		if err != nil {
+			slog.Warn("Failed to read", "path", p, "err", err)
+			return nil
+		}
+		if p == root {
+			return nil
+		}
+
+		parent := dirs[filepath.Dir(p)]
+		if d.IsDir() {
+			if filepath.Clean(p) == backupDir {
+				return fs.SkipDir
+			}
+			dirs[p] = &dirState{}
+			if parent != nil {
+				parent.hasDir = true
+			}
+			return nil
+		}
+		if parent != nil && s.shouldSyncFile(p) {
+			parent.hasFile = true
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to walk directory: %w", err)
+	}
+
+	var empty []string
+	for p, state := range dirs {
+		if state.hasDir || state.hasFile {
+			continue
+		}
+		rel, err := filepath.Rel(root, p)
+		if err != nil {
+			continue
+		}
+		empty = append(empty, filepath.ToSlash(rel))
+	}
+	return empty, nil
+}
+
+// planDirs adds to items a marker upload for each empty local directory
+// without one in the cloud, and a directory creation for each cloud marker
+// whose directory is missing locally. Markers are never downloaded as
+// files.
+func (s *Syncer) planDirs(items map[string]*PlanItem, cloudFiles []*SyncFileInfo) error {
+	markers := make(map[string]*SyncFileInfo)
+	for _, f := range cloudFiles {
+		if isDirMarker(f.Name) {
+			markers[f.Name] = f
+		}
+	}
+
+	if s.canUpload() {
+		empty, err := s.listEmptyDirs(s.watchPath)
+		if err != nil {
+			return err
+		}
+		for _, dir := range empty {
+			name := path.Join(dir, dirMarkerName)
+			if _, ok := markers[name]; ok {
+				continue
+			}
+			items[name] = &PlanItem{
+				Name:   name,
+				Path:   filepath.Join(s.watchPath, filepath.FromSlash(dir)),
+				Action: PlanMarkDir,
+				Reason: "empty directory not in the cloud",
+			}
+		}
+	}
+
+	if s.canDownload() {
+		for name, f := range markers {
+			dir := path.Dir(name)
+			if dir == "." {
+				continue
+			}
+			dirPath, err := s.localPath(dir)
+			if err != nil {
+				slog.Warn("Skipping cloud directory marker", "err", err)
+				continue
+			}
+			if _, err := os.Stat(dirPath); err == nil {
+				continue
+			}
+			items[name] = &PlanItem{
+				Name:   name,
+				Path:   dirPath,
+				Action: PlanCreateDir,
+				Reason: "directory not in the watch path",
+				Cloud:  f,
+			}
+		}
+	}
+
+	return nil
+}
+
+// uploadDirMarker uploads the empty marker object of the directory dirPath
+func (s *Syncer) uploadDirMarker(ctx context.Context, name, dirPath string) error {
+	if s.DryRun {
+		slog.Info("[dry-run] Would upload a marker for empty directory", "path", dirPath)
+		return nil
+	}
+
+	tmp, err := os.CreateTemp(s.TempDir, dirMarkerTempPattern)
+	if err != nil {
+		return fmt.Errorf("failed to create directory marker: %w", err)
+	}
+	tempPath := tmp.Name()
+	defer os.Remove(tempPath)
+	if err := tmp.Close(); err != nil {
+		return fmt.Errorf("failed to create directory marker: %w", err)
+	}
+
+	if err := s.storage.Upload(ctx, tempPath, name); err != nil {
+		return fmt.Errorf("failed to upload directory marker: %w", err)
+	}
+	slog.Debug("Uploaded marker for empty directory", "path", dirPath)
+	return nil
+}
+
+// createDir recreates the directory of a cloud marker
+func (s *Syncer) createDir(dirPath string) error {
+	if s.DryRun {
+		slog.Info("[dry-run] Would create directory", "path", dirPath)
+		return nil
+	}
+
+	if err := os.MkdirAll(dirPath, 0755); err != nil {
+		return fmt.Errorf("failed to create directory: %w", err)
+	}
+	slog.Info("Created directory", "path", dirPath)
+	return nil
+}
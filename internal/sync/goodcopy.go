@@ -0,0 +1,122 @@
+package sync
+
+import (
+	"context"
+	"fmt"
+	"io/fs"
+	"log/slog"
+	"os"
+	"path/filepath"
+
+	"github.com/danielbehrens/cloudsync/internal/fsutil"
+)
+
+// updateGoodCopy refreshes the latest-known-good shadow copy of localPath,
+// but only when the local content is confirmed to match the checksum the
+// cloud recorded for objectName. Failures are logged; they never fail the
+// sync that triggered them.
+func (s *Syncer) updateGoodCopy(ctx context.Context, localPath, objectName string) {
+	if s.GoodCopyDir == "" {
+		return
+	}
+
+	cloudInfo, err := s.storage.Stat(ctx, objectName)
+	if err != nil {
+		slog.Warn("Skipping good copy: failed to stat cloud object", "file", objectName, "err", err)
+		return
+	}
+	if cloudInfo.Checksum == "" {
+		slog.Warn("Skipping good copy: cloud object has no checksum to verify against", "file", objectName)
+		return
+	}
+
+	sum, err := fsutil.FileSHA256(localPath)
+	if err != nil {
+		slog.Warn("Skipping good copy", "file", objectName, "err", err)
+		return
+	}
+	if sum != cloudInfo.Checksum {
+		slog.Warn("Skipping good copy: local content does not match cloud checksum", "file", objectName)
+		return
+	}
+
+	// Copy beside the target and rename so a crash never leaves a partial
+	// good copy behind
+	goodPath := filepath.Join(s.GoodCopyDir, filepath.FromSlash(objectName))
+	if err := ensureDir(filepath.Dir(goodPath)); err != nil {
+		slog.Warn("Failed to create good copy directory", "err", err)
+		return
+	}
+
+	tempPath := goodPath + ".tmp"
+	if err := fsutil.CopyFile(localPath, tempPath); err != nil {
+		os.Remove(tempPath)
+		slog.Warn("Failed to write good copy", "file", objectName, "err", err)
+		return
+	}
+	if err := os.Rename(tempPath, goodPath); err != nil {
+		os.Remove(tempPath)
+		slog.Warn("Failed to write good copy", "file", objectName, "err", err)
+		return
+	}
+
+	slog.Info("Updated good copy", "file", objectName)
+}
+
+// GoodCopies lists the object names that have a latest-known-good copy
+func (s *Syncer) GoodCopies() ([]string, error) {
+	var names []string
+	err := filepath.WalkDir(s.GoodCopyDir, func(p string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if d.IsDir() || !s.shouldSyncFile(p) {
+			return nil
+		}
+
+		rel, err := filepath.Rel(s.GoodCopyDir, p)
+		if err != nil {
+			return err
+		}
+		names = append(names, filepath.ToSlash(rel))
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to read good copy directory: %w", err)
+	}
+
+	return names, nil
+}
+
+// RestoreGoodCopy replaces the live file name (an object name, i.e. a
+// slash-separated path relative to the watch path) in the watch path with its
+// latest-known-good copy, backing up the current live file first. The
+// restored file gets a fresh modification time so the next sync pushes it
+// over whatever (possibly corrupt) version the cloud holds.
+func (s *Syncer) RestoreGoodCopy(name string) error {
+	livePath, err := s.localPath(name)
+	if err != nil || !s.shouldSyncFile(name) {
+		return fmt.Errorf("%s is not a syncable save file name", name)
+	}
+
+	goodPath := filepath.Join(s.GoodCopyDir, filepath.FromSlash(name))
+	if !fileExists(goodPath) {
+		return fmt.Errorf("no good copy of %s in %s", name, s.GoodCopyDir)
+	}
+
+	if err := ensureDir(filepath.Dir(livePath)); err != nil {
+		return fmt.Errorf("failed to create save directory: %w", err)
+	}
+	if fileExists(livePath) {
+		if err := s.createBackup(livePath); err != nil {
+			return fmt.Errorf("failed to back up live file: %w", err)
+		}
+	}
+
+	if err := replaceFile(goodPath, livePath); err != nil {
+		return fmt.Errorf("failed to restore good copy: %w", err)
+	}
+
+	slog.Info("Restored from good copy", "file", name)
+	return nil
+}
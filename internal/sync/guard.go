@@ -0,0 +1,37 @@
+package sync
+
+import (
+	"fmt"
+	"os"
+)
+
+// defaultMinFileSize skips empty files, which a game crashing mid-save
+// commonly leaves behind
+const defaultMinFileSize = 1
+
+// uploadGuard returns why a local file of size bytes must not be uploaded,
+// or "" if it may be
+func (s *Syncer) uploadGuard(size int64) string {
+	if size < s.MinFileSize {
+		return fmt.Sprintf("it has %d bytes, less than the minimum of %d", size, s.MinFileSize)
+	}
+	return ""
+}
+
+// downloadGuard returns why a cloud object of size bytes must not replace
+// localPath, or "" if it may. A missing or already undersized local file
+// has nothing worth protecting.
+func (s *Syncer) downloadGuard(size int64, localPath string) string {
+	info, err := os.Stat(localPath)
+	if err != nil || info.Size() < s.MinFileSize || info.Size() == 0 {
+		return ""
+	}
+
+	switch {
+	case size < s.MinFileSize:
+		return fmt.Sprintf("the cloud copy has %d bytes, less than the minimum of %d", size, s.MinFileSize)
+	case s.MinSizeRatio > 0 && float64(size) < s.MinSizeRatio*float64(info.Size()):
+		return fmt.Sprintf("the cloud copy has %d bytes, less than %g times the local %d", size, s.MinSizeRatio, info.Size())
+	}
+	return ""
+}
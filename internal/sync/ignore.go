@@ -0,0 +1,69 @@
+package sync
+
+import (
+	"errors"
+	"io/fs"
+	"log/slog"
+	"os"
+	"path/filepath"
+
+	"github.com/danielbehrens/cloudsync/internal/fsutil"
+)
+
+// ignoreFile returns the path of the watch path's .cloudsyncignore file
+func (s *Syncer) ignoreFile() string {
+	return filepath.Join(s.watchPath, fsutil.IgnoreFileName)
+}
+
+// LoadIgnoreFile (re)reads the watch path's .cloudsyncignore file and
+// reports whether the rules changed. A missing file ignores nothing; one
+// that can't be read or parsed is logged and the previous rules are kept.
+func (s *Syncer) LoadIgnoreFile() bool {
+	data, err := os.ReadFile(s.ignoreFile())
+	if errors.Is(err, fs.ErrNotExist) {
+		data, err = nil, nil
+	}
+	if err != nil {
+		slog.Warn("Failed to read ignore file, keeping the previous rules", "path", s.ignoreFile(), "err", err)
+		return false
+	}
+
+	rules, err := fsutil.ParseIgnore(data)
+	if err != nil {
+		slog.Warn("Invalid ignore file, keeping the previous rules", "path", s.ignoreFile(), "err", err)
+		return false
+	}
+
+	s.ignoreMu.Lock()
+	defer s.ignoreMu.Unlock()
+	if string(data) == s.ignoreData {
+		return false
+	}
+	s.ignore, s.ignoreData = rules, string(data)
+	return true
+}
+
+// ignored reports whether the .cloudsyncignore rules exclude filePath, a
+// local path or an object name
+func (s *Syncer) ignored(filePath string) bool {
+	s.ignoreMu.RLock()
+	rules := s.ignore
+	s.ignoreMu.RUnlock()
+	if rules == nil {
+		return false
+	}
+
+	rel := filePath
+	if filepath.IsAbs(filePath) {
+		var err error
+		if rel, err = filepath.Rel(s.watchPath, filePath); err != nil {
+			return false
+		}
+	}
+	return rules.Ignored(filepath.ToSlash(rel))
+}
+
+// isIgnoreFile reports whether path is the watch path's .cloudsyncignore
+func (s *Syncer) isIgnoreFile(path string) bool {
+	return filepath.Clean(path) == filepath.Clean(s.ignoreFile())
+}
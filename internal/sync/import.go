@@ -0,0 +1,66 @@
+package sync
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"time"
+)
+
+// ImportSummary reports the outcome of a bulk import
+type ImportSummary struct {
+	Uploaded int
+	Skipped  int
+	Failed   int
+	Bytes    int64
+	Elapsed  time.Duration
+}
+
+// Import uploads every syncable file in dir to cloud storage, creating the
+// bucket if needed. Unlike InitialSync it never compares against or
+// downloads from the cloud: it is a one-time seed of a fresh bucket. With
+// Recursive set, subdirectories of dir are imported under their relative
+// paths.
+func (s *Syncer) Import(ctx context.Context, dir string) (*ImportSummary, error) {
+	start := time.Now()
+	summary := &ImportSummary{}
+
+	if err := s.storage.EnsureBucket(ctx); err != nil {
+		return nil, fmt.Errorf("failed to ensure bucket: %w", err)
+	}
+
+	files, skipped, err := s.listFiles(dir)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read import directory: %w", err)
+	}
+	summary.Skipped = skipped
+
+	for i, f := range files {
+		if err := ctx.Err(); err != nil {
+			return summary, err
+		}
+
+		if s.DryRun {
+			slog.Info("[dry-run] Would import", "file", f.name, "n", i+1, "of", len(files), "bytes", f.info.Size())
+			continue
+		}
+
+		slog.Info("Importing", "file", f.name, "n", i+1, "of", len(files), "bytes", f.info.Size())
+		if err := s.upload(ctx, f.path, f.name); err != nil {
+			slog.Error("Failed to import", "path", f.path, "err", err)
+			summary.Failed++
+			continue
+		}
+
+		summary.Uploaded++
+		summary.Bytes += f.info.Size()
+	}
+
+	summary.Elapsed = time.Since(start)
+
+	if summary.Failed > 0 {
+		return summary, fmt.Errorf("%d of %d files failed to import", summary.Failed, len(files))
+	}
+
+	return summary, nil
+}
@@ -0,0 +1,160 @@
+package sync
+
+import (
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// journalEntry is a download in progress, keyed by Temp in JournalFile
+type journalEntry struct {
+	Object  string    `json:"object"`
+	Temp    string    `json:"temp"`
+	Target  string    `json:"target"`
+	ModTime time.Time `json:"mod_time"`
+	Started time.Time `json:"started"`
+
+	// Complete is set once Temp holds the whole object, so only the
+	// rename into Target is left
+	Complete bool `json:"complete"`
+}
+
+// loadJournal reads JournalFile. A missing file is an empty journal.
+// Callers hold journalMu.
+func (s *Syncer) loadJournal() (map[string]journalEntry, error) {
+	entries := make(map[string]journalEntry)
+
+	data, err := os.ReadFile(s.JournalFile)
+	if os.IsNotExist(err) {
+		return entries, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read journal: %w", err)
+	}
+	if err := json.Unmarshal(data, &entries); err != nil {
+		return nil, fmt.Errorf("failed to parse journal %s: %w", s.JournalFile, err)
+	}
+	return entries, nil
+}
+
+// saveJournal writes JournalFile atomically, removing it when entries is
+// empty. Callers hold journalMu.
+func (s *Syncer) saveJournal(entries map[string]journalEntry) error {
+	if len(entries) == 0 {
+		if err := os.Remove(s.JournalFile); err != nil && !os.IsNotExist(err) {
+			return fmt.Errorf("failed to remove journal: %w", err)
+		}
+		return nil
+	}
+
+	data, err := json.MarshalIndent(entries, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to encode journal: %w", err)
+	}
+	if err := ensureDir(filepath.Dir(s.JournalFile)); err != nil {
+		return fmt.Errorf("failed to create journal directory: %w", err)
+	}
+
+	tempPath := s.JournalFile + ".tmp"
+	if err := os.WriteFile(tempPath, data, 0644); err != nil {
+		os.Remove(tempPath)
+		return fmt.Errorf("failed to write journal: %w", err)
+	}
+	if err := os.Rename(tempPath, s.JournalFile); err != nil {
+		os.Remove(tempPath)
+		return fmt.Errorf("failed to write journal: %w", err)
+	}
+	return nil
+}
+
+// updateJournal applies change to the journal. Failures are logged: the
+// download itself goes ahead, only its recovery after a crash is lost.
+func (s *Syncer) updateJournal(change func(entries map[string]journalEntry)) {
+	if s.JournalFile == "" {
+		return
+	}
+
+	s.journalMu.Lock()
+	defer s.journalMu.Unlock()
+
+	entries, err := s.loadJournal()
+	if err != nil {
+		slog.Warn("Failed to update journal", "err", err)
+		return
+	}
+	change(entries)
+	if err := s.saveJournal(entries); err != nil {
+		slog.Warn("Failed to update journal", "err", err)
+	}
+}
+
+// journal records entry, replacing an earlier record of the same download
+func (s *Syncer) journal(entry journalEntry) {
+	s.updateJournal(func(entries map[string]journalEntry) {
+		entries[entry.Temp] = entry
+	})
+}
+
+// unjournal removes the download to tempPath from the journal
+func (s *Syncer) unjournal(tempPath string) {
+	s.updateJournal(func(entries map[string]journalEntry) {
+		delete(entries, tempPath)
+	})
+}
+
+// recoverJournal deals with the downloads a crash interrupted: a complete
+// one is renamed into place, unless its target was changed after the
+// download started, and the temp files of the others are removed. It runs
+// before any sync, while no download is in progress.
+func (s *Syncer) recoverJournal() {
+	if s.JournalFile == "" || s.DryRun {
+		return
+	}
+
+	s.journalMu.Lock()
+	defer s.journalMu.Unlock()
+
+	entries, err := s.loadJournal()
+	if err != nil {
+		slog.Warn("Ignoring journal", "err", err)
+		return
+	}
+
+	for _, e := range entries {
+		if _, err := os.Stat(e.Temp); err != nil {
+			continue
+		}
+
+		if e.Complete && !changedSince(e.Target, e.Started) {
+			if err := moveFile(e.Temp, e.Target); err == nil {
+				if err := os.Chtimes(e.Target, time.Time{}, e.ModTime); err != nil {
+					slog.Warn("Failed to set mod time", "path", e.Target, "err", err)
+				}
+				slog.Info("Finished interrupted download", "file", e.Object)
+				s.recordSync(e.Object, e.Target)
+				continue
+			} else {
+				slog.Error("Failed to finish interrupted download", "file", e.Object, "err", err)
+			}
+		}
+
+		if err := os.Remove(e.Temp); err != nil {
+			slog.Warn("Failed to remove temp file", "path", e.Temp, "err", err)
+			continue
+		}
+		slog.Info("Removed interrupted download", "file", e.Object)
+	}
+
+	if err := s.saveJournal(nil); err != nil {
+		slog.Warn("Failed to clear journal", "err", err)
+	}
+}
+
+// changedSince reports whether path was modified at or after t
+func changedSince(path string, t time.Time) bool {
+	info, err := os.Stat(path)
+	return err == nil && !info.ModTime().Before(t)
+}
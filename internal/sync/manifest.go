@@ -0,0 +1,138 @@
+package sync
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/danielbehrens/cloudsync/internal/fsutil"
+)
+
+// Verdicts describing how a local file relates to its cloud object, as
+// the action a full sync would take on it
+const (
+	VerdictInSync         = "in-sync"
+	VerdictLocalNewer     = "local-newer"
+	VerdictCloudNewer     = "cloud-newer"
+	VerdictLocalOnly      = "local-only"
+	VerdictCloudOnly      = "cloud-only"
+	VerdictConflict       = "conflict"
+	VerdictDeletedLocally = "deleted-locally"
+	VerdictDeletedInCloud = "deleted-in-cloud"
+
+	// VerdictSkipped is a file that differs but that sync leaves alone,
+	// e.g. because of the direction or a name collision
+	VerdictSkipped = "skipped"
+)
+
+// FileState is one side (local or cloud) of a tracked file
+type FileState struct {
+	ModTime  time.Time `json:"mod_time"`
+	Size     int64     `json:"size"`
+	Checksum string    `json:"checksum,omitempty"`
+	ETag     string    `json:"etag,omitempty"`
+
+	// CoarseModTime marks a cloud mod time of low resolution, as on
+	// SyncFileInfo
+	CoarseModTime bool `json:"coarse_mod_time,omitempty"`
+}
+
+// ManifestEntry pairs the local and cloud state of one file. Reason is
+// why sync would act on it as Verdict says. LastSynced is when it was last
+// uploaded or downloaded, zero if unknown.
+type ManifestEntry struct {
+	Name       string     `json:"name"`
+	Local      *FileState `json:"local,omitempty"`
+	Cloud      *FileState `json:"cloud,omitempty"`
+	Verdict    string     `json:"verdict"`
+	Reason     string     `json:"reason,omitempty"`
+	LastSynced time.Time  `json:"last_synced,omitzero"`
+}
+
+// Manifest is a read-only snapshot of cloudsync's view of every tracked file
+type Manifest struct {
+	GeneratedAt   time.Time       `json:"generated_at"`
+	WatchPath     string          `json:"watch_path"`
+	TimeTolerance string          `json:"time_tolerance"`
+	Files         []ManifestEntry `json:"files"`
+}
+
+// BuildManifest collects local and cloud state for every syncable file
+// without transferring or modifying anything. The verdicts come from the
+// plan a full sync would carry out.
+func (s *Syncer) BuildManifest(ctx context.Context) (*Manifest, error) {
+	plan, err := s.Plan(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	manifest := &Manifest{
+		GeneratedAt:   time.Now().UTC(),
+		WatchPath:     s.watchPath,
+		TimeTolerance: s.timeTolerance.String(),
+		Files:         make([]ManifestEntry, 0, len(plan.Items)),
+	}
+
+	lastSynced := s.lastSyncTimes()
+	for _, item := range plan.Items {
+		e := ManifestEntry{
+			Name:       item.Name,
+			Verdict:    verdict(item),
+			Reason:     item.Reason,
+			LastSynced: lastSynced[item.Name],
+		}
+
+		if item.Local != nil {
+			e.Local = &FileState{ModTime: item.Local.ModTime().UTC()}
+			if !item.Local.IsDir() {
+				sum, err := fsutil.FileSHA256(item.Path)
+				if err != nil {
+					return nil, fmt.Errorf("failed to checksum %s: %w", item.Path, err)
+				}
+				e.Local.Size = item.Local.Size()
+				e.Local.Checksum = sum
+			}
+		}
+
+		if f := item.Cloud; f != nil {
+			e.Cloud = &FileState{
+				ModTime:       f.ModTime,
+				Size:          f.Size,
+				Checksum:      f.Checksum,
+				ETag:          f.ETag,
+				CoarseModTime: f.CoarseModTime,
+			}
+		}
+
+		manifest.Files = append(manifest.Files, e)
+	}
+
+	return manifest, nil
+}
+
+// verdict names what a full sync would do with item
+func verdict(item PlanItem) string {
+	switch item.Action {
+	case PlanUpload, PlanMarkDir:
+		if item.Cloud == nil {
+			return VerdictLocalOnly
+		}
+		return VerdictLocalNewer
+	case PlanDownload, PlanCreateDir:
+		if item.Local == nil {
+			return VerdictCloudOnly
+		}
+		return VerdictCloudNewer
+	case PlanConflict:
+		return VerdictConflict
+	case PlanDeleteCloud:
+		return VerdictDeletedLocally
+	case PlanDeleteLocal:
+		return VerdictDeletedInCloud
+	}
+
+	if item.Reason == reasonInSync || item.Reason == reasonSameContent {
+		return VerdictInSync
+	}
+	return VerdictSkipped
+}
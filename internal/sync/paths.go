@@ -0,0 +1,145 @@
+package sync
+
+import (
+	"fmt"
+	"io/fs"
+	"log/slog"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+
+	"github.com/danielbehrens/cloudsync/internal/fsutil"
+)
+
+// localFile is a syncable file found under a directory
+type localFile struct {
+	name string // object name: slash-separated path relative to the root
+	path string
+	info fs.FileInfo
+}
+
+// listFiles returns the syncable files under root and how many entries were
+// skipped by the file filter. Only root itself is read unless Recursive is
+// set, in which case subdirectories are walked too (following symlinks),
+// except the backup directory.
+func (s *Syncer) listFiles(root string) ([]localFile, int, error) {
+	var files []localFile
+	skipped := 0
+
+	add := func(p string, d fs.DirEntry) {
+		if !s.shouldSyncFile(p) {
+			skipped++
+			return
+		}
+
+		info, err := d.Info()
+		if err != nil {
+			slog.Warn("Failed to stat", "path", p, "err", err)
+			return
+		}
+
+		rel, err := filepath.Rel(root, p)
+		if err != nil {
+			slog.Warn("Failed to resolve", "path", p, "err", err)
+			return
+		}
+
+		files = append(files, localFile{name: filepath.ToSlash(rel), path: p, info: info})
+	}
+
+	if !s.Recursive {
+		entries, err := os.ReadDir(root)
+		if err != nil {
+			return nil, 0, fmt.Errorf("failed to read directory: %w", err)
+		}
+		for _, entry := range entries {
+			if entry.IsDir() {
+				skipped++
+				continue
+			}
+			add(filepath.Join(root, entry.Name()), entry)
+		}
+		return files, skipped, nil
+	}
+
+	backupDir := filepath.Clean(s.backupDir)
+	err := fsutil.Walk(root, func(p string, d fs.DirEntry, err error) error {
+		if err != nil {
+			slog.Warn("Failed to read", "path", p, "err", err)
+			return nil
+		}
+		if d.IsDir() {
+			if p != root && filepath.Clean(p) == backupDir {
+				return fs.SkipDir
+			}
+			return nil
+		}
+		add(p, d)
+		return nil
+	})
+	if err != nil {
+		return nil, 0, fmt.Errorf("failed to walk directory: %w", err)
+	}
+
+	return files, skipped, nil
+}
+
+// objectName maps a local path under the watch path to its object name, the
+// slash-separated path relative to the watch path. Without Recursive that is
+// just the file name.
+func (s *Syncer) objectName(localPath string) (string, error) {
+	rel, err := filepath.Rel(s.watchPath, localPath)
+	if err != nil {
+		return "", fmt.Errorf("%s is not under the watch path: %w", localPath, err)
+	}
+
+	name := filepath.ToSlash(rel)
+	if name == ".." || strings.HasPrefix(name, "../") {
+		return "", fmt.Errorf("%s is not under the watch path", localPath)
+	}
+	if !s.Recursive && strings.Contains(name, "/") {
+		return "", fmt.Errorf("%s is in a subdirectory and recursive sync is off", localPath)
+	}
+
+	return name, nil
+}
+
+// ObjectName returns the object name of file, a path inside the watch
+// path or a name relative to it as BuildManifest lists them, mapped the way
+// sync maps it
+func (s *Syncer) ObjectName(file string) (string, error) {
+	if filepath.IsAbs(file) {
+		return s.objectName(file)
+	}
+
+	name := filepath.ToSlash(filepath.Clean(file))
+	if _, err := s.localPath(name); err != nil {
+		return "", err
+	}
+	return name, nil
+}
+
+// localPath maps an object name to its path under the watch path. Cloud keys
+// are untrusted, so keys that are absolute or would climb out of the watch
+// path are rejected, as are nested keys while Recursive is off.
+func (s *Syncer) localPath(objectName string) (string, error) {
+	if objectName == "" || path.IsAbs(objectName) || strings.Contains(objectName, `\`) {
+		return "", fmt.Errorf("invalid object name %q", objectName)
+	}
+
+	for _, part := range strings.Split(objectName, "/") {
+		if part == "" || part == "." || part == ".." {
+			return "", fmt.Errorf("invalid object name %q", objectName)
+		}
+	}
+	if !filepath.IsLocal(filepath.FromSlash(objectName)) {
+		return "", fmt.Errorf("invalid object name %q", objectName)
+	}
+
+	if !s.Recursive && strings.Contains(objectName, "/") {
+		return "", fmt.Errorf("object %s is in a subdirectory and recursive sync is off", objectName)
+	}
+
+	return filepath.Join(s.watchPath, filepath.FromSlash(objectName)), nil
+}
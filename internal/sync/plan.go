@@ -0,0 +1,273 @@
+package sync
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"os"
+	"sort"
+	"sync/atomic"
+	"time"
+)
+
+// PlanAction is what a full sync does with one file
+type PlanAction string
+
+// Plan actions
+const (
+	PlanSkip        PlanAction = "skip"
+	PlanUpload      PlanAction = "upload"
+	PlanDownload    PlanAction = "download"
+	PlanConflict    PlanAction = "conflict"
+	PlanDeleteCloud PlanAction = "delete-cloud"
+	PlanDeleteLocal PlanAction = "delete-local"
+
+	// With SyncEmptyDirs: upload the marker of an empty local directory,
+	// or create the local directory of a cloud marker
+	PlanMarkDir   PlanAction = "mark-dir"
+	PlanCreateDir PlanAction = "create-dir"
+)
+
+// Reasons of a PlanSkip for a file that is in sync, as opposed to one sync
+// leaves alone
+const (
+	reasonInSync      = "in sync"
+	reasonSameContent = "same content"
+)
+
+// PlanItem is the action planned for one file and why. Local is nil for a
+// file only in the cloud, Cloud for one only in the watch path.
+type PlanItem struct {
+	Name   string // object name
+	Path   string // local path
+	Action PlanAction
+	Reason string
+	Local  os.FileInfo
+	Cloud  *SyncFileInfo
+}
+
+// SyncPlan is what a full sync would do with every syncable file, built
+// from one listing of the watch path and one of the cloud. Items are sorted
+// by name.
+type SyncPlan struct {
+	Items []PlanItem
+}
+
+// Count returns how many items have the given action
+func (p *SyncPlan) Count(action PlanAction) int {
+	n := 0
+	for _, item := range p.Items {
+		if item.Action == action {
+			n++
+		}
+	}
+	return n
+}
+
+// Plan lists the watch path and the cloud once and decides what a full sync
+// does with each file, without transferring anything
+func (s *Syncer) Plan(ctx context.Context) (*SyncPlan, error) {
+	localFiles, _, err := s.listFiles(s.watchPath)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read watch directory: %w", err)
+	}
+
+	cloudFiles, err := s.listCloud(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list cloud files: %w", err)
+	}
+
+	items := make(map[string]*PlanItem, len(localFiles))
+	for _, f := range localFiles {
+		items[f.name] = &PlanItem{Name: f.name, Path: f.path, Local: f.info}
+	}
+
+	for _, f := range cloudFiles {
+		if !s.shouldSyncFile(f.Name) {
+			continue
+		}
+		if item, ok := items[f.Name]; ok {
+			item.Cloud = f
+			continue
+		}
+
+		localPath, err := s.localPath(f.Name)
+		if err != nil {
+			slog.Warn("Skipping cloud file", "err", err)
+			continue
+		}
+
+		// The listing can miss a file a case-insensitive file system
+		// stores under a differently cased name
+		item := &PlanItem{Name: f.Name, Path: localPath, Cloud: f}
+		if info, err := os.Stat(localPath); err == nil && !info.IsDir() {
+			item.Local = info
+		}
+		items[f.Name] = item
+	}
+
+	if s.syncsDirs() {
+		if err := s.planDirs(items, cloudFiles); err != nil {
+			return nil, fmt.Errorf("failed to read watch directory: %w", err)
+		}
+	}
+
+	markCollisions(items)
+
+	plan := &SyncPlan{Items: make([]PlanItem, 0, len(items))}
+	for _, item := range items {
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+		if item.Reason != "" {
+			plan.Items = append(plan.Items, *item)
+			continue
+		}
+		if err := s.decide(ctx, item); err != nil {
+			slog.Warn("Skipping", "file", item.Name, "err", err)
+			item.Action, item.Reason = PlanSkip, err.Error()
+		}
+		s.restrict(item)
+		plan.Items = append(plan.Items, *item)
+	}
+
+	sort.Slice(plan.Items, func(i, j int) bool {
+		return plan.Items[i].Name < plan.Items[j].Name
+	})
+
+	return plan, nil
+}
+
+// decide fills in item's Action and Reason the way SyncFile would compare
+// the two copies
+func (s *Syncer) decide(ctx context.Context, item *PlanItem) error {
+	switch {
+	case item.Cloud == nil:
+		if s.PropagateDeletes && s.canDownload() {
+			reason, err := s.deletedInCloud(ctx, item.Name, item.Path, item.Local)
+			if err != nil {
+				return err
+			}
+			if reason != "" {
+				item.Action, item.Reason = PlanDeleteLocal, reason
+				return nil
+			}
+		}
+		item.Action, item.Reason = PlanUpload, "not in the cloud"
+		return nil
+
+	case item.Local == nil:
+		if s.PropagateDeletes && s.canUpload() && s.deletedLocally(item.Cloud) {
+			item.Action, item.Reason = PlanDeleteCloud, "deleted locally since the last sync"
+			return nil
+		}
+		item.Action, item.Reason = PlanDownload, "not in the watch path"
+		return nil
+	}
+
+	content := s.compareContent(item.Path, item.Cloud)
+	if content == contentSame {
+		item.Action, item.Reason = PlanSkip, reasonSameContent
+		return nil
+	}
+
+	if s.inConflict(item.Name, item.Path, item.Cloud) {
+		item.Action, item.Reason = PlanConflict, "changed both locally and in the cloud since the last sync"
+		return nil
+	}
+
+	localTime := item.Local.ModTime().UTC()
+	cloudTime := item.Cloud.ModTime
+
+	switch decideAction(localTime, cloudTime, s.toleranceFor(item.Cloud.CoarseModTime)) {
+	case actionDownload:
+		item.Action = PlanDownload
+		item.Reason = fmt.Sprintf("cloud copy is newer (cloud: %v, local: %v)", cloudTime, localTime)
+	case actionUpload:
+		item.Action = PlanUpload
+		item.Reason = fmt.Sprintf("local copy is newer (cloud: %v, local: %v)", cloudTime, localTime)
+	case actionNone:
+		// Mod times agree but the content doesn't; the local copy wins,
+		// as it does in SyncFile
+		if content == contentDifferent {
+			item.Action, item.Reason = PlanUpload, "differs from the cloud copy with matching mod times"
+		} else {
+			item.Action, item.Reason = PlanSkip, reasonInSync
+		}
+	}
+	return nil
+}
+
+// applyPlan carries out plan's items with up to Concurrency workers, in
+// priority order, and returns how many failed. Per-file failures are logged
+// and skipped; a local file another process is writing is retried later in
+// the background.
+func (s *Syncer) applyPlan(ctx context.Context, plan *SyncPlan) (int, error) {
+	queue := newWorkQueue()
+	for i := range plan.Items {
+		item := &plan.Items[i]
+		if item.Action == PlanSkip {
+			continue
+		}
+
+		size := int64(0)
+		if item.Cloud != nil {
+			size = item.Cloud.Size
+		}
+		if item.Local != nil {
+			size = item.Local.Size()
+		}
+		job := s.newJob(item.Name, item.Path, size)
+		job.item = item
+		queue.push(job)
+	}
+	queue.close()
+
+	var failed atomic.Int32
+	err := s.runJobs(ctx, queue, func(ctx context.Context, job *transferJob) error {
+		err := s.applyItem(ctx, job.item)
+		if errors.Is(err, ErrFileBusy) && s.retryBusy(ctx, job.path, 1) {
+			return nil
+		}
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		if err != nil {
+			slog.Error("Failed to sync", "file", job.name, "action", job.item.Action, "err", err)
+			failed.Add(1)
+		}
+		s.recordOutcome(ctx, err)
+		return nil
+	})
+	return int(failed.Load()), err
+}
+
+// applyItem carries out one planned action
+func (s *Syncer) applyItem(ctx context.Context, item *PlanItem) error {
+	var cloudTime time.Time
+	if item.Cloud != nil {
+		cloudTime = item.Cloud.ModTime
+	}
+
+	switch item.Action {
+	case PlanUpload:
+		slog.Debug("Uploading", "file", item.Name, "reason", item.Reason)
+		return s.backupAndUpload(ctx, item.Path, item.Name, cloudTime)
+	case PlanDownload:
+		slog.Debug("Downloading", "file", item.Name, "reason", item.Reason)
+		return s.downloadAndReplace(ctx, item.Name, item.Path, item.Cloud)
+	case PlanConflict:
+		return s.resolveConflict(ctx, item.Name, item.Path, item.Cloud)
+	case PlanDeleteCloud:
+		slog.Info("Deleting from the cloud", "file", item.Name, "reason", item.Reason)
+		return s.deleteFromCloud(ctx, item.Name, item.Cloud)
+	case PlanDeleteLocal:
+		return s.removeLocal(item.Name, item.Path, item.Reason)
+	case PlanMarkDir:
+		return s.uploadDirMarker(ctx, item.Name, item.Path)
+	case PlanCreateDir:
+		return s.createDir(item.Path)
+	}
+	return nil
+}
@@ -0,0 +1,85 @@
+package sync
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"time"
+)
+
+// FindOrphans returns the cloud objects passing the file filter that have no
+// counterpart in the watch path and were last modified more than olderThan
+// ago, sorted by name. Nothing is modified.
+func (s *Syncer) FindOrphans(ctx context.Context, olderThan time.Duration) ([]*SyncFileInfo, error) {
+	cloudFiles, err := s.storage.List(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list cloud files: %w", err)
+	}
+
+	// An unreadable watch path would make every object look orphaned
+	files, _, err := s.listFiles(s.watchPath)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list local files: %w", err)
+	}
+	local := make(map[string]bool, len(files))
+	for _, f := range files {
+		local[f.name] = true
+	}
+
+	cutoff := time.Now().Add(-olderThan)
+	var orphans []*SyncFileInfo
+	for _, f := range cloudFiles {
+		if !s.shouldSyncFile(f.Name) || local[f.Name] || f.ModTime.After(cutoff) {
+			continue
+		}
+		// Keys this watch never syncs, such as nested keys without
+		// Recursive, have no local counterpart to miss
+		if _, err := s.localPath(f.Name); err != nil {
+			continue
+		}
+		orphans = append(orphans, f)
+	}
+
+	sort.Slice(orphans, func(i, j int) bool {
+		return orphans[i].Name < orphans[j].Name
+	})
+
+	return orphans, nil
+}
+
+// RemoveOrphans deletes the given objects from the cloud the way a
+// propagated local deletion does, returning how many were removed. Each is
+// backed up and leaves a tombstone for machines propagating deletes.
+// An object whose local file reappeared or that changed since it was found
+// is left alone.
+func (s *Syncer) RemoveOrphans(ctx context.Context, orphans []*SyncFileInfo) (int, error) {
+	removed := 0
+	for _, orphan := range orphans {
+		if err := ctx.Err(); err != nil {
+			return removed, err
+		}
+
+		localPath, err := s.localPath(orphan.Name)
+		if err != nil {
+			return removed, err
+		}
+		if fileExists(localPath) {
+			continue
+		}
+
+		cloud, err := s.storage.Stat(ctx, orphan.Name)
+		if err != nil {
+			return removed, fmt.Errorf("failed to stat %s: %w", orphan.Name, err)
+		}
+		if !cloud.ModTime.Equal(orphan.ModTime) || cloud.Checksum != orphan.Checksum {
+			continue
+		}
+
+		if err := s.deleteFromCloud(ctx, orphan.Name, cloud); err != nil {
+			return removed, err
+		}
+		removed++
+	}
+
+	return removed, nil
+}
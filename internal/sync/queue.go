@@ -0,0 +1,154 @@
+package sync
+
+import (
+	"container/heap"
+	"context"
+	gosync "sync"
+
+	"github.com/danielbehrens/cloudsync/internal/fsutil"
+)
+
+// transferJob is one file waiting to be synced
+type transferJob struct {
+	name     string // object name
+	path     string // local path
+	size     int64
+	priority int // lower is dequeued first
+	item     *PlanItem
+}
+
+// Job priorities. Within a priority, smaller files are dequeued first so a
+// single huge save cannot hold up many small ones.
+const (
+	priorityHigh = iota
+	priorityNormal
+)
+
+// jobPriority ranks a file by the configured PriorityPatterns
+func (s *Syncer) jobPriority(name string) int {
+	if fsutil.MatchAny(name, s.PriorityPatterns) {
+		return priorityHigh
+	}
+	return priorityNormal
+}
+
+// newJob builds a transferJob with its priority filled in
+func (s *Syncer) newJob(name, path string, size int64) *transferJob {
+	return &transferJob{
+		name:     name,
+		path:     path,
+		size:     size,
+		priority: s.jobPriority(name),
+	}
+}
+
+// jobHeap orders jobs by priority, then size ascending, then name
+type jobHeap []*transferJob
+
+func (h jobHeap) Len() int { return len(h) }
+
+func (h jobHeap) Less(i, j int) bool {
+	if h[i].priority != h[j].priority {
+		return h[i].priority < h[j].priority
+	}
+	if h[i].size != h[j].size {
+		return h[i].size < h[j].size
+	}
+	return h[i].name < h[j].name
+}
+
+func (h jobHeap) Swap(i, j int) { h[i], h[j] = h[j], h[i] }
+
+func (h *jobHeap) Push(x interface{}) { *h = append(*h, x.(*transferJob)) }
+
+func (h *jobHeap) Pop() interface{} {
+	old := *h
+	n := len(old)
+	job := old[n-1]
+	*h = old[:n-1]
+	return job
+}
+
+// workQueue is a goroutine-safe priority queue of transfer jobs. Workers
+// block in pop until a job is available or the queue is closed and drained.
+type workQueue struct {
+	mu     gosync.Mutex
+	cond   *gosync.Cond
+	jobs   jobHeap
+	closed bool
+}
+
+func newWorkQueue() *workQueue {
+	q := &workQueue{}
+	q.cond = gosync.NewCond(&q.mu)
+	return q
+}
+
+// push adds a job to the queue
+func (q *workQueue) push(job *transferJob) {
+	q.mu.Lock()
+	heap.Push(&q.jobs, job)
+	q.mu.Unlock()
+	q.cond.Signal()
+}
+
+// close marks the queue as complete; pop returns false once it is drained
+func (q *workQueue) close() {
+	q.mu.Lock()
+	q.closed = true
+	q.mu.Unlock()
+	q.cond.Broadcast()
+}
+
+// pop removes the highest priority job, blocking while the queue is empty
+func (q *workQueue) pop() (*transferJob, bool) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	for len(q.jobs) == 0 && !q.closed {
+		q.cond.Wait()
+	}
+	if len(q.jobs) == 0 {
+		return nil, false
+	}
+
+	return heap.Pop(&q.jobs).(*transferJob), true
+}
+
+// runJobs drains queue with up to Concurrency workers calling work. work
+// logs per-file failures itself; an error it returns is fatal, cancels the
+// jobs still running and skips the rest. runJobs returns the first such
+// error, or ctx's error if it was cancelled.
+func (s *Syncer) runJobs(ctx context.Context, queue *workQueue, work func(ctx context.Context, job *transferJob) error) error {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	var (
+		wg       gosync.WaitGroup
+		errOnce  gosync.Once
+		firstErr error
+	)
+
+	for range max(s.Concurrency, 1) {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for job, ok := queue.pop(); ok && ctx.Err() == nil; job, ok = queue.pop() {
+				if err := work(ctx, job); err != nil {
+					errOnce.Do(func() {
+						firstErr = err
+						cancel()
+					})
+					return
+				}
+			}
+		}()
+	}
+
+	wg.Wait()
+
+	if firstErr != nil {
+		return firstErr
+	}
+	return ctx.Err()
+}
@@ -0,0 +1,133 @@
+package sync
+
+import (
+	"context"
+	"fmt"
+	"path/filepath"
+	"strings"
+	gosync "sync"
+	"testing"
+	"time"
+)
+
+func TestWorkQueueOrdersBySizeAndPriority(t *testing.T) {
+	s := NewSyncer(newFakeStorage(), "", "", nil, 0)
+	s.PriorityPatterns = []string{"profile*.sav"}
+
+	q := newWorkQueue()
+	q.push(s.newJob("world-big.sav", "", 5000))
+	q.push(s.newJob("world-small.sav", "", 10))
+	q.push(s.newJob("Profile-Big.SAV", "", 9000))
+	q.push(s.newJob("world-medium.sav", "", 300))
+	q.push(s.newJob("profile-small.sav", "", 20))
+	q.close()
+
+	want := []string{"profile-small.sav", "Profile-Big.SAV", "world-small.sav", "world-medium.sav", "world-big.sav"}
+
+	var got []string
+	for job, ok := q.pop(); ok; job, ok = q.pop() {
+		got = append(got, job.name)
+	}
+
+	if strings.Join(got, ",") != strings.Join(want, ",") {
+		t.Errorf("dequeue order = %v, want %v", got, want)
+	}
+}
+
+func TestWorkQueueUnderContention(t *testing.T) {
+	s := NewSyncer(newFakeStorage(), "", "", nil, 0)
+	q := newWorkQueue()
+
+	// Producers push concurrently while the queue is still closed to workers
+	var producers gosync.WaitGroup
+	for p := 0; p < 4; p++ {
+		producers.Add(1)
+		go func(p int) {
+			defer producers.Done()
+			for i := 0; i < 50; i++ {
+				size := int64(p*50 + i)
+				q.push(s.newJob(fmt.Sprintf("f%03d.sav", size), "", size))
+			}
+		}(p)
+	}
+	producers.Wait()
+	q.close()
+
+	// Concurrent workers record the order in which jobs left the queue
+	var (
+		mu    gosync.Mutex
+		order []int64
+	)
+	var workers gosync.WaitGroup
+	for w := 0; w < 8; w++ {
+		workers.Add(1)
+		go func() {
+			defer workers.Done()
+			for {
+				mu.Lock()
+				job, ok := q.pop()
+				if ok {
+					order = append(order, job.size)
+				}
+				mu.Unlock()
+				if !ok {
+					return
+				}
+			}
+		}()
+	}
+	workers.Wait()
+
+	if len(order) != 200 {
+		t.Fatalf("dequeued %d jobs, want 200", len(order))
+	}
+	for i := 1; i < len(order); i++ {
+		if order[i-1] > order[i] {
+			t.Fatalf("job of size %d dequeued before smaller job of size %d", order[i-1], order[i])
+		}
+	}
+}
+
+func TestWorkQueuePopBlocksUntilPush(t *testing.T) {
+	s := NewSyncer(newFakeStorage(), "", "", nil, 0)
+	q := newWorkQueue()
+
+	got := make(chan string)
+	go func() {
+		job, _ := q.pop()
+		got <- job.name
+	}()
+
+	time.Sleep(10 * time.Millisecond)
+	q.push(s.newJob("late.sav", "", 1))
+
+	select {
+	case name := <-got:
+		if name != "late.sav" {
+			t.Errorf("pop() = %v, want %v", name, "late.sav")
+		}
+	case <-time.After(time.Second):
+		t.Fatal("pop() did not wake up after push")
+	}
+}
+
+func TestInitialSyncUploadsSmallFilesFirst(t *testing.T) {
+	dir := t.TempDir()
+	modTime := time.Now().Add(-time.Hour)
+
+	writeFile(t, filepath.Join(dir, "a-large.sav"), strings.Repeat("x", 4096), modTime)
+	writeFile(t, filepath.Join(dir, "b-small.sav"), "x", modTime)
+	writeFile(t, filepath.Join(dir, "c-medium.sav"), strings.Repeat("x", 512), modTime)
+
+	store := newFakeStorage()
+	s := NewSyncer(store, dir, t.TempDir(), nil, 500*time.Millisecond)
+
+	if _, err := s.InitialSync(context.Background()); err != nil {
+		t.Fatalf("InitialSync() error = %v", err)
+	}
+
+	want := []string{"b-small.sav", "c-medium.sav", "a-large.sav"}
+	if strings.Join(store.uploadOrder, ",") != strings.Join(want, ",") {
+		t.Errorf("upload order = %v, want %v", store.uploadOrder, want)
+	}
+}
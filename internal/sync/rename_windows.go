@@ -0,0 +1,16 @@
+package sync
+
+import (
+	"errors"
+	"syscall"
+)
+
+// errorNotSameDevice is ERROR_NOT_SAME_DEVICE, returned when moving a file
+// to another volume
+const errorNotSameDevice syscall.Errno = 17
+
+// isCrossDevice reports whether a rename failed because source and target
+// are on different volumes
+func isCrossDevice(err error) bool {
+	return errors.Is(err, errorNotSameDevice) || errors.Is(err, syscall.EXDEV)
+}
@@ -0,0 +1,144 @@
+package sync
+
+import (
+	"fmt"
+	"io/fs"
+	"log/slog"
+	"path/filepath"
+	"sort"
+	"time"
+)
+
+// Backup is one timestamped backup folder
+type Backup struct {
+	Name  string    // folder name, the backup timestamp
+	Time  time.Time // when the backup was taken
+	Files []string  // object names of the files it holds
+}
+
+// LatestBackup selects the newest backup in RestoreBackup
+const LatestBackup = "latest"
+
+// Backups lists the timestamped backup folders, newest first
+func (s *Syncer) Backups() ([]Backup, error) {
+	folders, err := s.listBackups()
+	if err != nil {
+		return nil, err
+	}
+
+	sort.Slice(folders, func(i, j int) bool {
+		return folders[i].newerThan(folders[j])
+	})
+
+	backups := make([]Backup, 0, len(folders))
+	for _, f := range folders {
+		files, err := s.backupFiles(f.path)
+		if err != nil {
+			return nil, err
+		}
+		backups = append(backups, Backup{Name: filepath.Base(f.path), Time: f.time, Files: files})
+	}
+
+	return backups, nil
+}
+
+// backupFiles returns the object names of the restorable files in a backup
+// folder: ones that pass the file filter and map to a valid local path
+func (s *Syncer) backupFiles(dir string) ([]string, error) {
+	var names []string
+	err := filepath.WalkDir(dir, func(p string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if d.IsDir() {
+			return nil
+		}
+
+		rel, err := filepath.Rel(dir, p)
+		if err != nil {
+			return err
+		}
+		name := filepath.ToSlash(rel)
+		if _, err := s.localPath(name); err != nil || !s.shouldSyncFile(name) {
+			return nil
+		}
+		names = append(names, name)
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to read backup %s: %w", dir, err)
+	}
+
+	return names, nil
+}
+
+// RestoreBackup copies every save in the named backup folder (or the newest
+// one, for LatestBackup) back into the watch path and returns their object
+// names. The live files it replaces are backed up together into a new
+// folder first. Restored files get a fresh modification time so the next
+// sync pushes them to the cloud. With DryRun, it only logs what it would do.
+func (s *Syncer) RestoreBackup(name string) ([]string, error) {
+	backups, err := s.Backups()
+	if err != nil {
+		return nil, err
+	}
+
+	var backup *Backup
+	for i := range backups {
+		if backups[i].Name == name || (name == LatestBackup && i == 0) {
+			backup = &backups[i]
+			break
+		}
+	}
+	if backup == nil {
+		return nil, fmt.Errorf("no backup %q in %s", name, s.backupDir)
+	}
+	if len(backup.Files) == 0 {
+		return nil, fmt.Errorf("backup %s holds no save files", backup.Name)
+	}
+
+	srcDir := filepath.Join(s.backupDir, backup.Name)
+
+	// Back up the live files first, all into one folder. This is taken
+	// before restoring anything, so pruning can't remove srcDir midway.
+	var preRestore string
+	for _, name := range backup.Files {
+		livePath, _ := s.localPath(name)
+		if !fileExists(livePath) {
+			continue
+		}
+
+		if s.DryRun {
+			slog.Info("[dry-run] Would back up", "path", livePath)
+			continue
+		}
+		if preRestore == "" {
+			if preRestore, err = s.createTimestampedBackupDir(); err != nil {
+				return nil, fmt.Errorf("failed to create backup directory: %w", err)
+			}
+		}
+		if err := s.backupInto(preRestore, livePath); err != nil {
+			return nil, fmt.Errorf("failed to back up live file: %w", err)
+		}
+	}
+
+	for i, name := range backup.Files {
+		livePath, _ := s.localPath(name)
+		src := filepath.Join(srcDir, filepath.FromSlash(name))
+
+		if s.DryRun {
+			slog.Info("[dry-run] Would restore from backup", "file", name, "backup", backup.Name)
+			continue
+		}
+
+		if err := ensureDir(filepath.Dir(livePath)); err != nil {
+			return backup.Files[:i], fmt.Errorf("failed to create save directory: %w", err)
+		}
+		if err := replaceFile(src, livePath); err != nil {
+			return backup.Files[:i], fmt.Errorf("failed to restore %s: %w", name, err)
+		}
+		slog.Info("Restored from backup", "file", name, "backup", backup.Name)
+	}
+
+	return backup.Files, nil
+}
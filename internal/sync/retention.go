@@ -0,0 +1,125 @@
+package sync
+
+import (
+	"fmt"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// backupTimeLayout names the timestamped backup folders, in local time
+const backupTimeLayout = "2006-01-02_15-04-05.000000"
+
+// maxBackupDirSuffix bounds the "-N" suffixes tried when a backup folder
+// for the same microsecond already exists
+const maxBackupDirSuffix = 100
+
+// backupFolder is a timestamped folder in the backup dir. seq is the
+// suffix that set apart folders created within the same microsecond, 0 for
+// the first.
+type backupFolder struct {
+	path string
+	time time.Time
+	seq  int
+}
+
+// newerThan reports whether f was created after g
+func (f backupFolder) newerThan(g backupFolder) bool {
+	if !f.time.Equal(g.time) {
+		return f.time.After(g.time)
+	}
+	return f.seq > g.seq
+}
+
+// parseBackupName parses a backup folder name, a backupTimeLayout time
+// optionally followed by "-N"
+func parseBackupName(name string) (time.Time, int, bool) {
+	if len(name) < len(backupTimeLayout) {
+		return time.Time{}, 0, false
+	}
+	t, err := time.ParseInLocation(backupTimeLayout, name[:len(backupTimeLayout)], time.Local)
+	if err != nil {
+		return time.Time{}, 0, false
+	}
+
+	suffix := name[len(backupTimeLayout):]
+	if suffix == "" {
+		return t, 0, true
+	}
+	seq, err := strconv.Atoi(strings.TrimPrefix(suffix, "-"))
+	if !strings.HasPrefix(suffix, "-") || err != nil || seq < 1 {
+		return time.Time{}, 0, false
+	}
+	return t, seq, true
+}
+
+// PruneBackups removes timestamped backup folders beyond MaxBackups or older
+// than MaxBackupAge. Folders whose names aren't backup timestamps (such as
+// the latest-good copies) are left alone. A zero limit disables it.
+func (s *Syncer) PruneBackups() error {
+	if s.MaxBackups <= 0 && s.MaxBackupAge <= 0 {
+		return nil
+	}
+
+	folders, err := s.listBackups()
+	if err != nil {
+		return err
+	}
+
+	// Newest first, so the folders to keep come before the ones to remove
+	sort.Slice(folders, func(i, j int) bool {
+		return folders[i].newerThan(folders[j])
+	})
+
+	cutoff := time.Now().Add(-s.MaxBackupAge)
+
+	var failed int
+	for i, f := range folders {
+		tooMany := s.MaxBackups > 0 && i >= s.MaxBackups
+		tooOld := s.MaxBackupAge > 0 && f.time.Before(cutoff)
+		if !tooMany && !tooOld {
+			continue
+		}
+
+		if err := os.RemoveAll(f.path); err != nil {
+			slog.Warn("Failed to remove old backup", "path", f.path, "err", err)
+			failed++
+			continue
+		}
+		slog.Info("Removed old backup", "path", f.path)
+	}
+
+	if failed > 0 {
+		return fmt.Errorf("failed to remove %d old backups", failed)
+	}
+	return nil
+}
+
+// listBackups returns the timestamped folders in the backup dir
+func (s *Syncer) listBackups() ([]backupFolder, error) {
+	entries, err := os.ReadDir(s.backupDir)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, fmt.Errorf("failed to read backup directory: %w", err)
+	}
+
+	var folders []backupFolder
+	for _, entry := range entries {
+		if !entry.IsDir() {
+			continue
+		}
+		t, seq, ok := parseBackupName(entry.Name())
+		if !ok {
+			continue
+		}
+		folders = append(folders, backupFolder{path: filepath.Join(s.backupDir, entry.Name()), time: t, seq: seq})
+	}
+
+	return folders, nil
+}
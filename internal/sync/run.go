@@ -0,0 +1,160 @@
+package sync
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"time"
+
+	"github.com/fsnotify/fsnotify"
+)
+
+// Watcher is the source of file events for Run. *watcher.FileWatcher
+// implements it.
+type Watcher interface {
+	Events() <-chan fsnotify.Event
+	Errors() <-chan error
+
+	// IsDeletion reports whether event removed a file that syncs
+	IsDeletion(event fsnotify.Event) bool
+
+	// Triggers reports whether event's operation should trigger a sync,
+	// without side effects
+	Triggers(event fsnotify.Event) bool
+
+	// ShouldProcess reports whether event should sync the file it names,
+	// applying the patterns and the cooldown
+	ShouldProcess(event fsnotify.Event) bool
+}
+
+// Rewatcher is implemented by watchers that re-establish their watch after
+// the watch path goes away and comes back. *watcher.FileWatcher implements
+// it.
+type Rewatcher interface {
+	// Rewatched receives a value each time the watch is re-established
+	Rewatched() <-chan struct{}
+}
+
+// Run syncs the files w reports changes to until ctx is cancelled or w's
+// channels are closed. Every SyncInterval it also runs a full sync, to
+// catch changes made while sync was paused, and so it does every
+// FullSyncInterval, to catch events the watcher missed, whenever a
+// Rewatcher re-establishes its watch, and on Resume. Call InitialSync
+// first: Run only handles changes from then on.
+func (s *Syncer) Run(ctx context.Context, w Watcher) {
+	tick, stopTick := newTicker(s.SyncInterval)
+	defer stopTick()
+	fullTick, stopFullTick := newTicker(s.FullSyncInterval)
+	defer stopFullTick()
+	var rewatched <-chan struct{}
+	if rw, ok := w.(Rewatcher); ok {
+		rewatched = rw.Rewatched()
+	}
+
+	for {
+		select {
+		case event, ok := <-w.Events():
+			if !ok {
+				return
+			}
+			s.handleEvent(ctx, w, event)
+		case err, ok := <-w.Errors():
+			if !ok {
+				return
+			}
+			slog.Warn("Watcher error", "watch", s.watchPath, "err", err)
+		case <-tick:
+			s.fullSyncUnlessPaused(ctx, "Periodic sync")
+		case <-fullTick:
+			s.fullSyncUnlessPaused(ctx, "Scheduled full sync")
+		case <-rewatched:
+			// Changes made while the watch path was gone went unreported
+			s.fullSyncUnlessPaused(ctx, "Catch-up sync")
+		case <-s.resumed:
+			// The events of changes made while paused were dropped
+			s.fullSyncUnlessPaused(ctx, "Catch-up sync")
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// newTicker returns the channel of a ticker firing every interval and a
+// function stopping it. A zero interval returns a nil channel, which never
+// fires.
+func newTicker(interval time.Duration) (<-chan time.Time, func()) {
+	if interval <= 0 {
+		return nil, func() {}
+	}
+	ticker := time.NewTicker(interval)
+	return ticker.C, ticker.Stop
+}
+
+// ErrPaused is returned by SyncNow while sync is paused
+var ErrPaused = errors.New("sync is paused")
+
+// SyncNow runs a full sync on request, unless sync is paused, and returns
+// what it did. It may be called while Run runs: it waits for a full sync
+// Run started to finish, but not for Run's handling of a file event, so a
+// file may be synced by both at once.
+func (s *Syncer) SyncNow(ctx context.Context) (*SyncSummary, error) {
+	if reason := s.PauseReason(); reason != "" {
+		return nil, fmt.Errorf("%w: %s", ErrPaused, reason)
+	}
+	return s.fullSync(ctx)
+}
+
+// fullSyncUnlessPaused runs a full sync unless sync is paused, logging a
+// failure as what failed
+func (s *Syncer) fullSyncUnlessPaused(ctx context.Context, what string) {
+	if s.PauseReason() != "" {
+		return
+	}
+	if err := s.FullSync(ctx); err != nil && ctx.Err() == nil {
+		slog.Error(what+" failed", "watch", s.watchPath, "err", err)
+	}
+}
+
+// handleEvent syncs the change or deletion event reports, unless sync is
+// paused
+func (s *Syncer) handleEvent(ctx context.Context, w Watcher, event fsnotify.Event) {
+	if s.isIgnoreFile(event.Name) {
+		// Files the new rules let through may not have synced yet
+		if s.LoadIgnoreFile() {
+			slog.Info("Reloaded", "path", event.Name)
+			s.fullSyncUnlessPaused(ctx, "Catch-up sync")
+		}
+		return
+	}
+	if s.IsPaused() {
+		slog.Debug("Sync paused, dropping event", "event", event)
+		return
+	}
+	if s.PropagateDeletes && w.IsDeletion(event) {
+		// A deletion missed while paused is caught by the next full sync
+		if s.PauseReason() != "" {
+			return
+		}
+		slog.Debug("Detected deletion", "path", event.Name)
+		if err := s.DeleteFile(ctx, event.Name); err != nil && ctx.Err() == nil {
+			slog.Error("Failed to propagate deletion", "path", event.Name, "err", err)
+		}
+		return
+	}
+	if !w.Triggers(event) {
+		return
+	}
+	if reason := s.PauseReason(); reason != "" {
+		slog.Info("Sync paused", "watch", s.watchPath, "reason", reason)
+		return
+	}
+	if !w.ShouldProcess(event) || s.ignored(event.Name) {
+		return
+	}
+
+	slog.Debug("Detected change", "path", event.Name)
+	if err := s.SyncFile(ctx, event.Name); err != nil && ctx.Err() == nil {
+		slog.Error("Failed to sync", "path", event.Name, "err", err)
+	}
+}
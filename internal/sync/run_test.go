@@ -0,0 +1,269 @@
+package sync
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/danielbehrens/cloudsync/internal/fsutil"
+	"github.com/danielbehrens/cloudsync/internal/power"
+	"github.com/fsnotify/fsnotify"
+)
+
+// fakeWatcher feeds synthetic events to Run. It applies the default
+// patterns and write/create trigger ops, without a cooldown.
+type fakeWatcher struct {
+	events chan fsnotify.Event
+	errors chan error
+}
+
+func newFakeWatcher() *fakeWatcher {
+	return &fakeWatcher{
+		events: make(chan fsnotify.Event),
+		errors: make(chan error),
+	}
+}
+
+func (w *fakeWatcher) Events() <-chan fsnotify.Event { return w.events }
+func (w *fakeWatcher) Errors() <-chan error          { return w.errors }
+
+func (w *fakeWatcher) IsDeletion(event fsnotify.Event) bool {
+	_, err := os.Lstat(event.Name)
+	return event.Has(fsnotify.Remove) && os.IsNotExist(err) && w.matches(event)
+}
+
+func (w *fakeWatcher) Triggers(event fsnotify.Event) bool {
+	return event.Has(fsnotify.Write) || event.Has(fsnotify.Create)
+}
+
+func (w *fakeWatcher) ShouldProcess(event fsnotify.Event) bool {
+	return w.Triggers(event) && w.matches(event)
+}
+
+func (w *fakeWatcher) matches(event fsnotify.Event) bool {
+	return fsutil.MatchFile(event.Name, fsutil.DefaultIncludePatterns, fsutil.DefaultExcludePatterns)
+}
+
+// runEvents runs s over events and returns once all were handled
+func runEvents(t *testing.T, s *Syncer, events ...fsnotify.Event) {
+	t.Helper()
+	w := newFakeWatcher()
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		s.Run(context.Background(), w)
+	}()
+
+	for _, event := range events {
+		w.events <- event
+	}
+	close(w.events)
+
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("Run did not return after the watcher closed")
+	}
+}
+
+func TestRunSyncsEvents(t *testing.T) {
+	store := newFakeStorage()
+	s := newDeleteClient(t, store)
+	if _, err := s.InitialSync(context.Background()); err != nil {
+		t.Fatalf("InitialSync() error = %v", err)
+	}
+
+	save := filepath.Join(s.watchPath, "game.sav")
+	notes := filepath.Join(s.watchPath, "notes.txt")
+	writeFile(t, save, "save", time.Now().Add(-time.Minute))
+	writeFile(t, notes, "notes", time.Now().Add(-time.Minute))
+
+	runEvents(t, s,
+		fsnotify.Event{Name: save, Op: fsnotify.Chmod},  // not a trigger
+		fsnotify.Event{Name: notes, Op: fsnotify.Write}, // not a save
+		fsnotify.Event{Name: save, Op: fsnotify.Write},
+	)
+	if store.uploads != 1 || store.objects["game.sav"] == nil {
+		t.Fatalf("uploads = %v, want just game.sav", store.uploadOrder)
+	}
+
+	os.Remove(save)
+	runEvents(t, s, fsnotify.Event{Name: save, Op: fsnotify.Remove})
+	if store.objects["game.sav"] != nil {
+		t.Error("game.sav still in the cloud after its deletion event")
+	}
+	if store.objects[tombstoneName("game.sav")] == nil {
+		t.Error("no tombstone left for game.sav")
+	}
+}
+
+func TestRunReloadsIgnoreFile(t *testing.T) {
+	store := newFakeStorage()
+	s := NewSyncer(store, t.TempDir(), t.TempDir(), nil, 500*time.Millisecond)
+
+	ignore := filepath.Join(s.watchPath, fsutil.IgnoreFileName)
+	writeFile(t, ignore, "# not yet\nb.sav\n", time.Now())
+	a := filepath.Join(s.watchPath, "a.sav")
+	b := filepath.Join(s.watchPath, "b.sav")
+	writeFile(t, a, "a", time.Now().Add(-time.Minute))
+	writeFile(t, b, "b", time.Now().Add(-time.Minute))
+
+	if _, err := s.InitialSync(context.Background()); err != nil {
+		t.Fatalf("InitialSync() error = %v", err)
+	}
+	if store.uploads != 1 || store.objects["a.sav"] == nil {
+		t.Fatalf("uploads = %v, want just a.sav", store.uploadOrder)
+	}
+
+	// An ignored file's own events don't sync it either
+	writeFile(t, b, "b2", time.Now())
+	runEvents(t, s, fsnotify.Event{Name: b, Op: fsnotify.Write})
+	if store.objects["b.sav"] != nil {
+		t.Fatal("b.sav uploaded while ignored")
+	}
+
+	writeFile(t, ignore, "# nothing ignored\n", time.Now())
+	runEvents(t, s, fsnotify.Event{Name: ignore, Op: fsnotify.Write})
+	if store.objects["b.sav"] == nil {
+		t.Errorf("uploads = %v, want b.sav synced once no longer ignored", store.uploadOrder)
+	}
+}
+
+func TestRunSkipsEventsWhilePaused(t *testing.T) {
+	store := newFakeStorage()
+	s := NewSyncer(store, t.TempDir(), t.TempDir(), nil, 500*time.Millisecond)
+	s.Power = power.ProviderFunc(func() (power.State, error) { return power.StateBattery, nil })
+
+	save := filepath.Join(s.watchPath, "game.sav")
+	writeFile(t, save, "save", time.Now().Add(-time.Minute))
+	runEvents(t, s, fsnotify.Event{Name: save, Op: fsnotify.Write})
+
+	if store.uploads != 0 {
+		t.Errorf("uploads = %v while paused, want none", store.uploadOrder)
+	}
+}
+
+func TestRunCatchesUpAfterResume(t *testing.T) {
+	store := newFakeStorage()
+	s := NewSyncer(store, t.TempDir(), t.TempDir(), nil, 500*time.Millisecond)
+	s.Pause()
+	if !s.IsPaused() || s.PauseReason() != PauseRequested {
+		t.Fatalf("IsPaused() = %v, PauseReason() = %q after Pause", s.IsPaused(), s.PauseReason())
+	}
+
+	w := newFakeWatcher()
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		s.Run(ctx, w)
+	}()
+
+	save := filepath.Join(s.watchPath, "game.sav")
+	writeFile(t, save, "save", time.Now().Add(-time.Minute))
+	w.events <- fsnotify.Event{Name: save, Op: fsnotify.Write}
+	// A second event is only received once the first was handled
+	w.events <- fsnotify.Event{Name: save, Op: fsnotify.Write}
+	if _, err := store.Stat(ctx, "game.sav"); err == nil {
+		t.Fatal("game.sav uploaded while paused")
+	}
+
+	s.Resume()
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		if _, err := store.Stat(ctx, "game.sav"); err == nil {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("sync after Resume never uploaded game.sav")
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+
+	cancel()
+	<-done
+}
+
+func TestRunPeriodicSync(t *testing.T) {
+	tests := []struct {
+		name string
+		set  func(s *Syncer)
+	}{
+		{name: "sync interval", set: func(s *Syncer) { s.SyncInterval = 10 * time.Millisecond }},
+		{name: "full sync interval", set: func(s *Syncer) { s.FullSyncInterval = 10 * time.Millisecond }},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			store := newFakeStorage()
+			s := NewSyncer(store, t.TempDir(), t.TempDir(), nil, 500*time.Millisecond)
+			tt.set(s)
+
+			// Changed without an event, as while paused
+			writeFile(t, filepath.Join(s.watchPath, "game.sav"), "save", time.Now().Add(-time.Minute))
+
+			ctx, cancel := context.WithCancel(context.Background())
+			done := make(chan struct{})
+			go func() {
+				defer close(done)
+				s.Run(ctx, newFakeWatcher())
+			}()
+
+			deadline := time.Now().Add(5 * time.Second)
+			for {
+				if _, err := store.Stat(ctx, "game.sav"); err == nil {
+					break
+				}
+				if time.Now().After(deadline) {
+					t.Fatal("periodic sync never uploaded game.sav")
+				}
+				time.Sleep(5 * time.Millisecond)
+			}
+
+			cancel()
+			<-done
+		})
+	}
+}
+
+// rewatchingWatcher is a fakeWatcher that also reports re-established
+// watches
+type rewatchingWatcher struct {
+	*fakeWatcher
+	rewatched chan struct{}
+}
+
+func (w *rewatchingWatcher) Rewatched() <-chan struct{} { return w.rewatched }
+
+func TestRunSyncsAfterRewatch(t *testing.T) {
+	store := newFakeStorage()
+	s := NewSyncer(store, t.TempDir(), t.TempDir(), nil, 500*time.Millisecond)
+
+	// Changed while the watch path was gone, so without an event
+	writeFile(t, filepath.Join(s.watchPath, "game.sav"), "save", time.Now().Add(-time.Minute))
+
+	w := &rewatchingWatcher{fakeWatcher: newFakeWatcher(), rewatched: make(chan struct{})}
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		s.Run(ctx, w)
+	}()
+	w.rewatched <- struct{}{}
+
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		if _, err := store.Stat(ctx, "game.sav"); err == nil {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("sync after the rewatch never uploaded game.sav")
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+
+	cancel()
+	<-done
+}
@@ -0,0 +1,40 @@
+package sync
+
+import (
+	"context"
+	"log/slog"
+	"time"
+)
+
+// skewResolution is how precisely ClockSkewer measures the skew
+const skewResolution = time.Second
+
+// CheckClockSkew warns when the local clock is further from the storage
+// server's than the time tolerance covers. Mod times are stamped by each
+// machine's own clock, so machines that disagree with the server likely
+// disagree with each other, and then a file that just synced can compare
+// as newer on the other machine and sync back. It returns the measured
+// skew, or 0 if the storage can't tell.
+func (s *Syncer) CheckClockSkew(ctx context.Context) time.Duration {
+	skewer, ok := s.storage.(ClockSkewer)
+	if !ok {
+		return 0
+	}
+
+	skew, err := skewer.ClockSkew(ctx)
+	if err != nil {
+		slog.Warn("Could not read the storage server's clock", "err", err)
+		return 0
+	}
+
+	if skew.Abs() > s.timeTolerance+skewResolution {
+		direction := "behind"
+		if skew < 0 {
+			direction = "ahead"
+		}
+		slog.Warn("This machine's clock is off from the storage server's by more than the time tolerance. "+
+			"Saves may sync back and forth between machines; sync the system clock (NTP) or raise -time-tolerance.",
+			"skew", skew.Abs().Round(time.Second), "clock", direction, "time_tolerance", s.timeTolerance)
+	}
+	return skew
+}
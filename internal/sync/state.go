@@ -0,0 +1,292 @@
+package sync
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// syncState is the content of StateFile
+type syncState struct {
+	// Files holds the baseline of each file at its last sync, for conflict
+	// detection
+	Files map[string]fileBaseline `json:"files"`
+
+	// Objects caches the cloud metadata seen by the last listing, so
+	// objects whose ETag is unchanged need not be statted again
+	Objects map[string]cachedObject `json:"objects"`
+}
+
+// fileBaseline is the content and mod time both sides of a file had when
+// it was last synced, and when that was
+type fileBaseline struct {
+	SHA256   string    `json:"sha256"`
+	ModTime  time.Time `json:"mod_time"`
+	SyncedAt time.Time `json:"synced_at,omitzero"`
+}
+
+// cachedObject is the metadata of a cloud object as of the last listing
+type cachedObject struct {
+	ModTime  time.Time `json:"mod_time"`
+	ETag     string    `json:"etag"`
+	Size     int64     `json:"size"`
+	Checksum string    `json:"sha256,omitempty"`
+	Coarse   bool      `json:"coarse_mod_time,omitempty"`
+}
+
+// loadState returns the content of StateFile, read on first use and kept
+// in memory after that, since this Syncer is its only writer. A missing
+// file is an empty state. Callers hold stateMu.
+func (s *Syncer) loadState() (*syncState, error) {
+	if s.state != nil {
+		return s.state, nil
+	}
+	state := &syncState{}
+
+	data, err := os.ReadFile(s.StateFile)
+	if err != nil && !os.IsNotExist(err) {
+		return nil, fmt.Errorf("failed to read sync state: %w", err)
+	}
+	if err == nil {
+		if err := json.Unmarshal(data, state); err != nil {
+			return nil, fmt.Errorf("failed to parse sync state %s: %w", s.StateFile, err)
+		}
+	}
+
+	if state.Files == nil {
+		state.Files = make(map[string]fileBaseline)
+	}
+	if state.Objects == nil {
+		state.Objects = make(map[string]cachedObject)
+	}
+
+	s.state = state
+	return state, nil
+}
+
+// saveState writes state to StateFile, or, during a full sync, leaves that
+// to flushState. Callers hold stateMu.
+func (s *Syncer) saveState(state *syncState) error {
+	if s.stateBatched {
+		s.stateDirty = true
+		return nil
+	}
+	return s.writeState(state)
+}
+
+// batchState defers saving the state until flushState, so a full sync
+// writes StateFile once rather than once per file
+func (s *Syncer) batchState() {
+	s.stateMu.Lock()
+	defer s.stateMu.Unlock()
+	s.stateBatched = true
+}
+
+// flushState ends batchState, writing the state if it changed meanwhile.
+// A failure is logged; the next save writes it again.
+func (s *Syncer) flushState() {
+	s.stateMu.Lock()
+	defer s.stateMu.Unlock()
+	s.stateBatched = false
+	if !s.stateDirty || s.state == nil {
+		return
+	}
+	if err := s.writeState(s.state); err != nil {
+		slog.Warn("Failed to save sync state", "err", err)
+		return
+	}
+	s.stateDirty = false
+}
+
+// writeState writes StateFile, replacing it atomically so a crash never
+// leaves it truncated. Callers hold stateMu.
+func (s *Syncer) writeState(state *syncState) error {
+	data, err := json.MarshalIndent(state, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to encode sync state: %w", err)
+	}
+
+	if err := ensureDir(filepath.Dir(s.StateFile)); err != nil {
+		return fmt.Errorf("failed to create sync state directory: %w", err)
+	}
+
+	tempPath := s.StateFile + ".tmp"
+	if err := os.WriteFile(tempPath, data, 0644); err != nil {
+		os.Remove(tempPath)
+		return fmt.Errorf("failed to write sync state: %w", err)
+	}
+	if err := os.Rename(tempPath, s.StateFile); err != nil {
+		os.Remove(tempPath)
+		return fmt.Errorf("failed to write sync state: %w", err)
+	}
+
+	return nil
+}
+
+// listCloud lists the cloud objects that shouldSyncFile accepts. When the
+// storage implements ETagLister and StateFile holds the previous listing,
+// objects whose ETag is unchanged reuse the cached metadata and only the
+// rest are statted; otherwise, or with ForceFullSync, it falls back to a
+// full listing. The result is cached for the next run.
+func (s *Syncer) listCloud(ctx context.Context) ([]*SyncFileInfo, error) {
+	lister, ok := s.storage.(ETagLister)
+	if s.StateFile == "" || s.ForceFullSync || !ok {
+		return s.listAndCache(ctx)
+	}
+
+	s.stateMu.Lock()
+	state, err := s.loadState()
+	s.stateMu.Unlock()
+	if err != nil {
+		slog.Warn("Ignoring cached listing", "err", err)
+		return s.listAndCache(ctx)
+	}
+	if len(state.Objects) == 0 {
+		return s.listAndCache(ctx)
+	}
+
+	etags, err := lister.ListETags(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	files := make([]*SyncFileInfo, 0, len(etags))
+	var changed []string
+	for name, etag := range etags {
+		if !s.listedInCloud(name) {
+			continue
+		}
+
+		// Listings and stats may differ in quoting the ETag
+		etag = strings.Trim(etag, `"`)
+		if cached, ok := state.Objects[name]; ok && etag != "" && strings.Trim(cached.ETag, `"`) == etag {
+			files = append(files, &SyncFileInfo{
+				Name:          name,
+				ModTime:       cached.ModTime,
+				Size:          cached.Size,
+				ETag:          cached.ETag,
+				Checksum:      cached.Checksum,
+				CoarseModTime: cached.Coarse,
+			})
+			continue
+		}
+		changed = append(changed, name)
+	}
+
+	statted, err := lister.StatAll(ctx, changed)
+	if err != nil {
+		return nil, err
+	}
+	files = append(files, statted...)
+	slog.Debug("Listed cloud objects", "objects", len(files), "changed", len(statted))
+
+	s.cacheListing(files)
+	return files, nil
+}
+
+// listAndCache lists the objects listedInCloud accepts and caches the
+// result. Storage implementing FilteredLister skips the metadata of the
+// others.
+func (s *Syncer) listAndCache(ctx context.Context) ([]*SyncFileInfo, error) {
+	var files []*SyncFileInfo
+	if lister, ok := s.storage.(FilteredLister); ok {
+		var err error
+		if files, err = lister.ListFiltered(ctx, "", s.listedInCloud); err != nil {
+			return nil, err
+		}
+	} else {
+		all, err := s.storage.List(ctx)
+		if err != nil {
+			return nil, err
+		}
+		for _, f := range all {
+			if s.listedInCloud(f.Name) {
+				files = append(files, f)
+			}
+		}
+	}
+
+	s.cacheListing(files)
+	return files, nil
+}
+
+// cacheListing replaces the cached cloud metadata with files. Failures are
+// logged; the next run then lists in full.
+func (s *Syncer) cacheListing(files []*SyncFileInfo) {
+	if s.StateFile == "" || s.DryRun {
+		return
+	}
+
+	s.stateMu.Lock()
+	defer s.stateMu.Unlock()
+
+	state, err := s.loadState()
+	if err != nil {
+		slog.Warn("Failed to cache cloud listing", "err", err)
+		return
+	}
+
+	state.Objects = make(map[string]cachedObject, len(files))
+	for _, f := range files {
+		state.Objects[f.Name] = cachedObject{
+			ModTime:  f.ModTime,
+			ETag:     f.ETag,
+			Size:     f.Size,
+			Checksum: f.Checksum,
+			Coarse:   f.CoarseModTime,
+		}
+	}
+
+	if err := s.saveState(state); err != nil {
+		slog.Warn("Failed to cache cloud listing", "err", err)
+	}
+}
+
+// setLastSynced records that objectName was transferred at t
+func (s *Syncer) setLastSynced(objectName string, t time.Time) {
+	s.stateMu.Lock()
+	defer s.stateMu.Unlock()
+
+	if s.lastSynced == nil {
+		s.lastSynced = make(map[string]time.Time)
+	}
+	s.lastSynced[objectName] = t
+}
+
+// LastSynced returns when objectName was last uploaded or downloaded
+// successfully, and false if that is unknown. Times from earlier runs come
+// from StateFile.
+func (s *Syncer) LastSynced(objectName string) (time.Time, bool) {
+	t, ok := s.lastSyncTimes()[objectName]
+	return t, ok
+}
+
+// lastSyncTimes returns the last successful sync time of every object
+// that has one: those recorded in StateFile, updated by this run's
+func (s *Syncer) lastSyncTimes() map[string]time.Time {
+	s.stateMu.Lock()
+	defer s.stateMu.Unlock()
+
+	times := make(map[string]time.Time)
+	if s.StateFile != "" {
+		state, err := s.loadState()
+		if err != nil {
+			slog.Warn("Last sync times from earlier runs unavailable", "err", err)
+		} else {
+			for name, base := range state.Files {
+				if !base.SyncedAt.IsZero() {
+					times[name] = base.SyncedAt
+				}
+			}
+		}
+	}
+	for name, t := range s.lastSynced {
+		times[name] = t
+	}
+	return times
+}
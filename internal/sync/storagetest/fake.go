@@ -0,0 +1,224 @@
+// Package storagetest provides an in-memory sync.Storage for tests of code
+// built on the sync package.
+package storagetest
+
+import (
+	"context"
+	"crypto/md5"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"os"
+	gosync "sync"
+	"time"
+
+	"github.com/danielbehrens/cloudsync/internal/sync"
+)
+
+// Op names a Storage operation, for injecting errors and counting calls
+type Op string
+
+// Storage operations
+const (
+	OpUpload       Op = "upload"
+	OpDownload     Op = "download"
+	OpDelete       Op = "delete"
+	OpStat         Op = "stat"
+	OpList         Op = "list"
+	OpEnsureBucket Op = "ensure-bucket"
+)
+
+// FakeStorage is an in-memory sync.Storage. Like the real backends it
+// records each upload's mod time to the nanosecond, its size and SHA-256,
+// and reports missing objects with sync.ErrNotExist. It is safe for
+// concurrent use.
+type FakeStorage struct {
+	// Latency is waited before every operation, standing in for a network
+	// round trip. An operation whose context ends first fails with the
+	// context's error.
+	Latency time.Duration
+
+	mu      gosync.Mutex
+	objects map[string]*object
+	errs    map[Op]error
+	calls   map[Op]int
+}
+
+type object struct {
+	info sync.SyncFileInfo
+	data []byte
+}
+
+var _ sync.Storage = (*FakeStorage)(nil)
+
+// New returns an empty FakeStorage
+func New() *FakeStorage {
+	return &FakeStorage{
+		objects: make(map[string]*object),
+		errs:    make(map[Op]error),
+		calls:   make(map[Op]int),
+	}
+}
+
+// SetError makes every later call of op fail with err, without touching
+// the stored objects, until it is cleared with a nil err
+func (f *FakeStorage) SetError(op Op, err error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if err == nil {
+		delete(f.errs, op)
+		return
+	}
+	f.errs[op] = err
+}
+
+// Calls returns how many times op was called, failed calls included
+func (f *FakeStorage) Calls(op Op) int {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.calls[op]
+}
+
+// Put stores data as objectName, as if uploaded from a file last modified
+// at modTime. It doesn't count as a call.
+func (f *FakeStorage) Put(objectName string, data []byte, modTime time.Time) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.put(objectName, data, modTime)
+}
+
+// Data returns the content of objectName and whether it exists
+func (f *FakeStorage) Data(objectName string) ([]byte, bool) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	obj, ok := f.objects[objectName]
+	if !ok {
+		return nil, false
+	}
+	return append([]byte(nil), obj.data...), true
+}
+
+// put stores an object. Callers hold mu.
+func (f *FakeStorage) put(objectName string, data []byte, modTime time.Time) {
+	sum := sha256.Sum256(data)
+	etag := md5.Sum(data)
+	f.objects[objectName] = &object{
+		info: sync.SyncFileInfo{
+			Name:     objectName,
+			ModTime:  modTime.UTC(),
+			Size:     int64(len(data)),
+			ETag:     hex.EncodeToString(etag[:]),
+			Checksum: hex.EncodeToString(sum[:]),
+		},
+		data: append([]byte(nil), data...),
+	}
+}
+
+// begin waits Latency, counts a call of op and returns the error to fail
+// it with, if any. On success it returns with mu held.
+func (f *FakeStorage) begin(ctx context.Context, op Op) error {
+	if f.Latency > 0 {
+		select {
+		case <-ctx.Done():
+		case <-time.After(f.Latency):
+		}
+	}
+
+	f.mu.Lock()
+	f.calls[op]++
+	err := ctx.Err()
+	if err == nil {
+		err = f.errs[op]
+	}
+	if err != nil {
+		f.mu.Unlock()
+		return err
+	}
+	return nil
+}
+
+// Upload implements sync.Storage
+func (f *FakeStorage) Upload(ctx context.Context, localPath, objectName string) error {
+	if err := f.begin(ctx, OpUpload); err != nil {
+		return err
+	}
+	defer f.mu.Unlock()
+
+	info, err := os.Stat(localPath)
+	if err != nil {
+		return err
+	}
+	data, err := os.ReadFile(localPath)
+	if err != nil {
+		return err
+	}
+	f.put(objectName, data, info.ModTime())
+	return nil
+}
+
+// Download implements sync.Storage
+func (f *FakeStorage) Download(ctx context.Context, objectName, localPath string) error {
+	if err := f.begin(ctx, OpDownload); err != nil {
+		return err
+	}
+	defer f.mu.Unlock()
+
+	obj, ok := f.objects[objectName]
+	if !ok {
+		return fmt.Errorf("object %s: %w", objectName, sync.ErrNotExist)
+	}
+	return os.WriteFile(localPath, obj.data, 0644)
+}
+
+// Delete implements sync.Storage
+func (f *FakeStorage) Delete(ctx context.Context, objectName string) error {
+	if err := f.begin(ctx, OpDelete); err != nil {
+		return err
+	}
+	defer f.mu.Unlock()
+
+	if _, ok := f.objects[objectName]; !ok {
+		return fmt.Errorf("object %s: %w", objectName, sync.ErrNotExist)
+	}
+	delete(f.objects, objectName)
+	return nil
+}
+
+// Stat implements sync.Storage
+func (f *FakeStorage) Stat(ctx context.Context, objectName string) (*sync.SyncFileInfo, error) {
+	if err := f.begin(ctx, OpStat); err != nil {
+		return nil, err
+	}
+	defer f.mu.Unlock()
+
+	obj, ok := f.objects[objectName]
+	if !ok {
+		return nil, fmt.Errorf("object %s: %w", objectName, sync.ErrNotExist)
+	}
+	info := obj.info
+	return &info, nil
+}
+
+// List implements sync.Storage
+func (f *FakeStorage) List(ctx context.Context) ([]*sync.SyncFileInfo, error) {
+	if err := f.begin(ctx, OpList); err != nil {
+		return nil, err
+	}
+	defer f.mu.Unlock()
+
+	files := make([]*sync.SyncFileInfo, 0, len(f.objects))
+	for _, obj := range f.objects {
+		info := obj.info
+		files = append(files, &info)
+	}
+	return files, nil
+}
+
+// EnsureBucket implements sync.Storage
+func (f *FakeStorage) EnsureBucket(ctx context.Context) error {
+	if err := f.begin(ctx, OpEnsureBucket); err != nil {
+		return err
+	}
+	f.mu.Unlock()
+	return nil
+}
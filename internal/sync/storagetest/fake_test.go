@@ -0,0 +1,178 @@
+package storagetest
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/danielbehrens/cloudsync/internal/sync"
+)
+
+func writeFile(t *testing.T, path, content string, modTime time.Time) {
+	t.Helper()
+	if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+		t.Fatalf("WriteFile() error = %v", err)
+	}
+	if err := os.Chtimes(path, modTime, modTime); err != nil {
+		t.Fatalf("Chtimes() error = %v", err)
+	}
+}
+
+func TestSyncFile(t *testing.T) {
+	now := time.Now().Truncate(time.Second)
+
+	tests := []struct {
+		name         string
+		local        string
+		localTime    time.Time
+		cloud        string // "" for no cloud copy
+		cloudTime    time.Time
+		wantUploads  int
+		wantDownload bool
+		want         string // content of both copies afterwards
+	}{
+		{
+			name: "local newer", local: "local", localTime: now,
+			cloud: "cloud", cloudTime: now.Add(-time.Hour),
+			wantUploads: 1, want: "local",
+		},
+		{
+			name: "cloud newer", local: "local", localTime: now.Add(-time.Hour),
+			cloud: "cloud", cloudTime: now,
+			wantDownload: true, want: "cloud",
+		},
+		{
+			name: "in sync", local: "same", localTime: now,
+			cloud: "same", cloudTime: now,
+			want: "same",
+		},
+		{
+			name: "missing in the cloud", local: "local", localTime: now,
+			wantUploads: 1, want: "local",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := t.TempDir()
+			path := filepath.Join(dir, "game.sav")
+			writeFile(t, path, tt.local, tt.localTime)
+
+			store := New()
+			if tt.cloud != "" {
+				store.Put("game.sav", []byte(tt.cloud), tt.cloudTime)
+			}
+
+			s := sync.NewSyncer(store, dir, t.TempDir(), nil, 500*time.Millisecond)
+			if err := s.SyncFile(context.Background(), path); err != nil {
+				t.Fatalf("SyncFile() error = %v", err)
+			}
+
+			if got := store.Calls(OpUpload); got != tt.wantUploads {
+				t.Errorf("uploads = %d, want %d", got, tt.wantUploads)
+			}
+			if got := store.Calls(OpDownload) > 0; got != tt.wantDownload {
+				t.Errorf("downloaded = %v, want %v", got, tt.wantDownload)
+			}
+
+			if got, _ := os.ReadFile(path); string(got) != tt.want {
+				t.Errorf("local content = %q, want %q", got, tt.want)
+			}
+			data, ok := store.Data("game.sav")
+			if !ok || string(data) != tt.want {
+				t.Errorf("cloud content = %q (exists %v), want %q", data, ok, tt.want)
+			}
+
+			// Both copies end up with the newer mod time
+			info, err := os.Stat(path)
+			if err != nil {
+				t.Fatalf("Stat() error = %v", err)
+			}
+			cloud, err := store.Stat(context.Background(), "game.sav")
+			if err != nil {
+				t.Fatalf("store.Stat() error = %v", err)
+			}
+			if !info.ModTime().Equal(cloud.ModTime) {
+				t.Errorf("local mod time %v, cloud %v, want equal", info.ModTime(), cloud.ModTime)
+			}
+			if cloud.Size != int64(len(tt.want)) {
+				t.Errorf("cloud Size = %d, want %d", cloud.Size, len(tt.want))
+			}
+		})
+	}
+}
+
+func TestInitialSyncDownloadsMissingFile(t *testing.T) {
+	dir := t.TempDir()
+	modTime := time.Now().Add(-time.Hour).Truncate(time.Second)
+
+	store := New()
+	store.Put("game.sav", []byte("cloud"), modTime)
+
+	s := sync.NewSyncer(store, dir, t.TempDir(), nil, 500*time.Millisecond)
+	summary, err := s.InitialSync(context.Background())
+	if err != nil {
+		t.Fatalf("InitialSync() error = %v", err)
+	}
+	if summary.Downloaded != 1 {
+		t.Errorf("Downloaded = %d, want 1", summary.Downloaded)
+	}
+
+	path := filepath.Join(dir, "game.sav")
+	if got, _ := os.ReadFile(path); string(got) != "cloud" {
+		t.Errorf("local content = %q, want %q", got, "cloud")
+	}
+	if info, err := os.Stat(path); err != nil || !info.ModTime().Equal(modTime) {
+		t.Errorf("local mod time = %v (%v), want %v", info.ModTime(), err, modTime)
+	}
+}
+
+func TestInjectedErrors(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "game.sav")
+	writeFile(t, path, "local", time.Now())
+
+	store := New()
+	errDown := errors.New("endpoint down")
+	store.SetError(OpUpload, errDown)
+
+	s := sync.NewSyncer(store, dir, t.TempDir(), nil, 500*time.Millisecond)
+	if err := s.SyncFile(context.Background(), path); !errors.Is(err, errDown) {
+		t.Fatalf("SyncFile() error = %v, want %v", err, errDown)
+	}
+	if _, ok := store.Data("game.sav"); ok {
+		t.Error("failed upload stored the object")
+	}
+
+	// Once the error is cleared the upload goes through
+	store.SetError(OpUpload, nil)
+	if err := s.SyncFile(context.Background(), path); err != nil {
+		t.Fatalf("SyncFile() after clearing the error: %v", err)
+	}
+	if _, ok := store.Data("game.sav"); !ok {
+		t.Error("game.sav not uploaded after clearing the error")
+	}
+}
+
+func TestLatencyHonorsContext(t *testing.T) {
+	store := New()
+	store.Latency = time.Minute
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	start := time.Now()
+	if _, err := store.List(ctx); !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("List() error = %v, want %v", err, context.DeadlineExceeded)
+	}
+	if elapsed := time.Since(start); elapsed > 10*time.Second {
+		t.Errorf("List() took %v despite the deadline", elapsed)
+	}
+
+	_, err := New().Stat(context.Background(), "missing.sav")
+	if !errors.Is(err, sync.ErrNotExist) {
+		t.Errorf("Stat() of a missing object error = %v, want ErrNotExist", err)
+	}
+}
@@ -0,0 +1,48 @@
+package sync
+
+import (
+	"time"
+
+	"github.com/danielbehrens/cloudsync/internal/notify"
+)
+
+// SyncSummary describes what a full sync did. Uploaded, Downloaded and the
+// byte totals count the transfers actually made, so a file a size guard
+// held back is not among them.
+type SyncSummary struct {
+	Uploaded   int
+	Downloaded int
+	Skipped    int // already in sync
+	Conflicts  int
+	Deleted    int // locally or from the cloud, with PropagateDeletes
+	Failed     int
+	BytesUp    int64
+	BytesDown  int64
+	Elapsed    time.Duration
+}
+
+// transferTotals are the transfers a Syncer made since it was created
+type transferTotals struct {
+	uploads, downloads int
+	bytesUp, bytesDown int64
+}
+
+// countTransfer adds a completed transfer to the totals
+func (s *Syncer) countTransfer(direction string, size int64) {
+	s.totalsMu.Lock()
+	defer s.totalsMu.Unlock()
+	if direction == notify.Upload {
+		s.totals.uploads++
+		s.totals.bytesUp += size
+	} else {
+		s.totals.downloads++
+		s.totals.bytesDown += size
+	}
+}
+
+// transferTotals returns a snapshot of the totals
+func (s *Syncer) transferTotals() transferTotals {
+	s.totalsMu.Lock()
+	defer s.totalsMu.Unlock()
+	return s.totals
+}
@@ -2,30 +2,74 @@ package sync
 
 import (
 	"context"
+	"errors"
 	"fmt"
-	"log"
+	"log/slog"
 	"os"
 	"path/filepath"
+	"runtime"
 	"strings"
+	gosync "sync"
+	"sync/atomic"
 	"time"
 
+	"github.com/danielbehrens/cloudsync/internal/fsutil"
+	"github.com/danielbehrens/cloudsync/internal/notify"
+	"github.com/danielbehrens/cloudsync/internal/power"
 	"github.com/shirou/gopsutil/v4/process"
 )
 
+// ErrNotExist is wrapped by Storage.Stat and Storage.Delete errors for
+// objects that don't exist. Other Stat errors may be transient and are never
+// taken to mean an object was deleted; a Delete that fails with it has
+// nothing left to do. S3 usually reports no error for a missing object.
+var ErrNotExist = errors.New("object does not exist")
+
 // Storage defines the interface for cloud storage operations
 type Storage interface {
 	Upload(ctx context.Context, localPath, objectName string) error
 	Download(ctx context.Context, objectName, localPath string) error
+	Delete(ctx context.Context, objectName string) error
 	Stat(ctx context.Context, objectName string) (*SyncFileInfo, error)
 	List(ctx context.Context) ([]*SyncFileInfo, error)
 	EnsureBucket(ctx context.Context) error
 }
 
+// ETagLister is implemented by storage that can list object names and ETags
+// more cheaply than List, which stats every object for its metadata. StatAll
+// then stats the objects whose ETag changed, as many at once as List would,
+// returning them in the order of names.
+type ETagLister interface {
+	ListETags(ctx context.Context) (map[string]string, error)
+	StatAll(ctx context.Context, names []string) ([]*SyncFileInfo, error)
+}
+
+// FilteredLister is implemented by storage that can list only the objects
+// under a name prefix and accepted by keep, without fetching the metadata
+// of the others
+type FilteredLister interface {
+	ListFiltered(ctx context.Context, prefix string, keep func(name string) bool) ([]*SyncFileInfo, error)
+}
+
+// ClockSkewer is implemented by storage that can tell how far the server's
+// clock is ahead of the local one
+type ClockSkewer interface {
+	ClockSkew(ctx context.Context) (time.Duration, error)
+}
+
 // SyncFileInfo represents file metadata
 type SyncFileInfo struct {
-	Name    string
-	ModTime time.Time
-	Size    int64
+	Name     string
+	ModTime  time.Time
+	Size     int64
+	ETag     string
+	Checksum string // hex SHA-256, empty if the backend doesn't record one
+
+	// CoarseModTime is set when ModTime isn't the file's own nanosecond
+	// mod time from upload metadata but a server timestamp of lower
+	// resolution, such as S3's LastModified. It is compared with
+	// CoarseTimeTolerance.
+	CoarseModTime bool
 }
 
 // Syncer handles bidirectional file synchronization
@@ -33,69 +77,499 @@ type Syncer struct {
 	storage       Storage
 	watchPath     string
 	backupDir     string
-	processName   string
+	processNames  []string
 	timeTolerance time.Duration
+
+	// CoarseTimeTolerance replaces the time tolerance for cloud mod times
+	// of CoarseModTime objects when it is larger. Such a time has at best
+	// second resolution and is when the object was uploaded, so it can't be
+	// compared at the sub-second tolerance precise times allow.
+	CoarseTimeTolerance time.Duration
+
+	// Recursive syncs files in subdirectories of the watch path too (except
+	// the backup directory). Object names are then slash-separated paths
+	// relative to the watch path, so the bucket mirrors the local tree.
+	Recursive bool
+
+	// SyncEmptyDirs, with Recursive, keeps empty subdirectories on both
+	// sides: each is stored as an empty marker object inside it (see
+	// dirMarkerName), and a marker in the cloud recreates its directory
+	// locally instead of being downloaded as a file
+	SyncEmptyDirs bool
+
+	// IncludePatterns and ExcludePatterns select the files to sync by base
+	// name (filepath.Match syntax, ignoring case). NewSyncer sets the .sav
+	// defaults.
+	IncludePatterns []string
+	ExcludePatterns []string
+
+	// ignore holds the rules of the watch path's .cloudsyncignore file,
+	// applied on top of the patterns (see LoadIgnoreFile), and ignoreData
+	// the file they were parsed from
+	ignoreMu   gosync.RWMutex
+	ignore     *fsutil.IgnoreRules // guarded by ignoreMu
+	ignoreData string              // guarded by ignoreMu
+
+	// ChecksumMode compares content hashes before mod times: when the local
+	// SHA-256 matches the one stored on the object (or, lacking that, a
+	// plain MD5 ETag), nothing is transferred even if mod times differ.
+	// Without a comparable hash, mod times decide as usual.
+	ChecksumMode bool
+
+	// PriorityPatterns are glob patterns (matched against the base name)
+	// for files that sync before all others. Otherwise smaller files go
+	// first.
+	PriorityPatterns []string
+
+	// MinFileSize and MinSizeRatio keep a save that a crash left empty or
+	// truncated from spreading: a file smaller than MinFileSize bytes is
+	// not uploaded, and a cloud object smaller than MinFileSize, or than
+	// MinSizeRatio times the local file, doesn't replace it. NewSyncer
+	// sets MinFileSize to 1, skipping empty files; zero turns either off.
+	MinFileSize  int64
+	MinSizeRatio float64
+
+	// HardlinkBackups hardlinks backups to the live file instead of copying
+	// them, falling back to a copy when linking fails (e.g. across file
+	// systems). Only safe while the live file is replaced, never rewritten
+	// in place.
+	HardlinkBackups bool
+
+	// Power, when set, pauses sync while the system runs on battery
+	Power          power.Provider
+	powerErrLogged atomic.Bool // PauseReason runs on several goroutines
+
+	// GoodCopyDir, when set, holds a latest-known-good copy of each file,
+	// refreshed after every sync whose result matches the cloud checksum
+	GoodCopyDir string
+
+	// VerifyAfterUpload re-downloads every uploaded object and compares it
+	// with the local file, retrying the upload on mismatch. It doubles the
+	// transfer for each upload.
+	VerifyAfterUpload bool
+
+	// MaxBackups and MaxBackupAge limit the timestamped backup folders kept
+	// in the backup dir; PruneBackups runs after every backup. Zero means
+	// no limit.
+	MaxBackups   int
+	MaxBackupAge time.Duration
+
+	// BackupWindow coalesces backups (see writeBackup): within it, a file
+	// whose content was already backed up is not backed up again, and
+	// further backups share the folder of the first. Zero gives every
+	// backup its own folder.
+	BackupWindow time.Duration
+	lastBackups  map[string]backupRecord // guarded by backupMu
+	burstDir     string                  // guarded by backupMu
+	burstStart   time.Time               // guarded by backupMu
+
+	// DryRun runs the usual comparisons but only logs the uploads,
+	// downloads and backups they would cause. Neither the cloud nor any
+	// local file is changed.
+	DryRun bool
+
+	// StateFile, when set, records each file's content hash and mod time
+	// after every sync. A file that has since changed both locally and in
+	// the cloud is a conflict, resolved by ConflictStrategy.
+	StateFile        string
+	ConflictStrategy ConflictStrategy
+	stateMu          gosync.Mutex
+	lastSynced       map[string]time.Time // guarded by stateMu
+	state            *syncState           // guarded by stateMu, nil until loadState reads StateFile
+	stateBatched     bool                 // guarded by stateMu, set while a full sync defers saves
+	stateDirty       bool                 // guarded by stateMu, a deferred save is pending
+
+	// JournalFile, when set, records each download in progress, so that
+	// InitialSync can clean up or finish one interrupted by a crash
+	JournalFile   string
+	journalMu     gosync.Mutex
+	beforeReplace func() // test hook, called between download and replace
+
+	// TempDir is where downloads are written before replacing the local
+	// file; empty writes them beside it. Read-back verifications and
+	// tombstones use it too, or the OS temp directory.
+	TempDir string
+
+	// Direction limits syncing to downloads or uploads; empty is
+	// DirectionBidirectional. Backups are taken as usual by the transfers
+	// it allows.
+	Direction SyncDirection
+
+	// Concurrency is how many files InitialSync transfers at once. Values
+	// below 1 mean one at a time.
+	Concurrency int
+
+	// backupMu serializes creating and pruning backup folders, so a prune
+	// can't remove a folder another transfer is still writing to
+	backupMu gosync.Mutex
+
+	// ForceFullSync stats every cloud object in InitialSync instead of
+	// reusing the metadata cached in StateFile for unchanged ETags
+	ForceFullSync bool
+
+	// Notifier, when set, is told about every completed upload and
+	// download
+	Notifier Notifier
+
+	// PropagateDeletes makes deletions sync like changes: DeleteFile
+	// removes a locally deleted file from the cloud and leaves a tombstone,
+	// and a file deleted in the cloud is backed up and removed locally.
+	// Deletions are recognized from the baseline in StateFile, so this
+	// needs StateFile.
+	PropagateDeletes bool
+
+	// SyncInterval is how often Run runs a full sync while not paused.
+	// Zero disables the periodic sync.
+	SyncInterval time.Duration
+
+	// FullSyncInterval is how often Run runs a full sync while not paused,
+	// whatever the process names, as a safety net against file events the
+	// watcher missed. Zero disables it.
+	FullSyncInterval time.Duration
+
+	// ProcessMatch selects how IsProcessRunning compares running processes
+	// with the process name. The default, ProcessMatchSubstring, also
+	// matches unrelated processes whose names contain it.
+	ProcessMatch ProcessMatchMode
+
+	// ProcessCacheTTL is how long IsProcessRunning reuses its last scan of
+	// the running processes. Zero scans on every call.
+	ProcessCacheTTL time.Duration
+	processMu       gosync.Mutex
+	processRunning  bool      // guarded by processMu
+	processScanned  time.Time // guarded by processMu, zero before the first scan
+	scanProcesses   func() bool
+
+	// Files another process holds open for writing are retried after
+	// busyRetryDelay, doubling with each attempt (see ErrFileBusy)
+	busyRetryDelay time.Duration
+	busyMu         gosync.Mutex
+	busyPending    map[string]bool // guarded by busyMu
+	fileBusy       func(path string) bool
+
+	// Metrics, when set, counts transfers, backups and sync outcomes
+	Metrics Metrics
+
+	// totals count the transfers made, for SyncSummary
+	totalsMu gosync.Mutex
+	totals   transferTotals // guarded by totalsMu
+
+	// fullSyncMu serializes full syncs, so one requested with SyncNow
+	// can't overlap one Run started. Event syncs don't take it.
+	fullSyncMu gosync.Mutex
+
+	// paused is set by Pause and cleared by Resume, which then signals
+	// resumed for Run to catch up
+	paused  atomic.Bool
+	resumed chan struct{}
+}
+
+// ProcessMatchMode selects how the process name is matched
+type ProcessMatchMode string
+
+const (
+	// ProcessMatchSubstring matches any process whose name contains the
+	// process name, ignoring case. This is the default.
+	ProcessMatchSubstring ProcessMatchMode = "substring"
+
+	// ProcessMatchExact matches processes named exactly the process name,
+	// ignoring case
+	ProcessMatchExact ProcessMatchMode = "exact"
+
+	// ProcessMatchPath matches processes whose executable is at the
+	// process name, which must then be a full path
+	ProcessMatchPath ProcessMatchMode = "path"
+)
+
+// Notifier receives completed transfers, e.g. to post a webhook. Notify
+// must not block the sync.
+type Notifier interface {
+	Notify(event notify.Event)
 }
 
-// NewSyncer creates a new Syncer instance
-func NewSyncer(storage Storage, watchPath, backupDir, processName string, timeTolerance time.Duration) *Syncer {
+// Metrics records sync activity, e.g. for a /metrics endpoint. Its methods
+// are called concurrently and must not block the sync.
+type Metrics interface {
+	Transferred(direction string, size int64)
+	BackedUp()
+	Synced()
+	SyncFailed()
+}
+
+// recordOutcome passes the result of a file or full sync to Metrics.
+// Cancellation is not a failure.
+func (s *Syncer) recordOutcome(ctx context.Context, err error) {
+	switch {
+	case s.Metrics == nil || ctx.Err() != nil:
+	case err != nil:
+		s.Metrics.SyncFailed()
+	default:
+		s.Metrics.Synced()
+	}
+}
+
+// verifyAttempts is how many times an upload is tried when
+// VerifyAfterUpload detects a mismatch
+const verifyAttempts = 3
+
+// toleranceFor returns the tolerance for comparing a cloud mod time with a
+// local one, larger when the cloud time is coarse
+func (s *Syncer) toleranceFor(coarse bool) time.Duration {
+	if coarse {
+		return max(s.timeTolerance, s.CoarseTimeTolerance)
+	}
+	return s.timeTolerance
+}
+
+// NewSyncer creates a new Syncer instance, which pauses while any of
+// processNames runs
+func NewSyncer(storage Storage, watchPath, backupDir string, processNames []string, timeTolerance time.Duration) *Syncer {
 	return &Syncer{
 		storage:       storage,
 		watchPath:     watchPath,
 		backupDir:     backupDir,
-		processName:   processName,
+		processNames:  processNames,
 		timeTolerance: timeTolerance,
+
+		busyRetryDelay: defaultBusyRetryDelay,
+		MinFileSize:    defaultMinFileSize,
+		resumed:        make(chan struct{}, 1),
+
+		IncludePatterns: fsutil.DefaultIncludePatterns,
+		ExcludePatterns: fsutil.DefaultExcludePatterns,
 	}
 }
 
-// IsProcessRunning checks if the specified process is currently running
+// IsProcessRunning checks if any of the specified processes is currently
+// running. Listing every process is slow on Windows, so the answer is
+// reused for ProcessCacheTTL.
 func (s *Syncer) IsProcessRunning() bool {
-	if s.processName == "" {
+	if len(s.processNames) == 0 {
 		return false
 	}
 
+	s.processMu.Lock()
+	defer s.processMu.Unlock()
+
+	now := time.Now()
+	if !s.processScanned.IsZero() && now.Sub(s.processScanned) < s.ProcessCacheTTL {
+		return s.processRunning
+	}
+
+	scan := s.scanProcesses
+	if scan == nil {
+		scan = s.scanRunning
+	}
+	s.processRunning = scan()
+	s.processScanned = now
+	return s.processRunning
+}
+
+// scanRunning lists the running processes and reports whether one of them
+// is a watched one
+func (s *Syncer) scanRunning() bool {
 	processes, err := process.Processes()
 	if err != nil {
-		log.Printf("Error listing processes: %v", err)
+		slog.Warn("Error listing processes", "err", err)
 		return false
 	}
 
-	processName := strings.ToLower(s.processName)
-
 	for _, p := range processes {
-		exeName, err := p.Name()
-		if err == nil && strings.Contains(strings.ToLower(exeName), processName) {
+		var name string
+		if s.ProcessMatch == ProcessMatchPath {
+			// Fails for processes of other users on some systems; those
+			// can't be the game anyway
+			name, err = p.Exe()
+		} else {
+			name, err = p.Name()
+		}
+		if err == nil && s.matchesProcess(name) {
+			return true
+		}
+	}
+	return false
+}
+
+// matchesProcess reports whether a process with name, or executable path
+// for ProcessMatchPath, is one of the watched ones
+func (s *Syncer) matchesProcess(name string) bool {
+	for _, want := range s.processNames {
+		if matchProcess(s.ProcessMatch, want, name) {
 			return true
 		}
 	}
 	return false
 }
 
-// InitialSync performs initial bidirectional synchronization
-func (s *Syncer) InitialSync(ctx context.Context) error {
-	log.Println("Starting initial sync...")
+// matchProcess reports whether name matches the process name want in mode
+func matchProcess(mode ProcessMatchMode, want, name string) bool {
+	switch mode {
+	case ProcessMatchExact:
+		return strings.EqualFold(name, want)
+	case ProcessMatchPath:
+		want, got := filepath.Clean(want), filepath.Clean(name)
+		if runtime.GOOS == "windows" {
+			return strings.EqualFold(got, want)
+		}
+		return got == want
+	default:
+		return strings.Contains(strings.ToLower(name), strings.ToLower(want))
+	}
+}
+
+// Pause reasons reported by PauseReason
+const (
+	PauseRequested      = "paused by request"
+	PauseProcessRunning = "game running"
+	PauseOnBattery      = "on battery"
+)
+
+// Pause pauses sync until Resume, as a running game does: Run drops file
+// events and skips its periodic syncs meanwhile
+func (s *Syncer) Pause() {
+	if s.paused.CompareAndSwap(false, true) {
+		slog.Info("Sync paused until resumed", "watch", s.watchPath)
+	}
+}
+
+// Resume undoes Pause, and Run then catches up on the changes it dropped
+// with a full sync. Sync stays paused while another pause reason applies.
+func (s *Syncer) Resume() {
+	if s.paused.CompareAndSwap(true, false) {
+		slog.Info("Sync resumed", "watch", s.watchPath)
+		select {
+		case s.resumed <- struct{}{}:
+		default:
+		}
+	}
+}
+
+// IsPaused reports whether sync was paused with Pause
+func (s *Syncer) IsPaused() bool {
+	return s.paused.Load()
+}
+
+// PauseReason reports why sync should not run right now, or "" if it may.
+// A power state that cannot be read never pauses sync.
+func (s *Syncer) PauseReason() string {
+	if s.IsPaused() {
+		return PauseRequested
+	}
+
+	if s.IsProcessRunning() {
+		return PauseProcessRunning
+	}
+
+	if s.Power != nil {
+		state, err := s.Power.State()
+		if err != nil && s.powerErrLogged.CompareAndSwap(false, true) {
+			slog.Warn("Cannot read power state, battery pause disabled", "err", err)
+		}
+		if state == power.StateBattery {
+			return PauseOnBattery
+		}
+	}
+
+	return ""
+}
+
+// EnsureBucket checks that cloud storage is reachable, creating the bucket
+// if it doesn't exist yet
+func (s *Syncer) EnsureBucket(ctx context.Context) error {
+	return s.storage.EnsureBucket(ctx)
+}
+
+// InitialSync performs initial bidirectional synchronization and returns a
+// summary of what it did
+func (s *Syncer) InitialSync(ctx context.Context) (*SyncSummary, error) {
+	slog.Info("Starting initial sync", "watch", s.watchPath)
 
 	// Ensure bucket exists
 	if err := s.storage.EnsureBucket(ctx); err != nil {
-		return fmt.Errorf("failed to ensure bucket: %w", err)
+		return nil, fmt.Errorf("failed to ensure bucket: %w", err)
+	}
+
+	s.recoverJournal()
+	s.cleanTempFiles()
+	s.LoadIgnoreFile()
+
+	summary, err := s.fullSync(ctx)
+	if err != nil {
+		return nil, err
 	}
 
-	// Upload newer local files
-	if err := s.uploadLocalFiles(ctx); err != nil {
-		return fmt.Errorf("failed to upload local files: %w", err)
+	slog.Info("Initial sync complete", "watch", s.watchPath)
+	return summary, nil
+}
+
+// FullSync plans what to do with every file (see Plan), then uploads newer
+// local files and downloads newer cloud files. Per-file failures are logged
+// and skipped; only errors that stop the whole sync are returned.
+func (s *Syncer) FullSync(ctx context.Context) error {
+	_, err := s.fullSync(ctx)
+	return err
+}
+
+// fullSync is FullSync, returning a summary of what it did. The transfer
+// counts include any SyncFile made meanwhile.
+func (s *Syncer) fullSync(ctx context.Context) (summary *SyncSummary, err error) {
+	s.fullSyncMu.Lock()
+	defer s.fullSyncMu.Unlock()
+	defer func() { s.recordOutcome(ctx, err) }()
+
+	// Every file synced updates the state; write it once at the end
+	s.batchState()
+	defer s.flushState()
+	start, before := time.Now(), s.transferTotals()
+
+	plan, err := s.Plan(ctx)
+	if err != nil {
+		return nil, err
 	}
 
-	// Download newer cloud files
-	if err := s.downloadCloudFiles(ctx); err != nil {
-		return fmt.Errorf("failed to download cloud files: %w", err)
+	if s.DryRun {
+		slog.Info("[dry-run] Plan", "uploads", plan.Count(PlanUpload), "downloads", plan.Count(PlanDownload),
+			"conflicts", plan.Count(PlanConflict), "cloud_deletions", plan.Count(PlanDeleteCloud), "local_deletions", plan.Count(PlanDeleteLocal))
 	}
 
-	log.Println("Initial sync complete")
-	return nil
+	failed, err := s.applyPlan(ctx, plan)
+	if err != nil {
+		return nil, err
+	}
+
+	after := s.transferTotals()
+	return &SyncSummary{
+		Uploaded:   after.uploads - before.uploads,
+		Downloaded: after.downloads - before.downloads,
+		Skipped:    plan.Count(PlanSkip),
+		Conflicts:  plan.Count(PlanConflict),
+		Deleted:    plan.Count(PlanDeleteCloud) + plan.Count(PlanDeleteLocal),
+		Failed:     failed,
+		BytesUp:    after.bytesUp - before.bytesUp,
+		BytesDown:  after.bytesDown - before.bytesDown,
+		Elapsed:    time.Since(start),
+	}, nil
 }
 
-// SyncFile synchronizes a single file with the cloud
+// SyncFile synchronizes a single file with the cloud. A file another
+// process holds open for writing is retried in the background a few times
+// (see ErrFileBusy), and nil returned meanwhile.
 func (s *Syncer) SyncFile(ctx context.Context, filePath string) error {
+	return s.syncFileAttempt(ctx, filePath, 1)
+}
+
+// syncFileAttempt is SyncFile's attempt-th try at filePath. A file that is
+// busy is retried later in the background, and nil returned meanwhile.
+func (s *Syncer) syncFileAttempt(ctx context.Context, filePath string, attempt int) error {
+	err := s.syncFile(ctx, filePath)
+	if errors.Is(err, ErrFileBusy) && s.retryBusy(ctx, filePath, attempt) {
+		return nil
+	}
+	s.recordOutcome(ctx, err)
+	return err
+}
+
+func (s *Syncer) syncFile(ctx context.Context, filePath string) error {
 	info, err := os.Stat(filePath)
 	if err != nil {
 		return fmt.Errorf("failed to stat file: %w", err)
@@ -104,184 +578,459 @@ func (s *Syncer) SyncFile(ctx context.Context, filePath string) error {
 		return fmt.Errorf("path is a directory, not a file")
 	}
 
-	objectName := filepath.Base(filePath)
+	objectName, err := s.objectName(filePath)
+	if err != nil {
+		return err
+	}
+	if err := s.checkLocalCollision(filePath, objectName); err != nil {
+		return err
+	}
 
 	// Check if file exists in cloud
 	cloudInfo, err := s.storage.Stat(ctx, objectName)
 	if err != nil {
+		if s.PropagateDeletes && s.canDownload() && errors.Is(err, ErrNotExist) {
+			reason, err := s.deletedInCloud(ctx, objectName, filePath, info)
+			if err != nil {
+				return err
+			}
+			if reason != "" {
+				return s.removeLocal(objectName, filePath, reason)
+			}
+		}
+
 		// File doesn't exist in cloud, upload it
-		log.Printf("File %s not found in cloud, uploading...", objectName)
-		return s.backupAndUpload(ctx, filePath, objectName)
+		if s.blocked(objectName, actionUpload) {
+			return nil
+		}
+		slog.Debug("File not found in cloud, uploading", "file", objectName)
+		return s.backupAndUpload(ctx, filePath, objectName, time.Time{})
+	}
+
+	content := s.compareContent(filePath, cloudInfo)
+	if content == contentSame {
+		return nil
+	}
+
+	if s.inConflict(objectName, filePath, cloudInfo) {
+		return s.resolveConflict(ctx, objectName, filePath, cloudInfo)
 	}
 
 	// Compare modification times
 	localTime := info.ModTime().UTC()
 	cloudTime := cloudInfo.ModTime
-	diff := cloudTime.Sub(localTime)
 
-	if diff > s.timeTolerance {
-		// Cloud is newer, download it
-		log.Printf("Cloud file %s is newer (cloud: %v, local: %v), downloading...", 
-			objectName, cloudTime, localTime)
-		return s.downloadAndReplace(ctx, objectName, filePath, cloudTime)
-	} else if diff < -s.timeTolerance {
-		// Local is newer, upload it
-		log.Printf("Local file %s is newer (cloud: %v, local: %v), uploading...", 
-			objectName, cloudTime, localTime)
-		return s.backupAndUpload(ctx, filePath, objectName)
+	switch decideAction(localTime, cloudTime, s.toleranceFor(cloudInfo.CoarseModTime)) {
+	case actionDownload:
+		if s.blocked(objectName, actionDownload) {
+			return nil
+		}
+		slog.Debug("Cloud file is newer, downloading", "file", objectName, "cloud_mod_time", cloudTime, "local_mod_time", localTime)
+		return s.downloadAndReplace(ctx, objectName, filePath, cloudInfo)
+	case actionUpload:
+		if s.blocked(objectName, actionUpload) {
+			return nil
+		}
+		slog.Debug("Local file is newer, uploading", "file", objectName, "cloud_mod_time", cloudTime, "local_mod_time", localTime)
+		return s.backupAndUpload(ctx, filePath, objectName, cloudTime)
+	}
+
+	// Mod times agree but the content doesn't; SyncFile runs for local
+	// changes, so the local copy wins
+	if content == contentDifferent {
+		if s.blocked(objectName, actionUpload) {
+			return nil
+		}
+		slog.Debug("Local file differs from cloud with matching mod times, uploading", "file", objectName)
+		return s.backupAndUpload(ctx, filePath, objectName, cloudTime)
 	}
 
 	// Files are in sync
 	return nil
 }
 
-func (s *Syncer) uploadLocalFiles(ctx context.Context) error {
-	entries, err := os.ReadDir(s.watchPath)
-	if err != nil {
-		return fmt.Errorf("failed to read watch directory: %w", err)
+// syncAction is the transfer needed to bring a local file and its cloud
+// object back in sync
+type syncAction int
+
+const (
+	actionNone syncAction = iota
+	actionUpload
+	actionDownload
+)
+
+// decideAction compares local and cloud modification times. Differences
+// within tolerance (inclusive) are treated as in sync; a zero tolerance means
+// only exactly equal times are in sync and any difference triggers a
+// transfer towards the older side.
+func decideAction(localTime, cloudTime time.Time, tolerance time.Duration) syncAction {
+	diff := cloudTime.Sub(localTime)
+
+	switch {
+	case diff > tolerance:
+		return actionDownload
+	case diff < -tolerance:
+		return actionUpload
+	default:
+		return actionNone
 	}
+}
 
-	for _, entry := range entries {
-		if entry.IsDir() {
-			continue
+// backupAndUpload backs up and uploads filePath. cloudTime is the mod time
+// of the cloud version it replaces, zero if there is none; it is only
+// logged. A file below MinFileSize is skipped with a warning.
+func (s *Syncer) backupAndUpload(ctx context.Context, filePath, objectName string, cloudTime time.Time) error {
+	if info, err := os.Stat(filePath); err == nil {
+		if reason := s.uploadGuard(info.Size()); reason != "" {
+			slog.Warn("Not uploading a file that may have been truncated by a crash", "path", filePath, "reason", reason)
+			return nil
 		}
+	}
 
-		path := filepath.Join(s.watchPath, entry.Name())
-		if !shouldSyncFile(path) {
-			continue
+	if s.DryRun {
+		if fileExists(filePath) {
+			slog.Info("[dry-run] Would back up", "path", filePath, "backup", s.backupFile(s.nextBackupDir(), filePath))
 		}
+		slog.Info("[dry-run] Would upload", "path", filePath, "file", objectName)
+		return nil
+	}
 
-		if err := s.SyncFile(ctx, path); err != nil {
-			log.Printf("Failed to sync file %s: %v", path, err)
-		}
+	// A save the game is still writing would be backed up and uploaded
+	// torn
+	if err := s.checkFileBusy(filePath); err != nil {
+		return err
 	}
 
-	return nil
-}
+	// Create backup if file exists
+	if fileExists(filePath) {
+		if err := s.createBackup(filePath); err != nil {
+			return fmt.Errorf("failed to create backup: %w", busyError(err))
+		}
+	}
 
-func (s *Syncer) downloadCloudFiles(ctx context.Context) error {
-	cloudFiles, err := s.storage.List(ctx)
+	info, err := os.Stat(filePath)
 	if err != nil {
-		return fmt.Errorf("failed to list cloud files: %w", err)
+		return fmt.Errorf("failed to stat file: %w", err)
 	}
 
-	for _, cloudFile := range cloudFiles {
-		if !shouldSyncFile(cloudFile.Name) {
-			continue
-		}
+	// Upload to cloud
+	start := time.Now()
+	if err := s.upload(ctx, filePath, objectName); err != nil {
+		return busyError(err)
+	}
 
-		localPath := filepath.Join(s.watchPath, filepath.Base(cloudFile.Name))
-		localInfo, err := os.Stat(localPath)
+	s.reportTransfer(fmt.Sprintf("Uploaded %s to cloud", objectName), objectName, notify.Upload,
+		info.ModTime().UTC(), cloudTime, info.Size(), time.Since(start))
+	s.recordSync(objectName, filePath)
+	if s.PropagateDeletes {
+		s.clearTombstone(ctx, objectName)
+	}
+	s.updateGoodCopy(ctx, filePath, objectName)
+	return nil
+}
 
-		if os.IsNotExist(err) {
-			// File doesn't exist locally, download it
-			log.Printf("Downloading new file from cloud: %s", cloudFile.Name)
-			if err := s.downloadAndReplace(ctx, cloudFile.Name, localPath, cloudFile.ModTime); err != nil {
-				log.Printf("Failed to download %s: %v", cloudFile.Name, err)
-			}
-			continue
+// upload sends filePath to storage, verifying the stored object afterwards
+// when VerifyAfterUpload is set
+func (s *Syncer) upload(ctx context.Context, filePath, objectName string) error {
+	if !s.VerifyAfterUpload {
+		if err := s.storage.Upload(ctx, filePath, objectName); err != nil {
+			return fmt.Errorf("failed to upload: %w", err)
 		}
+		return nil
+	}
 
-		if err != nil {
-			log.Printf("Failed to stat local file %s: %v", localPath, err)
-			continue
+	var err error
+	for attempt := 1; attempt <= verifyAttempts; attempt++ {
+		if err = s.storage.Upload(ctx, filePath, objectName); err != nil {
+			return fmt.Errorf("failed to upload: %w", err)
 		}
 
-		// Check if cloud is newer
-		if cloudFile.ModTime.Sub(localInfo.ModTime().UTC()) > s.timeTolerance {
-			log.Printf("Cloud file %s is newer, downloading...", cloudFile.Name)
-			if err := s.downloadAndReplace(ctx, cloudFile.Name, localPath, cloudFile.ModTime); err != nil {
-				log.Printf("Failed to download %s: %v", cloudFile.Name, err)
-			}
+		if err = s.verifyUpload(ctx, filePath, objectName); err == nil {
+			return nil
 		}
+		slog.Warn("Verification failed", "file", objectName, "attempt", attempt, "attempts", verifyAttempts, "err", err)
 	}
 
-	return nil
+	return fmt.Errorf("upload of %s could not be verified after %d attempts: %w", objectName, verifyAttempts, err)
 }
 
-func (s *Syncer) backupAndUpload(ctx context.Context, filePath, objectName string) error {
-	// Create backup if file exists
-	if fileExists(filePath) {
-		if err := s.createBackup(filePath); err != nil {
-			return fmt.Errorf("failed to create backup: %w", err)
-		}
+// verifyUpload downloads objectName and checks it is byte-identical to the
+// local file
+func (s *Syncer) verifyUpload(ctx context.Context, filePath, objectName string) error {
+	tempFile, err := os.CreateTemp(s.TempDir, verifyTempPattern)
+	if err != nil {
+		return fmt.Errorf("failed to create temp file: %w", err)
 	}
+	tempPath := tempFile.Name()
+	tempFile.Close()
+	defer os.Remove(tempPath)
 
-	// Upload to cloud
-	if err := s.storage.Upload(ctx, filePath, objectName); err != nil {
-		return fmt.Errorf("failed to upload: %w", err)
+	if err := s.storage.Download(ctx, objectName, tempPath); err != nil {
+		return fmt.Errorf("failed to read back object: %w", err)
+	}
+
+	localSum, err := fsutil.FileSHA256(filePath)
+	if err != nil {
+		return fmt.Errorf("failed to checksum local file: %w", err)
+	}
+	cloudSum, err := fsutil.FileSHA256(tempPath)
+	if err != nil {
+		return fmt.Errorf("failed to checksum downloaded object: %w", err)
+	}
+
+	if localSum != cloudSum {
+		return fmt.Errorf("checksum mismatch: local %s, cloud %s", localSum, cloudSum)
 	}
 
-	log.Printf("Uploaded %s to cloud", objectName)
 	return nil
 }
 
-func (s *Syncer) downloadAndReplace(ctx context.Context, objectName, localPath string, modTime time.Time) error {
+// downloadAndReplace downloads the cloud object over localPath, backing up
+// the local file first. A cloud object that the size guards reject (see
+// MinFileSize) is skipped with a warning.
+func (s *Syncer) downloadAndReplace(ctx context.Context, objectName, localPath string, cloud *SyncFileInfo) error {
+	if reason := s.downloadGuard(cloud.Size, localPath); reason != "" {
+		slog.Warn("Not replacing a file with a cloud copy that may have been truncated by a crash", "path", localPath, "file", objectName, "reason", reason)
+		return nil
+	}
+
+	modTime := cloud.ModTime
+	if s.DryRun {
+		if fileExists(localPath) {
+			slog.Info("[dry-run] Would back up", "path", localPath, "backup", s.backupFile(s.nextBackupDir(), localPath))
+		}
+		slog.Info("[dry-run] Would download", "file", objectName, "path", localPath)
+		return nil
+	}
+
 	// Create backup if file exists
-	if fileExists(localPath) {
+	var localTime time.Time
+	if info, err := os.Stat(localPath); err == nil {
+		localTime = info.ModTime().UTC()
 		if err := s.createBackup(localPath); err != nil {
 			return fmt.Errorf("failed to create backup: %w", err)
 		}
 	}
 
-	// Download to temp location first
-	tempPath := filepath.Join(os.TempDir(), objectName+".download")
+	// Nested objects may land in a folder that doesn't exist locally yet
+	if err := ensureDir(filepath.Dir(localPath)); err != nil {
+		return fmt.Errorf("failed to create local directory: %w", err)
+	}
+
+	// Download to a temp file, so a crash never leaves a half-written save
+	start := time.Now()
+	tempPath, err := s.createDownloadTemp(localPath)
+	if err != nil {
+		return err
+	}
+
+	entry := journalEntry{Object: objectName, Temp: tempPath, Target: localPath, ModTime: modTime, Started: start}
+	s.journal(entry)
+
 	if err := s.storage.Download(ctx, objectName, tempPath); err != nil {
+		os.Remove(tempPath)
+		s.unjournal(tempPath)
 		return fmt.Errorf("failed to download: %w", err)
 	}
+	entry.Complete = true
+	s.journal(entry)
+	if s.beforeReplace != nil {
+		s.beforeReplace()
+	}
 
 	// Replace local file
-	if err := copyFile(tempPath, localPath); err != nil {
+	if err := moveFile(tempPath, localPath); err != nil {
 		os.Remove(tempPath)
+		s.unjournal(tempPath)
 		return fmt.Errorf("failed to replace local file: %w", err)
 	}
+	s.unjournal(tempPath)
 
-	os.Remove(tempPath)
-
-	// Restore modification time
-	if err := os.Chtimes(localPath, modTime, modTime); err != nil {
-		log.Printf("Warning: failed to set mod time on %s: %v", localPath, err)
+	// Restore modification time. The access time is left as the storage
+	// restored it.
+	if err := os.Chtimes(localPath, time.Time{}, modTime); err != nil {
+		slog.Warn("Failed to set mod time", "path", localPath, "err", err)
 	}
 
-	log.Printf("Downloaded and replaced %s", filepath.Base(localPath))
+	var size int64
+	if info, err := os.Stat(localPath); err == nil {
+		size = info.Size()
+	}
+	s.reportTransfer(fmt.Sprintf("Downloaded and replaced %s", objectName), objectName, notify.Download,
+		localTime, modTime, size, time.Since(start))
+	s.recordSync(objectName, localPath)
+	s.updateGoodCopy(ctx, localPath, objectName)
 	return nil
 }
 
+// reportTransfer logs a completed transfer and passes it to Notifier. The
+// default logger prints msg followed by the fields; a JSON handler
+// (-log-format json) makes each field a key. localTime and cloudTime are
+// the mod times of the two sides before the transfer and are left out when
+// zero.
+func (s *Syncer) reportTransfer(msg, objectName, direction string, localTime, cloudTime time.Time, size int64, took time.Duration) {
+	attrs := []any{"file", objectName, "direction", direction}
+	if !localTime.IsZero() {
+		attrs = append(attrs, "local_mod_time", localTime)
+	}
+	if !cloudTime.IsZero() {
+		attrs = append(attrs, "cloud_mod_time", cloudTime)
+	}
+	attrs = append(attrs, "bytes", size, "duration", took)
+
+	slog.Info(msg, attrs...)
+	s.countTransfer(direction, size)
+
+	if s.Notifier != nil {
+		s.Notifier.Notify(notify.Event{File: objectName, Direction: direction, Time: time.Now().UTC(), Size: size})
+	}
+	if s.Metrics != nil {
+		s.Metrics.Transferred(direction, size)
+	}
+}
+
+// createBackup backs filePath up into a timestamped folder, then prunes
+// folders beyond the retention limits
 func (s *Syncer) createBackup(filePath string) error {
-	backupPath, err := s.createTimestampedBackupDir()
+	s.backupMu.Lock()
+	defer s.backupMu.Unlock()
+
+	if written, err := s.writeBackup(filePath); err != nil || !written {
+		return err
+	}
+	if s.Metrics != nil {
+		s.Metrics.BackedUp()
+	}
+
+	if err := s.PruneBackups(); err != nil {
+		slog.Warn("Failed to prune backups", "err", err)
+	}
+	return nil
+}
+
+// writeBackup backs filePath up and reports whether it wrote a backup.
+// Every backup gets a new timestamped folder unless BackupWindow is set;
+// then content backed up within the window is skipped, and the backups of
+// a burst of changes share the burst's folder, unless it already holds an
+// older version of the file. Callers hold backupMu.
+func (s *Syncer) writeBackup(filePath string) (bool, error) {
+	if s.BackupWindow <= 0 {
+		backupPath, err := s.createTimestampedBackupDir()
+		if err != nil {
+			return false, fmt.Errorf("failed to create backup directory: %w", err)
+		}
+		return true, s.backupInto(backupPath, filePath)
+	}
+
+	now := time.Now()
+	hash, err := fsutil.FileSHA256(filePath)
 	if err != nil {
+		return false, fmt.Errorf("failed to checksum %s: %w", filePath, err)
+	}
+
+	if last, ok := s.lastBackups[filePath]; ok && last.hash == hash && now.Sub(last.at) < s.BackupWindow {
+		slog.Debug("Skipped backup: unchanged since the last backup", "path", filePath, "backed_up_at", last.at)
+		return false, nil
+	}
+
+	backupPath := s.burstDir
+	if backupPath == "" || now.Sub(s.burstStart) >= s.BackupWindow || !dirExists(backupPath) || fileExists(s.backupFile(backupPath, filePath)) {
+		if backupPath, err = s.createTimestampedBackupDir(); err != nil {
+			return false, fmt.Errorf("failed to create backup directory: %w", err)
+		}
+		s.burstDir, s.burstStart = backupPath, now
+	}
+
+	if err := s.backupInto(backupPath, filePath); err != nil {
+		return false, err
+	}
+
+	if s.lastBackups == nil {
+		s.lastBackups = make(map[string]backupRecord)
+	}
+	s.lastBackups[filePath] = backupRecord{hash: hash, at: now}
+	return true, nil
+}
+
+// backupRecord is the content and time of a file's latest backup
+type backupRecord struct {
+	hash string
+	at   time.Time
+}
+
+// backupInto backs filePath up into an existing backup folder
+func (s *Syncer) backupInto(backupPath, filePath string) error {
+	backupFile := s.backupFile(backupPath, filePath)
+	if err := ensureDir(filepath.Dir(backupFile)); err != nil {
 		return fmt.Errorf("failed to create backup directory: %w", err)
 	}
+	if s.HardlinkBackups {
+		err := linkFile(filePath, backupFile)
+		if err == nil {
+			slog.Debug("Created backup", "path", backupFile, "hardlink", true)
+			return nil
+		}
+		slog.Warn("Hardlink backup failed, copying instead", "err", err)
+	}
 
-	backupFile := filepath.Join(backupPath, filepath.Base(filePath))
-	if err := copyFile(filePath, backupFile); err != nil {
+	if err := fsutil.CopyFile(filePath, backupFile); err != nil {
 		return fmt.Errorf("failed to copy file to backup: %w", err)
 	}
 
-	log.Printf("Created backup: %s", backupFile)
+	slog.Debug("Created backup", "path", backupFile)
 	return nil
 }
 
+// createTimestampedBackupDir creates a new, empty backup folder named for
+// the current time. When a folder for the same microsecond already exists,
+// as in a burst of backups, the new one gets a "-N" suffix instead of
+// sharing it, so neither backup can overwrite the other's files.
 func (s *Syncer) createTimestampedBackupDir() (string, error) {
 	if err := ensureDir(s.backupDir); err != nil {
 		return "", err
 	}
 
-	timestamp := time.Now().Format("2006-01-02_15-04-05.000000")
-	backupPath := filepath.Join(s.backupDir, timestamp)
-
-	if err := os.Mkdir(backupPath, 0755); err != nil {
-		return "", fmt.Errorf("failed to create timestamped backup directory: %w", err)
+	base := s.nextBackupDir()
+	backupPath := base
+	for seq := 1; ; seq++ {
+		err := os.Mkdir(backupPath, 0755)
+		if err == nil {
+			return backupPath, nil
+		}
+		if !os.IsExist(err) || seq > maxBackupDirSuffix {
+			return "", fmt.Errorf("failed to create timestamped backup directory: %w", err)
+		}
+		backupPath = fmt.Sprintf("%s-%d", base, seq)
 	}
+}
+
+// backupClock names backup folders; tests replace it to make backups land
+// in the same microsecond
+var backupClock = time.Now
+
+// nextBackupDir returns the timestamped directory a backup taken now goes to
+func (s *Syncer) nextBackupDir() string {
+	return filepath.Join(s.backupDir, backupClock().Format(backupTimeLayout))
+}
 
-	return backupPath, nil
+// backupFile returns where filePath is backed up inside backupPath. It
+// mirrors the file's place in the watch path so nested saves with the same
+// name don't collide.
+func (s *Syncer) backupFile(backupPath, filePath string) string {
+	rel := filepath.Base(filePath)
+	if name, err := s.objectName(filePath); err == nil {
+		rel = filepath.FromSlash(name)
+	}
+	return filepath.Join(backupPath, rel)
 }
 
 // Utility functions
 
-func shouldSyncFile(filePath string) bool {
-	name := filepath.Base(filePath)
-	return strings.HasSuffix(name, ".sav") && name != "EnhancedInputUserSettings.sav"
+// shouldSyncFile reports whether filePath, a local path or an object name,
+// passes the include and exclude patterns and the .cloudsyncignore rules.
+// Tombstones and directory markers never do.
+func (s *Syncer) shouldSyncFile(filePath string) bool {
+	return !isTombstone(filePath) && !isDirMarker(filePath) && fsutil.MatchFile(filePath, s.IncludePatterns, s.ExcludePatterns) && !s.ignored(filePath)
 }
 
 func fileExists(path string) bool {
@@ -289,16 +1038,49 @@ func fileExists(path string) bool {
 	return err == nil
 }
 
-func copyFile(src, dst string) error {
-	input, err := os.ReadFile(src)
-	if err != nil {
-		return fmt.Errorf("failed to read source: %w", err)
+func dirExists(path string) bool {
+	info, err := os.Stat(path)
+	return err == nil && info.IsDir()
+}
+
+// linkFile hardlinks dst to src. It fails across file systems and on file
+// systems without hardlink support; tests replace it to simulate that.
+var linkFile = os.Link
+
+// replaceFile copies src beside dst and renames it into place. dst gets a new
+// inode, so hardlinked backups of the previous content stay untouched.
+func replaceFile(src, dst string) error {
+	tempPath := dst + ".tmp"
+	if err := fsutil.CopyFile(src, tempPath); err != nil {
+		os.Remove(tempPath)
+		return err
 	}
 
-	if err := os.WriteFile(dst, input, 0644); err != nil {
-		return fmt.Errorf("failed to write destination: %w", err)
+	if err := renameFile(tempPath, dst); err != nil {
+		os.Remove(tempPath)
+		return fmt.Errorf("failed to rename into place: %w", err)
+	}
+
+	return nil
+}
+
+// renameFile is os.Rename; tests replace it to simulate a crash or a move
+// across file systems
+var renameFile = os.Rename
+
+// moveFile atomically renames src over dst. Only when they are on
+// different file systems, where rename can't work, does it fall back to
+// replaceFile's copy beside dst; src is removed either way.
+func moveFile(src, dst string) error {
+	err := renameFile(src, dst)
+	if err == nil || !isCrossDevice(err) {
+		return err
 	}
 
+	if err := replaceFile(src, dst); err != nil {
+		return err
+	}
+	os.Remove(src)
 	return nil
 }
 
@@ -0,0 +1,3003 @@
+package sync
+
+import (
+	"bytes"
+	"context"
+	"crypto/md5"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	gosync "sync"
+	"sync/atomic"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/danielbehrens/cloudsync/internal/notify"
+	"github.com/danielbehrens/cloudsync/internal/power"
+)
+
+// fakeStorage is an in-memory Storage used by the sync tests. It is safe
+// for concurrent use.
+type fakeStorage struct {
+	mu      gosync.Mutex
+	objects map[string]*SyncFileInfo
+	data    map[string][]byte
+
+	// corruptUploads flips a byte in the next n uploaded objects
+	corruptUploads int
+	uploads        int
+	uploadOrder    []string
+
+	// downloadErr, when set, is returned by Download after writing half
+	// of the object, like a truncated transfer the backend caught
+	downloadErr error
+}
+
+func newFakeStorage() *fakeStorage {
+	return &fakeStorage{
+		objects: make(map[string]*SyncFileInfo),
+		data:    make(map[string][]byte),
+	}
+}
+
+func (f *fakeStorage) Upload(ctx context.Context, localPath, objectName string) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	info, err := os.Stat(localPath)
+	if err != nil {
+		return err
+	}
+	data, err := os.ReadFile(localPath)
+	if err != nil {
+		return err
+	}
+
+	if f.corruptUploads > 0 && len(data) > 0 {
+		f.corruptUploads--
+		data[0] ^= 0xff
+	}
+
+	f.uploads++
+	f.uploadOrder = append(f.uploadOrder, objectName)
+	sum := sha256.Sum256(data)
+	f.objects[objectName] = &SyncFileInfo{
+		Name:     objectName,
+		ModTime:  info.ModTime().UTC(),
+		Size:     info.Size(),
+		Checksum: hex.EncodeToString(sum[:]),
+	}
+	f.data[objectName] = data
+	return nil
+}
+
+func (f *fakeStorage) Download(ctx context.Context, objectName, localPath string) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	data, ok := f.data[objectName]
+	if !ok {
+		return fmt.Errorf("object %s not found", objectName)
+	}
+	if f.downloadErr != nil {
+		os.WriteFile(localPath, data[:len(data)/2], 0644)
+		return f.downloadErr
+	}
+	return os.WriteFile(localPath, data, 0644)
+}
+
+func (f *fakeStorage) Delete(ctx context.Context, objectName string) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if _, ok := f.objects[objectName]; !ok {
+		return fmt.Errorf("object %s: %w", objectName, ErrNotExist)
+	}
+	delete(f.objects, objectName)
+	delete(f.data, objectName)
+	return nil
+}
+
+func (f *fakeStorage) Stat(ctx context.Context, objectName string) (*SyncFileInfo, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	info, ok := f.objects[objectName]
+	if !ok {
+		return nil, fmt.Errorf("object %s: %w", objectName, ErrNotExist)
+	}
+	return info, nil
+}
+
+func (f *fakeStorage) List(ctx context.Context) ([]*SyncFileInfo, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	var result []*SyncFileInfo
+	for _, info := range f.objects {
+		result = append(result, info)
+	}
+	return result, nil
+}
+
+func (f *fakeStorage) EnsureBucket(ctx context.Context) error {
+	return nil
+}
+
+func writeFile(t *testing.T, path, content string, modTime time.Time) {
+	t.Helper()
+	if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+		t.Fatalf("WriteFile() error = %v", err)
+	}
+	if err := os.Chtimes(path, modTime, modTime); err != nil {
+		t.Fatalf("Chtimes() error = %v", err)
+	}
+}
+
+func TestImport(t *testing.T) {
+	dir := t.TempDir()
+	modTime := time.Now().Add(-time.Hour).Truncate(time.Second)
+
+	writeFile(t, filepath.Join(dir, "slot1.sav"), "one", modTime)
+	writeFile(t, filepath.Join(dir, "slot2.sav"), "two", modTime)
+	writeFile(t, filepath.Join(dir, "EnhancedInputUserSettings.sav"), "settings", modTime)
+	writeFile(t, filepath.Join(dir, "notes.txt"), "notes", modTime)
+
+	store := newFakeStorage()
+	s := NewSyncer(store, dir, t.TempDir(), nil, 500*time.Millisecond)
+
+	summary, err := s.Import(context.Background(), dir)
+	if err != nil {
+		t.Fatalf("Import() error = %v", err)
+	}
+
+	if summary.Uploaded != 2 {
+		t.Errorf("Uploaded = %v, want %v", summary.Uploaded, 2)
+	}
+	if summary.Skipped != 2 {
+		t.Errorf("Skipped = %v, want %v", summary.Skipped, 2)
+	}
+	if summary.Bytes != 6 {
+		t.Errorf("Bytes = %v, want %v", summary.Bytes, 6)
+	}
+
+	info, err := store.Stat(context.Background(), "slot1.sav")
+	if err != nil {
+		t.Fatalf("slot1.sav was not uploaded: %v", err)
+	}
+	if !info.ModTime.Equal(modTime) {
+		t.Errorf("ModTime = %v, want %v", info.ModTime, modTime)
+	}
+
+	if _, err := store.Stat(context.Background(), "EnhancedInputUserSettings.sav"); err == nil {
+		t.Error("excluded settings file should not be imported")
+	}
+}
+
+func TestDecideAction(t *testing.T) {
+	base := time.Date(2025, 6, 1, 12, 0, 0, 0, time.UTC)
+
+	tests := []struct {
+		name      string
+		local     time.Time
+		cloud     time.Time
+		tolerance time.Duration
+		want      syncAction
+	}{
+		{
+			name:      "zero tolerance, exactly equal",
+			local:     base,
+			cloud:     base,
+			tolerance: 0,
+			want:      actionNone,
+		},
+		{
+			name:      "zero tolerance, cloud newer by 1ns",
+			local:     base,
+			cloud:     base.Add(time.Nanosecond),
+			tolerance: 0,
+			want:      actionDownload,
+		},
+		{
+			name:      "zero tolerance, local newer by 1ns",
+			local:     base.Add(time.Nanosecond),
+			cloud:     base,
+			tolerance: 0,
+			want:      actionUpload,
+		},
+		{
+			name:      "default tolerance, diff exactly at tolerance",
+			local:     base,
+			cloud:     base.Add(500 * time.Millisecond),
+			tolerance: 500 * time.Millisecond,
+			want:      actionNone,
+		},
+		{
+			name:      "default tolerance, cloud just past tolerance",
+			local:     base,
+			cloud:     base.Add(500*time.Millisecond + time.Nanosecond),
+			tolerance: 500 * time.Millisecond,
+			want:      actionDownload,
+		},
+		{
+			name:      "default tolerance, local just past tolerance",
+			local:     base.Add(500*time.Millisecond + time.Nanosecond),
+			cloud:     base,
+			tolerance: 500 * time.Millisecond,
+			want:      actionUpload,
+		},
+		{
+			name:      "default tolerance, sub-tolerance diff",
+			local:     base,
+			cloud:     base.Add(100 * time.Millisecond),
+			tolerance: 500 * time.Millisecond,
+			want:      actionNone,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := decideAction(tt.local, tt.cloud, tt.tolerance); got != tt.want {
+				t.Errorf("decideAction() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestSyncFileZeroTolerance(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "game.sav")
+	modTime := time.Now().Add(-time.Hour).Truncate(time.Second)
+
+	writeFile(t, path, "local", modTime)
+
+	store := newFakeStorage()
+	s := NewSyncer(store, dir, t.TempDir(), nil, 0)
+
+	// Identical mod times must not trigger a transfer
+	store.objects["game.sav"] = &SyncFileInfo{Name: "game.sav", ModTime: modTime, Size: 5}
+	store.data["game.sav"] = []byte("cloud")
+
+	if err := s.SyncFile(context.Background(), path); err != nil {
+		t.Fatalf("SyncFile() error = %v", err)
+	}
+	if got, _ := os.ReadFile(path); string(got) != "local" {
+		t.Errorf("local content = %q, want unchanged %q", got, "local")
+	}
+
+	// A sub-millisecond newer cloud copy must be downloaded
+	store.objects["game.sav"].ModTime = modTime.Add(time.Microsecond)
+
+	if err := s.SyncFile(context.Background(), path); err != nil {
+		t.Fatalf("SyncFile() error = %v", err)
+	}
+	if got, _ := os.ReadFile(path); string(got) != "cloud" {
+		t.Errorf("local content = %q, want %q", got, "cloud")
+	}
+}
+
+func TestSyncFileCoarseTolerance(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "game.sav")
+	modTime := time.Now().Add(-time.Hour).Truncate(time.Second).Add(200 * time.Millisecond)
+
+	writeFile(t, path, "local", modTime)
+
+	store := newFakeStorage()
+	s := NewSyncer(store, dir, t.TempDir(), nil, 500*time.Millisecond)
+	s.CoarseTimeTolerance = 2 * time.Second
+
+	// A second-resolution upload time just after the local mod time is
+	// the same version
+	store.objects["game.sav"] = &SyncFileInfo{
+		Name:          "game.sav",
+		ModTime:       modTime.Truncate(time.Second).Add(time.Second),
+		Size:          5,
+		CoarseModTime: true,
+	}
+	store.data["game.sav"] = []byte("cloud")
+
+	if err := s.SyncFile(context.Background(), path); err != nil {
+		t.Fatalf("SyncFile() error = %v", err)
+	}
+	if got, _ := os.ReadFile(path); string(got) != "local" {
+		t.Errorf("local content = %q, want unchanged %q", got, "local")
+	}
+
+	// The same difference in a precise mod time is a newer cloud copy
+	store.objects["game.sav"].CoarseModTime = false
+
+	if err := s.SyncFile(context.Background(), path); err != nil {
+		t.Fatalf("SyncFile() error = %v", err)
+	}
+	if got, _ := os.ReadFile(path); string(got) != "cloud" {
+		t.Errorf("local content = %q, want %q", got, "cloud")
+	}
+}
+
+func TestSyncFileDownloadBacksUpAndKeepsModTime(t *testing.T) {
+	dir := t.TempDir()
+	backupDir := t.TempDir()
+	path := filepath.Join(dir, "game.sav")
+	writeFile(t, path, "local", time.Now().Add(-time.Hour))
+
+	cloudTime := time.Now().Add(-time.Minute).Truncate(time.Microsecond)
+	store := newFakeStorage()
+	store.objects["game.sav"] = &SyncFileInfo{Name: "game.sav", ModTime: cloudTime, Size: 10}
+	store.data["game.sav"] = []byte("cloud save")
+
+	s := NewSyncer(store, dir, backupDir, nil, 500*time.Millisecond)
+	if err := s.SyncFile(context.Background(), path); err != nil {
+		t.Fatalf("SyncFile() error = %v", err)
+	}
+
+	if got, _ := os.ReadFile(path); string(got) != "cloud save" {
+		t.Errorf("local content = %q, want %q", got, "cloud save")
+	}
+	if info, err := os.Stat(path); err != nil {
+		t.Errorf("Stat() error = %v", err)
+	} else if !info.ModTime().Equal(cloudTime) {
+		t.Errorf("mod time = %v, want the cloud copy's %v", info.ModTime(), cloudTime)
+	}
+
+	// The replaced file is kept in a timestamped backup
+	backups, _ := filepath.Glob(filepath.Join(backupDir, "*", "game.sav"))
+	if len(backups) != 1 {
+		t.Fatalf("backups = %v, want 1", backups)
+	}
+	if got, _ := os.ReadFile(backups[0]); string(got) != "local" {
+		t.Errorf("backup content = %q, want %q", got, "local")
+	}
+}
+
+func TestObjectName(t *testing.T) {
+	dir := t.TempDir()
+
+	tests := []struct {
+		name      string
+		file      string
+		recursive bool
+		want      string
+		wantErr   bool
+	}{
+		{name: "path in watch path", file: filepath.Join(dir, "game.sav"), want: "game.sav"},
+		{name: "relative name", file: "game.sav", want: "game.sav"},
+		{name: "nested path", file: filepath.Join(dir, "slot1", "game.sav"), recursive: true, want: "slot1/game.sav"},
+		{name: "nested name", file: filepath.Join("slot1", "game.sav"), recursive: true, want: "slot1/game.sav"},
+		{name: "nested without recursive", file: filepath.Join("slot1", "game.sav"), wantErr: true},
+		{name: "path outside watch path", file: filepath.Join(filepath.Dir(dir), "game.sav"), wantErr: true},
+		{name: "name climbing out", file: filepath.Join("..", "game.sav"), wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := NewSyncer(newFakeStorage(), dir, t.TempDir(), nil, 500*time.Millisecond)
+			s.Recursive = tt.recursive
+
+			got, err := s.ObjectName(tt.file)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("ObjectName(%q) error = %v, wantErr %v", tt.file, err, tt.wantErr)
+			}
+			if got != tt.want {
+				t.Errorf("ObjectName(%q) = %q, want %q", tt.file, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestSyncFileRetriesBusyFile(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "game.sav")
+	writeFile(t, path, "saved", time.Now().Add(-time.Hour).Truncate(time.Second))
+
+	store := newFakeStorage()
+	s := NewSyncer(store, dir, t.TempDir(), nil, 0)
+	s.busyRetryDelay = 10 * time.Millisecond
+
+	// The game holds the file for the first two attempts
+	var checks atomic.Int32
+	s.fileBusy = func(string) bool { return checks.Add(1) <= 2 }
+
+	if err := s.SyncFile(context.Background(), path); err != nil {
+		t.Fatalf("SyncFile() error = %v, want nil while the retry is pending", err)
+	}
+	// An event for the same file while the retry is pending doesn't add one
+	if err := s.SyncFile(context.Background(), path); err != nil {
+		t.Fatalf("SyncFile() error = %v", err)
+	}
+
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		store.mu.Lock()
+		uploads := store.uploads
+		store.mu.Unlock()
+		if uploads > 0 {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("busy file not uploaded after retrying, %d checks", checks.Load())
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+	if got := checks.Load(); got != 3 {
+		t.Errorf("busy checks = %d, want 3", got)
+	}
+
+	// A file that stays busy is given up on after busyAttempts
+	checks.Store(0)
+	s.fileBusy = func(string) bool { checks.Add(1); return true }
+	writeFile(t, path, "saved again", time.Now())
+	if err := s.SyncFile(context.Background(), path); err != nil {
+		t.Fatalf("SyncFile() error = %v", err)
+	}
+	deadline = time.Now().Add(5 * time.Second)
+	for checks.Load() < busyAttempts && time.Now().Before(deadline) {
+		time.Sleep(5 * time.Millisecond)
+	}
+	time.Sleep(100 * time.Millisecond)
+	if got := checks.Load(); got != busyAttempts {
+		t.Errorf("busy checks = %d, want %d", got, busyAttempts)
+	}
+	if store.uploads != 1 {
+		t.Errorf("uploads = %d, want the busy file not uploaded again", store.uploads)
+	}
+}
+
+func TestVerifyAfterUpload(t *testing.T) {
+	tests := []struct {
+		name           string
+		corruptUploads int
+		wantErr        bool
+		wantUploads    int
+	}{
+		{
+			name:           "clean upload verifies first time",
+			corruptUploads: 0,
+			wantErr:        false,
+			wantUploads:    1,
+		},
+		{
+			name:           "corrupted upload is detected and retried",
+			corruptUploads: 1,
+			wantErr:        false,
+			wantUploads:    2,
+		},
+		{
+			name:           "persistent corruption fails the upload",
+			corruptUploads: verifyAttempts,
+			wantErr:        true,
+			wantUploads:    verifyAttempts,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := t.TempDir()
+			path := filepath.Join(dir, "game.sav")
+			writeFile(t, path, "precious save", time.Now())
+
+			store := newFakeStorage()
+			store.corruptUploads = tt.corruptUploads
+
+			s := NewSyncer(store, dir, t.TempDir(), nil, 500*time.Millisecond)
+			s.VerifyAfterUpload = true
+
+			err := s.SyncFile(context.Background(), path)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("SyncFile() error = %v, wantErr %v", err, tt.wantErr)
+			}
+
+			if store.uploads != tt.wantUploads {
+				t.Errorf("uploads = %v, want %v", store.uploads, tt.wantUploads)
+			}
+
+			if !tt.wantErr && string(store.data["game.sav"]) != "precious save" {
+				t.Errorf("stored content = %q, want %q", store.data["game.sav"], "precious save")
+			}
+		})
+	}
+}
+
+func TestBuildManifest(t *testing.T) {
+	dir := t.TempDir()
+	base := time.Now().Add(-time.Hour).Truncate(time.Second)
+
+	writeFile(t, filepath.Join(dir, "same.sav"), "same", base)
+	writeFile(t, filepath.Join(dir, "local-newer.sav"), "new local", base.Add(time.Minute))
+	writeFile(t, filepath.Join(dir, "cloud-newer.sav"), "old local", base)
+	writeFile(t, filepath.Join(dir, "local-only.sav"), "only here", base)
+	writeFile(t, filepath.Join(dir, "ignored.txt"), "not a save", base)
+
+	store := newFakeStorage()
+	store.objects["same.sav"] = &SyncFileInfo{Name: "same.sav", ModTime: base, Size: 4, ETag: "etag-same"}
+	store.objects["local-newer.sav"] = &SyncFileInfo{Name: "local-newer.sav", ModTime: base, Size: 9}
+	store.objects["cloud-newer.sav"] = &SyncFileInfo{Name: "cloud-newer.sav", ModTime: base.Add(time.Minute), Size: 9}
+	store.objects["cloud-only.sav"] = &SyncFileInfo{Name: "cloud-only.sav", ModTime: base, Size: 3}
+
+	s := NewSyncer(store, dir, t.TempDir(), nil, 500*time.Millisecond)
+
+	manifest, err := s.BuildManifest(context.Background())
+	if err != nil {
+		t.Fatalf("BuildManifest() error = %v", err)
+	}
+
+	want := map[string]string{
+		"cloud-newer.sav": VerdictCloudNewer,
+		"cloud-only.sav":  VerdictCloudOnly,
+		"local-newer.sav": VerdictLocalNewer,
+		"local-only.sav":  VerdictLocalOnly,
+		"same.sav":        VerdictInSync,
+	}
+
+	if len(manifest.Files) != len(want) {
+		t.Fatalf("len(Files) = %v, want %v", len(manifest.Files), len(want))
+	}
+
+	for i, f := range manifest.Files {
+		if i > 0 && manifest.Files[i-1].Name >= f.Name {
+			t.Errorf("Files not sorted: %v before %v", manifest.Files[i-1].Name, f.Name)
+		}
+		if f.Verdict != want[f.Name] {
+			t.Errorf("%s verdict = %v, want %v", f.Name, f.Verdict, want[f.Name])
+		}
+		if f.Name == "same.sav" {
+			sum := sha256.Sum256([]byte("same"))
+			if f.Local.Checksum != hex.EncodeToString(sum[:]) {
+				t.Errorf("same.sav local checksum = %v, want sha256 of content", f.Local.Checksum)
+			}
+			if f.Cloud.ETag != "etag-same" {
+				t.Errorf("same.sav cloud ETag = %v, want %v", f.Cloud.ETag, "etag-same")
+			}
+		}
+	}
+
+	// Building a manifest must not transfer anything
+	if store.uploads != 0 {
+		t.Errorf("uploads = %v, want 0", store.uploads)
+	}
+	if _, err := os.Stat(filepath.Join(dir, "cloud-only.sav")); !os.IsNotExist(err) {
+		t.Error("BuildManifest() must not download cloud-only files")
+	}
+}
+
+// TestBuildManifestFollowsPlan checks that the verdicts are what a sync
+// would do, not a separate comparison of mod times
+func TestBuildManifestFollowsPlan(t *testing.T) {
+	dir := t.TempDir()
+	base := time.Now().Add(-time.Hour).Truncate(time.Second)
+
+	writeFile(t, filepath.Join(dir, "edited.sav"), "edited locally", base)
+	writeFile(t, filepath.Join(dir, "local-newer.sav"), "new local", base.Add(time.Minute))
+
+	cloudSum := sha256.Sum256([]byte("cloud copy"))
+	store := newFakeStorage()
+	store.objects["edited.sav"] = &SyncFileInfo{Name: "edited.sav", ModTime: base, Size: 10, Checksum: hex.EncodeToString(cloudSum[:])}
+	store.objects["local-newer.sav"] = &SyncFileInfo{Name: "local-newer.sav", ModTime: base, Size: 9}
+
+	s := NewSyncer(store, dir, t.TempDir(), nil, 500*time.Millisecond)
+	s.ChecksumMode = true
+	s.Direction = DirectionDownloadOnly
+
+	manifest, err := s.BuildManifest(context.Background())
+	if err != nil {
+		t.Fatalf("BuildManifest() error = %v", err)
+	}
+
+	// Same mod time, different content: sync uploads the local copy, which
+	// download-only rules out
+	want := map[string]string{
+		"edited.sav":      VerdictSkipped,
+		"local-newer.sav": VerdictSkipped,
+	}
+	if len(manifest.Files) != len(want) {
+		t.Fatalf("len(Files) = %v, want %v", len(manifest.Files), len(want))
+	}
+	for _, f := range manifest.Files {
+		if f.Verdict != want[f.Name] || f.Reason == "" {
+			t.Errorf("%s verdict = %v (%q), want %v with a reason", f.Name, f.Verdict, f.Reason, want[f.Name])
+		}
+	}
+
+	s.Direction = DirectionBidirectional
+	manifest, err = s.BuildManifest(context.Background())
+	if err != nil {
+		t.Fatalf("BuildManifest() error = %v", err)
+	}
+	for _, f := range manifest.Files {
+		if f.Verdict != VerdictLocalNewer {
+			t.Errorf("%s verdict = %v, want %v", f.Name, f.Verdict, VerdictLocalNewer)
+		}
+	}
+}
+
+func TestLastSynced(t *testing.T) {
+	dir := t.TempDir()
+	stateFile := filepath.Join(t.TempDir(), "sync-state.json")
+	path := filepath.Join(dir, "game.sav")
+	writeFile(t, path, "local", time.Now().Add(-time.Hour))
+
+	store := newFakeStorage()
+	s := NewSyncer(store, dir, t.TempDir(), nil, 500*time.Millisecond)
+	s.StateFile = stateFile
+
+	if _, ok := s.LastSynced("game.sav"); ok {
+		t.Error("LastSynced() before any sync reported a time")
+	}
+
+	before := time.Now()
+	if err := s.SyncFile(context.Background(), path); err != nil {
+		t.Fatalf("SyncFile() error = %v", err)
+	}
+	synced, ok := s.LastSynced("game.sav")
+	if !ok || synced.Before(before) || synced.After(time.Now()) {
+		t.Errorf("LastSynced() = %v, %v, want the time of the upload", synced, ok)
+	}
+
+	// A restarted daemon reads it from the state file
+	restarted := NewSyncer(store, dir, t.TempDir(), nil, 500*time.Millisecond)
+	restarted.StateFile = stateFile
+	if got, ok := restarted.LastSynced("game.sav"); !ok || !got.Equal(synced) {
+		t.Errorf("LastSynced() after restart = %v, %v, want %v", got, ok, synced)
+	}
+
+	manifest, err := restarted.BuildManifest(context.Background())
+	if err != nil {
+		t.Fatalf("BuildManifest() error = %v", err)
+	}
+	if len(manifest.Files) != 1 || !manifest.Files[0].LastSynced.Equal(synced) {
+		t.Errorf("manifest = %+v, want game.sav last synced at %v", manifest.Files, synced)
+	}
+}
+
+func TestGoodCopyUpdateAndRestore(t *testing.T) {
+	dir := t.TempDir()
+	backupDir := t.TempDir()
+	path := filepath.Join(dir, "game.sav")
+	goodPath := filepath.Join(backupDir, "LatestGood", "game.sav")
+
+	store := newFakeStorage()
+	s := NewSyncer(store, dir, backupDir, nil, 500*time.Millisecond)
+	s.GoodCopyDir = filepath.Join(backupDir, "LatestGood")
+
+	// A verified upload refreshes the good copy
+	writeFile(t, path, "good save", time.Now().Add(-time.Hour))
+	if err := s.SyncFile(context.Background(), path); err != nil {
+		t.Fatalf("SyncFile() error = %v", err)
+	}
+	if got, _ := os.ReadFile(goodPath); string(got) != "good save" {
+		t.Fatalf("good copy = %q, want %q", got, "good save")
+	}
+
+	// An upload the cloud checksum does not confirm leaves it alone
+	store.corruptUploads = 1
+	writeFile(t, path, "bad save", time.Now())
+	if err := s.SyncFile(context.Background(), path); err != nil {
+		t.Fatalf("SyncFile() error = %v", err)
+	}
+	if got, _ := os.ReadFile(goodPath); string(got) != "good save" {
+		t.Errorf("good copy = %q after unverified upload, want %q", got, "good save")
+	}
+
+	names, err := s.GoodCopies()
+	if err != nil {
+		t.Fatalf("GoodCopies() error = %v", err)
+	}
+	if len(names) != 1 || names[0] != "game.sav" {
+		t.Errorf("GoodCopies() = %v, want [game.sav]", names)
+	}
+
+	before := time.Now().Add(-time.Second)
+	if err := s.RestoreGoodCopy("game.sav"); err != nil {
+		t.Fatalf("RestoreGoodCopy() error = %v", err)
+	}
+	if got, _ := os.ReadFile(path); string(got) != "good save" {
+		t.Errorf("live file = %q after restore, want %q", got, "good save")
+	}
+
+	// The restored file must win the next mod time comparison
+	info, err := os.Stat(path)
+	if err != nil {
+		t.Fatalf("Stat() error = %v", err)
+	}
+	if info.ModTime().Before(before) {
+		t.Errorf("restored ModTime = %v, want fresh", info.ModTime())
+	}
+
+	// The replaced live file is kept in a timestamped backup
+	backups, _ := filepath.Glob(filepath.Join(backupDir, "*", "game.sav"))
+	var found bool
+	for _, b := range backups {
+		if data, _ := os.ReadFile(b); string(data) == "bad save" {
+			found = true
+		}
+	}
+	if !found {
+		t.Error("RestoreGoodCopy() did not back up the live file")
+	}
+}
+
+func TestRestoreGoodCopyErrors(t *testing.T) {
+	s := NewSyncer(newFakeStorage(), t.TempDir(), t.TempDir(), nil, 500*time.Millisecond)
+	s.GoodCopyDir = t.TempDir()
+
+	for _, name := range []string{"missing.sav", "../game.sav", "notes.txt"} {
+		if err := s.RestoreGoodCopy(name); err == nil {
+			t.Errorf("RestoreGoodCopy(%q) error = nil, want error", name)
+		}
+	}
+}
+
+func TestDedupeCloud(t *testing.T) {
+	dir := t.TempDir()
+	backupDir := t.TempDir()
+	modTime := time.Now().Add(-time.Hour)
+
+	writeFile(t, filepath.Join(dir, "Character.sav"), "hero", modTime)
+	writeFile(t, filepath.Join(dir, "World.sav"), "world", modTime)
+
+	store := newFakeStorage()
+	s := NewSyncer(store, dir, backupDir, nil, 500*time.Millisecond)
+
+	// Seed the same content under several keys, as a key migration would
+	for key, path := range map[string]string{
+		"Character.sav":        "Character.sav",
+		"character.sav":        "Character.sav",
+		"saves/Character.sav":  "Character.sav",
+		"World.sav":            "World.sav",
+		"Unique.sav":           "Character.sav",
+		"archive/Old.sav":      "World.sav",
+		"archive/Untagged.sav": "World.sav",
+	} {
+		if err := store.Upload(context.Background(), filepath.Join(dir, path), key); err != nil {
+			t.Fatalf("Upload() error = %v", err)
+		}
+	}
+	// Unique.sav differs in content; Untagged.sav has no checksum to prove identity
+	store.objects["Unique.sav"].Checksum = "different"
+	store.objects["archive/Untagged.sav"].Checksum = ""
+
+	groups, err := s.FindDuplicates(context.Background())
+	if err != nil {
+		t.Fatalf("FindDuplicates() error = %v", err)
+	}
+	if len(groups) != 2 {
+		t.Fatalf("len(groups) = %v, want 2: %+v", len(groups), groups)
+	}
+
+	if groups[0].Keep != "Character.sav" {
+		t.Errorf("groups[0].Keep = %v, want Character.sav", groups[0].Keep)
+	}
+	if want := []string{"character.sav", "saves/Character.sav"}; fmt.Sprint(groups[0].Redundant) != fmt.Sprint(want) {
+		t.Errorf("groups[0].Redundant = %v, want %v", groups[0].Redundant, want)
+	}
+	if groups[1].Keep != "World.sav" || fmt.Sprint(groups[1].Redundant) != "[archive/Old.sav]" {
+		t.Errorf("groups[1] = %+v, want World.sav keeping archive/Old.sav as redundant", groups[1])
+	}
+
+	removed, err := s.RemoveDuplicates(context.Background(), groups)
+	if err != nil {
+		t.Fatalf("RemoveDuplicates() error = %v", err)
+	}
+	if removed != 3 {
+		t.Errorf("removed = %v, want 3", removed)
+	}
+
+	for _, key := range []string{"Character.sav", "World.sav", "Unique.sav", "archive/Untagged.sav"} {
+		if _, ok := store.objects[key]; !ok {
+			t.Errorf("%s was removed, want kept", key)
+		}
+	}
+	for _, key := range []string{"character.sav", "saves/Character.sav", "archive/Old.sav"} {
+		if _, ok := store.objects[key]; ok {
+			t.Errorf("%s was kept, want removed", key)
+		}
+	}
+
+	backups, _ := filepath.Glob(filepath.Join(backupDir, "*", "*"))
+	if len(backups) != 3 {
+		t.Errorf("backups = %v, want 3 files", backups)
+	}
+}
+
+func TestRemoveDuplicatesKeepsLastCopy(t *testing.T) {
+	store := newFakeStorage()
+	store.objects["b.sav"] = &SyncFileInfo{Name: "b.sav", Size: 1, Checksum: "abc"}
+	store.data["b.sav"] = []byte("x")
+
+	s := NewSyncer(store, t.TempDir(), t.TempDir(), nil, 500*time.Millisecond)
+
+	// The canonical copy vanished since the report was built
+	groups := []DuplicateGroup{{Checksum: "abc", Size: 1, Keep: "a.sav", Redundant: []string{"b.sav"}}}
+
+	if _, err := s.RemoveDuplicates(context.Background(), groups); err == nil {
+		t.Fatal("RemoveDuplicates() error = nil, want error")
+	}
+	if _, ok := store.objects["b.sav"]; !ok {
+		t.Error("b.sav was removed although it is the last copy")
+	}
+}
+
+func TestPruneCloud(t *testing.T) {
+	dir := t.TempDir()
+	backupDir := t.TempDir()
+	store := newFakeStorage()
+	old := time.Now().Add(-48 * time.Hour)
+
+	s := NewSyncer(store, dir, backupDir, nil, 500*time.Millisecond)
+	s.ExcludePatterns = []string{"*.log"}
+
+	src := t.TempDir()
+	for name, modTime := range map[string]time.Time{
+		"Kept.sav":    old,
+		"Deleted.sav": old,
+		"Recent.sav":  time.Now(),
+		"game.log":    old,
+		"sub/Old.sav": old,
+	} {
+		path := filepath.Join(src, strings.ReplaceAll(name, "/", "_"))
+		writeFile(t, path, name, modTime)
+		if err := store.Upload(context.Background(), path, name); err != nil {
+			t.Fatalf("Upload() error = %v", err)
+		}
+	}
+	writeFile(t, filepath.Join(dir, "Kept.sav"), "Kept.sav", old)
+
+	orphans, err := s.FindOrphans(context.Background(), 24*time.Hour)
+	if err != nil {
+		t.Fatalf("FindOrphans() error = %v", err)
+	}
+	if len(orphans) != 1 || orphans[0].Name != "Deleted.sav" {
+		t.Fatalf("orphans = %+v, want only Deleted.sav", orphans)
+	}
+
+	s.DryRun = true
+	if removed, err := s.RemoveOrphans(context.Background(), orphans); err != nil || removed != 1 {
+		t.Fatalf("RemoveOrphans() in dry run = %v, %v, want 1, nil", removed, err)
+	}
+	if _, ok := store.objects["Deleted.sav"]; !ok {
+		t.Fatal("dry run removed Deleted.sav")
+	}
+
+	s.DryRun = false
+	if removed, err := s.RemoveOrphans(context.Background(), orphans); err != nil || removed != 1 {
+		t.Fatalf("RemoveOrphans() = %v, %v, want 1, nil", removed, err)
+	}
+	if _, ok := store.objects["Deleted.sav"]; ok {
+		t.Error("Deleted.sav was kept, want removed")
+	}
+	if _, ok := store.objects[tombstoneName("Deleted.sav")]; !ok {
+		t.Error("no tombstone left for Deleted.sav")
+	}
+	for _, key := range []string{"Kept.sav", "Recent.sav", "game.log", "sub/Old.sav"} {
+		if _, ok := store.objects[key]; !ok {
+			t.Errorf("%s was removed, want kept", key)
+		}
+	}
+
+	backups, _ := filepath.Glob(filepath.Join(backupDir, "*", "Deleted.sav"))
+	if len(backups) != 1 {
+		t.Errorf("backups = %v, want 1", backups)
+	}
+}
+
+func TestVerify(t *testing.T) {
+	dir := t.TempDir()
+	store := newFakeStorage()
+	now := time.Now()
+
+	for _, name := range []string{"good.sav", "bad.sav", "broken.sav"} {
+		path := filepath.Join(dir, name)
+		writeFile(t, path, name, now)
+		if err := store.Upload(context.Background(), path, name); err != nil {
+			t.Fatalf("Upload() error = %v", err)
+		}
+	}
+	store.data["bad.sav"] = []byte("bitrot")
+	delete(store.data, "broken.sav")
+	writeFile(t, filepath.Join(dir, "new.sav"), "not uploaded", now)
+
+	s := NewSyncer(store, dir, t.TempDir(), nil, 500*time.Millisecond)
+	s.Concurrency = 2
+
+	results, err := s.Verify(context.Background(), 1)
+	if err != nil {
+		t.Fatalf("Verify() error = %v", err)
+	}
+
+	want := map[string]string{
+		"bad.sav":    VerifyMismatch,
+		"broken.sav": VerifyFailed,
+		"good.sav":   VerifyMatch,
+		"new.sav":    VerifyMissing,
+	}
+	if len(results) != len(want) {
+		t.Fatalf("results = %+v, want %d", results, len(want))
+	}
+	for _, r := range results {
+		if r.Status != want[r.Name] {
+			t.Errorf("%s: status = %s, want %s", r.Name, r.Status, want[r.Name])
+		}
+		if (r.Err != nil) != (r.Status == VerifyFailed) {
+			t.Errorf("%s: err = %v with status %s", r.Name, r.Err, r.Status)
+		}
+	}
+	if sum := sha256.Sum256([]byte("good.sav")); results[2].CloudChecksum != hex.EncodeToString(sum[:]) {
+		t.Errorf("good.sav cloud checksum = %s, want %x", results[2].CloudChecksum, sum)
+	}
+
+	results, err = s.Verify(context.Background(), 0.5)
+	if err != nil {
+		t.Fatalf("Verify() with sample error = %v", err)
+	}
+	if len(results) != 2 {
+		t.Errorf("sampled %d files, want 2 of 4", len(results))
+	}
+}
+
+func TestHardlinkBackups(t *testing.T) {
+	tests := []struct {
+		name       string
+		linkErr    error
+		wantLinked bool
+	}{
+		{
+			name:       "same file system hardlinks",
+			linkErr:    nil,
+			wantLinked: true,
+		},
+		{
+			name:       "cross file system copies",
+			linkErr:    &os.LinkError{Op: "link", Err: errors.New("invalid cross-device link")},
+			wantLinked: false,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if tt.linkErr != nil {
+				orig := linkFile
+				linkFile = func(oldname, newname string) error { return tt.linkErr }
+				defer func() { linkFile = orig }()
+			}
+
+			dir := t.TempDir()
+			backupDir := t.TempDir()
+			path := filepath.Join(dir, "game.sav")
+			writeFile(t, path, "local", time.Now())
+
+			s := NewSyncer(newFakeStorage(), dir, backupDir, nil, 500*time.Millisecond)
+			s.HardlinkBackups = true
+
+			if err := s.createBackup(path); err != nil {
+				t.Fatalf("createBackup() error = %v", err)
+			}
+
+			backups, _ := filepath.Glob(filepath.Join(backupDir, "*", "game.sav"))
+			if len(backups) != 1 {
+				t.Fatalf("backups = %v, want 1", backups)
+			}
+
+			liveInfo, _ := os.Stat(path)
+			backupInfo, _ := os.Stat(backups[0])
+			if linked := os.SameFile(liveInfo, backupInfo); linked != tt.wantLinked {
+				t.Errorf("backup linked = %v, want %v", linked, tt.wantLinked)
+			}
+
+			// Replacing the live file must leave the backup intact either way
+			newPath := filepath.Join(t.TempDir(), "new.sav")
+			writeFile(t, newPath, "cloud", time.Now())
+			if err := replaceFile(newPath, path); err != nil {
+				t.Fatalf("replaceFile() error = %v", err)
+			}
+
+			if got, _ := os.ReadFile(backups[0]); string(got) != "local" {
+				t.Errorf("backup content = %q, want %q", got, "local")
+			}
+			if got, _ := os.ReadFile(path); string(got) != "cloud" {
+				t.Errorf("live content = %q, want %q", got, "cloud")
+			}
+		})
+	}
+}
+
+func TestPauseReasonOnBattery(t *testing.T) {
+	state := power.StateAC
+	var stateErr error
+
+	s := NewSyncer(newFakeStorage(), t.TempDir(), t.TempDir(), nil, 500*time.Millisecond)
+	s.Power = power.ProviderFunc(func() (power.State, error) { return state, stateErr })
+
+	if got := s.PauseReason(); got != "" {
+		t.Errorf("PauseReason() on AC = %q, want none", got)
+	}
+
+	state = power.StateBattery
+	if got := s.PauseReason(); got != PauseOnBattery {
+		t.Errorf("PauseReason() on battery = %q, want %q", got, PauseOnBattery)
+	}
+
+	// Sync resumes once back on AC
+	state = power.StateAC
+	if got := s.PauseReason(); got != "" {
+		t.Errorf("PauseReason() back on AC = %q, want none", got)
+	}
+
+	// An unreadable power state never pauses sync
+	state, stateErr = power.StateUnknown, errors.New("no power supply info")
+	if got := s.PauseReason(); got != "" {
+		t.Errorf("PauseReason() with detector error = %q, want none", got)
+	}
+
+	// Without a provider the battery is ignored
+	s.Power = nil
+	if got := s.PauseReason(); got != "" {
+		t.Errorf("PauseReason() without provider = %q, want none", got)
+	}
+}
+
+func TestMatchesProcess(t *testing.T) {
+	tests := []struct {
+		mode        ProcessMatchMode
+		processName string
+		name        string
+		want        bool
+	}{
+		{ProcessMatchSubstring, "game", "Game.exe", true},
+		{ProcessMatchSubstring, "game", "gameoverlayui.exe", true},
+		{ProcessMatchSubstring, "game", "steam.exe", false},
+		{ProcessMatchExact, "Game.exe", "game.exe", true},
+		{ProcessMatchExact, "Game.exe", "gameoverlayui.exe", false},
+		{ProcessMatchPath, "/opt/game/bin/game", "/opt/game/bin/game", true},
+		{ProcessMatchPath, "/opt/game/bin/game", "/opt/game/bin/../bin/game", true},
+		{ProcessMatchPath, "/opt/game/bin/game", "/opt/other/bin/game", false},
+	}
+
+	for _, tt := range tests {
+		s := NewSyncer(newFakeStorage(), t.TempDir(), t.TempDir(), []string{tt.processName}, 0)
+		s.ProcessMatch = tt.mode
+		if got := s.matchesProcess(tt.name); got != tt.want {
+			t.Errorf("%s match of %q against %q = %v, want %v", tt.mode, tt.name, tt.processName, got, tt.want)
+		}
+	}
+}
+
+func TestMatchesAnyProcess(t *testing.T) {
+	s := NewSyncer(newFakeStorage(), t.TempDir(), t.TempDir(), []string{"Launcher.exe", "Game.exe"}, 0)
+	s.ProcessMatch = ProcessMatchExact
+
+	for _, name := range []string{"launcher.exe", "GAME.EXE"} {
+		if !s.matchesProcess(name) {
+			t.Errorf("matchesProcess(%q) = false, want true", name)
+		}
+	}
+	if s.matchesProcess("steam.exe") {
+		t.Error("matchesProcess(steam.exe) = true, want false")
+	}
+
+	s = NewSyncer(newFakeStorage(), t.TempDir(), t.TempDir(), nil, 0)
+	if s.matchesProcess("Game.exe") || s.IsProcessRunning() {
+		t.Error("a Syncer without process names matched a process")
+	}
+}
+
+func TestProcessCacheTTL(t *testing.T) {
+	s := NewSyncer(newFakeStorage(), t.TempDir(), t.TempDir(), []string{"Game.exe"}, 0)
+	s.ProcessCacheTTL = 50 * time.Millisecond
+
+	running, scans := true, 0
+	s.scanProcesses = func() bool {
+		scans++
+		return running
+	}
+
+	// A burst of checks lists the processes once
+	for range 5 {
+		if !s.IsProcessRunning() {
+			t.Fatal("IsProcessRunning() = false, want true")
+		}
+	}
+	if scans != 1 {
+		t.Errorf("scans = %d, want 1", scans)
+	}
+
+	// The game exits: noticed once the cached scan expires
+	running = false
+	if !s.IsProcessRunning() {
+		t.Error("IsProcessRunning() = false within the TTL, want the cached true")
+	}
+	time.Sleep(60 * time.Millisecond)
+	if s.IsProcessRunning() {
+		t.Error("IsProcessRunning() = true after the TTL, want false")
+	}
+	if scans != 2 {
+		t.Errorf("scans = %d, want 2", scans)
+	}
+
+	// Without a TTL every check scans
+	s.ProcessCacheTTL = 0
+	s.IsProcessRunning()
+	s.IsProcessRunning()
+	if scans != 4 {
+		t.Errorf("scans = %d without a TTL, want 4", scans)
+	}
+}
+
+func TestChecksumMode(t *testing.T) {
+	base := time.Now().Add(-time.Hour).Truncate(time.Second)
+	sha := func(s string) string {
+		sum := sha256.Sum256([]byte(s))
+		return hex.EncodeToString(sum[:])
+	}
+	md := func(s string) string {
+		sum := md5.Sum([]byte(s))
+		return `"` + hex.EncodeToString(sum[:]) + `"`
+	}
+
+	tests := []struct {
+		name        string
+		local       string
+		localTime   time.Time
+		cloud       *SyncFileInfo
+		wantUploads int
+		wantContent string
+	}{
+		{
+			name:        "same content, local touched later",
+			local:       "save",
+			localTime:   base.Add(time.Minute),
+			cloud:       &SyncFileInfo{ModTime: base, Checksum: sha("save")},
+			wantUploads: 0,
+			wantContent: "save",
+		},
+		{
+			name:        "same content, cloud touched later",
+			local:       "save",
+			localTime:   base,
+			cloud:       &SyncFileInfo{ModTime: base.Add(time.Minute), Checksum: sha("save")},
+			wantUploads: 0,
+			wantContent: "save",
+		},
+		{
+			name:        "different content, identical mod times",
+			local:       "edited",
+			localTime:   base,
+			cloud:       &SyncFileInfo{ModTime: base, Checksum: sha("save")},
+			wantUploads: 1,
+			wantContent: "edited",
+		},
+		{
+			name:        "same content by plain ETag",
+			local:       "save",
+			localTime:   base.Add(time.Minute),
+			cloud:       &SyncFileInfo{ModTime: base, ETag: md("save")},
+			wantUploads: 0,
+			wantContent: "save",
+		},
+		{
+			name:        "multipart ETag falls back to mod times",
+			local:       "save",
+			localTime:   base.Add(time.Minute),
+			cloud:       &SyncFileInfo{ModTime: base, ETag: `"0123456789abcdef0123456789abcdef-2"`},
+			wantUploads: 1,
+			wantContent: "save",
+		},
+		{
+			name:        "no hash falls back to mod times",
+			local:       "save",
+			localTime:   base,
+			cloud:       &SyncFileInfo{ModTime: base.Add(time.Minute)},
+			wantUploads: 0,
+			wantContent: "cloud",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := t.TempDir()
+			path := filepath.Join(dir, "game.sav")
+			writeFile(t, path, tt.local, tt.localTime)
+
+			store := newFakeStorage()
+			tt.cloud.Name = "game.sav"
+			tt.cloud.Size = int64(len("cloud"))
+			store.objects["game.sav"] = tt.cloud
+			store.data["game.sav"] = []byte("cloud")
+
+			s := NewSyncer(store, dir, t.TempDir(), nil, 500*time.Millisecond)
+			s.ChecksumMode = true
+
+			if err := s.SyncFile(context.Background(), path); err != nil {
+				t.Fatalf("SyncFile() error = %v", err)
+			}
+
+			if store.uploads != tt.wantUploads {
+				t.Errorf("uploads = %v, want %v", store.uploads, tt.wantUploads)
+			}
+			if got, _ := os.ReadFile(path); string(got) != tt.wantContent {
+				t.Errorf("local content = %q, want %q", got, tt.wantContent)
+			}
+		})
+	}
+}
+
+func TestSizeGuards(t *testing.T) {
+	base := time.Now().Add(-time.Hour).Truncate(time.Second)
+	tests := []struct {
+		name        string
+		local       string
+		localTime   time.Time
+		cloud       string
+		cloudTime   time.Time // zero for no cloud object
+		minSize     int64
+		ratio       float64
+		wantUploads int
+		wantContent string
+	}{
+		{name: "empty file not uploaded", local: "", localTime: base, minSize: 1, wantContent: ""},
+		{name: "empty file doesn't replace cloud copy", local: "", localTime: base.Add(time.Minute), cloud: "good save", cloudTime: base, minSize: 1, wantContent: ""},
+		{name: "empty file uploaded with guard off", local: "", localTime: base, minSize: 0, wantUploads: 1, wantContent: ""},
+		{name: "small file below minimum", local: "tiny", localTime: base, minSize: 10, wantContent: "tiny"},
+		{name: "empty cloud copy doesn't replace save", local: "good save", localTime: base, cloud: "", cloudTime: base.Add(time.Minute), minSize: 1, wantContent: "good save"},
+		{name: "much smaller cloud copy kept out by ratio", local: "a long and healthy save", localTime: base, cloud: "torn", cloudTime: base.Add(time.Minute), minSize: 1, ratio: 0.5, wantContent: "a long and healthy save"},
+		{name: "slightly smaller cloud copy passes ratio", local: "healthy save", localTime: base, cloud: "healthy sav", cloudTime: base.Add(time.Minute), minSize: 1, ratio: 0.5, wantContent: "healthy sav"},
+		{name: "smaller cloud copy without ratio", local: "a long and healthy save", localTime: base, cloud: "torn", cloudTime: base.Add(time.Minute), minSize: 1, wantContent: "torn"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := t.TempDir()
+			path := filepath.Join(dir, "game.sav")
+			writeFile(t, path, tt.local, tt.localTime)
+
+			store := newFakeStorage()
+			if !tt.cloudTime.IsZero() {
+				store.objects["game.sav"] = &SyncFileInfo{Name: "game.sav", ModTime: tt.cloudTime, Size: int64(len(tt.cloud))}
+				store.data["game.sav"] = []byte(tt.cloud)
+			}
+
+			s := NewSyncer(store, dir, t.TempDir(), nil, 500*time.Millisecond)
+			s.MinFileSize = tt.minSize
+			s.MinSizeRatio = tt.ratio
+
+			if err := s.SyncFile(context.Background(), path); err != nil {
+				t.Fatalf("SyncFile() error = %v", err)
+			}
+
+			if store.uploads != tt.wantUploads {
+				t.Errorf("uploads = %v, want %v", store.uploads, tt.wantUploads)
+			}
+			if got, _ := os.ReadFile(path); string(got) != tt.wantContent {
+				t.Errorf("local content = %q, want %q", got, tt.wantContent)
+			}
+			if !tt.cloudTime.IsZero() && tt.wantUploads == 0 {
+				if got := string(store.data["game.sav"]); got != tt.cloud {
+					t.Errorf("cloud content = %q, want unchanged %q", got, tt.cloud)
+				}
+			}
+		})
+	}
+}
+
+func TestRecursiveSync(t *testing.T) {
+	dir := t.TempDir()
+	backupDir := filepath.Join(dir, "Backup")
+	modTime := time.Now().Add(-time.Hour).Truncate(time.Second)
+
+	for _, sub := range []string{"Profile1", "Backup/old"} {
+		if err := os.MkdirAll(filepath.Join(dir, sub), 0755); err != nil {
+			t.Fatalf("MkdirAll() error = %v", err)
+		}
+	}
+	writeFile(t, filepath.Join(dir, "root.sav"), "root", modTime)
+	writeFile(t, filepath.Join(dir, "Profile1", "game.sav"), "profile1", modTime)
+	writeFile(t, filepath.Join(dir, "Backup", "old", "game.sav"), "backup", modTime)
+
+	store := newFakeStorage()
+	for key, content := range map[string]string{
+		"Profile2/game.sav":  "profile2",
+		"../escape.sav":      "evil",
+		"Profile2/../x.sav":  "evil",
+		"/abs/game.sav":      "evil",
+		"Profile2//game.sav": "evil",
+	} {
+		store.objects[key] = &SyncFileInfo{Name: key, ModTime: modTime, Size: int64(len(content))}
+		store.data[key] = []byte(content)
+	}
+
+	s := NewSyncer(store, dir, backupDir, nil, 500*time.Millisecond)
+	s.Recursive = true
+
+	if _, err := s.InitialSync(context.Background()); err != nil {
+		t.Fatalf("InitialSync() error = %v", err)
+	}
+
+	// Uploads keep the relative path and leave the backup dir alone
+	for _, key := range []string{"root.sav", "Profile1/game.sav"} {
+		if _, ok := store.objects[key]; !ok {
+			t.Errorf("%s was not uploaded", key)
+		}
+	}
+	for key := range store.objects {
+		if strings.HasPrefix(key, "Backup/") {
+			t.Errorf("backup file %s was uploaded", key)
+		}
+	}
+
+	// Nested cloud objects land in their folder
+	if got, _ := os.ReadFile(filepath.Join(dir, "Profile2", "game.sav")); string(got) != "profile2" {
+		t.Errorf("Profile2/game.sav content = %q, want %q", got, "profile2")
+	}
+
+	// Keys that would escape the watch path are never written
+	if _, err := os.Stat(filepath.Join(filepath.Dir(dir), "escape.sav")); !os.IsNotExist(err) {
+		t.Error("../escape.sav was written outside the watch path")
+	}
+	if _, err := os.Stat(filepath.Join(dir, "x.sav")); !os.IsNotExist(err) {
+		t.Error("Profile2/../x.sav was written")
+	}
+}
+
+func TestSyncEmptyDirs(t *testing.T) {
+	dir := t.TempDir()
+	backupDir := filepath.Join(dir, "Backup")
+	for _, sub := range []string{"Empty/Slot2", "Notes", "Profile1", "Backup/old"} {
+		if err := os.MkdirAll(filepath.Join(dir, sub), 0755); err != nil {
+			t.Fatalf("MkdirAll() error = %v", err)
+		}
+	}
+	writeFile(t, filepath.Join(dir, "Profile1", "game.sav"), "profile1", time.Now())
+	writeFile(t, filepath.Join(dir, "Notes", "readme.txt"), "not a save", time.Now())
+
+	store := newFakeStorage()
+	s := NewSyncer(store, dir, backupDir, nil, 500*time.Millisecond)
+	s.Recursive = true
+	s.SyncEmptyDirs = true
+
+	if _, err := s.InitialSync(context.Background()); err != nil {
+		t.Fatalf("InitialSync() error = %v", err)
+	}
+
+	// Only the leaves without saves get a marker
+	var keys []string
+	for key := range store.objects {
+		keys = append(keys, key)
+	}
+	slices.Sort(keys)
+	want := []string{"Empty/Slot2/" + dirMarkerName, "Notes/" + dirMarkerName, "Profile1/game.sav"}
+	if !slices.Equal(keys, want) {
+		t.Fatalf("objects = %v, want %v", keys, want)
+	}
+
+	uploads := store.uploads
+	if err := s.FullSync(context.Background()); err != nil {
+		t.Fatalf("FullSync() error = %v", err)
+	}
+	if store.uploads != uploads {
+		t.Errorf("second sync uploaded %d objects, want none", store.uploads-uploads)
+	}
+
+	// Another machine gets the folders, without marker files
+	other := t.TempDir()
+	s2 := NewSyncer(store, other, t.TempDir(), nil, 500*time.Millisecond)
+	s2.Recursive = true
+	s2.SyncEmptyDirs = true
+	if _, err := s2.InitialSync(context.Background()); err != nil {
+		t.Fatalf("InitialSync() on the other machine error = %v", err)
+	}
+	for _, sub := range []string{"Empty/Slot2", "Notes", "Profile1"} {
+		if !dirExists(filepath.Join(other, sub)) {
+			t.Errorf("%s was not created", sub)
+		}
+	}
+	markers, _ := filepath.Glob(filepath.Join(other, "*", "*", dirMarkerName))
+	more, _ := filepath.Glob(filepath.Join(other, "*", dirMarkerName))
+	if len(markers)+len(more) != 0 {
+		t.Errorf("markers written as files: %v %v", markers, more)
+	}
+
+	// Without the option markers are neither uploaded nor downloaded
+	s3 := NewSyncer(store, t.TempDir(), t.TempDir(), nil, 500*time.Millisecond)
+	s3.Recursive = true
+	plan, err := s3.Plan(context.Background())
+	if err != nil {
+		t.Fatalf("Plan() error = %v", err)
+	}
+	if len(plan.Items) != 1 || plan.Items[0].Name != "Profile1/game.sav" {
+		t.Errorf("plan = %+v, want only Profile1/game.sav", plan.Items)
+	}
+}
+
+func TestNonRecursiveSkipsNestedKeys(t *testing.T) {
+	dir := t.TempDir()
+
+	store := newFakeStorage()
+	store.objects["Profile2/game.sav"] = &SyncFileInfo{Name: "Profile2/game.sav", ModTime: time.Now(), Size: 6}
+	store.data["Profile2/game.sav"] = []byte("nested")
+
+	s := NewSyncer(store, dir, t.TempDir(), nil, 500*time.Millisecond)
+	if _, err := s.InitialSync(context.Background()); err != nil {
+		t.Fatalf("InitialSync() error = %v", err)
+	}
+
+	if _, err := os.Stat(filepath.Join(dir, "Profile2")); !os.IsNotExist(err) {
+		t.Error("nested object was downloaded with recursive sync off")
+	}
+	if _, err := os.Stat(filepath.Join(dir, "game.sav")); !os.IsNotExist(err) {
+		t.Error("nested object was flattened into the watch path")
+	}
+}
+
+func TestPlan(t *testing.T) {
+	dir := t.TempDir()
+	store := newFakeStorage()
+	now := time.Now().Truncate(time.Second)
+
+	src := t.TempDir()
+	for name, modTime := range map[string]time.Time{
+		"cloud-only.sav":  now,
+		"cloud-newer.sav": now,
+		"local-newer.sav": now.Add(-time.Hour),
+		"in-sync.sav":     now,
+	} {
+		writeFile(t, filepath.Join(src, name), "cloud", modTime)
+		if err := store.Upload(context.Background(), filepath.Join(src, name), name); err != nil {
+			t.Fatalf("Upload() error = %v", err)
+		}
+	}
+	writeFile(t, filepath.Join(dir, "local-only.sav"), "local", now)
+	writeFile(t, filepath.Join(dir, "cloud-newer.sav"), "local", now.Add(-time.Hour))
+	writeFile(t, filepath.Join(dir, "local-newer.sav"), "local", now)
+	writeFile(t, filepath.Join(dir, "in-sync.sav"), "cloud", now)
+
+	s := NewSyncer(store, dir, t.TempDir(), nil, 500*time.Millisecond)
+	plan, err := s.Plan(context.Background())
+	if err != nil {
+		t.Fatalf("Plan() error = %v", err)
+	}
+
+	want := map[string]PlanAction{
+		"cloud-newer.sav": PlanDownload,
+		"cloud-only.sav":  PlanDownload,
+		"in-sync.sav":     PlanSkip,
+		"local-newer.sav": PlanUpload,
+		"local-only.sav":  PlanUpload,
+	}
+	if len(plan.Items) != len(want) {
+		t.Fatalf("plan = %+v, want %d items", plan.Items, len(want))
+	}
+	for _, item := range plan.Items {
+		if item.Action != want[item.Name] {
+			t.Errorf("%s: Action = %v (%s), want %v", item.Name, item.Action, item.Reason, want[item.Name])
+		}
+	}
+	if n := plan.Count(PlanDownload); n != 2 {
+		t.Errorf("Count(PlanDownload) = %d, want 2", n)
+	}
+
+	// Planning transfers nothing
+	if store.uploads != 4 {
+		t.Errorf("uploads = %d, want only the 4 fixtures", store.uploads)
+	}
+	if fileExists(filepath.Join(dir, "cloud-only.sav")) {
+		t.Error("Plan() downloaded cloud-only.sav")
+	}
+}
+
+func TestNameCollisions(t *testing.T) {
+	ctx := context.Background()
+	dir := t.TempDir()
+	store := newFakeStorage()
+	now := time.Now().Truncate(time.Second)
+
+	// Two local files, and a local file and a cloud object, whose names
+	// differ only in case
+	writeFile(t, filepath.Join(dir, "Slot1.sav"), "one", now)
+	writeFile(t, filepath.Join(dir, "slot1.sav"), "two", now)
+	if entries, _ := os.ReadDir(dir); len(entries) != 2 {
+		t.Skip("the file system ignores case")
+	}
+	writeFile(t, filepath.Join(dir, "profile.sav"), "local", now)
+	src := filepath.Join(t.TempDir(), "Profile.sav")
+	writeFile(t, src, "cloud", now)
+	if err := store.Upload(ctx, src, "Profile.sav"); err != nil {
+		t.Fatalf("Upload() error = %v", err)
+	}
+	writeFile(t, filepath.Join(dir, "other.sav"), "local", now)
+
+	s := NewSyncer(store, dir, t.TempDir(), nil, 500*time.Millisecond)
+	plan, err := s.Plan(ctx)
+	if err != nil {
+		t.Fatalf("Plan() error = %v", err)
+	}
+	for _, item := range plan.Items {
+		collides := item.Name != "other.sav"
+		if collides && (item.Action != PlanSkip || !strings.Contains(item.Reason, ErrNameCollision.Error())) {
+			t.Errorf("%s: Action = %v (%s), want a skip for the collision", item.Name, item.Action, item.Reason)
+		}
+		if !collides && item.Action != PlanUpload {
+			t.Errorf("%s: Action = %v (%s), want %v", item.Name, item.Action, item.Reason, PlanUpload)
+		}
+	}
+
+	err = s.SyncFile(ctx, filepath.Join(dir, "slot1.sav"))
+	if !errors.Is(err, ErrNameCollision) {
+		t.Errorf("SyncFile() error = %v, want ErrNameCollision", err)
+	}
+	if _, ok := store.objects["slot1.sav"]; ok {
+		t.Error("SyncFile() uploaded a colliding file")
+	}
+}
+
+func TestDirection(t *testing.T) {
+	tests := []struct {
+		direction SyncDirection
+		uploaded  []string // of the fixtures, besides the cloud-side ones
+		local     map[string]string
+	}{
+		{
+			direction: DirectionBidirectional,
+			uploaded:  []string{"local-newer.sav", "local-only.sav"},
+			local:     map[string]string{"cloud-newer.sav": "cloud", "cloud-only.sav": "cloud", "local-newer.sav": "local"},
+		},
+		{
+			direction: DirectionDownloadOnly,
+			local:     map[string]string{"cloud-newer.sav": "cloud", "cloud-only.sav": "cloud", "local-newer.sav": "local"},
+		},
+		{
+			direction: DirectionUploadOnly,
+			uploaded:  []string{"local-newer.sav", "local-only.sav"},
+			local:     map[string]string{"cloud-newer.sav": "local", "local-newer.sav": "local"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(string(tt.direction), func(t *testing.T) {
+			ctx := context.Background()
+			dir := t.TempDir()
+			backupDir := t.TempDir()
+			store := newFakeStorage()
+			now := time.Now().Truncate(time.Second)
+
+			src := t.TempDir()
+			for name, modTime := range map[string]time.Time{
+				"cloud-only.sav":  now,
+				"cloud-newer.sav": now,
+				"local-newer.sav": now.Add(-time.Hour),
+			} {
+				writeFile(t, filepath.Join(src, name), "cloud", modTime)
+				if err := store.Upload(ctx, filepath.Join(src, name), name); err != nil {
+					t.Fatalf("Upload() error = %v", err)
+				}
+			}
+			store.uploadOrder = nil
+			writeFile(t, filepath.Join(dir, "local-only.sav"), "local", now)
+			writeFile(t, filepath.Join(dir, "cloud-newer.sav"), "local", now.Add(-time.Hour))
+			writeFile(t, filepath.Join(dir, "local-newer.sav"), "local", now)
+
+			s := NewSyncer(store, dir, backupDir, nil, 500*time.Millisecond)
+			s.Direction = tt.direction
+			if _, err := s.InitialSync(ctx); err != nil {
+				t.Fatalf("InitialSync() error = %v", err)
+			}
+
+			got := append([]string(nil), store.uploadOrder...)
+			slices.Sort(got)
+			if !slices.Equal(got, tt.uploaded) {
+				t.Errorf("uploaded %v, want %v", got, tt.uploaded)
+			}
+			for name, want := range tt.local {
+				if content, _ := os.ReadFile(filepath.Join(dir, name)); string(content) != want {
+					t.Errorf("%s = %q, want %q", name, content, want)
+				}
+			}
+			if tt.direction == DirectionUploadOnly && fileExists(filepath.Join(dir, "cloud-only.sav")) {
+				t.Error("cloud-only.sav downloaded in upload-only direction")
+			}
+
+			// Whichever way a save moved, its old local copy was backed up
+			backups, _ := filepath.Glob(filepath.Join(backupDir, "*", "*.sav"))
+			if len(backups) == 0 {
+				t.Error("no backups taken")
+			}
+
+			// A local change alone doesn't upload in download-only direction
+			writeFile(t, filepath.Join(dir, "local-newer.sav"), "changed", now.Add(time.Hour))
+			before := len(store.uploadOrder)
+			if err := s.SyncFile(ctx, filepath.Join(dir, "local-newer.sav")); err != nil {
+				t.Fatalf("SyncFile() error = %v", err)
+			}
+			if uploaded := len(store.uploadOrder) > before; uploaded != (tt.direction != DirectionDownloadOnly) {
+				t.Errorf("SyncFile() uploaded = %v", uploaded)
+			}
+		})
+	}
+}
+
+func TestInitialSyncSummary(t *testing.T) {
+	dir := t.TempDir()
+	store := newFakeStorage()
+	now := time.Now().Truncate(time.Second)
+
+	src := t.TempDir()
+	for name, content := range map[string]string{"cloud.sav": "cloud!", "same.sav": "same"} {
+		writeFile(t, filepath.Join(src, name), content, now)
+		if err := store.Upload(context.Background(), filepath.Join(src, name), name); err != nil {
+			t.Fatalf("Upload() error = %v", err)
+		}
+	}
+	writeFile(t, filepath.Join(dir, "same.sav"), "same", now)
+	writeFile(t, filepath.Join(dir, "local.sav"), "local", now)
+
+	s := NewSyncer(store, dir, t.TempDir(), nil, 500*time.Millisecond)
+	summary, err := s.InitialSync(context.Background())
+	if err != nil {
+		t.Fatalf("InitialSync() error = %v", err)
+	}
+
+	want := SyncSummary{Uploaded: 1, Downloaded: 1, Skipped: 1, BytesUp: 5, BytesDown: 6}
+	summary.Elapsed = 0
+	if *summary != want {
+		t.Errorf("summary = %+v, want %+v", *summary, want)
+	}
+}
+
+func TestDryRun(t *testing.T) {
+	dir := t.TempDir()
+	backupDir := filepath.Join(t.TempDir(), "Backup")
+	base := time.Now().Add(-time.Hour).Truncate(time.Second)
+
+	writeFile(t, filepath.Join(dir, "local-newer.sav"), "local", base.Add(time.Minute))
+	writeFile(t, filepath.Join(dir, "cloud-newer.sav"), "stale", base)
+	writeFile(t, filepath.Join(dir, "local-only.sav"), "new", base)
+
+	store := newFakeStorage()
+	for key, obj := range map[string]struct {
+		content string
+		modTime time.Time
+	}{
+		"local-newer.sav": {"old", base},
+		"cloud-newer.sav": {"fresh", base.Add(time.Minute)},
+		"cloud-only.sav":  {"cloud", base},
+	} {
+		store.objects[key] = &SyncFileInfo{Name: key, ModTime: obj.modTime, Size: int64(len(obj.content))}
+		store.data[key] = []byte(obj.content)
+	}
+
+	s := NewSyncer(store, dir, backupDir, nil, 500*time.Millisecond)
+	s.DryRun = true
+
+	if _, err := s.InitialSync(context.Background()); err != nil {
+		t.Fatalf("InitialSync() error = %v", err)
+	}
+	if err := s.SyncFile(context.Background(), filepath.Join(dir, "local-newer.sav")); err != nil {
+		t.Fatalf("SyncFile() error = %v", err)
+	}
+
+	if store.uploads != 0 {
+		t.Errorf("uploads = %d, want 0", store.uploads)
+	}
+	if _, ok := store.objects["local-only.sav"]; ok {
+		t.Error("local-only.sav was uploaded")
+	}
+
+	for name, want := range map[string]string{
+		"local-newer.sav": "local",
+		"cloud-newer.sav": "stale",
+		"local-only.sav":  "new",
+	} {
+		got, err := os.ReadFile(filepath.Join(dir, name))
+		if err != nil {
+			t.Fatalf("ReadFile(%s) error = %v", name, err)
+		}
+		if string(got) != want {
+			t.Errorf("%s = %q, want %q", name, got, want)
+		}
+	}
+	if _, err := os.Stat(filepath.Join(dir, "cloud-only.sav")); !os.IsNotExist(err) {
+		t.Error("cloud-only.sav was downloaded")
+	}
+	if _, err := os.Stat(backupDir); !os.IsNotExist(err) {
+		t.Error("backup dir was created")
+	}
+}
+
+func TestPruneBackups(t *testing.T) {
+	now := time.Now()
+	ages := []time.Duration{time.Minute, time.Hour, 48 * time.Hour, 30 * 24 * time.Hour}
+
+	tests := []struct {
+		name         string
+		maxBackups   int
+		maxBackupAge time.Duration
+		wantKept     int
+	}{
+		{name: "no limits", wantKept: 4},
+		{name: "max count", maxBackups: 2, wantKept: 2},
+		{name: "max age", maxBackupAge: 24 * time.Hour, wantKept: 2},
+		{name: "both limits apply", maxBackups: 1, maxBackupAge: 24 * time.Hour, wantKept: 1},
+		{name: "count above existing", maxBackups: 10, wantKept: 4},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			backupDir := t.TempDir()
+			var folders []string
+			for _, age := range ages {
+				name := now.Add(-age).Format(backupTimeLayout)
+				folders = append(folders, name)
+				if err := os.Mkdir(filepath.Join(backupDir, name), 0755); err != nil {
+					t.Fatalf("Mkdir() error = %v", err)
+				}
+				writeFile(t, filepath.Join(backupDir, name, "game.sav"), "backup", now)
+			}
+			if err := os.Mkdir(filepath.Join(backupDir, "LatestGood"), 0755); err != nil {
+				t.Fatalf("Mkdir() error = %v", err)
+			}
+			writeFile(t, filepath.Join(backupDir, "LatestGood", "game.sav"), "good", now)
+			writeFile(t, filepath.Join(backupDir, "old_sync.log"), "log", now)
+
+			s := NewSyncer(newFakeStorage(), t.TempDir(), backupDir, nil, 500*time.Millisecond)
+			s.MaxBackups = tt.maxBackups
+			s.MaxBackupAge = tt.maxBackupAge
+
+			if err := s.PruneBackups(); err != nil {
+				t.Fatalf("PruneBackups() error = %v", err)
+			}
+
+			// The newest folders survive
+			for i, name := range folders {
+				_, err := os.Stat(filepath.Join(backupDir, name))
+				if kept := err == nil; kept != (i < tt.wantKept) {
+					t.Errorf("%s kept = %v, want %v", name, kept, i < tt.wantKept)
+				}
+			}
+			for _, name := range []string{"LatestGood", "old_sync.log"} {
+				if _, err := os.Stat(filepath.Join(backupDir, name)); err != nil {
+					t.Errorf("%s was removed: %v", name, err)
+				}
+			}
+		})
+	}
+}
+
+func TestCreateBackupPrunes(t *testing.T) {
+	dir := t.TempDir()
+	backupDir := t.TempDir()
+	path := filepath.Join(dir, "game.sav")
+	writeFile(t, path, "local", time.Now())
+
+	s := NewSyncer(newFakeStorage(), dir, backupDir, nil, 500*time.Millisecond)
+	s.MaxBackups = 2
+
+	for i := 0; i < 4; i++ {
+		if err := s.createBackup(path); err != nil {
+			t.Fatalf("createBackup() error = %v", err)
+		}
+	}
+
+	backups, _ := filepath.Glob(filepath.Join(backupDir, "*", "game.sav"))
+	if len(backups) != 2 {
+		t.Errorf("backups = %v, want 2", backups)
+	}
+}
+
+func TestBackupDirCollision(t *testing.T) {
+	fixed := time.Now()
+	backupClock = func() time.Time { return fixed }
+	t.Cleanup(func() { backupClock = time.Now })
+
+	dir := t.TempDir()
+	backupDir := t.TempDir()
+	path := filepath.Join(dir, "game.sav")
+
+	s := NewSyncer(newFakeStorage(), dir, backupDir, nil, 500*time.Millisecond)
+	s.MaxBackups = 2
+
+	for _, content := range []string{"v1", "v2", "v3"} {
+		writeFile(t, path, content, time.Now())
+		if err := s.createBackup(path); err != nil {
+			t.Fatalf("createBackup(%s) error = %v", content, err)
+		}
+	}
+
+	backups, err := s.Backups()
+	if err != nil {
+		t.Fatalf("Backups() error = %v", err)
+	}
+	base := fixed.Format(backupTimeLayout)
+	var names []string
+	for _, b := range backups {
+		names = append(names, b.Name)
+	}
+	if want := []string{base + "-2", base + "-1"}; !slices.Equal(names, want) {
+		t.Fatalf("backups = %v, want %v (newest first, oldest pruned)", names, want)
+	}
+
+	for name, want := range map[string]string{base + "-1": "v2", base + "-2": "v3"} {
+		got, err := os.ReadFile(filepath.Join(backupDir, name, "game.sav"))
+		if err != nil || string(got) != want {
+			t.Errorf("%s holds %q, %v, want %q", name, got, err, want)
+		}
+	}
+}
+
+func TestBackupWindow(t *testing.T) {
+	dir := t.TempDir()
+	backupDir := t.TempDir()
+	game := filepath.Join(dir, "game.sav")
+	other := filepath.Join(dir, "other.sav")
+	writeFile(t, game, "v1", time.Now())
+	writeFile(t, other, "other", time.Now())
+
+	s := NewSyncer(newFakeStorage(), dir, backupDir, nil, 500*time.Millisecond)
+	s.BackupWindow = time.Hour
+
+	backup := func(path string) {
+		t.Helper()
+		if err := s.createBackup(path); err != nil {
+			t.Fatalf("createBackup(%s) error = %v", path, err)
+		}
+	}
+
+	backup(game)
+	backup(game)  // same content: skipped
+	backup(other) // same burst: shares the folder
+	writeFile(t, game, "v2", time.Now())
+	backup(game) // the folder already holds v1: a new one
+
+	folders, _ := filepath.Glob(filepath.Join(backupDir, "*"))
+	if len(folders) != 2 {
+		t.Fatalf("backup folders = %v, want 2", folders)
+	}
+	if files, _ := filepath.Glob(filepath.Join(folders[0], "*")); len(files) != 2 {
+		t.Errorf("first folder holds %v, want game.sav and other.sav", files)
+	}
+	if got, _ := os.ReadFile(filepath.Join(folders[1], "game.sav")); string(got) != "v2" {
+		t.Errorf("second folder game.sav = %q, want v2", got)
+	}
+
+	// Past the window, the same content is backed up again
+	s.lastBackups[game] = backupRecord{hash: s.lastBackups[game].hash, at: time.Now().Add(-2 * time.Hour)}
+	s.burstStart = time.Now().Add(-2 * time.Hour)
+	backup(game)
+	if folders, _ := filepath.Glob(filepath.Join(backupDir, "*")); len(folders) != 3 {
+		t.Errorf("backup folders = %v, want 3 after the window", folders)
+	}
+}
+
+func TestRestoreBackup(t *testing.T) {
+	dir := t.TempDir()
+	backupDir := t.TempDir()
+	path := filepath.Join(dir, "game.sav")
+	now := time.Now()
+
+	older := now.Add(-2 * time.Hour).Format(backupTimeLayout)
+	newer := now.Add(-time.Hour).Format(backupTimeLayout)
+	for name, content := range map[string]string{older: "older save", newer: "newer save"} {
+		if err := os.Mkdir(filepath.Join(backupDir, name), 0755); err != nil {
+			t.Fatalf("Mkdir() error = %v", err)
+		}
+		writeFile(t, filepath.Join(backupDir, name, "game.sav"), content, now.Add(-3*time.Hour))
+		writeFile(t, filepath.Join(backupDir, name, "notes.txt"), "not a save", now)
+	}
+	if err := os.Mkdir(filepath.Join(backupDir, "LatestGood"), 0755); err != nil {
+		t.Fatalf("Mkdir() error = %v", err)
+	}
+	writeFile(t, path, "live save", now.Add(-time.Minute))
+
+	s := NewSyncer(newFakeStorage(), dir, backupDir, nil, 500*time.Millisecond)
+
+	backups, err := s.Backups()
+	if err != nil {
+		t.Fatalf("Backups() error = %v", err)
+	}
+	if len(backups) != 2 || backups[0].Name != newer || backups[1].Name != older {
+		t.Fatalf("Backups() = %+v, want [%s %s]", backups, newer, older)
+	}
+	if len(backups[0].Files) != 1 || backups[0].Files[0] != "game.sav" {
+		t.Errorf("Backups()[0].Files = %v, want [game.sav]", backups[0].Files)
+	}
+
+	if _, err := s.RestoreBackup("2000-01-01_00-00-00"); err == nil {
+		t.Error("RestoreBackup(unknown) error = nil, want error")
+	}
+
+	// A dry run changes nothing
+	s.DryRun = true
+	if _, err := s.RestoreBackup(LatestBackup); err != nil {
+		t.Fatalf("RestoreBackup() dry run error = %v", err)
+	}
+	if got, _ := os.ReadFile(path); string(got) != "live save" {
+		t.Errorf("live file = %q after dry run, want %q", got, "live save")
+	}
+	if after, _ := s.Backups(); len(after) != 2 {
+		t.Errorf("dry run created a backup: %d folders, want 2", len(after))
+	}
+	s.DryRun = false
+
+	before := time.Now().Add(-time.Second)
+	restored, err := s.RestoreBackup(LatestBackup)
+	if err != nil {
+		t.Fatalf("RestoreBackup() error = %v", err)
+	}
+	if len(restored) != 1 || restored[0] != "game.sav" {
+		t.Errorf("RestoreBackup() = %v, want [game.sav]", restored)
+	}
+	if got, _ := os.ReadFile(path); string(got) != "newer save" {
+		t.Errorf("live file = %q after restore, want %q", got, "newer save")
+	}
+
+	// The restored file must win the next mod time comparison
+	info, err := os.Stat(path)
+	if err != nil {
+		t.Fatalf("Stat() error = %v", err)
+	}
+	if info.ModTime().Before(before) {
+		t.Errorf("restored ModTime = %v, want fresh", info.ModTime())
+	}
+
+	// The replaced live file is kept in a new timestamped backup
+	after, err := s.Backups()
+	if err != nil {
+		t.Fatalf("Backups() error = %v", err)
+	}
+	if len(after) != 3 {
+		t.Fatalf("Backups() after restore = %d folders, want 3", len(after))
+	}
+	got, _ := os.ReadFile(filepath.Join(backupDir, after[0].Name, "game.sav"))
+	if string(got) != "live save" {
+		t.Errorf("pre-restore backup = %q, want %q", got, "live save")
+	}
+}
+
+func TestConflictStrategy(t *testing.T) {
+	base := time.Now().Add(-3 * time.Hour).Truncate(time.Second)
+
+	tests := []struct {
+		name         string
+		strategy     ConflictStrategy
+		cloudChanged bool
+		wantLocal    string
+		wantCloud    string
+		wantConflict bool // a .conflict- copy holding the cloud edit
+	}{
+		{name: "newer wins", strategy: ConflictNewerWins, cloudChanged: true, wantLocal: "local edit", wantCloud: "local edit"},
+		{name: "local wins", strategy: ConflictLocalWins, cloudChanged: true, wantLocal: "local edit", wantCloud: "local edit"},
+		{name: "cloud wins", strategy: ConflictCloudWins, cloudChanged: true, wantLocal: "cloud edit", wantCloud: "cloud edit"},
+		{name: "keep both", strategy: ConflictKeepBoth, cloudChanged: true, wantLocal: "local edit", wantCloud: "local edit", wantConflict: true},
+		{name: "only local changed", strategy: ConflictCloudWins, wantLocal: "local edit", wantCloud: "local edit"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := t.TempDir()
+			backupDir := t.TempDir()
+			path := filepath.Join(dir, "game.sav")
+
+			store := newFakeStorage()
+			s := NewSyncer(store, dir, backupDir, nil, 500*time.Millisecond)
+			s.StateFile = filepath.Join(backupDir, "sync-state.json")
+			s.ConflictStrategy = tt.strategy
+
+			// The first sync records the baseline
+			writeFile(t, path, "baseline", base)
+			if err := s.SyncFile(context.Background(), path); err != nil {
+				t.Fatalf("SyncFile() error = %v", err)
+			}
+
+			if tt.cloudChanged {
+				other := filepath.Join(t.TempDir(), "game.sav")
+				writeFile(t, other, "cloud edit", base.Add(time.Hour))
+				if err := store.Upload(context.Background(), other, "game.sav"); err != nil {
+					t.Fatalf("Upload() error = %v", err)
+				}
+			}
+			writeFile(t, path, "local edit", base.Add(2*time.Hour))
+
+			if err := s.SyncFile(context.Background(), path); err != nil {
+				t.Fatalf("SyncFile() error = %v", err)
+			}
+
+			if got, _ := os.ReadFile(path); string(got) != tt.wantLocal {
+				t.Errorf("local = %q, want %q", got, tt.wantLocal)
+			}
+			if got := string(store.data["game.sav"]); got != tt.wantCloud {
+				t.Errorf("cloud = %q, want %q", got, tt.wantCloud)
+			}
+
+			copies, _ := filepath.Glob(path + conflictSuffix + "*")
+			if got := len(copies) > 0; got != tt.wantConflict {
+				t.Fatalf("conflict copies = %v, want present = %v", copies, tt.wantConflict)
+			}
+			if tt.wantConflict {
+				if got, _ := os.ReadFile(copies[0]); string(got) != "cloud edit" {
+					t.Errorf("conflict copy = %q, want %q", got, "cloud edit")
+				}
+			}
+		})
+	}
+}
+
+func TestConflictNeedsBaseline(t *testing.T) {
+	dir := t.TempDir()
+	backupDir := t.TempDir()
+	path := filepath.Join(dir, "game.sav")
+	now := time.Now().Truncate(time.Second)
+
+	store := newFakeStorage()
+	other := filepath.Join(t.TempDir(), "game.sav")
+	writeFile(t, other, "cloud edit", now.Add(-time.Hour))
+	if err := store.Upload(context.Background(), other, "game.sav"); err != nil {
+		t.Fatalf("Upload() error = %v", err)
+	}
+	writeFile(t, path, "local edit", now)
+
+	// Both sides differ, but with no recorded sync there is no conflict and
+	// the newer local file wins even under cloud-wins
+	s := NewSyncer(store, dir, backupDir, nil, 500*time.Millisecond)
+	s.StateFile = filepath.Join(backupDir, "sync-state.json")
+	s.ConflictStrategy = ConflictCloudWins
+	if err := s.SyncFile(context.Background(), path); err != nil {
+		t.Fatalf("SyncFile() error = %v", err)
+	}
+	if got := string(store.data["game.sav"]); got != "local edit" {
+		t.Errorf("cloud = %q, want %q", got, "local edit")
+	}
+
+	if _, ok := s.baseline("game.sav"); !ok {
+		t.Error("upload did not record a baseline")
+	}
+}
+
+// etagStorage adds ETagLister to fakeStorage, using the checksum as the
+// ETag, and counts stats
+type etagStorage struct {
+	*fakeStorage
+	stats int
+}
+
+func (e *etagStorage) Stat(ctx context.Context, objectName string) (*SyncFileInfo, error) {
+	e.stats++
+	info, err := e.fakeStorage.Stat(ctx, objectName)
+	if err != nil {
+		return nil, err
+	}
+	withETag := *info
+	withETag.ETag = info.Checksum
+	return &withETag, nil
+}
+
+func (e *etagStorage) List(ctx context.Context) ([]*SyncFileInfo, error) {
+	var result []*SyncFileInfo
+	for name := range e.objects {
+		info, err := e.Stat(ctx, name)
+		if err != nil {
+			return nil, err
+		}
+		result = append(result, info)
+	}
+	return result, nil
+}
+
+func (e *etagStorage) ListETags(ctx context.Context) (map[string]string, error) {
+	etags := make(map[string]string)
+	for name, info := range e.objects {
+		etags[name] = info.Checksum
+	}
+	return etags, nil
+}
+
+func (e *etagStorage) StatAll(ctx context.Context, names []string) ([]*SyncFileInfo, error) {
+	result := make([]*SyncFileInfo, 0, len(names))
+	for _, name := range names {
+		info, err := e.Stat(ctx, name)
+		if err != nil {
+			return nil, err
+		}
+		result = append(result, info)
+	}
+	return result, nil
+}
+
+func TestInitialSyncCachesListing(t *testing.T) {
+	store := &etagStorage{fakeStorage: newFakeStorage()}
+	src := t.TempDir()
+	modTime := time.Now().Add(-time.Hour).Truncate(time.Second)
+	for _, name := range []string{"a.sav", "b.sav", "c.sav"} {
+		writeFile(t, filepath.Join(src, name), "save "+name, modTime)
+		if err := store.fakeStorage.Upload(context.Background(), filepath.Join(src, name), name); err != nil {
+			t.Fatalf("Upload() error = %v", err)
+		}
+	}
+
+	backupDir := t.TempDir()
+	s := NewSyncer(store, t.TempDir(), backupDir, nil, 500*time.Millisecond)
+	s.StateFile = filepath.Join(backupDir, "sync-state.json")
+
+	// The first run has no cache and stats everything
+	if _, err := s.InitialSync(context.Background()); err != nil {
+		t.Fatalf("InitialSync() error = %v", err)
+	}
+	if store.stats < 3 {
+		t.Fatalf("first run stats = %d, want at least 3", store.stats)
+	}
+
+	// Only the changed object is statted on the next run
+	writeFile(t, filepath.Join(src, "b.sav"), "changed", modTime.Add(time.Minute))
+	if err := store.fakeStorage.Upload(context.Background(), filepath.Join(src, "b.sav"), "b.sav"); err != nil {
+		t.Fatalf("Upload() error = %v", err)
+	}
+	store.stats = 0
+	if err := s.FullSync(context.Background()); err != nil {
+		t.Fatalf("FullSync() error = %v", err)
+	}
+	if store.stats != 1 {
+		t.Errorf("cached run stats = %d, want 1", store.stats)
+	}
+	if got, _ := os.ReadFile(filepath.Join(s.watchPath, "b.sav")); string(got) != "changed" {
+		t.Errorf("b.sav = %q, want %q", got, "changed")
+	}
+
+	// ForceFullSync ignores the cache
+	s.ForceFullSync = true
+	store.stats = 0
+	if err := s.FullSync(context.Background()); err != nil {
+		t.Fatalf("FullSync() error = %v", err)
+	}
+	if store.stats != 3 {
+		t.Errorf("forced run stats = %d, want 3", store.stats)
+	}
+}
+
+// filteredStorage adds FilteredLister to etagStorage, statting only the
+// objects keep accepts
+type filteredStorage struct {
+	*etagStorage
+}
+
+func (f *filteredStorage) ListFiltered(ctx context.Context, prefix string, keep func(name string) bool) ([]*SyncFileInfo, error) {
+	var result []*SyncFileInfo
+	for name := range f.objects {
+		if !strings.HasPrefix(name, prefix) || !keep(name) {
+			continue
+		}
+		info, err := f.Stat(ctx, name)
+		if err != nil {
+			return nil, err
+		}
+		result = append(result, info)
+	}
+	return result, nil
+}
+
+func TestListCloudSkipsUnsyncedObjects(t *testing.T) {
+	store := &filteredStorage{&etagStorage{fakeStorage: newFakeStorage()}}
+	src := t.TempDir()
+	modTime := time.Now().Add(-time.Hour).Truncate(time.Second)
+	for _, name := range []string{"a.sav", "notes.txt"} {
+		writeFile(t, filepath.Join(src, name), "content of "+name, modTime)
+		if err := store.fakeStorage.Upload(context.Background(), filepath.Join(src, name), name); err != nil {
+			t.Fatalf("Upload() error = %v", err)
+		}
+	}
+
+	backupDir := t.TempDir()
+	s := NewSyncer(store, t.TempDir(), backupDir, nil, 500*time.Millisecond)
+	s.StateFile = filepath.Join(backupDir, "sync-state.json")
+
+	for _, run := range []string{"uncached", "cached"} {
+		store.stats = 0
+		files, err := s.listCloud(context.Background())
+		if err != nil {
+			t.Fatalf("%s listCloud() error = %v", run, err)
+		}
+		if len(files) != 1 || files[0].Name != "a.sav" {
+			t.Errorf("%s listCloud() = %v, want only a.sav", run, files)
+		}
+		if store.stats > 1 {
+			t.Errorf("%s run stats = %d, want notes.txt not statted", run, store.stats)
+		}
+	}
+}
+
+func TestInitialSyncConcurrency(t *testing.T) {
+	store := newFakeStorage()
+	dir := t.TempDir()
+	modTime := time.Now().Add(-time.Hour).Truncate(time.Second)
+
+	// Local saves to upload and cloud saves to download
+	src := t.TempDir()
+	for i := range 20 {
+		writeFile(t, filepath.Join(dir, fmt.Sprintf("local%02d.sav", i)), "local", modTime)
+
+		name := fmt.Sprintf("cloud%02d.sav", i)
+		writeFile(t, filepath.Join(src, name), "cloud", modTime)
+		if err := store.Upload(context.Background(), filepath.Join(src, name), name); err != nil {
+			t.Fatalf("Upload() error = %v", err)
+		}
+	}
+
+	s := NewSyncer(store, dir, t.TempDir(), nil, 500*time.Millisecond)
+	s.Concurrency = 4
+	if _, err := s.InitialSync(context.Background()); err != nil {
+		t.Fatalf("InitialSync() error = %v", err)
+	}
+
+	for i := range 20 {
+		if _, ok := store.data[fmt.Sprintf("local%02d.sav", i)]; !ok {
+			t.Errorf("local%02d.sav was not uploaded", i)
+		}
+		if got, _ := os.ReadFile(filepath.Join(dir, fmt.Sprintf("cloud%02d.sav", i))); string(got) != "cloud" {
+			t.Errorf("cloud%02d.sav = %q, want %q", i, got, "cloud")
+		}
+	}
+}
+
+func TestInitialSyncStopsOnCancel(t *testing.T) {
+	store := newFakeStorage()
+	dir := t.TempDir()
+	for i := range 10 {
+		writeFile(t, filepath.Join(dir, fmt.Sprintf("save%02d.sav", i)), "save", time.Now().Add(-time.Hour))
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	s := NewSyncer(store, dir, t.TempDir(), nil, 500*time.Millisecond)
+	s.Concurrency = 4
+	if _, err := s.InitialSync(ctx); !errors.Is(err, context.Canceled) {
+		t.Errorf("InitialSync() error = %v, want %v", err, context.Canceled)
+	}
+	if store.uploads != 0 {
+		t.Errorf("uploads = %d after cancel, want 0", store.uploads)
+	}
+}
+
+func TestSyncFileLogsTransfer(t *testing.T) {
+	var buf bytes.Buffer
+	prev := slog.Default()
+	slog.SetDefault(slog.New(slog.NewJSONHandler(&buf, nil)))
+	t.Cleanup(func() { slog.SetDefault(prev) })
+
+	dir := t.TempDir()
+	path := filepath.Join(dir, "game.sav")
+	localTime := time.Now().Add(-time.Hour).Truncate(time.Second).UTC()
+	cloudTime := localTime.Add(-time.Hour)
+	writeFile(t, path, "local", localTime)
+
+	store := newFakeStorage()
+	store.objects["game.sav"] = &SyncFileInfo{Name: "game.sav", ModTime: cloudTime, Size: 5}
+	store.data["game.sav"] = []byte("cloud")
+
+	s := NewSyncer(store, dir, t.TempDir(), nil, 500*time.Millisecond)
+	if err := s.SyncFile(context.Background(), path); err != nil {
+		t.Fatalf("SyncFile() error = %v", err)
+	}
+
+	var event struct {
+		Msg          string    `json:"msg"`
+		File         string    `json:"file"`
+		Direction    string    `json:"direction"`
+		LocalModTime time.Time `json:"local_mod_time"`
+		CloudModTime time.Time `json:"cloud_mod_time"`
+		Bytes        int64     `json:"bytes"`
+		Duration     *int64    `json:"duration"`
+	}
+	var found bool
+	for _, line := range bytes.Split(bytes.TrimSpace(buf.Bytes()), []byte("\n")) {
+		if err := json.Unmarshal(line, &event); err != nil {
+			t.Fatalf("log line %s is not JSON: %v", line, err)
+		}
+		if event.Direction != "" {
+			found = true
+			break
+		}
+	}
+	if !found {
+		t.Fatalf("no transfer event logged in:\n%s", buf.String())
+	}
+
+	if event.File != "game.sav" || event.Direction != notify.Upload {
+		t.Errorf("event file, direction = %q, %q, want %q, %q", event.File, event.Direction, "game.sav", notify.Upload)
+	}
+	if !event.LocalModTime.Equal(localTime) || !event.CloudModTime.Equal(cloudTime) {
+		t.Errorf("event mod times = %v, %v, want %v, %v", event.LocalModTime, event.CloudModTime, localTime, cloudTime)
+	}
+	if event.Bytes != 5 || event.Duration == nil {
+		t.Errorf("event bytes, duration = %d, %v, want 5 and a duration", event.Bytes, event.Duration)
+	}
+}
+
+// recordingNotifier collects the events passed to Notify
+type recordingNotifier struct {
+	mu     gosync.Mutex
+	events []notify.Event
+}
+
+func (r *recordingNotifier) Notify(event notify.Event) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.events = append(r.events, event)
+}
+
+func TestSyncFileNotifies(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "game.sav")
+	writeFile(t, path, "local", time.Now().Add(-time.Hour))
+
+	store := newFakeStorage()
+	notifier := &recordingNotifier{}
+	s := NewSyncer(store, dir, t.TempDir(), nil, 500*time.Millisecond)
+	s.Notifier = notifier
+
+	if err := s.SyncFile(context.Background(), path); err != nil {
+		t.Fatalf("SyncFile() error = %v", err)
+	}
+
+	// Already in sync: no transfer, no notification
+	if err := s.SyncFile(context.Background(), path); err != nil {
+		t.Fatalf("SyncFile() error = %v", err)
+	}
+
+	if len(notifier.events) != 1 {
+		t.Fatalf("got %d notifications, want 1", len(notifier.events))
+	}
+	event := notifier.events[0]
+	if event.File != "game.sav" || event.Direction != notify.Upload || event.Size != 5 || event.Time.IsZero() {
+		t.Errorf("event = %+v, want an upload of game.sav, 5 bytes", event)
+	}
+}
+
+// metricCounts are the totals a countingMetrics has seen
+type metricCounts struct {
+	transfers, bytes, backups, ok, bad int
+}
+
+// countingMetrics counts the calls to each Metrics method
+type countingMetrics struct {
+	mu gosync.Mutex
+	metricCounts
+}
+
+func (m *countingMetrics) Transferred(direction string, size int64) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.transfers++
+	m.bytes += int(size)
+}
+
+func (m *countingMetrics) BackedUp() {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.backups++
+}
+
+func (m *countingMetrics) Synced() {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.ok++
+}
+
+func (m *countingMetrics) SyncFailed() {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.bad++
+}
+
+func TestSyncFileMetrics(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "game.sav")
+	writeFile(t, path, "local", time.Now().Add(-time.Hour))
+
+	store := newFakeStorage()
+	metrics := &countingMetrics{}
+	s := NewSyncer(store, dir, t.TempDir(), nil, 500*time.Millisecond)
+	s.Metrics = metrics
+
+	if err := s.SyncFile(context.Background(), path); err != nil {
+		t.Fatalf("SyncFile() error = %v", err)
+	}
+	// Already in sync: a success without a transfer
+	if err := s.SyncFile(context.Background(), path); err != nil {
+		t.Fatalf("SyncFile() error = %v", err)
+	}
+	if err := s.SyncFile(context.Background(), filepath.Join(dir, "missing.sav")); err == nil {
+		t.Fatal("SyncFile() of a missing file succeeded")
+	}
+
+	want := metricCounts{transfers: 1, bytes: 5, backups: 1, ok: 2, bad: 1}
+	if got := metrics.metricCounts; got != want {
+		t.Errorf("metrics = %+v, want %+v", got, want)
+	}
+}
+
+// skewedStorage reports a fixed clock skew
+type skewedStorage struct {
+	*fakeStorage
+	skew time.Duration
+}
+
+func (s *skewedStorage) ClockSkew(ctx context.Context) (time.Duration, error) {
+	return s.skew, nil
+}
+
+func TestCheckClockSkew(t *testing.T) {
+	var buf bytes.Buffer
+	log.SetOutput(&buf)
+	t.Cleanup(func() { log.SetOutput(os.Stderr) })
+
+	tests := []struct {
+		skew time.Duration
+		warn string
+	}{
+		{skew: time.Second},
+		{skew: -1400 * time.Millisecond},
+		{skew: 7 * time.Second, warn: "skew=7s clock=behind"},
+		{skew: -90 * time.Second, warn: "skew=1m30s clock=ahead"},
+	}
+
+	for _, tt := range tests {
+		buf.Reset()
+		store := &skewedStorage{fakeStorage: newFakeStorage(), skew: tt.skew}
+		s := NewSyncer(store, t.TempDir(), t.TempDir(), nil, 500*time.Millisecond)
+
+		if got := s.CheckClockSkew(context.Background()); got != tt.skew {
+			t.Errorf("CheckClockSkew() = %v, want %v", got, tt.skew)
+		}
+		if tt.warn == "" && buf.Len() > 0 {
+			t.Errorf("skew %v: unexpected warning %q", tt.skew, buf.String())
+		}
+		if tt.warn != "" && !strings.Contains(buf.String(), tt.warn) {
+			t.Errorf("skew %v: log = %q, want it to contain %q", tt.skew, buf.String(), tt.warn)
+		}
+	}
+}
+
+func TestFailedDownloadKeepsLocalFile(t *testing.T) {
+	dir := t.TempDir()
+	backupDir := t.TempDir()
+	path := filepath.Join(dir, "game.sav")
+	writeFile(t, path, "local", time.Now().Add(-time.Hour))
+
+	store := newFakeStorage()
+	store.objects["game.sav"] = &SyncFileInfo{Name: "game.sav", ModTime: time.Now(), Size: 10}
+	store.data["game.sav"] = []byte("cloud save")
+	store.downloadErr = errors.New("downloaded file does not match the stored object")
+
+	s := NewSyncer(store, dir, backupDir, nil, 500*time.Millisecond)
+	if err := s.SyncFile(context.Background(), path); err == nil {
+		t.Fatal("SyncFile() error = nil, want the download error")
+	}
+
+	if got, _ := os.ReadFile(path); string(got) != "local" {
+		t.Errorf("local content = %q, want unchanged %q", got, "local")
+	}
+	backups, err := s.Backups()
+	if err != nil {
+		t.Fatalf("Backups() error = %v", err)
+	}
+	if len(backups) != 1 {
+		t.Errorf("got %d backups, want the one taken before the download", len(backups))
+	}
+}
+
+func TestDownloadInterruptedBeforeRename(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "game.sav")
+	writeFile(t, path, "local", time.Now().Add(-time.Hour))
+
+	store := newFakeStorage()
+	store.objects["game.sav"] = &SyncFileInfo{Name: "game.sav", ModTime: time.Now(), Size: 10}
+	store.data["game.sav"] = []byte("cloud save")
+
+	// The process "dies" after the download is written but before it is
+	// swapped in
+	var renamedFrom string
+	renameFile = func(oldpath, newpath string) error {
+		renamedFrom = oldpath
+		return errors.New("interrupted")
+	}
+	t.Cleanup(func() { renameFile = os.Rename })
+
+	s := NewSyncer(store, dir, t.TempDir(), nil, 500*time.Millisecond)
+	if err := s.SyncFile(context.Background(), path); err == nil {
+		t.Fatal("SyncFile() error = nil, want the interruption")
+	}
+
+	if filepath.Dir(renamedFrom) != dir {
+		t.Errorf("downloaded to %s, want a temp file beside the target in %s", renamedFrom, dir)
+	}
+	if got, _ := os.ReadFile(path); string(got) != "local" {
+		t.Errorf("local content = %q, want unchanged %q", got, "local")
+	}
+	if entries, _ := os.ReadDir(dir); len(entries) != 1 {
+		t.Errorf("watch dir holds %d entries, want only game.sav (temp file cleaned up)", len(entries))
+	}
+
+	// Once renames work again the download goes through whole
+	renameFile = os.Rename
+	if err := s.SyncFile(context.Background(), path); err != nil {
+		t.Fatalf("SyncFile() error = %v", err)
+	}
+	if got, _ := os.ReadFile(path); string(got) != "cloud save" {
+		t.Errorf("local content = %q, want %q", got, "cloud save")
+	}
+}
+
+func TestJournalRecoversInterruptedDownload(t *testing.T) {
+	ctx := context.Background()
+	dir := t.TempDir()
+	backupDir := t.TempDir()
+	path := filepath.Join(dir, "game.sav")
+	writeFile(t, path, "local", time.Now().Add(-time.Hour))
+
+	cloudTime := time.Now().Truncate(time.Second)
+	store := newFakeStorage()
+	store.objects["game.sav"] = &SyncFileInfo{Name: "game.sav", ModTime: cloudTime, Size: 10}
+	store.data["game.sav"] = []byte("cloud save")
+
+	newJournaled := func() *Syncer {
+		s := NewSyncer(store, dir, backupDir, nil, 500*time.Millisecond)
+		s.JournalFile = filepath.Join(backupDir, "journal.json")
+		return s
+	}
+
+	// The process dies after the download is written but before it is
+	// swapped in, leaving the temp file and the journal behind
+	crashed := newJournaled()
+	crashed.beforeReplace = func() { panic("crash") }
+	func() {
+		defer func() {
+			if recover() == nil {
+				t.Fatal("SyncFile() returned, want the crash")
+			}
+		}()
+		crashed.SyncFile(ctx, path)
+	}()
+	if got, _ := os.ReadFile(path); string(got) != "local" {
+		t.Fatalf("local content after crash = %q, want unchanged %q", got, "local")
+	}
+	if !fileExists(crashed.JournalFile) {
+		t.Fatal("no journal left after the crash")
+	}
+
+	// The next start finishes the download before syncing
+	s := newJournaled()
+	s.recoverJournal()
+
+	if got, _ := os.ReadFile(path); string(got) != "cloud save" {
+		t.Errorf("local content = %q, want %q", got, "cloud save")
+	}
+	if info, err := os.Stat(path); err != nil {
+		t.Errorf("Stat() error = %v", err)
+	} else if !info.ModTime().Equal(cloudTime) {
+		t.Errorf("mod time = %v, want %v", info.ModTime(), cloudTime)
+	}
+	if entries, _ := os.ReadDir(dir); len(entries) != 1 {
+		t.Errorf("watch dir holds %d entries, want only game.sav (temp file renamed)", len(entries))
+	}
+	if fileExists(s.JournalFile) {
+		t.Error("journal still exists after recovery")
+	}
+}
+
+func TestJournalRemovesIncompleteDownload(t *testing.T) {
+	dir := t.TempDir()
+	backupDir := t.TempDir()
+	path := filepath.Join(dir, "game.sav")
+	writeFile(t, path, "local", time.Now().Add(-time.Hour))
+
+	// A download cut off midway, and one whose target the game saved over
+	// after it started
+	partial := filepath.Join(dir, "game.sav.cloudsync-1.download")
+	writeFile(t, partial, "cloud", time.Now())
+	newer := filepath.Join(dir, "other.sav")
+	writeFile(t, newer, "saved meanwhile", time.Now())
+	stale := filepath.Join(dir, "other.sav.cloudsync-2.download")
+	writeFile(t, stale, "cloud other", time.Now())
+
+	s := NewSyncer(newFakeStorage(), dir, backupDir, nil, 500*time.Millisecond)
+	s.JournalFile = filepath.Join(backupDir, "journal.json")
+	s.journal(journalEntry{Object: "game.sav", Temp: partial, Target: path, Started: time.Now()})
+	s.journal(journalEntry{Object: "other.sav", Temp: stale, Target: newer, Started: time.Now().Add(-time.Minute), Complete: true})
+
+	s.recoverJournal()
+
+	if got, _ := os.ReadFile(path); string(got) != "local" {
+		t.Errorf("game.sav content = %q, want unchanged %q", got, "local")
+	}
+	if got, _ := os.ReadFile(newer); string(got) != "saved meanwhile" {
+		t.Errorf("other.sav content = %q, want unchanged %q", got, "saved meanwhile")
+	}
+	for _, temp := range []string{partial, stale} {
+		if fileExists(temp) {
+			t.Errorf("%s not removed", filepath.Base(temp))
+		}
+	}
+	if fileExists(s.JournalFile) {
+		t.Error("journal still exists after recovery")
+	}
+}
+
+func TestDownloadUsesTempDir(t *testing.T) {
+	ctx := context.Background()
+	dir := t.TempDir()
+	tempDir := filepath.Join(t.TempDir(), "downloads")
+	path := filepath.Join(dir, "game.sav")
+	writeFile(t, path, "local", time.Now().Add(-time.Hour))
+
+	store := newFakeStorage()
+	store.objects["game.sav"] = &SyncFileInfo{Name: "game.sav", ModTime: time.Now().Truncate(time.Second), Size: 10}
+	store.data["game.sav"] = []byte("cloud save")
+
+	s := NewSyncer(store, dir, t.TempDir(), nil, 500*time.Millisecond)
+	s.TempDir = tempDir
+	var temps []string
+	s.beforeReplace = func() {
+		temps, _ = filepath.Glob(filepath.Join(tempDir, "game.sav"+downloadTempPattern))
+	}
+	if err := s.SyncFile(ctx, path); err != nil {
+		t.Fatalf("SyncFile() error = %v", err)
+	}
+
+	if len(temps) != 1 {
+		t.Errorf("temp files in -temp-dir before replace = %v, want one", temps)
+	}
+	if got, _ := os.ReadFile(path); string(got) != "cloud save" {
+		t.Errorf("local content = %q, want %q", got, "cloud save")
+	}
+	if entries, _ := os.ReadDir(tempDir); len(entries) != 0 {
+		t.Errorf("temp dir holds %d files after the download, want none", len(entries))
+	}
+}
+
+func TestCleanTempFiles(t *testing.T) {
+	dir := t.TempDir()
+	tempDir := t.TempDir()
+	old := processStart.Add(-time.Hour)
+
+	stale := []string{
+		filepath.Join(dir, "game.sav.cloudsync-1.download"),
+		filepath.Join(dir, "slot1", "game.sav.cloudsync-2.download"),
+		filepath.Join(tempDir, "other.sav.cloudsync-3.download"),
+		filepath.Join(tempDir, "cloudsync-verify-4"),
+		filepath.Join(tempDir, "cloudsync-5.tombstone"),
+	}
+	kept := []string{
+		filepath.Join(dir, "game.sav"),
+		filepath.Join(tempDir, "notes.txt"),
+		filepath.Join(dir, "cloudsync-verify-6"), // only cleaned in TempDir
+	}
+	if err := os.Mkdir(filepath.Join(dir, "slot1"), 0755); err != nil {
+		t.Fatalf("Mkdir() error = %v", err)
+	}
+	for _, path := range append(stale, kept...) {
+		writeFile(t, path, "data", old)
+	}
+	// A download another watch of this process is writing
+	current := filepath.Join(tempDir, "live.sav.cloudsync-7.download")
+	writeFile(t, current, "data", time.Now())
+	kept = append(kept, current)
+
+	s := NewSyncer(newFakeStorage(), dir, t.TempDir(), nil, 500*time.Millisecond)
+	s.TempDir = tempDir
+	s.cleanTempFiles()
+
+	for _, path := range stale {
+		if fileExists(path) {
+			t.Errorf("stale %s not removed", path)
+		}
+	}
+	for _, path := range kept {
+		if !fileExists(path) {
+			t.Errorf("%s removed", path)
+		}
+	}
+}
+
+func TestMoveFileAcrossDevices(t *testing.T) {
+	// Renames between directories fail as they would across file systems
+	renameFile = func(oldpath, newpath string) error {
+		if filepath.Dir(oldpath) != filepath.Dir(newpath) {
+			return &os.LinkError{Op: "rename", Old: oldpath, New: newpath, Err: syscall.EXDEV}
+		}
+		return os.Rename(oldpath, newpath)
+	}
+	t.Cleanup(func() { renameFile = os.Rename })
+
+	src := filepath.Join(t.TempDir(), "download")
+	dst := filepath.Join(t.TempDir(), "game.sav")
+	writeFile(t, src, "cloud", time.Now())
+	writeFile(t, dst, "local", time.Now())
+
+	if err := moveFile(src, dst); err != nil {
+		t.Fatalf("moveFile() error = %v", err)
+	}
+	if got, _ := os.ReadFile(dst); string(got) != "cloud" {
+		t.Errorf("dst content = %q, want %q", got, "cloud")
+	}
+	if fileExists(src) {
+		t.Error("src still exists after moveFile")
+	}
+
+	// Other rename errors are not papered over with a copy
+	renameFile = func(oldpath, newpath string) error { return syscall.EACCES }
+	writeFile(t, src, "again", time.Now())
+	if err := moveFile(src, dst); err == nil {
+		t.Error("moveFile() error = nil, want the rename error")
+	}
+}
+
+// newDeleteClient returns a Syncer with its own watch path, backup dir and
+// state file on store, as a second machine would have
+func newDeleteClient(t *testing.T, store Storage) *Syncer {
+	t.Helper()
+	backupDir := t.TempDir()
+	s := NewSyncer(store, t.TempDir(), backupDir, nil, 500*time.Millisecond)
+	s.StateFile = filepath.Join(backupDir, "sync-state.json")
+	s.PropagateDeletes = true
+	return s
+}
+
+func TestPropagateDeletes(t *testing.T) {
+	ctx := context.Background()
+	store := newFakeStorage()
+	a := newDeleteClient(t, store)
+	b := newDeleteClient(t, store)
+
+	pathA := filepath.Join(a.watchPath, "game.sav")
+	pathB := filepath.Join(b.watchPath, "game.sav")
+	writeFile(t, pathA, "save", time.Now().Add(-time.Hour))
+	for _, s := range []*Syncer{a, b} {
+		if _, err := s.InitialSync(ctx); err != nil {
+			t.Fatalf("InitialSync() error = %v", err)
+		}
+	}
+	if !fileExists(pathB) {
+		t.Fatal("game.sav not synced to the second machine")
+	}
+
+	// Deleting on A removes the object, leaves a tombstone and keeps the
+	// cloud copy in A's backups
+	before, _ := a.Backups()
+	os.Remove(pathA)
+	if err := a.DeleteFile(ctx, pathA); err != nil {
+		t.Fatalf("DeleteFile() error = %v", err)
+	}
+	if _, ok := store.objects["game.sav"]; ok {
+		t.Error("game.sav still in the cloud after DeleteFile")
+	}
+	if _, ok := store.objects[tombstoneName("game.sav")]; !ok {
+		t.Error("no tombstone written for game.sav")
+	}
+	if backups, _ := a.Backups(); len(backups) != len(before)+1 {
+		t.Errorf("A has %d backups, want %d with the cloud copy", len(backups), len(before)+1)
+	}
+
+	// B removes its copy instead of uploading it again, keeping a backup
+	if err := b.FullSync(ctx); err != nil {
+		t.Fatalf("FullSync() error = %v", err)
+	}
+	if fileExists(pathB) {
+		t.Error("game.sav still on B after the deletion synced")
+	}
+	if backups, _ := b.Backups(); len(backups) != 1 {
+		t.Errorf("B has %d backups, want its removed copy", len(backups))
+	}
+
+	// Both machines converge on the file being gone
+	for _, s := range []*Syncer{a, b} {
+		if err := s.FullSync(ctx); err != nil {
+			t.Fatalf("FullSync() error = %v", err)
+		}
+	}
+	if _, ok := store.objects["game.sav"]; ok || fileExists(pathA) || fileExists(pathB) {
+		t.Error("game.sav came back after the deletion")
+	}
+
+	// A save made after the deletion wins and clears the tombstone
+	writeFile(t, pathB, "new save", time.Now().Add(time.Minute))
+	if err := b.FullSync(ctx); err != nil {
+		t.Fatalf("FullSync() error = %v", err)
+	}
+	if _, ok := store.objects[tombstoneName("game.sav")]; ok {
+		t.Error("tombstone not cleared by the new upload")
+	}
+	if err := a.FullSync(ctx); err != nil {
+		t.Fatalf("FullSync() error = %v", err)
+	}
+	if got, _ := os.ReadFile(pathA); string(got) != "new save" {
+		t.Errorf("A content = %q, want %q", got, "new save")
+	}
+}
+
+func TestPropagateDeletesOffline(t *testing.T) {
+	ctx := context.Background()
+	store := newFakeStorage()
+	a := newDeleteClient(t, store)
+	b := newDeleteClient(t, store)
+
+	pathA := filepath.Join(a.watchPath, "game.sav")
+	pathB := filepath.Join(b.watchPath, "game.sav")
+	writeFile(t, pathA, "save", time.Now().Add(-time.Hour))
+	for _, s := range []*Syncer{a, b} {
+		if _, err := s.InitialSync(ctx); err != nil {
+			t.Fatalf("InitialSync() error = %v", err)
+		}
+	}
+
+	// Deleted while cloudsync wasn't running: the next start notices the
+	// synced file is missing rather than downloading it again
+	os.Remove(pathA)
+	if _, err := a.InitialSync(ctx); err != nil {
+		t.Fatalf("InitialSync() error = %v", err)
+	}
+	if _, ok := store.objects["game.sav"]; ok || fileExists(pathA) {
+		t.Error("game.sav restored instead of deleted")
+	}
+
+	// A file never synced to this machine is downloaded, not deleted
+	c := newDeleteClient(t, store)
+	writeFile(t, filepath.Join(b.watchPath, "other.sav"), "other", time.Now().Add(-time.Hour))
+	if err := b.FullSync(ctx); err != nil {
+		t.Fatalf("FullSync() error = %v", err)
+	}
+	if _, err := c.InitialSync(ctx); err != nil {
+		t.Fatalf("InitialSync() error = %v", err)
+	}
+	if !fileExists(filepath.Join(c.watchPath, "other.sav")) {
+		t.Error("other.sav not downloaded to a new machine")
+	}
+	if fileExists(filepath.Join(c.watchPath, "game.sav")) || fileExists(pathB) {
+		t.Error("deleted game.sav present on B or the new machine")
+	}
+}
+
+func TestPropagateDeletesFromCloud(t *testing.T) {
+	ctx := context.Background()
+	store := newFakeStorage()
+	s := newDeleteClient(t, store)
+
+	path := filepath.Join(s.watchPath, "game.sav")
+	writeFile(t, path, "save", time.Now().Add(-time.Hour))
+	if _, err := s.InitialSync(ctx); err != nil {
+		t.Fatalf("InitialSync() error = %v", err)
+	}
+
+	// Removed by hand in the bucket, with no tombstone
+	store.Delete(ctx, "game.sav")
+	if err := s.SyncFile(ctx, path); err != nil {
+		t.Fatalf("SyncFile() error = %v", err)
+	}
+	if fileExists(path) {
+		t.Error("game.sav not removed after it was deleted from the cloud")
+	}
+
+	// Without PropagateDeletes the file is uploaded again, as before
+	s.PropagateDeletes = false
+	writeFile(t, path, "save", time.Now().Add(-time.Hour))
+	s.recordSync("game.sav", path)
+	if err := s.SyncFile(ctx, path); err != nil {
+		t.Fatalf("SyncFile() error = %v", err)
+	}
+	if _, ok := store.objects["game.sav"]; !ok {
+		t.Error("game.sav not uploaded with PropagateDeletes off")
+	}
+}
+
+// versionedStorage keeps the content of every version of an object, as a
+// versioned bucket does
+type versionedStorage struct {
+	*fakeStorage
+	versions map[string][]*VersionInfo
+	content  map[string]string // by version ID
+}
+
+func (v *versionedStorage) ListVersions(ctx context.Context, objectName string) ([]*VersionInfo, error) {
+	return v.versions[objectName], nil
+}
+
+func (v *versionedStorage) DownloadVersion(ctx context.Context, objectName, versionID, localPath string) error {
+	content, ok := v.content[versionID]
+	if !ok {
+		return fmt.Errorf("no version %s", versionID)
+	}
+	return os.WriteFile(localPath, []byte(content), 0644)
+}
+
+func TestRestoreVersion(t *testing.T) {
+	ctx := context.Background()
+	oldTime := time.Now().Add(-48 * time.Hour)
+	store := &versionedStorage{
+		fakeStorage: newFakeStorage(),
+		versions: map[string][]*VersionInfo{"game.sav": {
+			{VersionID: "v3", ModTime: oldTime.Add(2 * time.Hour), DeleteMarker: true, IsLatest: true},
+			{VersionID: "v2", ModTime: oldTime.Add(time.Hour), Size: 3},
+			{VersionID: "v1", ModTime: oldTime, Size: 3},
+		}},
+		content: map[string]string{"v1": "old", "v2": "mid"},
+	}
+	s := NewSyncer(store, t.TempDir(), t.TempDir(), nil, 500*time.Millisecond)
+
+	versions, err := s.Versions(ctx, "game.sav")
+	if err != nil || len(versions) != 3 {
+		t.Fatalf("Versions() = %v, %v, want the 3 versions", versions, err)
+	}
+
+	livePath := filepath.Join(s.watchPath, "game.sav")
+	writeFile(t, livePath, "new", time.Now().Add(-time.Hour))
+	before := time.Now().Add(-time.Second)
+
+	if err := s.RestoreVersion(ctx, "game.sav", "v1"); err != nil {
+		t.Fatalf("RestoreVersion() error = %v", err)
+	}
+	if got, _ := os.ReadFile(livePath); string(got) != "old" {
+		t.Errorf("live content = %q, want %q", got, "old")
+	}
+	if info, _ := os.Stat(livePath); info.ModTime().Before(before) {
+		t.Errorf("restored mod time %v, want a fresh one so the next sync uploads it", info.ModTime())
+	}
+	if backups, _ := s.Backups(); len(backups) != 1 {
+		t.Errorf("backups = %v, want the replaced live file backed up", backups)
+	}
+
+	for _, id := range []string{"v3", "v9"} {
+		if err := s.RestoreVersion(ctx, "game.sav", id); err == nil {
+			t.Errorf("RestoreVersion(%s) succeeded, want an error", id)
+		}
+	}
+
+	plain := NewSyncer(newFakeStorage(), t.TempDir(), t.TempDir(), nil, 500*time.Millisecond)
+	if _, err := plain.Versions(ctx, "game.sav"); !errors.Is(err, ErrNoVersions) {
+		t.Errorf("Versions() on unversioned storage error = %v, want ErrNoVersions", err)
+	}
+}
+
+// stateWatchStorage reports an upload made after StateFile was written
+type stateWatchStorage struct {
+	*fakeStorage
+	t         *testing.T
+	stateFile string
+}
+
+func (w *stateWatchStorage) Upload(ctx context.Context, localPath, objectName string) error {
+	if _, err := os.Stat(w.stateFile); err == nil {
+		w.t.Errorf("state file written before the upload of %s, want it written once the sync ends", objectName)
+	}
+	return w.fakeStorage.Upload(ctx, localPath, objectName)
+}
+
+func TestFullSyncSavesStateOnce(t *testing.T) {
+	dir, backupDir := t.TempDir(), t.TempDir()
+	stateFile := filepath.Join(backupDir, "sync-state.json")
+	store := &stateWatchStorage{fakeStorage: newFakeStorage(), t: t, stateFile: stateFile}
+
+	names := []string{"slot1.sav", "slot2.sav", "slot3.sav"}
+	for _, name := range names {
+		writeFile(t, filepath.Join(dir, name), name, time.Now().Add(-time.Hour))
+	}
+
+	s := NewSyncer(store, dir, backupDir, nil, 500*time.Millisecond)
+	s.StateFile = stateFile
+	if err := s.FullSync(context.Background()); err != nil {
+		t.Fatalf("FullSync() error = %v", err)
+	}
+
+	// A new Syncer reads the saved state from disk
+	restarted := NewSyncer(store, dir, backupDir, nil, 500*time.Millisecond)
+	restarted.StateFile = stateFile
+	for _, name := range names {
+		if _, ok := restarted.baseline(name); !ok {
+			t.Errorf("no baseline saved for %s", name)
+		}
+	}
+}
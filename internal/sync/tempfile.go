@@ -0,0 +1,102 @@
+package sync
+
+import (
+	"fmt"
+	"io/fs"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/danielbehrens/cloudsync/internal/fsutil"
+)
+
+// Name patterns of the temp files a Syncer creates. Download temp files
+// are named after the save they replace; none of them match the save
+// patterns, so the watcher ignores them.
+const (
+	downloadTempPattern  = ".cloudsync-*.download" // after the save's name
+	verifyTempPattern    = "cloudsync-verify-*"
+	tombstoneTempPattern = "cloudsync-*.tombstone"
+	dirMarkerTempPattern = "cloudsync-dir-*"
+)
+
+// processStart is when this process started. Temp files last modified
+// before it belong to an earlier run.
+var processStart = time.Now()
+
+// createDownloadTemp creates the empty temp file the object for localPath
+// is downloaded to before it replaces localPath: in TempDir if set, or else
+// beside localPath, so it can be renamed into place
+func (s *Syncer) createDownloadTemp(localPath string) (string, error) {
+	dir := filepath.Dir(localPath)
+	if s.TempDir != "" {
+		dir = s.TempDir
+		if err := ensureDir(dir); err != nil {
+			return "", fmt.Errorf("failed to create temp directory: %w", err)
+		}
+	}
+
+	tmp, err := os.CreateTemp(dir, filepath.Base(localPath)+downloadTempPattern)
+	if err != nil {
+		return "", fmt.Errorf("failed to create temp file: %w", err)
+	}
+	tmp.Close()
+	return tmp.Name(), nil
+}
+
+// cleanTempFiles removes the temp files an earlier run left behind in the
+// watch path and TempDir, e.g. when it was killed before recording a
+// download in the journal. Files modified since this process started are
+// left alone, as another watch may be writing them.
+func (s *Syncer) cleanTempFiles() {
+	if s.DryRun {
+		return
+	}
+
+	removeStale := func(path string, patterns ...string) {
+		name := filepath.Base(path)
+		for _, pattern := range patterns {
+			if ok, _ := filepath.Match(pattern, name); !ok {
+				continue
+			}
+			info, err := os.Stat(path)
+			if err != nil || info.IsDir() || !info.ModTime().Before(processStart) {
+				return
+			}
+			if err := os.Remove(path); err != nil {
+				slog.Warn("Failed to remove stale temp file", "path", path, "err", err)
+				return
+			}
+			slog.Info("Removed stale temp file", "path", path)
+			return
+		}
+	}
+
+	err := fsutil.Walk(s.watchPath, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return nil
+		}
+		if !d.IsDir() {
+			removeStale(path, "*"+downloadTempPattern)
+		}
+		return nil
+	})
+	if err != nil {
+		slog.Warn("Failed to look for stale temp files", "path", s.watchPath, "err", err)
+	}
+
+	if s.TempDir == "" {
+		return
+	}
+	entries, err := os.ReadDir(s.TempDir)
+	if err != nil {
+		if !os.IsNotExist(err) {
+			slog.Warn("Failed to look for stale temp files", "path", s.TempDir, "err", err)
+		}
+		return
+	}
+	for _, e := range entries {
+		removeStale(filepath.Join(s.TempDir, e.Name()), "*"+downloadTempPattern, verifyTempPattern, tombstoneTempPattern, dirMarkerTempPattern)
+	}
+}
@@ -0,0 +1,139 @@
+package sync
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"math/rand/v2"
+	"os"
+	"sort"
+	gosync "sync"
+
+	"github.com/danielbehrens/cloudsync/internal/fsutil"
+)
+
+// Outcomes of verifying one file against its cloud object
+const (
+	VerifyMatch    = "match"    // the cloud object holds the same bytes
+	VerifyMismatch = "mismatch" // the cloud object holds different bytes
+	VerifyMissing  = "missing"  // there is no cloud object
+	VerifyFailed   = "failed"   // the object could not be downloaded or hashed
+)
+
+// VerifyResult is the outcome of verifying one local file. The checksums
+// are hex SHA-256 and empty when they couldn't be computed; Err is set for
+// VerifyFailed.
+type VerifyResult struct {
+	Name          string
+	Status        string
+	LocalChecksum string
+	CloudChecksum string
+	Err           error
+}
+
+// Verify downloads the cloud object of each syncable local file and
+// compares its SHA-256 with the local file's, with up to Concurrency
+// downloads at once. sample is the fraction of files to check, picked at
+// random; 1 checks all of them. Nothing is modified. The results are
+// sorted by name.
+func (s *Syncer) Verify(ctx context.Context, sample float64) ([]VerifyResult, error) {
+	files, _, err := s.listFiles(s.watchPath)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read watch directory: %w", err)
+	}
+
+	cloudFiles, err := s.storage.List(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list cloud files: %w", err)
+	}
+	inCloud := make(map[string]bool, len(cloudFiles))
+	for _, f := range cloudFiles {
+		inCloud[f.Name] = true
+	}
+
+	if sample < 1 && len(files) > 0 {
+		n := max(int(math.Round(float64(len(files))*sample)), 1)
+		rand.Shuffle(len(files), func(i, j int) { files[i], files[j] = files[j], files[i] })
+		files = files[:n]
+	}
+
+	results := make([]VerifyResult, len(files))
+	next := make(chan int)
+	var wg gosync.WaitGroup
+	for range max(s.Concurrency, 1) {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range next {
+				results[i] = s.verifyFile(ctx, files[i], inCloud[files[i].name])
+			}
+		}()
+	}
+
+feed:
+	for i := range files {
+		select {
+		case next <- i:
+		case <-ctx.Done():
+			break feed
+		}
+	}
+	close(next)
+	wg.Wait()
+
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	sort.Slice(results, func(i, j int) bool {
+		return results[i].Name < results[j].Name
+	})
+
+	return results, nil
+}
+
+// verifyFile compares f with its cloud object, downloaded to a temporary
+// file
+func (s *Syncer) verifyFile(ctx context.Context, f localFile, inCloud bool) VerifyResult {
+	result := VerifyResult{Name: f.name}
+
+	localSum, err := fsutil.FileSHA256(f.path)
+	if err != nil {
+		result.Status, result.Err = VerifyFailed, fmt.Errorf("failed to checksum local file: %w", err)
+		return result
+	}
+	result.LocalChecksum = localSum
+
+	if !inCloud {
+		result.Status = VerifyMissing
+		return result
+	}
+
+	tempFile, err := os.CreateTemp(s.TempDir, verifyTempPattern)
+	if err != nil {
+		result.Status, result.Err = VerifyFailed, fmt.Errorf("failed to create temp file: %w", err)
+		return result
+	}
+	tempPath := tempFile.Name()
+	tempFile.Close()
+	defer os.Remove(tempPath)
+
+	if err := s.storage.Download(ctx, f.name, tempPath); err != nil {
+		result.Status, result.Err = VerifyFailed, fmt.Errorf("failed to download: %w", err)
+		return result
+	}
+
+	cloudSum, err := fsutil.FileSHA256(tempPath)
+	if err != nil {
+		result.Status, result.Err = VerifyFailed, fmt.Errorf("failed to checksum downloaded object: %w", err)
+		return result
+	}
+	result.CloudChecksum = cloudSum
+
+	if result.CloudChecksum == result.LocalChecksum {
+		result.Status = VerifyMatch
+	} else {
+		result.Status = VerifyMismatch
+	}
+	return result
+}
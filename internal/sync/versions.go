@@ -0,0 +1,103 @@
+package sync
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// ErrNoVersions is returned for storage that doesn't keep earlier versions
+// of objects
+var ErrNoVersions = errors.New("storage does not keep object versions")
+
+// Versioner is implemented by storage that keeps earlier versions of
+// objects, such as a versioned S3 bucket
+type Versioner interface {
+	// ListVersions returns the versions of objectName, newest first
+	ListVersions(ctx context.Context, objectName string) ([]*VersionInfo, error)
+	DownloadVersion(ctx context.Context, objectName, versionID, localPath string) error
+}
+
+// VersionInfo describes one stored version of an object
+type VersionInfo struct {
+	VersionID    string
+	ModTime      time.Time
+	Size         int64
+	IsLatest     bool
+	DeleteMarker bool // records a deletion; there is nothing to restore
+}
+
+// Versions returns the stored versions of the save name (an object name),
+// newest first
+func (s *Syncer) Versions(ctx context.Context, name string) ([]*VersionInfo, error) {
+	v, ok := s.storage.(Versioner)
+	if !ok {
+		return nil, ErrNoVersions
+	}
+	return v.ListVersions(ctx, name)
+}
+
+// RestoreVersion replaces the live file of the save name with the stored
+// version versionID, backing up the current live file first. Like
+// RestoreGoodCopy, the restored file gets a fresh modification time so the
+// next sync pushes it to the cloud as the latest version.
+func (s *Syncer) RestoreVersion(ctx context.Context, name, versionID string) error {
+	v, ok := s.storage.(Versioner)
+	if !ok {
+		return ErrNoVersions
+	}
+	livePath, err := s.localPath(name)
+	if err != nil || !s.shouldSyncFile(name) {
+		return fmt.Errorf("%s is not a syncable save file name", name)
+	}
+
+	versions, err := v.ListVersions(ctx, name)
+	if err != nil {
+		return err
+	}
+	var version *VersionInfo
+	for _, candidate := range versions {
+		if candidate.VersionID == versionID {
+			version = candidate
+		}
+	}
+	switch {
+	case version == nil:
+		return fmt.Errorf("no version %s of %s", versionID, name)
+	case version.DeleteMarker:
+		return fmt.Errorf("version %s of %s records a deletion and holds no data", versionID, name)
+	}
+
+	if s.DryRun {
+		slog.Info("[dry-run] Would restore version", "file", name, "version", versionID)
+		return nil
+	}
+
+	if err := ensureDir(filepath.Dir(livePath)); err != nil {
+		return fmt.Errorf("failed to create save directory: %w", err)
+	}
+	tempPath, err := s.createDownloadTemp(livePath)
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tempPath)
+
+	if err := v.DownloadVersion(ctx, name, versionID, tempPath); err != nil {
+		return err
+	}
+	if fileExists(livePath) {
+		if err := s.createBackup(livePath); err != nil {
+			return fmt.Errorf("failed to back up live file: %w", err)
+		}
+	}
+	if err := moveFile(tempPath, livePath); err != nil {
+		return fmt.Errorf("failed to restore version: %w", err)
+	}
+
+	slog.Info("Restored version", "file", name, "version", versionID, "mod_time", version.ModTime)
+	return nil
+}
@@ -0,0 +1,80 @@
+// Package version holds the build metadata printed by `cloudsync version`.
+// Release builds set it with the linker, as the Makefile does:
+//
+//	go build -ldflags "-X github.com/danielbehrens/cloudsync/internal/version.Version=v1.2.0 \
+//	  -X github.com/danielbehrens/cloudsync/internal/version.Commit=$(git rev-parse --short HEAD) \
+//	  -X github.com/danielbehrens/cloudsync/internal/version.Date=$(date -u +%Y-%m-%dT%H:%M:%SZ)"
+package version
+
+import (
+	"fmt"
+	"io"
+	"runtime"
+	"runtime/debug"
+)
+
+// Set with -ldflags -X at build time
+var (
+	Version = "dev"
+	Commit  = "unknown"
+	Date    = "unknown"
+)
+
+// Info is the metadata of the running binary
+type Info struct {
+	Version   string
+	Commit    string
+	Date      string
+	GoVersion string
+	Platform  string
+}
+
+// Get returns the build metadata. Values not set by the linker fall back to
+// the VCS stamp go build records when run in a git checkout.
+func Get() Info {
+	info := Info{
+		Version:   Version,
+		Commit:    Commit,
+		Date:      Date,
+		GoVersion: runtime.Version(),
+		Platform:  runtime.GOOS + "/" + runtime.GOARCH,
+	}
+
+	if build, ok := debug.ReadBuildInfo(); ok {
+		fillFromBuildInfo(&info, build)
+	}
+
+	return info
+}
+
+// fillFromBuildInfo replaces the unset commit and date with build's VCS
+// settings, marking a commit built with uncommitted changes
+func fillFromBuildInfo(info *Info, build *debug.BuildInfo) {
+	settings := make(map[string]string)
+	for _, s := range build.Settings {
+		settings[s.Key] = s.Value
+	}
+
+	if info.Commit == "unknown" && settings["vcs.revision"] != "" {
+		info.Commit = settings["vcs.revision"]
+		if len(info.Commit) > 12 {
+			info.Commit = info.Commit[:12]
+		}
+		if settings["vcs.modified"] == "true" {
+			info.Commit += "-dirty"
+		}
+	}
+	if info.Date == "unknown" && settings["vcs.time"] != "" {
+		info.Date = settings["vcs.time"]
+	}
+}
+
+// String formats the metadata as a single line for bug reports
+func (i Info) String() string {
+	return fmt.Sprintf("cloudsync %s (commit %s, built %s, %s %s)", i.Version, i.Commit, i.Date, i.GoVersion, i.Platform)
+}
+
+// Print writes the build metadata of the running binary to w
+func Print(w io.Writer) {
+	fmt.Fprintln(w, Get())
+}
@@ -0,0 +1,43 @@
+package version
+
+import (
+	"runtime"
+	"runtime/debug"
+	"strings"
+	"testing"
+)
+
+func TestGetDefaults(t *testing.T) {
+	info := Get()
+
+	if info.Version != "dev" {
+		t.Errorf("Version = %q, want %q", info.Version, "dev")
+	}
+	if info.GoVersion != runtime.Version() {
+		t.Errorf("GoVersion = %q, want %q", info.GoVersion, runtime.Version())
+	}
+	if s := info.String(); !strings.HasPrefix(s, "cloudsync dev (commit ") || !strings.Contains(s, runtime.Version()) {
+		t.Errorf("String() = %q, want the version, commit and Go version", s)
+	}
+}
+
+func TestFillFromBuildInfo(t *testing.T) {
+	build := &debug.BuildInfo{Settings: []debug.BuildSetting{
+		{Key: "vcs.revision", Value: "0123456789abcdef0123"},
+		{Key: "vcs.time", Value: "2025-06-01T12:00:00Z"},
+		{Key: "vcs.modified", Value: "true"},
+	}}
+
+	info := Info{Commit: "unknown", Date: "unknown"}
+	fillFromBuildInfo(&info, build)
+	if info.Commit != "0123456789ab-dirty" || info.Date != "2025-06-01T12:00:00Z" {
+		t.Errorf("got commit %q, date %q, want the VCS stamp", info.Commit, info.Date)
+	}
+
+	// Values from -ldflags win
+	info = Info{Commit: "abc1234", Date: "2025-07-01"}
+	fillFromBuildInfo(&info, build)
+	if info.Commit != "abc1234" || info.Date != "2025-07-01" {
+		t.Errorf("got commit %q, date %q, want the linker values kept", info.Commit, info.Date)
+	}
+}
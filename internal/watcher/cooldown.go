@@ -0,0 +1,79 @@
+package watcher
+
+import (
+	"sync"
+	"time"
+)
+
+// Entries idle for evictAfter cooldown periods (but at least minEvictAge)
+// are dropped, so the map doesn't grow with every file name ever seen
+const (
+	evictAfter  = 10
+	minEvictAge = time.Minute
+)
+
+// Cooldown suppresses repeat events for a path within a period of the last
+// one let through. It is safe for concurrent use.
+type Cooldown struct {
+	period time.Duration
+
+	mu        sync.Mutex
+	last      map[string]time.Time
+	lastSweep time.Time
+}
+
+// NewCooldown creates a Cooldown with the given period
+func NewCooldown(period time.Duration) *Cooldown {
+	return &Cooldown{
+		period: period,
+		last:   make(map[string]time.Time),
+	}
+}
+
+// Allow reports whether an event on name at now is outside the cooldown,
+// recording it if so
+func (c *Cooldown) Allow(name string, now time.Time) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.sweep(now)
+
+	last, seen := c.last[name]
+	if seen && now.Sub(last) <= c.period {
+		return false
+	}
+
+	c.last[name] = now
+	return true
+}
+
+// sweep evicts idle entries, at most once per eviction age. Callers hold mu.
+func (c *Cooldown) sweep(now time.Time) {
+	maxAge := max(c.period*evictAfter, minEvictAge)
+	if now.Sub(c.lastSweep) < maxAge {
+		return
+	}
+
+	for name, last := range c.last {
+		if now.Sub(last) > maxAge {
+			delete(c.last, name)
+		}
+	}
+	c.lastSweep = now
+}
+
+// forget drops name, so its next event is allowed
+func (c *Cooldown) forget(name string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	delete(c.last, name)
+}
+
+// len returns the number of tracked names
+func (c *Cooldown) len() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return len(c.last)
+}
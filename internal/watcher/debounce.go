@@ -0,0 +1,146 @@
+package watcher
+
+import (
+	"sync"
+	"time"
+
+	"github.com/fsnotify/fsnotify"
+)
+
+// DebounceMode selects how a FileWatcher collapses bursts of events on a
+// file
+type DebounceMode string
+
+const (
+	// DebounceLeading passes the first event of a burst at once and drops
+	// the rest within the cooldown. This is the default.
+	DebounceLeading DebounceMode = "leading"
+
+	// DebounceTrailing holds a file's events until it has been quiet for
+	// the cooldown, then passes one event carrying all their operations.
+	// A file that never goes quiet is passed at least once per max wait.
+	DebounceTrailing DebounceMode = "trailing"
+)
+
+// defaultMaxWaitFactor sets the max wait of a trailing debounce, when not
+// given, as a multiple of the quiet period
+const defaultMaxWaitFactor = 10
+
+// Debouncer merges events on the same file until it has been quiet for a
+// period, or the first of them has waited maxWait, and then sends the
+// merged event on Events. Run does the work; Events and Stop may be
+// called from any goroutine.
+type Debouncer struct {
+	quiet   time.Duration
+	maxWait time.Duration
+
+	in   <-chan fsnotify.Event
+	out  chan fsnotify.Event
+	due  chan dueFile
+	done chan struct{}
+	stop sync.Once
+
+	pending map[string]*pendingEvent
+}
+
+// pendingEvent is the merged burst of events on one file
+type pendingEvent struct {
+	event fsnotify.Event
+	first time.Time
+	timer *time.Timer
+	gen   int // identifies the latest timer, so stale firings are ignored
+}
+
+// dueFile is sent by a pending file's timer
+type dueFile struct {
+	name string
+	gen  int
+}
+
+// NewDebouncer debounces the events received from in. A maxWait below
+// quiet means ten times quiet.
+func NewDebouncer(in <-chan fsnotify.Event, quiet, maxWait time.Duration) *Debouncer {
+	if maxWait < quiet {
+		maxWait = defaultMaxWaitFactor * quiet
+	}
+	return &Debouncer{
+		quiet:   quiet,
+		maxWait: maxWait,
+		in:      in,
+		out:     make(chan fsnotify.Event),
+		due:     make(chan dueFile),
+		done:    make(chan struct{}),
+		pending: make(map[string]*pendingEvent),
+	}
+}
+
+// Events returns the channel of debounced events. It is closed when the
+// input channel is or Stop is called; events still pending are dropped.
+func (d *Debouncer) Events() <-chan fsnotify.Event {
+	return d.out
+}
+
+// Stop makes Run return
+func (d *Debouncer) Stop() {
+	d.stop.Do(func() { close(d.done) })
+}
+
+// Run debounces events until the input channel is closed or Stop is called
+func (d *Debouncer) Run() {
+	defer close(d.out)
+	defer func() {
+		for _, p := range d.pending {
+			p.timer.Stop()
+		}
+	}()
+
+	for {
+		select {
+		case event, ok := <-d.in:
+			if !ok {
+				return
+			}
+			d.add(event, time.Now())
+		case f := <-d.due:
+			p := d.pending[f.name]
+			if p == nil || p.gen != f.gen {
+				continue
+			}
+			delete(d.pending, f.name)
+			select {
+			case d.out <- p.event:
+			case <-d.done:
+				return
+			}
+		case <-d.done:
+			return
+		}
+	}
+}
+
+// add merges event into its file's pending burst and restarts the quiet
+// period, without passing the max wait
+func (d *Debouncer) add(event fsnotify.Event, now time.Time) {
+	p := d.pending[event.Name]
+	if p == nil {
+		p = &pendingEvent{event: event, first: now}
+		d.pending[event.Name] = p
+	} else {
+		p.event.Op |= event.Op
+		p.timer.Stop()
+	}
+
+	wait := d.quiet
+	if left := p.first.Add(d.maxWait).Sub(now); left < wait {
+		wait = max(left, 0)
+	}
+
+	p.gen++
+	f := dueFile{name: event.Name, gen: p.gen}
+	p.timer = time.AfterFunc(wait, func() {
+		select {
+		case d.due <- f:
+		case <-d.done:
+		}
+	})
+}
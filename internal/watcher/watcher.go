@@ -2,10 +2,15 @@ package watcher
 
 import (
 	"fmt"
+	"io/fs"
+	"log/slog"
+	"os"
 	"path/filepath"
 	"strings"
+	"sync"
 	"time"
 
+	"github.com/danielbehrens/cloudsync/internal/fsutil"
 	"github.com/fsnotify/fsnotify"
 )
 
@@ -14,31 +19,374 @@ type FileWatcher struct {
 	watcher       *fsnotify.Watcher
 	watchPath     string
 	eventCooldown time.Duration
-	lastEventTime map[string]time.Time
+	cooldown      *Cooldown
+	recursive     bool
+	ignoreDirs    []string
+	debouncer     *Debouncer // nil unless DebounceTrailing
+	rewatched     chan struct{}
+	ready         chan struct{} // closed once the watch path is watched
+	done          chan struct{} // closed by Close
+
+	// TriggerOps is the set of operations that cause a sync
+	TriggerOps fsnotify.Op
+
+	// IncludePatterns and ExcludePatterns select the files to sync by base
+	// name (filepath.Match syntax, ignoring case). Set them before use, or
+	// with SetPatterns while events are handled.
+	IncludePatterns []string
+	ExcludePatterns []string
+	patternsMu      sync.RWMutex
+}
+
+// DefaultTriggerOps are the operations that trigger a sync unless configured
+const DefaultTriggerOps = fsnotify.Write | fsnotify.Create
+
+var opNames = map[string]fsnotify.Op{
+	"create": fsnotify.Create,
+	"write":  fsnotify.Write,
+	"remove": fsnotify.Remove,
+	"rename": fsnotify.Rename,
+	"chmod":  fsnotify.Chmod,
+}
+
+// ParseOps converts operation names (create, write, remove, rename, chmod)
+// into an fsnotify.Op set. An empty list yields DefaultTriggerOps.
+func ParseOps(names []string) (fsnotify.Op, error) {
+	if len(names) == 0 {
+		return DefaultTriggerOps, nil
+	}
+
+	var ops fsnotify.Op
+	for _, name := range names {
+		op, ok := opNames[strings.ToLower(strings.TrimSpace(name))]
+		if !ok {
+			return 0, fmt.Errorf("unknown trigger operation %q (valid: create, write, remove, rename, chmod)", name)
+		}
+		ops |= op
+	}
+
+	return ops, nil
+}
+
+// EffectiveOp returns the operations event stands for. Rename and Remove
+// name the path that went away; if a regular file is there again by the
+// time the event is handled, something replaced it, which counts as a
+// Write too. This is how a save written atomically (to file.sav.tmp, then
+// renamed over file.sav) shows up on some platforms. The temp file's own
+// events are dropped by the include patterns.
+func EffectiveOp(event fsnotify.Event) fsnotify.Op {
+	if event.Has(fsnotify.Rename) || event.Has(fsnotify.Remove) {
+		if info, err := os.Stat(event.Name); err == nil && info.Mode().IsRegular() {
+			return event.Op | fsnotify.Write
+		}
+	}
+	return event.Op
+}
+
+// AddOptions controls how AddWithRetry waits for a watch path to appear
+type AddOptions struct {
+	// Attempts is the total number of tries; values below 1 mean one try
+	Attempts int
+
+	// Backoff is the delay after the first failure. It doubles after each
+	// further failure, up to maxAddBackoff.
+	Backoff time.Duration
+
+	// Create makes the watch path (and its parents) if it doesn't exist
+	Create bool
+}
+
+// maxAddBackoff caps the delay between AddWithRetry attempts
+const maxAddBackoff = 30 * time.Second
+
+// AddWithRetry adds path to w, retrying with exponential backoff while it
+// fails. This covers paths that are not ready yet at boot, such as a network
+// drive still mounting or a save folder the game has not created yet.
+func AddWithRetry(w *fsnotify.Watcher, path string, opts AddOptions) error {
+	delay := opts.Backoff
+	var err error
+
+	for attempt := 1; ; attempt++ {
+		if opts.Create {
+			if mkErr := os.MkdirAll(path, 0755); mkErr != nil {
+				slog.Warn("Failed to create watch path", "path", path, "err", mkErr)
+			}
+		}
+
+		if err = w.Add(path); err == nil {
+			return nil
+		}
+
+		if attempt >= opts.Attempts {
+			return fmt.Errorf("failed to watch path %s after %d attempts: %w", path, attempt, err)
+		}
+
+		slog.Warn("Watch path not ready, retrying", "path", path, "attempt", attempt, "attempts", opts.Attempts, "delay", delay, "err", err)
+		time.Sleep(delay)
+
+		delay *= 2
+		if delay > maxAddBackoff {
+			delay = maxAddBackoff
+		}
+	}
 }
 
+// Options configures a FileWatcher
+type Options struct {
+	// Recursive watches every directory under the watch path, including
+	// ones created later, instead of only the watch path itself
+	Recursive bool
+
+	// IgnoreDirs are directories under the watch path that are never
+	// watched or synced, such as a backup dir kept inside it
+	IgnoreDirs []string
+
+	// Add controls how the watch path itself is added; the zero value
+	// tries once
+	Add AddOptions
+
+	// Debounce selects how bursts of events on a file are collapsed, using
+	// the cooldown as the period. The zero value means DebounceLeading.
+	Debounce DebounceMode
+
+	// DebounceMaxWait bounds how long DebounceTrailing holds the events of
+	// a file that never goes quiet. Zero means ten times the cooldown.
+	DebounceMaxWait time.Duration
+
+	// CheckInterval is how often the watch path is checked for having gone
+	// away, e.g. with a removable drive or network share; see Rewatched.
+	// Zero turns the check off.
+	CheckInterval time.Duration
+
+	// WaitForPath tolerates a watch path that doesn't exist yet, such as a
+	// save folder the game only creates on first launch. NewFileWatcher
+	// then returns without watching it and polls until it appears: first
+	// after CheckInterval (or a second if that is zero), then ever less
+	// often, up to every 30 seconds. See Ready.
+	WaitForPath bool
+}
+
+// defaultWaitInterval is how often a missing watch path is polled for with
+// WaitForPath when no CheckInterval is set
+const defaultWaitInterval = time.Second
+
 // NewFileWatcher creates a new file watcher
-func NewFileWatcher(watchPath string, cooldown time.Duration) (*FileWatcher, error) {
+func NewFileWatcher(watchPath string, cooldown time.Duration, opts Options) (*FileWatcher, error) {
 	watcher, err := fsnotify.NewWatcher()
 	if err != nil {
 		return nil, fmt.Errorf("failed to create watcher: %w", err)
 	}
 
-	if err := watcher.Add(watchPath); err != nil {
-		watcher.Close()
-		return nil, fmt.Errorf("failed to watch path %s: %w", watchPath, err)
-	}
-
-	return &FileWatcher{
+	fw := &FileWatcher{
 		watcher:       watcher,
 		watchPath:     watchPath,
 		eventCooldown: cooldown,
-		lastEventTime: make(map[string]time.Time),
-	}, nil
+		cooldown:      NewCooldown(cooldown),
+		recursive:     opts.Recursive,
+		rewatched:     make(chan struct{}, 1),
+		ready:         make(chan struct{}),
+		done:          make(chan struct{}),
+		TriggerOps:    DefaultTriggerOps,
+
+		IncludePatterns: fsutil.DefaultIncludePatterns,
+		ExcludePatterns: fsutil.DefaultExcludePatterns,
+	}
+	for _, dir := range opts.IgnoreDirs {
+		fw.ignoreDirs = append(fw.ignoreDirs, filepath.Clean(dir))
+	}
+
+	_, statErr := os.Stat(watchPath)
+	waiting := opts.WaitForPath && !opts.Add.Create && os.IsNotExist(statErr)
+	if !waiting {
+		if err := AddWithRetry(watcher, watchPath, opts.Add); err != nil {
+			watcher.Close()
+			return nil, err
+		}
+		if fw.recursive {
+			fw.addTree(watchPath)
+		}
+		close(fw.ready)
+	}
+	if opts.Debounce == DebounceTrailing {
+		fw.debouncer = NewDebouncer(watcher.Events, cooldown, opts.DebounceMaxWait)
+		go fw.debouncer.Run()
+	}
+	if waiting {
+		go fw.awaitPath(opts.CheckInterval)
+	} else if opts.CheckInterval > 0 {
+		go fw.supervise(opts.CheckInterval)
+	}
+
+	return fw, nil
+}
+
+// awaitPath polls for a watch path that didn't exist at startup (see
+// Options.WaitForPath), watches it once it appears and closes ready. It
+// then supervises it every interval, unless interval is zero.
+func (fw *FileWatcher) awaitPath(interval time.Duration) {
+	delay := interval
+	if delay <= 0 {
+		delay = defaultWaitInterval
+	}
+
+	if !fw.rewatch(delay) {
+		return
+	}
+	slog.Info("Watch path was created, watching it", "watch", fw.watchPath)
+	close(fw.ready)
+
+	if interval > 0 {
+		fw.supervise(interval)
+	}
 }
 
-// Events returns the channel for file system events
+// Ready is closed once the watch path is watched. That is on return from
+// NewFileWatcher, unless Options.WaitForPath let it start without the
+// watch path. Changes are only reported from then on.
+func (fw *FileWatcher) Ready() <-chan struct{} {
+	return fw.ready
+}
+
+// supervise checks every interval that the watch path is still watched.
+// When it has gone away, fsnotify reports nothing more for it, so
+// supervise waits for it to return, with exponential backoff, and watches
+// it again.
+func (fw *FileWatcher) supervise(interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-fw.done:
+			return
+		case <-ticker.C:
+		}
+		if fw.watching() {
+			continue
+		}
+
+		slog.Warn("Watch path is unavailable, waiting for it to return", "path", fw.watchPath)
+		if !fw.rewatch(interval) {
+			return
+		}
+		slog.Info("Watch path is available again, watching it", "watch", fw.watchPath)
+
+		select {
+		case fw.rewatched <- struct{}{}:
+		default:
+		}
+	}
+}
+
+// watching reports whether the watch path exists and fsnotify still
+// watches it. A path deleted and created again in between exists but is
+// no longer watched. Paths are compared as files, since backends may list
+// them resolved or in another case.
+func (fw *FileWatcher) watching() bool {
+	info, err := os.Stat(fw.watchPath)
+	if err != nil {
+		return false
+	}
+	for _, path := range fw.watcher.WatchList() {
+		if watched, err := os.Stat(path); err == nil && os.SameFile(info, watched) {
+			return true
+		}
+	}
+	return false
+}
+
+// rewatch tries to watch the watch path again after delay, doubling it up
+// to maxAddBackoff after each failure. It returns false if the watcher is
+// closed first.
+func (fw *FileWatcher) rewatch(delay time.Duration) bool {
+	for {
+		select {
+		case <-fw.done:
+			return false
+		case <-time.After(delay):
+		}
+
+		if _, err := os.Stat(fw.watchPath); err == nil {
+			// Drop a watch left over from before, which reports nothing
+			fw.watcher.Remove(fw.watchPath)
+			if err := fw.watcher.Add(fw.watchPath); err == nil {
+				if fw.recursive {
+					fw.addTree(fw.watchPath)
+				}
+				return true
+			}
+		}
+
+		delay *= 2
+		if delay > maxAddBackoff {
+			delay = maxAddBackoff
+		}
+	}
+}
+
+// Rewatched receives a value each time the watch path is watched again
+// after going away (see Options.CheckInterval). Changes made meanwhile
+// were not reported, so the caller should rescan it.
+func (fw *FileWatcher) Rewatched() <-chan struct{} {
+	return fw.rewatched
+}
+
+// addTree watches every directory below root, skipping ignored ones.
+// Directories that can't be watched are logged and left out.
+func (fw *FileWatcher) addTree(root string) {
+	err := fsutil.Walk(root, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			slog.Warn("Failed to read", "path", path, "err", err)
+			return nil
+		}
+		if !d.IsDir() || path == fw.watchPath {
+			return nil
+		}
+		if fw.ignored(path) {
+			return fs.SkipDir
+		}
+		if err := fw.watcher.Add(path); err != nil {
+			slog.Warn("Failed to watch", "path", path, "err", err)
+		}
+		return nil
+	})
+	if err != nil {
+		slog.Warn("Failed to walk", "path", root, "err", err)
+	}
+}
+
+// ignored reports whether dir is, or is inside, one of the ignored dirs
+func (fw *FileWatcher) ignored(dir string) bool {
+	dir = filepath.Clean(dir)
+	for _, ignore := range fw.ignoreDirs {
+		if rel, err := filepath.Rel(ignore, dir); err == nil && rel != ".." && !strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+			return true
+		}
+	}
+	return false
+}
+
+// dirInScope reports whether files in dir are ones this watcher syncs:
+// dir is the watch path or, when recursive, below it outside the ignored dirs
+func (fw *FileWatcher) dirInScope(dir string) bool {
+	dir = filepath.Clean(dir)
+	if !fw.recursive {
+		return dir == filepath.Clean(fw.watchPath)
+	}
+
+	rel, err := filepath.Rel(fw.watchPath, dir)
+	if err != nil || rel == ".." || strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+		return false
+	}
+	return !fw.ignored(dir)
+}
+
+// Events returns the channel for file system events, debounced when
+// DebounceTrailing is set
 func (fw *FileWatcher) Events() <-chan fsnotify.Event {
+	if fw.debouncer != nil {
+		return fw.debouncer.Events()
+	}
 	return fw.watcher.Events
 }
 
@@ -49,42 +397,90 @@ func (fw *FileWatcher) Errors() <-chan error {
 
 // Close closes the file watcher
 func (fw *FileWatcher) Close() error {
+	close(fw.done)
+	if fw.debouncer != nil {
+		fw.debouncer.Stop()
+	}
 	return fw.watcher.Close()
 }
 
-// ShouldProcess determines if an event should be processed based on:
-// - File type (must be .sav, excluding EnhancedInputUserSettings.sav)
-// - Location (must be in root watch directory)
+// SetPatterns replaces IncludePatterns and ExcludePatterns. Unlike setting
+// the fields, it is safe while events are handled, and keeps the cooldown
+// state.
+func (fw *FileWatcher) SetPatterns(include, exclude []string) {
+	fw.patternsMu.Lock()
+	defer fw.patternsMu.Unlock()
+	fw.IncludePatterns = include
+	fw.ExcludePatterns = exclude
+}
+
+// matches reports whether path passes the include and exclude patterns
+func (fw *FileWatcher) matches(path string) bool {
+	fw.patternsMu.RLock()
+	defer fw.patternsMu.RUnlock()
+	return fsutil.MatchFile(path, fw.IncludePatterns, fw.ExcludePatterns)
+}
+
+// Triggers reports whether event's operation is one of TriggerOps, as
+// ShouldProcess checks first. Unlike ShouldProcess it has no side effects.
+func (fw *FileWatcher) Triggers(event fsnotify.Event) bool {
+	return EffectiveOp(event)&fw.TriggerOps != 0
+}
+
+// IsDeletion reports whether event removed a file this watcher syncs: a
+// Remove or Rename of a path matching the patterns, in the watched tree,
+// that no longer exists. TriggerOps and the cooldown don't apply.
+func (fw *FileWatcher) IsDeletion(event fsnotify.Event) bool {
+	if !event.Has(fsnotify.Remove) && !event.Has(fsnotify.Rename) {
+		return false
+	}
+	if _, err := os.Lstat(event.Name); !os.IsNotExist(err) {
+		return false
+	}
+
+	return fw.matches(event.Name) &&
+		fw.dirInScope(filepath.Dir(event.Name))
+}
+
+// ShouldProcess is safe for concurrent use. It determines if an event
+// should be processed based on:
+// - Operation (must be one of TriggerOps, see EffectiveOp for renames)
+// - File name (must pass IncludePatterns and ExcludePatterns)
+// - Location (must be in root watch directory, or below it when recursive)
 // - Cooldown period (prevents duplicate events)
+//
+// When recursive, a newly created directory is added to the watch here and
+// the event itself is not processed. Files written into it before the watch
+// took effect are picked up by the next full sync.
 func (fw *FileWatcher) ShouldProcess(event fsnotify.Event) bool {
-	// Only process write and create events
-	if event.Op&(fsnotify.Write|fsnotify.Create) == 0 {
-		return false
+	if fw.recursive && event.Has(fsnotify.Create) {
+		if info, err := os.Stat(event.Name); err == nil && info.IsDir() {
+			if fw.dirInScope(event.Name) {
+				fw.addTree(event.Name)
+			}
+			return false
+		}
 	}
 
-	// Check if it's a .sav file (excluding settings)
-	if !shouldSyncFile(event.Name) {
+	// Only process the configured trigger operations
+	if !fw.Triggers(event) {
 		return false
 	}
 
-	// Must be in root watch directory (not subdirectories)
-	if filepath.Dir(event.Name) != filepath.Clean(fw.watchPath) {
+	// Check the file name against the include and exclude patterns
+	if !fw.matches(event.Name) {
 		return false
 	}
 
-	// Check cooldown period
-	now := time.Now()
-	last, seen := fw.lastEventTime[event.Name]
-	if seen && now.Sub(last) <= fw.eventCooldown {
+	// Must be in the watched tree
+	if !fw.dirInScope(filepath.Dir(event.Name)) {
 		return false
 	}
 
-	fw.lastEventTime[event.Name] = now
-	return true
-}
-
-// shouldSyncFile determines if a file should be synced based on its name
-func shouldSyncFile(filePath string) bool {
-	name := filepath.Base(filePath)
-	return strings.HasSuffix(name, ".sav") && name != "EnhancedInputUserSettings.sav"
+	// Check cooldown period. Trailing events already stand for a whole
+	// burst.
+	if fw.debouncer != nil {
+		return true
+	}
+	return fw.cooldown.Allow(event.Name, time.Now())
 }
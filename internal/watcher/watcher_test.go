@@ -1,56 +1,22 @@
 package watcher
 
 import (
+	"fmt"
 	"os"
 	"path/filepath"
+	"sync"
+	"sync/atomic"
 	"testing"
 	"time"
 
 	"github.com/fsnotify/fsnotify"
 )
 
-func TestShouldSyncFile(t *testing.T) {
-	tests := []struct {
-		name     string
-		filePath string
-		want     bool
-	}{
-		{
-			name:     "valid sav file",
-			filePath: "/path/to/game.sav",
-			want:     true,
-		},
-		{
-			name:     "excluded settings file",
-			filePath: "/path/to/EnhancedInputUserSettings.sav",
-			want:     false,
-		},
-		{
-			name:     "non-sav file",
-			filePath: "/path/to/game.txt",
-			want:     false,
-		},
-		{
-			name:     "sav extension check",
-			filePath: "/path/to/savegame.sav",
-			want:     true,
-		},
-	}
-
-	for _, tt := range tests {
-		t.Run(tt.name, func(t *testing.T) {
-			if got := shouldSyncFile(tt.filePath); got != tt.want {
-				t.Errorf("shouldSyncFile() = %v, want %v", got, tt.want)
-			}
-		})
-	}
-}
-
 func TestNewFileWatcher(t *testing.T) {
 	// Create temp directory
 	tmpDir := t.TempDir()
 
-	fw, err := NewFileWatcher(tmpDir, time.Second)
+	fw, err := NewFileWatcher(tmpDir, time.Second, Options{})
 	if err != nil {
 		t.Fatalf("NewFileWatcher() error = %v", err)
 	}
@@ -68,7 +34,7 @@ func TestNewFileWatcher(t *testing.T) {
 func TestFileWatcherShouldProcess(t *testing.T) {
 	tmpDir := t.TempDir()
 
-	fw, err := NewFileWatcher(tmpDir, 100*time.Millisecond)
+	fw, err := NewFileWatcher(tmpDir, 100*time.Millisecond, Options{})
 	if err != nil {
 		t.Fatalf("NewFileWatcher() error = %v", err)
 	}
@@ -118,7 +84,7 @@ func TestFileWatcherShouldProcess(t *testing.T) {
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
 			// Reset cooldown state
-			delete(fw.lastEventTime, tt.event.Name)
+			fw.cooldown.forget(tt.event.Name)
 
 			if got := fw.ShouldProcess(tt.event); got != tt.want {
 				t.Errorf("ShouldProcess() = %v, want %v", got, tt.want)
@@ -131,7 +97,7 @@ func TestFileWatcherCooldown(t *testing.T) {
 	tmpDir := t.TempDir()
 	testFile := filepath.Join(tmpDir, "test.sav")
 
-	fw, err := NewFileWatcher(tmpDir, 200*time.Millisecond)
+	fw, err := NewFileWatcher(tmpDir, 200*time.Millisecond, Options{})
 	if err != nil {
 		t.Fatalf("NewFileWatcher() error = %v", err)
 	}
@@ -160,3 +126,609 @@ func TestFileWatcherCooldown(t *testing.T) {
 		t.Error("Event after cooldown should be processed")
 	}
 }
+
+func TestParseOps(t *testing.T) {
+	tests := []struct {
+		name    string
+		names   []string
+		want    fsnotify.Op
+		wantErr bool
+	}{
+		{
+			name:  "default when empty",
+			names: nil,
+			want:  fsnotify.Write | fsnotify.Create,
+		},
+		{
+			name:  "write create rename",
+			names: []string{"write", "create", "rename"},
+			want:  fsnotify.Write | fsnotify.Create | fsnotify.Rename,
+		},
+		{
+			name:  "case and whitespace insensitive",
+			names: []string{" Chmod", "WRITE "},
+			want:  fsnotify.Chmod | fsnotify.Write,
+		},
+		{
+			name:  "all operations",
+			names: []string{"create", "write", "remove", "rename", "chmod"},
+			want:  fsnotify.Create | fsnotify.Write | fsnotify.Remove | fsnotify.Rename | fsnotify.Chmod,
+		},
+		{
+			name:    "unknown operation",
+			names:   []string{"write", "modify"},
+			wantErr: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := ParseOps(tt.names)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("ParseOps() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if !tt.wantErr && got != tt.want {
+				t.Errorf("ParseOps() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestFileWatcherTriggerOps(t *testing.T) {
+	tmpDir := t.TempDir()
+	testFile := filepath.Join(tmpDir, "test.sav")
+
+	allOps := []fsnotify.Op{fsnotify.Create, fsnotify.Write, fsnotify.Remove, fsnotify.Rename, fsnotify.Chmod}
+
+	tests := []struct {
+		name       string
+		triggerOps fsnotify.Op
+	}{
+		{name: "write only", triggerOps: fsnotify.Write},
+		{name: "default write and create", triggerOps: DefaultTriggerOps},
+		{name: "write create rename", triggerOps: fsnotify.Write | fsnotify.Create | fsnotify.Rename},
+		{name: "chmod only", triggerOps: fsnotify.Chmod},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			fw, err := NewFileWatcher(tmpDir, time.Second, Options{})
+			if err != nil {
+				t.Fatalf("NewFileWatcher() error = %v", err)
+			}
+			defer fw.Close()
+			fw.TriggerOps = tt.triggerOps
+
+			for _, op := range allOps {
+				fw.cooldown.forget(testFile)
+
+				want := tt.triggerOps&op != 0
+				if got := fw.ShouldProcess(fsnotify.Event{Name: testFile, Op: op}); got != want {
+					t.Errorf("ShouldProcess(%v) = %v, want %v", op, got, want)
+				}
+			}
+		})
+	}
+}
+
+func TestAddWithRetry(t *testing.T) {
+	t.Run("path appears after a delay", func(t *testing.T) {
+		path := filepath.Join(t.TempDir(), "SaveGames")
+
+		w, err := fsnotify.NewWatcher()
+		if err != nil {
+			t.Fatalf("NewWatcher() error = %v", err)
+		}
+		defer w.Close()
+
+		go func() {
+			time.Sleep(50 * time.Millisecond)
+			os.Mkdir(path, 0755)
+		}()
+
+		if err := AddWithRetry(w, path, AddOptions{Attempts: 10, Backoff: 20 * time.Millisecond}); err != nil {
+			t.Fatalf("AddWithRetry() error = %v", err)
+		}
+	})
+
+	t.Run("gives up after the configured attempts", func(t *testing.T) {
+		path := filepath.Join(t.TempDir(), "missing")
+
+		w, err := fsnotify.NewWatcher()
+		if err != nil {
+			t.Fatalf("NewWatcher() error = %v", err)
+		}
+		defer w.Close()
+
+		if err := AddWithRetry(w, path, AddOptions{Attempts: 3, Backoff: time.Millisecond}); err == nil {
+			t.Fatal("AddWithRetry() error = nil, want error")
+		}
+	})
+
+	t.Run("creates the watch path", func(t *testing.T) {
+		path := filepath.Join(t.TempDir(), "Saved", "SaveGames")
+
+		w, err := fsnotify.NewWatcher()
+		if err != nil {
+			t.Fatalf("NewWatcher() error = %v", err)
+		}
+		defer w.Close()
+
+		if err := AddWithRetry(w, path, AddOptions{Attempts: 1, Create: true}); err != nil {
+			t.Fatalf("AddWithRetry() error = %v", err)
+		}
+		if info, err := os.Stat(path); err != nil || !info.IsDir() {
+			t.Errorf("watch path was not created: %v", err)
+		}
+	})
+}
+
+func TestFileWatcherPatterns(t *testing.T) {
+	tmpDir := t.TempDir()
+
+	fw, err := NewFileWatcher(tmpDir, 0, Options{})
+	if err != nil {
+		t.Fatalf("NewFileWatcher() error = %v", err)
+	}
+	defer fw.Close()
+
+	fw.IncludePatterns = []string{"*.sav", "*.dat"}
+	fw.ExcludePatterns = []string{"autosave_*"}
+
+	tests := []struct {
+		file string
+		want bool
+	}{
+		{"profile.dat", true},
+		{"game.sav", true},
+		{"autosave_1.sav", false},
+		{"EnhancedInputUserSettings.sav", true},
+		{"notes.txt", false},
+	}
+
+	for _, tt := range tests {
+		event := fsnotify.Event{Name: filepath.Join(tmpDir, tt.file), Op: fsnotify.Write}
+		if got := fw.ShouldProcess(event); got != tt.want {
+			t.Errorf("ShouldProcess(%s) = %v, want %v", tt.file, got, tt.want)
+		}
+	}
+}
+
+func TestFileWatcherSetPatterns(t *testing.T) {
+	tmpDir := t.TempDir()
+
+	fw, err := NewFileWatcher(tmpDir, time.Minute, Options{})
+	if err != nil {
+		t.Fatalf("NewFileWatcher() error = %v", err)
+	}
+	defer fw.Close()
+
+	save := fsnotify.Event{Name: filepath.Join(tmpDir, "game.sav"), Op: fsnotify.Write}
+	world := fsnotify.Event{Name: filepath.Join(tmpDir, "world.dat"), Op: fsnotify.Write}
+	if !fw.ShouldProcess(save) {
+		t.Fatal("ShouldProcess(game.sav) = false, want true")
+	}
+	if fw.ShouldProcess(world) {
+		t.Fatal("ShouldProcess(world.dat) = true before SetPatterns, want false")
+	}
+
+	fw.SetPatterns([]string{"*.sav", "*.dat"}, nil)
+	if !fw.ShouldProcess(world) {
+		t.Error("ShouldProcess(world.dat) = false after SetPatterns, want true")
+	}
+	// The cooldown of game.sav outlives the new patterns
+	if fw.ShouldProcess(save) {
+		t.Error("ShouldProcess(game.sav) = true within its cooldown, want false")
+	}
+}
+
+func TestFileWatcherRecursive(t *testing.T) {
+	tmpDir := t.TempDir()
+	backupDir := filepath.Join(tmpDir, "Backup")
+	profileDir := filepath.Join(tmpDir, "Profile1")
+	for _, dir := range []string{backupDir, profileDir} {
+		if err := os.Mkdir(dir, 0755); err != nil {
+			t.Fatalf("Mkdir() error = %v", err)
+		}
+	}
+
+	fw, err := NewFileWatcher(tmpDir, 0, Options{Recursive: true, IgnoreDirs: []string{backupDir}})
+	if err != nil {
+		t.Fatalf("NewFileWatcher() error = %v", err)
+	}
+	defer fw.Close()
+
+	watched := make(map[string]bool)
+	for _, p := range fw.watcher.WatchList() {
+		watched[p] = true
+	}
+	if !watched[profileDir] {
+		t.Errorf("existing subdirectory %s not watched", profileDir)
+	}
+	if watched[backupDir] {
+		t.Errorf("ignored directory %s is watched", backupDir)
+	}
+
+	// A directory created later is added when its Create event arrives
+	newDir := filepath.Join(tmpDir, "Profile2")
+	if err := os.Mkdir(newDir, 0755); err != nil {
+		t.Fatalf("Mkdir() error = %v", err)
+	}
+	if fw.ShouldProcess(fsnotify.Event{Name: newDir, Op: fsnotify.Create}) {
+		t.Error("directory Create event should not be processed as a file")
+	}
+
+	found := false
+	for _, p := range fw.watcher.WatchList() {
+		found = found || p == newDir
+	}
+	if !found {
+		t.Errorf("new subdirectory %s not watched", newDir)
+	}
+
+	tests := []struct {
+		path string
+		want bool
+	}{
+		{filepath.Join(profileDir, "game.sav"), true},
+		{filepath.Join(newDir, "game.sav"), true},
+		{filepath.Join(tmpDir, "root.sav"), true},
+		{filepath.Join(backupDir, "game.sav"), false},
+		{filepath.Join(filepath.Dir(tmpDir), "outside.sav"), false},
+	}
+
+	for _, tt := range tests {
+		if got := fw.ShouldProcess(fsnotify.Event{Name: tt.path, Op: fsnotify.Write}); got != tt.want {
+			t.Errorf("ShouldProcess(%s) = %v, want %v", tt.path, got, tt.want)
+		}
+	}
+}
+
+func TestFileWatcherAtomicSave(t *testing.T) {
+	tmpDir := t.TempDir()
+	target := filepath.Join(tmpDir, "test.sav")
+	temp := target + ".tmp"
+
+	fw, err := NewFileWatcher(tmpDir, time.Second, Options{})
+	if err != nil {
+		t.Fatalf("NewFileWatcher() error = %v", err)
+	}
+	defer fw.Close()
+
+	// Written to a temp file first: its events never sync
+	if err := os.WriteFile(temp, []byte("save"), 0644); err != nil {
+		t.Fatalf("WriteFile() error = %v", err)
+	}
+	for _, op := range []fsnotify.Op{fsnotify.Create, fsnotify.Write} {
+		if fw.ShouldProcess(fsnotify.Event{Name: temp, Op: op}) {
+			t.Errorf("ShouldProcess(%v %s) = true, want false", op, filepath.Base(temp))
+		}
+	}
+
+	// A Remove for the target before it is replaced is dropped
+	if fw.ShouldProcess(fsnotify.Event{Name: target, Op: fsnotify.Remove}) {
+		t.Error("ShouldProcess(Remove) for missing target = true, want false")
+	}
+
+	if err := os.Rename(temp, target); err != nil {
+		t.Fatalf("Rename() error = %v", err)
+	}
+	if fw.ShouldProcess(fsnotify.Event{Name: temp, Op: fsnotify.Rename}) {
+		t.Error("ShouldProcess(Rename) for temp file = true, want false")
+	}
+
+	// Once replaced, Rename and Remove naming the target count as a write,
+	// even when only writes trigger a sync
+	fw.TriggerOps = fsnotify.Write
+	for _, op := range []fsnotify.Op{fsnotify.Rename, fsnotify.Remove} {
+		fw.cooldown.forget(target)
+		if !fw.ShouldProcess(fsnotify.Event{Name: target, Op: op}) {
+			t.Errorf("ShouldProcess(%v) for replaced target = false, want true", op)
+		}
+	}
+}
+
+// TestFileWatcherShouldProcessConcurrent is meant for -race: many
+// goroutines deliver events for overlapping names at once
+func TestFileWatcherShouldProcessConcurrent(t *testing.T) {
+	tmpDir := t.TempDir()
+
+	fw, err := NewFileWatcher(tmpDir, time.Hour, Options{})
+	if err != nil {
+		t.Fatalf("NewFileWatcher() error = %v", err)
+	}
+	defer fw.Close()
+
+	const goroutines, names = 16, 10
+	var processed atomic.Int32
+	var wg sync.WaitGroup
+	for g := range goroutines {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range 200 {
+				event := fsnotify.Event{
+					Name: filepath.Join(tmpDir, fmt.Sprintf("save%d.sav", (g+i)%names)),
+					Op:   fsnotify.Write,
+				}
+				if fw.ShouldProcess(event) {
+					processed.Add(1)
+				}
+			}
+		}()
+	}
+	wg.Wait()
+
+	// Within the cooldown each name gets through exactly once
+	if got := processed.Load(); got != names {
+		t.Errorf("processed %d events, want %d", got, names)
+	}
+}
+
+func TestCooldownEviction(t *testing.T) {
+	c := NewCooldown(time.Second)
+	start := time.Now()
+
+	for i := range 100 {
+		c.Allow(fmt.Sprintf("old%d.sav", i), start)
+	}
+	if got := c.len(); got != 100 {
+		t.Fatalf("len = %d, want 100", got)
+	}
+
+	// Entries idle past the eviction age are dropped by a later event;
+	// recent ones are kept
+	c.Allow("recent.sav", start.Add(minEvictAge+30*time.Second))
+	later := start.Add(2*minEvictAge + time.Second)
+	if !c.Allow("new.sav", later) {
+		t.Error("Allow(new.sav) = false, want true")
+	}
+	if got := c.len(); got != 2 {
+		t.Errorf("len after eviction = %d, want 2 (recent.sav, new.sav)", got)
+	}
+
+	// Eviction never shortens the cooldown itself
+	if c.Allow("new.sav", later.Add(time.Second/2)) {
+		t.Error("Allow(new.sav) within cooldown = true, want false")
+	}
+}
+
+func TestFileWatcherIsDeletion(t *testing.T) {
+	tmpDir := t.TempDir()
+
+	fw, err := NewFileWatcher(tmpDir, 100*time.Millisecond, Options{})
+	if err != nil {
+		t.Fatalf("NewFileWatcher() error = %v", err)
+	}
+	defer fw.Close()
+
+	present := filepath.Join(tmpDir, "present.sav")
+	if err := os.WriteFile(present, []byte("save"), 0644); err != nil {
+		t.Fatalf("WriteFile() error = %v", err)
+	}
+	gone := filepath.Join(tmpDir, "gone.sav")
+
+	tests := []struct {
+		name  string
+		event fsnotify.Event
+		want  bool
+	}{
+		{name: "removed save", event: fsnotify.Event{Name: gone, Op: fsnotify.Remove}, want: true},
+		{name: "renamed away", event: fsnotify.Event{Name: gone, Op: fsnotify.Rename}, want: true},
+		{name: "replaced by atomic save", event: fsnotify.Event{Name: present, Op: fsnotify.Rename}, want: false},
+		{name: "write", event: fsnotify.Event{Name: gone, Op: fsnotify.Write}, want: false},
+		{name: "excluded name", event: fsnotify.Event{Name: filepath.Join(tmpDir, "EnhancedInputUserSettings.sav"), Op: fsnotify.Remove}, want: false},
+		{name: "other file type", event: fsnotify.Event{Name: filepath.Join(tmpDir, "notes.txt"), Op: fsnotify.Remove}, want: false},
+		{name: "subdirectory", event: fsnotify.Event{Name: filepath.Join(tmpDir, "sub", "gone.sav"), Op: fsnotify.Remove}, want: false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := fw.IsDeletion(tt.event); got != tt.want {
+				t.Errorf("IsDeletion() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestDebouncerTrailing(t *testing.T) {
+	in := make(chan fsnotify.Event)
+	d := NewDebouncer(in, 50*time.Millisecond, time.Second)
+	go d.Run()
+	defer d.Stop()
+
+	// A burst of writes on one file, with a write to another in between
+	start := time.Now()
+	in <- fsnotify.Event{Name: "a.sav", Op: fsnotify.Create}
+	for range 3 {
+		time.Sleep(20 * time.Millisecond)
+		in <- fsnotify.Event{Name: "a.sav", Op: fsnotify.Write}
+	}
+	in <- fsnotify.Event{Name: "b.sav", Op: fsnotify.Write}
+	last := time.Now()
+
+	got := make(map[string]fsnotify.Op)
+	for range 2 {
+		select {
+		case event := <-d.Events():
+			if _, dup := got[event.Name]; dup {
+				t.Errorf("%s passed more than once", event.Name)
+			}
+			got[event.Name] = event.Op
+		case <-time.After(2 * time.Second):
+			t.Fatalf("debounced events = %v, want a.sav and b.sav", got)
+		}
+	}
+	if since := time.Since(last); since < 40*time.Millisecond {
+		t.Errorf("events passed %v after the burst, want after the quiet period", since)
+	}
+	if want := fsnotify.Create | fsnotify.Write; got["a.sav"] != want {
+		t.Errorf("a.sav op = %v, want the merged %v", got["a.sav"], want)
+	}
+
+	select {
+	case event := <-d.Events():
+		t.Errorf("unexpected event %v after the burst started at %v", event, start)
+	case <-time.After(100 * time.Millisecond):
+	}
+}
+
+func TestDebouncerMaxWait(t *testing.T) {
+	in := make(chan fsnotify.Event)
+	d := NewDebouncer(in, 50*time.Millisecond, 150*time.Millisecond)
+	go d.Run()
+	defer d.Stop()
+
+	// A file written more often than the quiet period still passes
+	passed := make(chan time.Time, 10)
+	go func() {
+		for range d.Events() {
+			passed <- time.Now()
+		}
+	}()
+
+	start := time.Now()
+	for time.Since(start) < 500*time.Millisecond {
+		in <- fsnotify.Event{Name: "busy.sav", Op: fsnotify.Write}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	select {
+	case at := <-passed:
+		if waited := at.Sub(start); waited > 400*time.Millisecond {
+			t.Errorf("first event passed after %v, want within about the 150ms max wait", waited)
+		}
+	default:
+		t.Fatal("a file that never went quiet never passed")
+	}
+}
+
+func TestFileWatcherRewatch(t *testing.T) {
+	tmpDir := filepath.Join(t.TempDir(), "SaveGames")
+	if err := os.Mkdir(tmpDir, 0755); err != nil {
+		t.Fatal(err)
+	}
+
+	fw, err := NewFileWatcher(tmpDir, 0, Options{CheckInterval: 20 * time.Millisecond})
+	if err != nil {
+		t.Fatalf("NewFileWatcher() error = %v", err)
+	}
+	defer fw.Close()
+
+	// The drive goes away and comes back
+	if err := os.RemoveAll(tmpDir); err != nil {
+		t.Fatal(err)
+	}
+	time.Sleep(100 * time.Millisecond)
+	if err := os.Mkdir(tmpDir, 0755); err != nil {
+		t.Fatal(err)
+	}
+
+	select {
+	case <-fw.Rewatched():
+	case <-time.After(5 * time.Second):
+		t.Fatal("watch path was not watched again")
+	}
+
+	testFile := filepath.Join(tmpDir, "test.sav")
+	if err := os.WriteFile(testFile, []byte("data"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	deadline := time.After(2 * time.Second)
+	for {
+		select {
+		case event := <-fw.Events():
+			if event.Name == testFile {
+				return
+			}
+		case <-deadline:
+			t.Fatal("no event for a file written after the rewatch")
+		}
+	}
+}
+
+func TestFileWatcherWaitForPath(t *testing.T) {
+	tmpDir := filepath.Join(t.TempDir(), "SaveGames")
+
+	fw, err := NewFileWatcher(tmpDir, 0, Options{WaitForPath: true, CheckInterval: 20 * time.Millisecond})
+	if err != nil {
+		t.Fatalf("NewFileWatcher() error = %v", err)
+	}
+	defer fw.Close()
+
+	select {
+	case <-fw.Ready():
+		t.Fatal("Ready() closed before the watch path exists")
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	// The game is launched for the first time
+	if err := os.Mkdir(tmpDir, 0755); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case <-fw.Ready():
+	case <-time.After(5 * time.Second):
+		t.Fatal("watch path was not watched once created")
+	}
+
+	testFile := filepath.Join(tmpDir, "test.sav")
+	if err := os.WriteFile(testFile, []byte("data"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	deadline := time.After(2 * time.Second)
+	for {
+		select {
+		case event := <-fw.Events():
+			if event.Name == testFile {
+				return
+			}
+		case <-deadline:
+			t.Fatal("no event for a file written after the watch path was created")
+		}
+	}
+}
+
+func TestFileWatcherMissingPath(t *testing.T) {
+	tmpDir := filepath.Join(t.TempDir(), "SaveGames")
+	if _, err := NewFileWatcher(tmpDir, 0, Options{}); err == nil {
+		t.Error("NewFileWatcher() on a missing path without WaitForPath error = nil, want error")
+	}
+}
+
+func TestFileWatcherTrailingDebounce(t *testing.T) {
+	tmpDir := t.TempDir()
+	testFile := filepath.Join(tmpDir, "test.sav")
+
+	fw, err := NewFileWatcher(tmpDir, 50*time.Millisecond, Options{Debounce: DebounceTrailing})
+	if err != nil {
+		t.Fatalf("NewFileWatcher() error = %v", err)
+	}
+	defer fw.Close()
+
+	for i := range 3 {
+		if err := os.WriteFile(testFile, []byte(fmt.Sprint("part ", i)), 0644); err != nil {
+			t.Fatal(err)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	select {
+	case event := <-fw.Events():
+		if event.Name != testFile || !fw.ShouldProcess(event) {
+			t.Errorf("debounced event %v not processed", event)
+		}
+		// Each debounced event stands for a burst, so no cooldown applies
+		if !fw.ShouldProcess(event) {
+			t.Error("second debounced event blocked by the cooldown")
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("no debounced event")
+	}
+
+	select {
+	case event := <-fw.Events():
+		t.Errorf("got a second event %v for one burst", event)
+	case <-time.After(150 * time.Millisecond):
+	}
+}
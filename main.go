@@ -2,353 +2,153 @@ package main
 
 import (
 	"context"
-	"fmt"
-	"io"
-	"log"
+	"log/slog"
 	"os"
-	"path/filepath"
-	"strconv"
-	"strings"
+	"os/signal"
+	"syscall"
 	"time"
 
-	"github.com/fsnotify/fsnotify"
-	"github.com/minio/minio-go/v7"
-	"github.com/shirou/gopsutil/v4/process"
+	"github.com/danielbehrens/cloudsync/internal/api"
+	"github.com/danielbehrens/cloudsync/internal/metrics"
+	"github.com/danielbehrens/cloudsync/internal/version"
 )
 
 const (
-	timeTolerance = 500 * time.Millisecond
 	eventCooldown = 1 * time.Second
+
+	// watchCheckInterval is how often each watch path is checked for
+	// having gone away, such as an unplugged drive
+	watchCheckInterval = 5 * time.Second
 )
 
-var (
-	lastEventTime = make(map[string]time.Time)
+// Exit codes reported to wrapper scripts and schedulers
+const (
+	exitOK           = 0
+	exitSync         = 1 // sync could not run (watch path unreadable, listing failed)
+	exitConfig       = 2 // invalid flags, watch path or endpoint, as the flag package uses
+	exitConnectivity = 3 // storage unreachable or credentials rejected
+	exitOutOfSync    = 4 // -status found files that aren't in sync
+	exitSynced       = 5 // -once transferred files; exitOK means there was nothing to do
+	exitMismatch     = 6 // verify found saves whose cloud copy differs or is missing
 )
 
 func main() {
-	client, watcher := configure()
-	defer watcher.Close()
-	ctx := context.Background()
-
-	log.Print("starting cloudsync")
-	defer log.Print("closing cloudsync")
-	log.Printf("Watching %s for changes...", watchPath)
-	log.Println("Performing initial sync...")
-	localAndCloudSync(ctx, client)
-	log.Println("Initial sync complete.")
-
-	for {
-		select {
-		case event := <-watcher.Events:
-			if event.Op&(fsnotify.Write|fsnotify.Create) != 0 {
-				if processName != "" && isProcessRunning(processName) {
-					log.Printf("Process '%s' is running. Sync paused.", processName)
-					continue
-				}
-
-				if shouldSyncFile(event.Name) && filepath.Dir(event.Name) == filepath.Clean(watchPath) {
-					now := time.Now().UTC()
-					last, seen := lastEventTime[event.Name]
-					if !seen || now.Sub(last) > eventCooldown {
-						log.Printf("Detected change: %s", event.Name)
-						lastEventTime[event.Name] = now
-						checkCloudAndSync(ctx, client, event.Name)
-					}
-				}
-			}
-		case err := <-watcher.Errors:
-			log.Println("Watcher error:", err)
-		case <-time.After(time.Second * 10):
-			if processName != "" && !isProcessRunning(processName) {
-				localAndCloudSync(ctx, client)
-			}
-		case <-ctx.Done():
-			return
-		}
-	}
+	os.Exit(run())
 }
 
-func isProcessRunning(name string) bool {
-	processes, err := process.Processes()
-	if err != nil {
-		log.Printf("Error listing processes: %v", err)
-		return false
+// run runs cloudsync and returns its exit code. Errors are logged here, on
+// stderr, rather than exiting deep in helpers, so deferred cleanup runs.
+func run() int {
+	if len(os.Args) > 1 && os.Args[1] == "version" {
+		version.Print(os.Stdout)
+		return exitOK
 	}
-
-	name = strings.ToLower(name)
-
-	for _, p := range processes {
-		exeName, err := p.Name()
-		if err == nil && strings.Contains(strings.ToLower(exeName), name) {
-			return true
-		}
+	if len(os.Args) > 1 && (os.Args[1] == "init" || os.Args[1] == "-init" || os.Args[1] == "--init") {
+		return runInit(os.Args[2:], os.Stdin, os.Stdout, isTerminal(os.Stdin))
 	}
-	return false
-}
 
-func shouldSyncFile(filePath string) bool {
-	name := filepath.Base(filePath)
-	return strings.HasSuffix(name, ".sav") && name != "EnhancedInputUserSettings.sav"
-}
-
-func localAndCloudSync(ctx context.Context, client *minio.Client) {
-	// Upload local files if newer
-	entries, err := os.ReadDir(watchPath)
+	cfg, err := loadConfig()
 	if err != nil {
-		log.Printf("Failed to read watch directory: %v", err)
-		return
-	}
-
-	for _, entry := range entries {
-		if entry.IsDir() {
-			continue
-		}
-		path := filepath.Join(watchPath, entry.Name())
-		if shouldSyncFile(path) {
-			checkCloudAndSync(ctx, client, path)
-		}
-	}
-
-	// Download newer files from cloud
-	syncFromCloud(ctx, client)
-}
-
-func syncFromCloud(ctx context.Context, client *minio.Client) {
-	objectCh := client.ListObjects(ctx, bucketName, minio.ListObjectsOptions{Recursive: true})
-
-	for object := range objectCh {
-		if object.Err != nil {
-			log.Printf("Error listing object: %v", object.Err)
-			continue
-		}
-
-		objectName := object.Key
-		if !shouldSyncFile(objectName) {
-			continue
-		}
-
-		localPath := filepath.Join(watchPath, filepath.Base(objectName))
-		localInfo, err := os.Stat(localPath)
-		if err != nil && !os.IsNotExist(err) {
-			log.Printf("Failed to stat local file %s: %v", localPath, err)
-			continue
-		}
-
-		// Fetch full metadata (including custom mod time)
-		stat, err := client.StatObject(ctx, bucketName, objectName, minio.StatObjectOptions{})
+		slog.Error("invalid configuration", "err", err)
+		return exitConfig
+	}
+	if cfg.ShowVersion {
+		version.Print(os.Stdout)
+		return exitOK
+	}
+
+	// SIGINT/SIGTERM cancel ctx, which aborts transfers in flight
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	sigs := make(chan os.Signal, 1)
+	signal.Notify(sigs, os.Interrupt, syscall.SIGTERM)
+	defer signal.Stop(sigs)
+	go shutdownWatchdog(sigs, cancel, cfg.ShutdownGrace)
+
+	switch {
+	case cfg.Doctor:
+		return runDoctor(ctx, cfg, os.Stdout)
+	case cfg.HistoryFile != "":
+		return runHistory(ctx, cfg, os.Stdout)
+	case cfg.ShareFile != "":
+		return runShare(ctx, cfg, os.Stdout)
+	case cfg.ImportDir != "":
+		return runImport(ctx, cfg)
+	case cfg.ExportManifest != "":
+		return runExportManifest(ctx, cfg)
+	case cfg.DedupeCloud:
+		return runDedupeCloud(ctx, cfg, os.Stdin, os.Stdout)
+	case cfg.PruneCloud:
+		return runPruneCloud(ctx, cfg, os.Stdin, os.Stdout)
+	case cfg.RestoreGood != "":
+		return runRestoreGood(cfg)
+	case cfg.ListBackups:
+		return runListBackups(cfg, os.Stdout)
+	case cfg.RestoreBackup != "":
+		return runRestoreBackup(cfg, os.Stdout)
+	case cfg.Status:
+		return runStatus(ctx, cfg, os.Stdout)
+	case cfg.Verify:
+		return runVerify(ctx, cfg, os.Stdout)
+	case cfg.Once:
+		return runOnce(ctx, cfg)
+	}
+
+	slog.Info("starting cloudsync")
+	defer slog.Info("closing cloudsync")
+
+	// Each watch runs on its own; one that can't start stops the others
+	watchCtx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	if cfg.MetricsAddr != "" {
+		ln, err := metrics.Listen(cfg.MetricsAddr)
 		if err != nil {
-			log.Printf("Failed to stat object %s: %v", objectName, err)
-			continue
-		}
-
-		cloudModTime := getCloudModTime(stat)
-
-		// If file doesn't exist locally or is older than cloud, replace it
-		if localInfo == nil || cloudModTime.Sub(localInfo.ModTime().UTC()) > timeTolerance {
-			log.Printf("Cloud has newer version of %s. Downloading and replacing...", objectName)
-			tempPath := filepath.Join(os.TempDir(), objectName+".download")
-			err := client.FGetObject(ctx, bucketName, objectName, tempPath, minio.GetObjectOptions{})
-			if err != nil {
-				log.Printf("Failed to download %s: %v", objectName, err)
-				continue
-			}
-
-			// Replace local file
-			backupAndReplace(localPath, tempPath)
-
-			// Restore original mod time from cloud
-			os.Chtimes(localPath, cloudModTime, cloudModTime)
-		}
-	}
-}
-
-func checkCloudAndSync(ctx context.Context, client *minio.Client, filePath string) {
-	info, err := os.Stat(filePath)
-	if err != nil || info.IsDir() {
-		return
-	}
-
-	objectName := filepath.Base(filePath)
-
-	// Fetch cloud metadata
-	stat, err := client.StatObject(ctx, bucketName, objectName, minio.StatObjectOptions{})
-	if err == nil {
-		cloudModTime := getCloudModTime(stat)
-		localFileTime := info.ModTime().UTC()
-		diff := cloudModTime.Sub(localFileTime)
-		if diff > timeTolerance {
-			log.Printf("file compare cloudtime: %v localfiletime: %v", cloudModTime, localFileTime)
-			log.Printf("Cloud has newer version of %s. Downloading...", objectName)
-			tempPath := filepath.Join(os.TempDir(), objectName+".cloud")
-			err := client.FGetObject(ctx, bucketName, objectName, tempPath, minio.GetObjectOptions{})
-			if err == nil {
-				backupAndReplace(filePath, tempPath)
-
-				// Restore original mod time from cloud
-				os.Chtimes(filePath, cloudModTime, cloudModTime)
-			}
-			return
-		} else if diff < -timeTolerance {
-			log.Printf("file compare cloudtime: %v localfiletime: %v", cloudModTime, localFileTime)
-			log.Printf("Local file %s is newer. Uploading...", objectName)
-			backupAndUpload(ctx, client, filePath)
-			return
-		} else {
-			// Times are close enough — no sync needed
-			return
+			slog.Error("cannot serve metrics", "err", err)
+			return exitConfig
 		}
+		registry = metrics.New()
+		go registry.Serve(watchCtx, ln)
 	}
 
-	// Otherwise, upload local file
-	backupAndUpload(ctx, client, filePath)
-}
-
-func backupAndReplace(localPath, newPath string) {
-	if fileExists(localPath) {
-		backupPath, err := createBackupTimeFolder()
-		if err != nil {
-			log.Printf("failed to create backup folder: %v", err)
-			return
-		}
-
-		backupFile := filepath.Join(backupPath, filepath.Base(localPath))
-		err = copyFile(localPath, backupFile)
+	controlAPI = api.New()
+	if cfg.APIAddr != "" {
+		ln, err := api.Listen(cfg.APIAddr)
 		if err != nil {
-			log.Printf("failed to backup file %s to %s", localPath, backupFile)
-			return
+			slog.Error("cannot serve the control API", "err", err)
+			return exitConfig
 		}
+		go controlAPI.Serve(watchCtx, ln)
 	}
-	err := copyFile(newPath, localPath)
-	if err != nil {
-		log.Printf("failed to copy cloud file %s to %s", newPath, localPath)
-		return
-	}
+	go handlePauseSignals(watchCtx, controlAPI)
 
-	log.Printf("replaced %s with cloud version", localPath)
-}
-
-func fileExists(path string) bool {
-	_, err := os.Stat(path)
-	return err == nil
-}
-
-func backupAndUpload(ctx context.Context, client *minio.Client, filePath string) {
-	backupPath, err := createBackupTimeFolder()
-	if err != nil {
-		log.Printf("failed to create backup folder: %v", err)
-		return
-	}
-
-	fileName := filepath.Base(filePath)
-	backupFile := filepath.Join(backupPath, fileName)
-
-	err = copyFile(filePath, backupFile)
-	if err != nil {
-		log.Printf("failed to backup file: %s", err)
-	}
-
-	uploadToCloud(ctx, client, filePath, fileName)
-}
-
-func createBackupTimeFolder() (string, error) {
-	err := ensureFolderExists(backupDir)
-	if err != nil {
-		return "", err
-	}
-
-	timeFolder := time.Now().Format("2006-01-02_15-04-05.000000")
-	backupPath := filepath.Join(backupDir, timeFolder)
-	err = os.Mkdir(backupPath, os.ModePerm)
-	return backupPath, err
-}
-
-func uploadToCloud(ctx context.Context, client *minio.Client, filePath, objectName string) {
-	exists, err := client.BucketExists(ctx, bucketName)
-	if err != nil {
-		log.Printf("error checking bucket: %v", err)
-		return
-	}
-	if !exists {
-		err = client.MakeBucket(ctx, bucketName, minio.MakeBucketOptions{})
-		if err != nil {
-			log.Printf("failed to create bucket: %v", err)
-			return
-		}
-	}
-
-	modTime := time.Now().UTC()
-	info, err := os.Stat(filePath)
-	if err == nil {
-		modTime = info.ModTime().UTC()
-	}
-
-	// Store full Unix nanoseconds timestamp in metadata
-	userMeta := map[string]string{
-		"X-Amz-Meta-Modtime":       fmt.Sprintf("%d", modTime.UnixNano()),
-		"X-Amz-Meta-ModtimeString": fmt.Sprintf("%v", modTime.Format("2006-01-02_15-04-05.000000")),
-	}
-
-	_, err = client.FPutObject(ctx, bucketName, objectName, filePath, minio.PutObjectOptions{
-		UserMetadata: userMeta,
-	})
-	if err != nil {
-		log.Printf("Failed to upload file: %v", err)
-	} else {
-		log.Printf("Uploaded file %s to bucket %s", objectName, bucketName)
-	}
-}
-
-func copyFile(src, dst string) error {
-	srcFile, err := os.Open(src)
-	if err != nil {
-		return err
-	}
-	defer srcFile.Close()
-
-	dstFile, err := os.Create(dst)
-	if err != nil {
-		return err
-	}
-	defer dstFile.Close()
+	// SIGHUP reloads the -config file
+	hup := make(chan os.Signal, 1)
+	signal.Notify(hup, syscall.SIGHUP)
+	defer signal.Stop(hup)
 
-	_, err = io.Copy(dstFile, srcFile)
-	return err
-}
+	code := newDaemon(watchCtx, cancel, cfg).run(hup)
 
-func ensureFolderExists(path string) error {
-	info, err := os.Stat(path)
-	if os.IsNotExist(err) {
-		// Folder does not exist, create it
-		err = os.MkdirAll(path, os.ModePerm)
-		if err != nil {
-			return err
-		}
-		log.Printf("Created folder: %s", path)
-		return nil
-	}
-	if err != nil {
-		return err
+	// Let the last notifications go out before exiting
+	if notifier != nil {
+		notifier.Wait()
 	}
-	if !info.IsDir() {
-		return fmt.Errorf("path exists but is not a folder: %s", path)
-	}
-	// Folder already exists
-	return nil
+	return code
 }
 
-func getCloudModTime(stat minio.ObjectInfo) time.Time {
-	cloudModTime := stat.LastModified
-
-	rawModTime := stat.UserMetadata["Modtime"]
-	if rawModTime != "" {
-		ts, err := strconv.ParseInt(rawModTime, 10, 64)
-		if err != nil {
-			log.Printf("Invalid modtime metadata %q: %v", rawModTime, err)
-		} else {
-			// Convert nanoseconds since epoch to time.Time
-			cloudModTime = time.Unix(0, ts)
-		}
-	}
-
-	return cloudModTime.UTC()
+// shutdownWatchdog waits for a signal on sigs, then cancels the main loop
+// and gives it grace to finish before forcing the process to exit. It
+// waits on sigs rather than the context, which is also cancelled when run
+// returns normally.
+func shutdownWatchdog(sigs chan os.Signal, cancel context.CancelFunc, grace time.Duration) {
+	<-sigs
+	slog.Info("shutting down gracefully")
+	cancel()
+
+	// Restore default signal handling so a second Ctrl+C exits at once
+	signal.Stop(sigs)
+
+	time.Sleep(grace)
+	slog.Error("shutdown took too long, forcing exit", "grace", grace)
+	os.Exit(exitSync)
 }
@@ -0,0 +1,82 @@
+package main
+
+import (
+	"context"
+	"log/slog"
+	"sync/atomic"
+
+	"github.com/danielbehrens/cloudsync/internal/config"
+	"github.com/danielbehrens/cloudsync/internal/lock"
+)
+
+// onceCounts counts the outcome of a -once run. It implements sync.Metrics.
+type onceCounts struct {
+	transferred atomic.Int64
+	failed      atomic.Int64
+}
+
+func (c *onceCounts) Transferred(direction string, size int64) { c.transferred.Add(1) }
+func (c *onceCounts) BackedUp()                                {}
+func (c *onceCounts) Synced()                                  {}
+func (c *onceCounts) SyncFailed()                              { c.failed.Add(1) }
+
+// runOnce runs one full sync of every watch and exits, for running from a
+// scheduler instead of as a daemon. It returns exitOK if everything was
+// already in sync, exitSynced if files were transferred, exitSync if any
+// file failed, or the code of the watch that could not sync at all.
+func runOnce(ctx context.Context, cfg *config.Config) int {
+	counts := &onceCounts{}
+	for _, w := range cfg.Watches {
+		if code := syncWatchOnce(ctx, cfg, w, counts); code != exitOK {
+			return code
+		}
+	}
+
+	transferred, failed := counts.transferred.Load(), counts.failed.Load()
+	slog.Info("Sync finished", "transferred", transferred, "failed", failed)
+	switch {
+	case failed > 0:
+		return exitSync
+	case transferred > 0:
+		return exitSynced
+	default:
+		return exitOK
+	}
+}
+
+// syncWatchOnce runs one full sync of w, adding its outcome to counts
+func syncWatchOnce(ctx context.Context, cfg *config.Config, w config.WatchConfig, counts *onceCounts) int {
+	// A daemon on the same folders would fight this run over uploads
+	lk, err := lock.Acquire(w.BackupDir)
+	if err != nil {
+		slog.Error("cannot sync watch", "watch", w.WatchPath, "err", err)
+		return exitConfig
+	}
+	defer lk.Release()
+
+	syncer, err := newWatchSyncer(cfg, w)
+	if err != nil {
+		slog.Error("could not create storage client", "watch", w.WatchPath, "err", err)
+		return exitConfig
+	}
+	syncer.Metrics = counts
+
+	if reason := syncer.PauseReason(); reason != "" {
+		slog.Info("Skipping sync", "watch", w.WatchPath, "reason", reason)
+		return exitOK
+	}
+
+	if err := syncer.EnsureBucket(ctx); err != nil {
+		slog.Error("cannot use storage"+storageHint(err), "watch", w.WatchPath, "err", err)
+		return exitConnectivity
+	}
+	syncer.CheckClockSkew(ctx)
+
+	summary, err := syncer.InitialSync(ctx)
+	if err != nil {
+		slog.Error("sync failed", "watch", w.WatchPath, "err", err)
+		return exitSync
+	}
+	logSummary(w.WatchPath, summary)
+	return exitOK
+}
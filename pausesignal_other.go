@@ -0,0 +1,13 @@
+//go:build !unix
+
+package main
+
+import (
+	"context"
+
+	"github.com/danielbehrens/cloudsync/internal/api"
+)
+
+// handlePauseSignals does nothing: this platform has no SIGUSR1 or
+// SIGUSR2. Use the API to pause sync instead.
+func handlePauseSignals(ctx context.Context, controls *api.Server) {}
@@ -0,0 +1,32 @@
+//go:build unix
+
+package main
+
+import (
+	"context"
+	"log/slog"
+	"os"
+	"os/signal"
+	"syscall"
+
+	"github.com/danielbehrens/cloudsync/internal/api"
+)
+
+// handlePauseSignals pauses every watch on SIGUSR1 and resumes them on
+// SIGUSR2, as the API's POST /pause and POST /resume do, until ctx is
+// cancelled
+func handlePauseSignals(ctx context.Context, controls *api.Server) {
+	sig := make(chan os.Signal, 1)
+	signal.Notify(sig, syscall.SIGUSR1, syscall.SIGUSR2)
+	defer signal.Stop(sig)
+
+	for {
+		select {
+		case s := <-sig:
+			slog.Info("Received signal", "signal", s)
+			controls.SetPaused(s == syscall.SIGUSR1)
+		case <-ctx.Done():
+			return
+		}
+	}
+}
@@ -0,0 +1,73 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"os"
+	gosync "sync"
+	"time"
+
+	"github.com/danielbehrens/cloudsync/internal/storage"
+)
+
+// progressInterval is the least time between two progress lines for one
+// transfer
+const progressInterval = 100 * time.Millisecond
+
+// isTerminal reports whether f is a terminal rather than a file or pipe
+func isTerminal(f *os.File) bool {
+	info, err := f.Stat()
+	return err == nil && info.Mode()&os.ModeCharDevice != 0
+}
+
+// progressPrinter draws a progress line per transfer, redrawn in place
+type progressPrinter struct {
+	out io.Writer
+
+	mu   gosync.Mutex
+	last map[string]time.Time // last line drawn per transfer
+}
+
+func newProgressPrinter(out io.Writer) *progressPrinter {
+	return &progressPrinter{out: out, last: make(map[string]time.Time)}
+}
+
+// report is a storage.ProgressFunc
+func (p *progressPrinter) report(pr storage.Progress) {
+	key := pr.Direction + " " + pr.Object
+	done := pr.Total > 0 && pr.Transferred >= pr.Total
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	now := time.Now()
+	if !done && now.Sub(p.last[key]) < progressInterval {
+		return
+	}
+	p.last[key] = now
+
+	line := fmt.Sprintf("\r%s %s %s", pr.Direction, pr.Object, formatBytes(pr.Transferred))
+	if pr.Total > 0 {
+		line = fmt.Sprintf("\r%s %s %3d%% (%s / %s)", pr.Direction, pr.Object,
+			pr.Transferred*100/pr.Total, formatBytes(pr.Transferred), formatBytes(pr.Total))
+	}
+	line += "\x1b[K" // clear what a longer previous line left behind
+	if done {
+		line += "\n"
+		delete(p.last, key)
+	}
+	fmt.Fprint(p.out, line)
+}
+
+// formatBytes formats n with a binary unit, e.g. 1.5 MiB
+func formatBytes(n int64) string {
+	const unit = 1024
+	if n < unit {
+		return fmt.Sprintf("%d B", n)
+	}
+	div, exp := int64(unit), 0
+	for m := n / unit; m >= unit; m /= unit {
+		div *= unit
+		exp++
+	}
+	return fmt.Sprintf("%.1f %ciB", float64(n)/float64(div), "KMGTPE"[exp])
+}
@@ -0,0 +1,61 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"io"
+	"log/slog"
+	"strings"
+
+	"github.com/danielbehrens/cloudsync/internal/config"
+)
+
+// runPruneCloud lists on out the cloud objects with no local counterpart
+// that have gone unmodified for cfg.PruneAge and, after confirmation on in
+// (or with -yes), deletes them. Each is backed up first. With -dry-run nothing is
+// deleted. It returns the process exit code.
+func runPruneCloud(ctx context.Context, cfg *config.Config, in io.Reader, out io.Writer) int {
+	syncer, err := newSyncer(cfg)
+	if err != nil {
+		slog.Error("could not create storage client", "err", err)
+		return exitConfig
+	}
+
+	orphans, err := syncer.FindOrphans(ctx, cfg.PruneAge)
+	if err != nil {
+		slog.Error("failed to find orphaned objects", "err", err)
+		return exitSync
+	}
+
+	if len(orphans) == 0 {
+		slog.Info("No cloud objects without a local copy found", "older_than", cfg.PruneAge)
+		return exitOK
+	}
+
+	for _, o := range orphans {
+		fmt.Fprintf(out, "%s (%d bytes, modified %s)\n", o.Name, o.Size, o.ModTime.Local().Format("2006-01-02 15:04"))
+	}
+
+	if !cfg.Yes && !cfg.DryRun {
+		fmt.Fprintf(out, "Delete %d objects with no local copy? Each is backed up to %s first. [y/N] ", len(orphans), cfg.BackupDir)
+		answer, _ := bufio.NewReader(in).ReadString('\n')
+		if a := strings.ToLower(strings.TrimSpace(answer)); a != "y" && a != "yes" {
+			slog.Info("Nothing deleted")
+			return exitOK
+		}
+	}
+
+	removed, err := syncer.RemoveOrphans(ctx, orphans)
+	if cfg.DryRun {
+		slog.Info("[dry-run] Would delete objects", "count", removed, "orphans", len(orphans))
+	} else {
+		slog.Info("Deleted objects", "count", removed, "orphans", len(orphans))
+	}
+	if err != nil {
+		slog.Error("prune failed", "err", err)
+		return exitSync
+	}
+
+	return exitOK
+}
@@ -0,0 +1,61 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/danielbehrens/cloudsync/internal/config"
+	"github.com/danielbehrens/cloudsync/internal/storage"
+)
+
+func TestRunPruneCloudListsOrphans(t *testing.T) {
+	ctx := context.Background()
+	cloudDir := t.TempDir()
+
+	// An object uploaded long ago whose local file is gone
+	src := filepath.Join(t.TempDir(), "old.sav")
+	if err := os.WriteFile(src, []byte("old save"), 0o644); err != nil {
+		t.Fatalf("WriteFile() error = %v", err)
+	}
+	modTime := time.Now().Add(-30 * 24 * time.Hour)
+	if err := os.Chtimes(src, modTime, modTime); err != nil {
+		t.Fatalf("Chtimes() error = %v", err)
+	}
+	store := storage.NewLocalBackend(cloudDir)
+	if err := store.Upload(ctx, src, "old.sav"); err != nil {
+		t.Fatalf("Upload() error = %v", err)
+	}
+
+	cfg := &config.Config{
+		S3Config: config.S3Config{Backend: config.BackendLocal},
+		Watches: []config.WatchConfig{{
+			WatchPath:       t.TempDir(),
+			BackupDir:       t.TempDir(),
+			LocalDir:        cloudDir,
+			IncludePatterns: []string{"*.sav"},
+		}},
+		PruneAge: 24 * time.Hour,
+	}
+	cfg.BackupDir = cfg.Watches[0].BackupDir
+
+	var out bytes.Buffer
+	if code := runPruneCloud(ctx, cfg, strings.NewReader("n\n"), &out); code != exitOK {
+		t.Fatalf("runPruneCloud() = %v, want %v", code, exitOK)
+	}
+
+	want := "old.sav (8 bytes, modified " + modTime.Local().Format("2006-01-02 15:04") + ")\n" +
+		"Delete 1 objects with no local copy? Each is backed up to " + cfg.BackupDir + " first. [y/N] "
+	if got := out.String(); got != want {
+		t.Errorf("output = %q, want %q", got, want)
+	}
+
+	// Declining the prompt deletes nothing
+	if _, err := store.Stat(ctx, "old.sav"); err != nil {
+		t.Errorf("Stat() after declining error = %v, want the object kept", err)
+	}
+}
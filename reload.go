@@ -0,0 +1,210 @@
+package main
+
+import (
+	"context"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/danielbehrens/cloudsync/internal/config"
+)
+
+// restartKeys are the settings that configure the process rather than a
+// watch, so a reload can't apply them
+var restartKeys = map[string]bool{
+	"metrics_addr":     true,
+	"api_addr":         true,
+	"log_format":       true,
+	"notify_url":       true,
+	"notify_events":    true,
+	"notify_template":  true,
+	"pause_on_battery": true,
+	"progress":         true,
+	"trigger_ops":      true,
+	"debounce_mode":    true,
+	"shutdown_grace":   true,
+	"recursive":        true,
+}
+
+// daemon runs a goroutine per watch of cfg and, on SIGHUP, reloads the
+// config file and reconciles the running watches with it
+type daemon struct {
+	ctx    context.Context
+	cancel context.CancelFunc // stops every watch
+	cfg    *config.Config
+	runs   map[string]*watchRun // by cleaned watch path
+	exited chan *watchRun
+	code   int
+}
+
+// watchRun is a watch running in its own goroutine
+type watchRun struct {
+	w      config.WatchConfig
+	cancel context.CancelFunc
+	reload chan watchReload
+	done   chan struct{} // closed once runWatch returned code
+	code   int
+
+	// fatal stops the daemon when the watch fails, as for the watches it
+	// started with; one added by a reload only logs its failure
+	fatal bool
+
+	// stopped is set when the daemon stops the watch itself
+	stopped bool
+}
+
+// watchReload carries a reloaded config to a running watch
+type watchReload struct {
+	cfg *config.Config
+	w   config.WatchConfig
+}
+
+func newDaemon(ctx context.Context, cancel context.CancelFunc, cfg *config.Config) *daemon {
+	return &daemon{
+		ctx:    ctx,
+		cancel: cancel,
+		cfg:    cfg,
+		runs:   make(map[string]*watchRun),
+		exited: make(chan *watchRun),
+	}
+}
+
+// run starts the watches and reloads the config on each signal from hup
+// until every watch has stopped. It returns exitOK, or the exit code of
+// the first watch that failed.
+func (d *daemon) run(hup <-chan os.Signal) int {
+	for _, w := range d.cfg.Watches {
+		d.start(d.cfg, w, true)
+	}
+
+	for len(d.runs) > 0 {
+		select {
+		case r := <-d.exited:
+			d.exit(r)
+		case <-hup:
+			d.reload()
+		}
+	}
+	return d.code
+}
+
+func (d *daemon) start(cfg *config.Config, w config.WatchConfig, fatal bool) {
+	ctx, cancel := context.WithCancel(d.ctx)
+	r := &watchRun{
+		w:      w,
+		cancel: cancel,
+		reload: make(chan watchReload, 1),
+		done:   make(chan struct{}),
+		fatal:  fatal,
+	}
+	d.runs[watchKey(w)] = r
+
+	go func() {
+		r.code = runWatch(ctx, cfg, w, r.reload)
+		cancel()
+		close(r.done)
+		d.exited <- r
+	}()
+}
+
+// stop stops r and waits for it, so its backup dir lock is released
+func (d *daemon) stop(r *watchRun) {
+	r.stopped = true
+	r.cancel()
+	<-r.done
+}
+
+func (d *daemon) exit(r *watchRun) {
+	key := watchKey(r.w)
+	if d.runs[key] == r {
+		delete(d.runs, key)
+	}
+	if r.stopped || r.code == exitOK {
+		return
+	}
+
+	// One watch that can't start stops the others. A failed watch added
+	// by a reload leaves them running, unless it was the last one.
+	if (r.fatal || len(d.runs) == 0) && d.code == exitOK {
+		d.code = r.code
+	}
+	if r.fatal {
+		d.cancel()
+	}
+}
+
+// reload reads the config file again and applies it: watches no longer
+// listed stop, new ones start, one whose backup dir moved restarts, and
+// the others carry on with a Syncer built from the new settings. A config
+// that fails to load leaves everything as it was.
+func (d *daemon) reload() {
+	slog.Info("Reloading", "path", d.cfg.ConfigFile)
+	cfg, err := d.cfg.Reload()
+	if err != nil {
+		slog.Warn("Reload failed, keeping the current settings", "err", err)
+		return
+	}
+
+	changed := cfg.Changes(d.cfg)
+	if len(changed) > 0 {
+		slog.Info("Changed settings", "settings", strings.Join(changed, ", "))
+	}
+	for _, key := range changed {
+		if restartKeys[key] {
+			slog.Info("Setting takes effect after a restart", "setting", key)
+		}
+	}
+	logLevel.Set(logLevels[cfg.LogLevel])
+
+	keep := make(map[string]bool)
+	for _, w := range cfg.Watches {
+		keep[watchKey(w)] = true
+	}
+	for key, r := range d.runs {
+		if !keep[key] {
+			slog.Info("Stopped watching", "watch", r.w.WatchPath)
+			d.stop(r)
+			delete(d.runs, key)
+		}
+	}
+
+	for _, w := range cfg.Watches {
+		r, ok := d.runs[watchKey(w)]
+		if !ok {
+			slog.Info("Watching new path", "watch", w.WatchPath)
+			d.start(cfg, w, false)
+			continue
+		}
+
+		watchChanged := config.WatchChanges(r.w, w)
+		if len(watchChanged) > 0 {
+			slog.Info("Changed watch settings", "watch", w.WatchPath, "settings", strings.Join(watchChanged, ", "))
+		} else if len(changed) == 0 {
+			continue
+		}
+
+		// The backup dir holds the watch's lock and state
+		if r.w.BackupDir != w.BackupDir {
+			slog.Info("Restarting for the new backup dir", "watch", w.WatchPath, "backup_dir", w.BackupDir)
+			d.stop(r)
+			d.start(cfg, w, false)
+			continue
+		}
+
+		// Replace a reload the watch hasn't picked up yet
+		select {
+		case <-r.reload:
+		default:
+		}
+		r.reload <- watchReload{cfg: cfg, w: w}
+		r.w = w
+	}
+
+	d.cfg = cfg
+}
+
+// watchKey identifies a watch across reloads by its path
+func watchKey(w config.WatchConfig) string {
+	return filepath.Clean(w.WatchPath)
+}
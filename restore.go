@@ -0,0 +1,105 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"log/slog"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/danielbehrens/cloudsync/internal/config"
+)
+
+// runRestoreGood copies the latest-known-good copy of cfg.RestoreGood (or of
+// every save when it is "all") back into the watch path. The live files are
+// backed up first. It returns the process exit code.
+func runRestoreGood(cfg *config.Config) int {
+	syncer, err := newSyncer(cfg)
+	if err != nil {
+		slog.Error("could not create storage client", "err", err)
+		return exitConfig
+	}
+	syncer.GoodCopyDir = cfg.GoodCopyDir
+
+	names := []string{cfg.RestoreGood}
+	if cfg.RestoreGood == "all" {
+		names, err = syncer.GoodCopies()
+		if err != nil {
+			slog.Error("failed to list good copies", "err", err)
+			return exitSync
+		}
+	}
+
+	failed := 0
+	for _, name := range names {
+		if err := syncer.RestoreGoodCopy(name); err != nil {
+			slog.Error("Failed to restore", "file", name, "err", err)
+			failed++
+		}
+	}
+
+	slog.Info("Restored saves", "count", len(names)-failed, "saves", len(names), "path", cfg.GoodCopyDir)
+	if failed > 0 {
+		return exitSync
+	}
+	return exitOK
+}
+
+// runListBackups prints the timestamped backups, newest first, with the
+// saves each holds. It returns the process exit code.
+func runListBackups(cfg *config.Config, out io.Writer) int {
+	syncer, err := newSyncer(cfg)
+	if err != nil {
+		slog.Error("could not create storage client", "err", err)
+		return exitConfig
+	}
+
+	backups, err := syncer.Backups()
+	if err != nil {
+		slog.Error("failed to list backups", "err", err)
+		return exitSync
+	}
+	if len(backups) == 0 {
+		fmt.Fprintf(out, "No backups in %s\n", cfg.BackupDir)
+		return exitOK
+	}
+
+	tw := tabwriter.NewWriter(out, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, "BACKUP\tFILES")
+	for _, b := range backups {
+		fmt.Fprintf(tw, "%s\t%s\n", b.Name, strings.Join(b.Files, ", "))
+	}
+	if err := tw.Flush(); err != nil {
+		slog.Error("failed to write backups", "err", err)
+		return exitSync
+	}
+
+	return exitOK
+}
+
+// runRestoreBackup copies the saves in the backup named by cfg.RestoreBackup
+// (or the newest, for "latest") back into the watch path, backing up the
+// live files first, and prints what it restored. It returns the process
+// exit code.
+func runRestoreBackup(cfg *config.Config, out io.Writer) int {
+	syncer, err := newSyncer(cfg)
+	if err != nil {
+		slog.Error("could not create storage client", "err", err)
+		return exitConfig
+	}
+
+	restored, err := syncer.RestoreBackup(cfg.RestoreBackup)
+	verb := "Restored"
+	if cfg.DryRun {
+		verb = "Would restore"
+	}
+	for _, name := range restored {
+		fmt.Fprintf(out, "%s %s\n", verb, name)
+	}
+	if err != nil {
+		slog.Error("Restore failed", "err", err)
+		return exitSync
+	}
+
+	return exitOK
+}
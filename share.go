@@ -0,0 +1,54 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"time"
+
+	"github.com/danielbehrens/cloudsync/internal/config"
+	"github.com/danielbehrens/cloudsync/internal/storage"
+)
+
+// runShare prints a presigned link to the cloud copy of cfg.ShareFile that
+// works without bucket credentials until cfg.ShareExpiry has passed. It
+// returns the process exit code.
+func runShare(ctx context.Context, cfg *config.Config, out io.Writer) int {
+	if cfg.S3Config.Backend != config.BackendS3 {
+		slog.Error("share needs -backend "+config.BackendS3, "backend", cfg.S3Config.Backend)
+		return exitConfig
+	}
+
+	// The Syncer only resolves the file's object name, as sync would
+	syncer, err := newSyncer(cfg)
+	if err != nil {
+		slog.Error("could not create storage client", "err", err)
+		return exitConfig
+	}
+	objectName, err := syncer.ObjectName(cfg.ShareFile)
+	if err != nil {
+		slog.Error("cannot share", "path", cfg.ShareFile, "err", err)
+		return exitConfig
+	}
+
+	client, err := storage.NewS3Client(cfg.S3Config)
+	if err != nil {
+		slog.Error("could not create storage client", "err", err)
+		return exitConfig
+	}
+
+	link, err := client.PresignedGetURL(ctx, objectName, cfg.ShareExpiry)
+	if err != nil {
+		slog.Error("cannot share", "file", objectName, "err", err)
+		if errors.Is(err, storage.ErrObjectEncrypted) {
+			return exitConfig
+		}
+		return exitSync
+	}
+
+	fmt.Fprintln(out, link)
+	slog.Info("Link expires", "file", objectName, "expires", time.Now().Add(cfg.ShareExpiry).Format(time.DateTime))
+	return exitOK
+}
@@ -0,0 +1,71 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log/slog"
+	"text/tabwriter"
+	"time"
+
+	"github.com/danielbehrens/cloudsync/internal/config"
+	"github.com/danielbehrens/cloudsync/internal/sync"
+)
+
+// runStatus prints a table of every tracked file's local and cloud mod time
+// and verdict. Nothing is transferred or modified. It returns exitOK when
+// every file is in sync and exitOutOfSync otherwise.
+func runStatus(ctx context.Context, cfg *config.Config, out io.Writer) int {
+	syncer, err := newSyncer(cfg)
+	if err != nil {
+		slog.Error("could not create storage client", "err", err)
+		return exitConfig
+	}
+
+	manifest, err := syncer.BuildManifest(ctx)
+	if err != nil {
+		slog.Error("failed to read sync state", "err", err)
+		return exitSync
+	}
+
+	tw := tabwriter.NewWriter(out, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, "FILE\tLOCAL\tCLOUD\tLAST SYNCED\tVERDICT")
+
+	outOfSync := 0
+	for _, f := range manifest.Files {
+		if f.Verdict != sync.VerdictInSync {
+			outOfSync++
+		}
+		fmt.Fprintf(tw, "%s\t%s\t%s\t%s\t%s\n", f.Name, statusTime(f.Local), statusTime(f.Cloud), lastSyncedTime(f.LastSynced), f.Verdict)
+	}
+	if err := tw.Flush(); err != nil {
+		slog.Error("failed to write status", "err", err)
+		return exitSync
+	}
+
+	if outOfSync > 0 {
+		fmt.Fprintf(out, "\n%d of %d files out of sync\n", outOfSync, len(manifest.Files))
+		return exitOutOfSync
+	}
+
+	fmt.Fprintf(out, "\nAll %d files in sync\n", len(manifest.Files))
+	return exitOK
+}
+
+// lastSyncedTime formats a last sync time in local time, or "-" if it is
+// unknown
+func lastSyncedTime(t time.Time) string {
+	if t.IsZero() {
+		return "-"
+	}
+	return t.Local().Format(time.DateTime)
+}
+
+// statusTime formats one side's mod time in local time, or "-" if the file
+// is missing on that side
+func statusTime(state *sync.FileState) string {
+	if state == nil {
+		return "-"
+	}
+	return state.ModTime.Local().Format(time.DateTime)
+}
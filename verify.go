@@ -0,0 +1,71 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log/slog"
+	"text/tabwriter"
+
+	"github.com/danielbehrens/cloudsync/internal/config"
+	"github.com/danielbehrens/cloudsync/internal/sync"
+)
+
+// runVerify downloads the cloud copy of every save in each watch (or a
+// random cfg.VerifySample of them) and prints whether its content matches
+// the local file. Nothing is transferred to the cloud or modified locally.
+// It returns exitOK when every checked save matches and exitMismatch
+// otherwise.
+func runVerify(ctx context.Context, cfg *config.Config, out io.Writer) int {
+	var checked, bad int
+	for _, w := range cfg.Watches {
+		syncer, err := newWatchSyncer(cfg, w)
+		if err != nil {
+			slog.Error("could not create storage client", "watch", w.WatchPath, "err", err)
+			return exitConfig
+		}
+
+		results, err := syncer.Verify(ctx, cfg.VerifySample)
+		if err != nil {
+			slog.Error("failed to verify", "watch", w.WatchPath, "err", err)
+			return exitSync
+		}
+
+		if len(cfg.Watches) > 1 {
+			fmt.Fprintf(out, "%s:\n", w.WatchPath)
+		}
+		tw := tabwriter.NewWriter(out, 0, 0, 2, ' ', 0)
+		fmt.Fprintln(tw, "FILE\tRESULT\tLOCAL SHA-256\tCLOUD SHA-256")
+		for _, r := range results {
+			if r.Status != sync.VerifyMatch {
+				bad++
+			}
+			fmt.Fprintf(tw, "%s\t%s\t%s\t%s\n", r.Name, r.Status, shortSum(r.LocalChecksum), shortSum(r.CloudChecksum))
+			if r.Err != nil {
+				slog.Warn("verify failed", "file", r.Name, "err", r.Err)
+			}
+		}
+		if err := tw.Flush(); err != nil {
+			slog.Error("failed to write results", "err", err)
+			return exitSync
+		}
+		checked += len(results)
+	}
+
+	if bad > 0 {
+		fmt.Fprintf(out, "\n%d of %d files do not match the cloud\n", bad, checked)
+		return exitMismatch
+	}
+
+	fmt.Fprintf(out, "\nAll %d files match the cloud\n", checked)
+	return exitOK
+}
+
+// shortSum abbreviates a hex checksum for the results table, or returns
+// "-" if it is unknown
+func shortSum(sum string) string {
+	if sum == "" {
+		return "-"
+	}
+	return sum[:min(len(sum), 12)]
+}
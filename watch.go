@@ -0,0 +1,165 @@
+package main
+
+import (
+	"context"
+	"log/slog"
+	"time"
+
+	"github.com/danielbehrens/cloudsync/internal/config"
+	"github.com/danielbehrens/cloudsync/internal/lock"
+	"github.com/danielbehrens/cloudsync/internal/sync"
+	"github.com/danielbehrens/cloudsync/internal/watcher"
+)
+
+// runWatch syncs one watch until ctx is cancelled and returns exitOK, or
+// the exit code for the reason it could not start. The settings of a
+// reloaded config arriving on reload are applied without stopping.
+func runWatch(ctx context.Context, cfg *config.Config, w config.WatchConfig, reload <-chan watchReload) int {
+	// A second instance on the same folders would fight this one over
+	// uploads and backups
+	lk, err := lock.Acquire(w.BackupDir)
+	if err != nil {
+		slog.Error("cannot watch", "watch", w.WatchPath, "err", err)
+		return exitConfig
+	}
+	defer lk.Release()
+
+	syncer, err := newWatchSyncer(cfg, w)
+	if err != nil {
+		slog.Error("could not create storage client", "watch", w.WatchPath, "err", err)
+		return exitConfig
+	}
+	if controlAPI != nil {
+		controlAPI.Register(w.WatchPath, syncer)
+		// syncer is reassigned to the one serveWatch ends with, so this
+		// unregisters the Syncer of the last reload
+		defer func() { controlAPI.Unregister(w.WatchPath, syncer) }()
+	}
+
+	// Check the storage first: a misconfigured backend fails at once,
+	// not after waiting for the watch path
+	if err := syncer.EnsureBucket(ctx); err != nil {
+		if ctx.Err() != nil {
+			return exitOK
+		}
+		slog.Error("cannot use storage"+storageHint(err), "watch", w.WatchPath, "err", err)
+		return exitConnectivity
+	}
+	syncer.CheckClockSkew(ctx)
+
+	fw, err := watcher.NewFileWatcher(w.WatchPath, eventCooldown, watcher.Options{
+		Recursive:  cfg.Recursive,
+		IgnoreDirs: []string{w.BackupDir},
+		Add: watcher.AddOptions{
+			Attempts: cfg.WatchRetries + 1,
+			Backoff:  time.Second,
+			Create:   cfg.CreateWatchPath,
+		},
+		Debounce:      watcher.DebounceMode(cfg.DebounceMode),
+		CheckInterval: watchCheckInterval,
+		WaitForPath:   cfg.WaitForWatchPath,
+	})
+	if err != nil {
+		slog.Error("cannot watch", "watch", w.WatchPath, "err", err)
+		return exitConfig
+	}
+	defer fw.Close()
+	fw.TriggerOps = triggerOps
+	fw.IncludePatterns = w.IncludePatterns
+	fw.ExcludePatterns = w.ExcludePatterns
+
+	// A game never launched has no save folder yet; sync starts once the
+	// watcher sees it created
+	select {
+	case <-fw.Ready():
+	default:
+		slog.Info("Waiting for watch path to be created", "watch", w.WatchPath)
+		select {
+		case <-fw.Ready():
+		case <-ctx.Done():
+			return exitOK
+		}
+	}
+
+	slog.Info("Watching for changes", "watch", w.WatchPath)
+	summary, err := syncer.InitialSync(ctx)
+	if err != nil {
+		if ctx.Err() != nil {
+			return exitOK
+		}
+		slog.Error("initial sync failed", "watch", w.WatchPath, "err", err)
+		return exitSync
+	}
+	logSummary(w.WatchPath, summary)
+
+	syncer = serveWatch(ctx, syncer, fw, reload)
+	return exitOK
+}
+
+// logSummary logs what the initial sync of watchPath did as one line
+func logSummary(watchPath string, s *sync.SyncSummary) {
+	slog.Info("Initial sync finished", "watch", watchPath,
+		"uploaded", s.Uploaded, "bytes_up", formatBytes(s.BytesUp), "downloaded", s.Downloaded, "bytes_down", formatBytes(s.BytesDown),
+		"in_sync", s.Skipped, "conflicts", s.Conflicts, "deleted", s.Deleted, "failed", s.Failed, "duration", s.Elapsed.Round(time.Millisecond))
+}
+
+// serveWatch runs syncer on fw until ctx is cancelled. A reload replaces
+// syncer with one built from the new settings, while fw, and with it the
+// cooldown of recent events, carries over. Stopping the old Syncer aborts
+// its transfer in flight, so the new one starts with a full sync.
+// serveWatch returns the Syncer that was running when ctx was cancelled.
+func serveWatch(ctx context.Context, syncer *sync.Syncer, fw *watcher.FileWatcher, reload <-chan watchReload) *sync.Syncer {
+	for {
+		runCtx, stop := context.WithCancel(ctx)
+		done := make(chan struct{})
+		go func() {
+			defer close(done)
+			syncer.Run(runCtx, fw)
+		}()
+
+		next, w := awaitReload(ctx, done, reload)
+		stop()
+		<-done
+		if next == nil {
+			return syncer
+		}
+
+		syncer = next
+		if controlAPI != nil {
+			controlAPI.Register(w.WatchPath, syncer)
+		}
+		fw.SetPatterns(w.IncludePatterns, w.ExcludePatterns)
+		slog.Info("Applied the new settings", "watch", w.WatchPath)
+		if syncer.PauseReason() != "" {
+			continue
+		}
+		if err := syncer.FullSync(ctx); err != nil && ctx.Err() == nil {
+			slog.Error("sync after reload failed", "watch", w.WatchPath, "err", err)
+		}
+	}
+}
+
+// awaitReload waits for a reload whose Syncer can be built and reach its
+// storage, and returns it, or returns nil once done is closed. A reload
+// that fails is logged and the current Syncer kept.
+func awaitReload(ctx context.Context, done <-chan struct{}, reload <-chan watchReload) (*sync.Syncer, config.WatchConfig) {
+	for {
+		select {
+		case <-done:
+			return nil, config.WatchConfig{}
+		case r := <-reload:
+			next, err := newWatchSyncer(r.cfg, r.w)
+			if err != nil {
+				slog.Warn("could not create storage client, keeping the current settings", "watch", r.w.WatchPath, "err", err)
+				continue
+			}
+			if err := next.EnsureBucket(ctx); err != nil {
+				if ctx.Err() == nil {
+					slog.Warn("cannot use storage, keeping the current settings"+storageHint(err), "watch", r.w.WatchPath, "err", err)
+				}
+				continue
+			}
+			return next, r.w
+		}
+	}
+}